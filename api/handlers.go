@@ -579,14 +579,31 @@ func getContainerJSON(c *context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	n, err := json.Marshal(container.Engine)
+	data, err = insertContainerMetadata(data, container, cluster.ContainerRescheduleEligibility(c.cluster, c.watchdogOpts, container))
 	if err != nil {
 		httpError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// insert Node field
-	data = bytes.Replace(data, []byte(`"Name":"/`), []byte(fmt.Sprintf(`"Node":%s,"Name":"/`, n)), -1)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// insertContainerMetadata inserts into the inspect data of a container the
+// node of the container and whether it would be rescheduled if the node
+// failed, and replaces the wildcard host IPs by the IP of the node.
+func insertContainerMetadata(data []byte, container *cluster.Container, eligibility cluster.RescheduleEligibility) ([]byte, error) {
+	n, err := json.Marshal(container.Engine)
+	if err != nil {
+		return nil, err
+	}
+	reschedule, err := json.Marshal(eligibility)
+	if err != nil {
+		return nil, err
+	}
+
+	// insert Node and Reschedule fields
+	data = bytes.Replace(data, []byte(`"Name":"/`), []byte(fmt.Sprintf(`"Node":%s,"Reschedule":%s,"Name":"/`, n, reschedule)), -1)
 
 	// insert node IP
 	if engineIP := net.ParseIP(container.Engine.IP); engineIP != nil {
@@ -599,9 +616,7 @@ func getContainerJSON(c *context, w http.ResponseWriter, r *http.Request) {
 		replace := fmt.Sprintf(`"HostIp":"%s"`, container.Engine.IP)
 		data = bytes.Replace(data, []byte(orig), []byte(replace), -1)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(data)
+	return data, nil
 }
 
 // POST /containers/create
@@ -1026,6 +1041,84 @@ func ping(c *context, w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte{'O', 'K'})
 }
 
+// GET /reschedule
+func getReschedule(c *context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Enabled     bool
+		Quarantined []cluster.QuarantinedContainer
+	}{c.rescheduleSwitch.Enabled(), c.quarantine.List()})
+}
+
+// GET /reschedule/plan/{node:.*}
+func getReschedulePlan(c *context, w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["node"]
+	engine := cluster.PlanEngine(c.cluster, name)
+	if engine == nil {
+		httpError(w, fmt.Sprintf("No container on node %s", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cluster.PlanReschedule(c.cluster, c.watchdogOpts, engine))
+}
+
+// GET /reschedule/preflight/{node:.*}
+func getReschedulePreflight(c *context, w http.ResponseWriter, r *http.Request) {
+	report, err := cluster.ReschedulePreflight(c.cluster, c.watchdogOpts, mux.Vars(r)["node"])
+	if err != nil {
+		httpError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GET /capacity
+func getCapacity(c *context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.cluster.Capacity())
+}
+
+// POST /reschedule/enable
+func postRescheduleEnable(c *context, w http.ResponseWriter, r *http.Request) {
+	setReschedule(c, w, true)
+}
+
+// POST /reschedule/disable
+func postRescheduleDisable(c *context, w http.ResponseWriter, r *http.Request) {
+	setReschedule(c, w, false)
+}
+
+func setReschedule(c *context, w http.ResponseWriter, enabled bool) {
+	if c.rescheduleSwitch == nil {
+		httpError(w, "Rescheduling can't be toggled on this manager.", http.StatusNotImplemented)
+		return
+	}
+	if err := c.rescheduleSwitch.SetEnabled(enabled); err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.WithField("enabled", enabled).Info("Toggled the rescheduling of containers")
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /reschedule/release/{name:.*}
+func postRescheduleRelease(c *context, w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if c.quarantine == nil {
+		httpError(w, "Quarantined containers can't be released on this manager.", http.StatusNotImplemented)
+		return
+	}
+	if !c.quarantine.Release(name) {
+		httpError(w, fmt.Sprintf("No such quarantined container: %s", name), http.StatusNotFound)
+		return
+	}
+	log.WithField("container", name).Info("Released container from quarantine")
+	w.WriteHeader(http.StatusOK)
+}
+
 // POST /networks/{networkid:.*}/disconnect
 func networkDisconnect(c *context, w http.ResponseWriter, r *http.Request) {
 	var networkid = mux.Vars(r)["networkid"]
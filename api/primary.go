@@ -13,12 +13,15 @@ import (
 
 // Primary router context, used by handlers.
 type context struct {
-	cluster       cluster.Cluster
-	eventsHandler *eventsHandler
-	statusHandler StatusHandler
-	debug         bool
-	tlsConfig     *tls.Config
-	apiVersion    string
+	cluster          cluster.Cluster
+	eventsHandler    *eventsHandler
+	statusHandler    StatusHandler
+	rescheduleSwitch *cluster.RescheduleSwitch
+	quarantine       *cluster.Quarantine
+	watchdogOpts     *cluster.WatchdogOpts
+	debug            bool
+	tlsConfig        *tls.Config
+	apiVersion       string
 }
 
 type handler func(c *context, w http.ResponseWriter, r *http.Request)
@@ -54,6 +57,10 @@ var routes = map[string]map[string]handler{
 		"/networks/{networkid:.*}":        getNetwork,
 		"/volumes":                        getVolumes,
 		"/volumes/{volumename:.*}":        getVolume,
+		"/reschedule":                     getReschedule,
+		"/reschedule/plan/{node:.*}":      getReschedulePlan,
+		"/reschedule/preflight/{node:.*}": getReschedulePreflight,
+		"/capacity":                       getCapacity,
 	},
 	"POST": {
 		"/auth":                               proxyRandom,
@@ -83,6 +90,9 @@ var routes = map[string]map[string]handler{
 		"/networks/{networkid:.*}/connect":    proxyNetworkConnect,
 		"/networks/{networkid:.*}/disconnect": networkDisconnect,
 		"/volumes/create":                     postVolumesCreate,
+		"/reschedule/enable":                  postRescheduleEnable,
+		"/reschedule/disable":                 postRescheduleDisable,
+		"/reschedule/release/{name:.*}":       postRescheduleRelease,
 	},
 	"PUT": {
 		"/containers/{name:.*}/archive": proxyContainer,
@@ -114,8 +124,11 @@ func profilerSetup(mainRouter *mux.Router, path string) {
 	r.HandleFunc("/pprof/threadcreate", pprof.Handler("threadcreate").ServeHTTP)
 }
 
-// NewPrimary creates a new API router.
-func NewPrimary(cluster cluster.Cluster, tlsConfig *tls.Config, status StatusHandler, debug, enableCors bool) *mux.Router {
+// NewPrimary creates a new API router. watchdogOpts, which may be nil, are the
+// options of the watchdog: its switch toggles the rescheduling of containers,
+// its quarantine holds the containers it stopped rescheduling, and the
+// reschedule plans follow its decisions.
+func NewPrimary(cluster cluster.Cluster, watchdogOpts *cluster.WatchdogOpts, tlsConfig *tls.Config, status StatusHandler, debug, enableCors bool) *mux.Router {
 	// Register the API events handler in the cluster.
 	eventsHandler := newEventsHandler()
 	cluster.RegisterEventHandler(eventsHandler)
@@ -124,8 +137,13 @@ func NewPrimary(cluster cluster.Cluster, tlsConfig *tls.Config, status StatusHan
 		cluster:       cluster,
 		eventsHandler: eventsHandler,
 		statusHandler: status,
+		watchdogOpts:  watchdogOpts,
 		tlsConfig:     tlsConfig,
 	}
+	if watchdogOpts != nil {
+		context.rescheduleSwitch = watchdogOpts.Switch
+		context.quarantine = watchdogOpts.Quarantine
+	}
 
 	r := mux.NewRouter()
 	setupPrimaryRouter(r, context, enableCors)
@@ -1,11 +1,16 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/swarm/cluster"
 	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestRequest(t *testing.T) {
@@ -79,3 +84,216 @@ func TestCorsRequest(t *testing.T) {
 	}
 
 }
+
+func TestRescheduleSwitch(t *testing.T) {
+	t.Parallel()
+
+	c := &context{rescheduleSwitch: cluster.NewRescheduleSwitch(nil, "")}
+	r := mux.NewRouter()
+	setupPrimaryRouter(r, c, false)
+
+	for _, test := range []struct {
+		path    string
+		enabled bool
+	}{
+		{"/reschedule/disable", false},
+		{"/v1.24/reschedule/enable", true},
+	} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", test.path, nil)
+		assert.NoError(t, err)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, test.enabled, c.rescheduleSwitch.Enabled())
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/reschedule", nil)
+		assert.NoError(t, err)
+		r.ServeHTTP(w, req)
+		var state struct{ Enabled bool }
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&state))
+		assert.Equal(t, test.enabled, state.Enabled)
+	}
+
+	// Without switch the rescheduling can't be toggled.
+	r = mux.NewRouter()
+	setupPrimaryRouter(r, &context{}, false)
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/reschedule/disable", nil)
+	assert.NoError(t, err)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestRescheduleQuarantine(t *testing.T) {
+	t.Parallel()
+
+	c := &context{quarantine: cluster.NewQuarantine()}
+	c.quarantine.Add(&cluster.QuarantinedContainer{ID: "c1", Name: "web", Engine: "node1", Attempts: 5})
+	r := mux.NewRouter()
+	setupPrimaryRouter(r, c, false)
+
+	// The quarantined containers are listed with the reschedule state.
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/reschedule", nil)
+	assert.NoError(t, err)
+	r.ServeHTTP(w, req)
+	var state struct {
+		Enabled     bool
+		Quarantined []cluster.QuarantinedContainer
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&state))
+	assert.True(t, state.Enabled)
+	if assert.Len(t, state.Quarantined, 1) {
+		assert.Equal(t, "web", state.Quarantined[0].Name)
+		assert.Equal(t, 5, state.Quarantined[0].Attempts)
+	}
+
+	for _, test := range []struct {
+		name string
+		code int
+	}{
+		{"web", http.StatusOK},
+		{"web", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/reschedule/release/"+test.name, nil)
+		assert.NoError(t, err)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code)
+	}
+	assert.False(t, c.quarantine.Quarantined("c1"))
+
+	// Without quarantine nothing can be released.
+	r = mux.NewRouter()
+	setupPrimaryRouter(r, &context{}, false)
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/reschedule/release/web", nil)
+	assert.NoError(t, err)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestReschedulePlan(t *testing.T) {
+	t.Parallel()
+
+	config := cluster.BuildContainerConfig(containertypes.Config{Labels: map[string]string{
+		cluster.SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure"]`,
+	}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	c := &context{cluster: cluster.NewReplayCluster(cluster.ClusterState{Nodes: []cluster.NodeState{
+		{ID: "node1", Name: "node1", HealthIndicator: 100, Containers: []cluster.ContainerState{
+			{ID: "c1", Names: []string{"/web"}, Running: true, Config: config},
+			{ID: "c2", Names: []string{"/static"}, Running: true, Config: cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})},
+		}},
+		{ID: "node2", Name: "node2", HealthIndicator: 100},
+	}}, nil)}
+	r := mux.NewRouter()
+	setupPrimaryRouter(r, c, false)
+
+	// The plan of a healthy node is what would happen if it failed.
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/reschedule/plan/node1", nil)
+	assert.NoError(t, err)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var plan cluster.ReschedulePlan
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&plan))
+	assert.Equal(t, []cluster.PlannedMove{{Container: "c1", Name: "web", Target: "node2"}}, plan.Moves)
+	assert.Equal(t, []cluster.PlannedSkip{{Container: "c2", Name: "static", Reason: "policy"}}, plan.Skipped)
+	assert.Len(t, c.cluster.Containers(), 2)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/reschedule/plan/node2", nil)
+	assert.NoError(t, err)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// The preflight classifies the same decisions.
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/reschedule/preflight/node1", nil)
+	assert.NoError(t, err)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report cluster.PreflightReport
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, []cluster.PlannedMove{{Container: "c1", Name: "web", Target: "node2"}}, report.Reschedule)
+	assert.Empty(t, report.Fail)
+	assert.Equal(t, []cluster.PlannedSkip{{Container: "c2", Name: "static", Reason: "policy"}}, report.Skip)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/reschedule/preflight/node3", nil)
+	assert.NoError(t, err)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCapacity(t *testing.T) {
+	t.Parallel()
+
+	config := cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{
+		Resources: containertypes.Resources{Memory: 512, CPUShares: 1},
+	}, networktypes.NetworkingConfig{})
+	c := &context{cluster: cluster.NewReplayCluster(cluster.ClusterState{Nodes: []cluster.NodeState{
+		{ID: "node1", Name: "node1", TotalMemory: 2048, TotalCpus: 2, HealthIndicator: 100, Containers: []cluster.ContainerState{
+			{ID: "c1", Names: []string{"/web"}, Running: true, Config: config},
+		}},
+	}}, nil)}
+	r := mux.NewRouter()
+	setupPrimaryRouter(r, c, false)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/capacity", nil)
+	assert.NoError(t, err)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report cluster.CapacityReport
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, int64(1536), report.AvailableMemory)
+	assert.Equal(t, int64(1), report.AvailableCpus)
+	if assert.Len(t, report.Nodes, 1) {
+		assert.Equal(t, int64(512), report.Nodes[0].ReservedMemory)
+	}
+}
+
+func TestInsertContainerMetadata(t *testing.T) {
+	t.Parallel()
+
+	policies := map[string]string{cluster.SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure"]`}
+	web := cluster.BuildContainerConfig(containertypes.Config{Labels: policies}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	db := cluster.BuildContainerConfig(containertypes.Config{Labels: policies}, containertypes.HostConfig{Binds: []string{"/srv/db:/var/lib/db"}}, networktypes.NetworkingConfig{})
+	cl := cluster.NewReplayCluster(cluster.ClusterState{Nodes: []cluster.NodeState{
+		{ID: "node1", Name: "node1", IP: "10.0.0.1", HealthIndicator: 100, Containers: []cluster.ContainerState{
+			{ID: "c1", Names: []string{"/web"}, Running: true, Config: web},
+			{ID: "c2", Names: []string{"/db"}, Running: true, Config: db},
+		}},
+	}}, nil)
+
+	inspect := func(name string) (metadata struct {
+		Name       string
+		Node       struct{ Name string }
+		Reschedule cluster.RescheduleEligibility
+		HostConfig struct {
+			PortBindings map[string][]struct{ HostIp string }
+		}
+	}) {
+		container := cl.Container(name)
+		data := []byte(`{"Id":"` + container.ID + `","Name":"/` + name + `","HostConfig":{"PortBindings":{"80/tcp":[{"HostIp":"0.0.0.0","HostPort":"8080"}]}}}`)
+		data, err := insertContainerMetadata(data, container, cluster.ContainerRescheduleEligibility(cl, nil, container))
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(data, &metadata))
+		return metadata
+	}
+
+	metadata := inspect("web")
+	assert.Equal(t, "/web", metadata.Name)
+	assert.Equal(t, "node1", metadata.Node.Name)
+	assert.Equal(t, cluster.RescheduleEligibility{Eligible: true, Policies: []string{"on-node-failure"}}, metadata.Reschedule)
+	assert.Equal(t, "10.0.0.1", metadata.HostConfig.PortBindings["80/tcp"][0].HostIp)
+
+	// The policy is set, but the bind mount keeps the container on its node.
+	metadata = inspect("db")
+	assert.False(t, metadata.Reschedule.Eligible)
+	assert.Equal(t, []string{"on-node-failure"}, metadata.Reschedule.Policies)
+	assert.Equal(t, "local_mount", metadata.Reschedule.Reason)
+	assert.Contains(t, metadata.Reschedule.Error, "/srv/db")
+}
@@ -22,14 +22,15 @@ var (
 			ShortName: "m",
 			Usage:     "Manage a docker cluster",
 			Flags: []cli.Flag{
-				flStrategy, flFilter,
+				flStrategy, flFilter, flMinNodeHealth,
 				flHosts,
 				flLeaderElection, flLeaderTTL, flManageAdvertise,
 				flTLS, flTLSCaCert, flTLSCert, flTLSKey, flTLSVerify,
 				flRefreshIntervalMin, flRefreshIntervalMax, flFailureRetry, flRefreshRetry,
 				flHeartBeat,
 				flEnableCors,
-				flCluster, flDiscoveryOpt, flClusterOpt, flRefreshOnNodeFilter, flContainerNameRefreshFilter},
+				flCluster, flDiscoveryOpt, flClusterOpt, flWatchdogOpt, flRefreshOnNodeFilter, flContainerNameRefreshFilter,
+				flRecordEvents},
 			Action: manage,
 		},
 		{
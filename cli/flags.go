@@ -137,6 +137,11 @@ var (
 		Usage: "cluster driver options",
 		Value: &cli.StringSlice{},
 	}
+	flWatchdogOpt = cli.StringSliceFlag{
+		Name:  "watchdog-opt",
+		Usage: "rescheduling watchdog options",
+		Value: &cli.StringSlice{},
+	}
 	flDiscoveryOpt = cli.StringSliceFlag{
 		Name:  "discovery-opt",
 		Usage: "discovery options",
@@ -160,4 +165,12 @@ var (
 		Name:  "container-name-refresh-filter",
 		Usage: "If set, refresh the cache when a ContainerList call comes in with a name filter set to this value",
 	}
+	flMinNodeHealth = cli.Float64Flag{
+		Name:  "min-node-health",
+		Usage: "health between 0 and 1 below which the nodes are excluded from scheduling, and from the reschedule targets",
+	}
+	flRecordEvents = cli.StringFlag{
+		Name:  "record-events",
+		Usage: "If set, record the cluster events to this file, for the watchdog to replay them",
+	}
 )
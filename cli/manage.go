@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"strings"
 	"time"
@@ -27,6 +28,12 @@ import (
 const (
 	leaderElectionPath = "docker/swarm/leader"
 	defaultRecoverTime = 10 * time.Second
+	// rescheduleSwitchPath is where the reschedule switch is kept in the
+	// discovery store.
+	rescheduleSwitchPath = "docker/swarm/reschedule"
+	// rescheduleCheckpointPath is where the watchdog checkpoints the
+	// engines being rescheduled in the discovery store.
+	rescheduleCheckpointPath = "docker/swarm/reschedule-checkpoint"
 )
 
 type logHandler struct {
@@ -145,13 +152,31 @@ func getCandidateAndFollower(discovery discovery.Backend, addr string, leaderTTL
 	return candidate, follower
 }
 
-func setupReplication(c *cli.Context, cluster cluster.Cluster, server *api.Server, candidate *leadership.Candidate, follower *leadership.Follower, addr string, tlsConfig *tls.Config) {
-	primary := api.NewPrimary(cluster, tlsConfig, &statusHandler{cluster, candidate, follower}, c.GlobalBool("debug"), c.Bool("cors"))
+// newRescheduleSwitch creates the reschedule switch, kept in the discovery
+// store if there is one so that all the managers honor it.
+func newRescheduleSwitch(discovery discovery.Backend) *cluster.RescheduleSwitch {
+	if kvDiscovery, ok := discovery.(*kvdiscovery.Discovery); ok {
+		return cluster.NewRescheduleSwitch(kvDiscovery.Store(), path.Join(kvDiscovery.Prefix(), rescheduleSwitchPath))
+	}
+	return cluster.NewRescheduleSwitch(nil, "")
+}
+
+// newCheckpointStore creates the store of the watchdog checkpoints, kept in
+// the discovery store if it is a key/value store, in memory otherwise.
+func newCheckpointStore(discovery discovery.Backend) cluster.CheckpointStore {
+	if kvDiscovery, ok := discovery.(*kvdiscovery.Discovery); ok {
+		return cluster.NewKVCheckpointStore(kvDiscovery.Store(), path.Join(kvDiscovery.Prefix(), rescheduleCheckpointPath))
+	}
+	return cluster.NewMemoryCheckpointStore()
+}
+
+func setupReplication(c *cli.Context, cluster cluster.Cluster, watchdogOpts *cluster.WatchdogOpts, server *api.Server, candidate *leadership.Candidate, follower *leadership.Follower, addr string, tlsConfig *tls.Config) {
+	primary := api.NewPrimary(cluster, watchdogOpts, tlsConfig, &statusHandler{cluster, candidate, follower}, c.GlobalBool("debug"), c.Bool("cors"))
 	replica := api.NewReplica(primary, tlsConfig)
 
 	go func() {
 		for {
-			run(cluster, candidate, server, primary, replica)
+			run(cluster, watchdogOpts, candidate, server, primary, replica)
 			time.Sleep(defaultRecoverTime)
 		}
 	}()
@@ -166,7 +191,7 @@ func setupReplication(c *cli.Context, cluster cluster.Cluster, server *api.Serve
 	server.SetHandler(primary)
 }
 
-func run(cl cluster.Cluster, candidate *leadership.Candidate, server *api.Server, primary *mux.Router, replica *api.Replica) {
+func run(cl cluster.Cluster, watchdogOpts *cluster.WatchdogOpts, candidate *leadership.Candidate, server *api.Server, primary *mux.Router, replica *api.Replica) {
 	electedCh, errCh := candidate.RunForElection()
 	var watchdog *cluster.Watchdog
 	for {
@@ -174,11 +199,17 @@ func run(cl cluster.Cluster, candidate *leadership.Candidate, server *api.Server
 		case isElected := <-electedCh:
 			if isElected {
 				log.Info("Leader Election: Cluster leadership acquired")
-				watchdog = cluster.NewWatchdog(cl)
+				if watchdog == nil {
+					watchdog = cluster.NewWatchdog(cl, watchdogOpts)
+				} else {
+					watchdog.Promote()
+				}
 				server.SetHandler(primary)
 			} else {
 				log.Info("Leader Election: Cluster leadership lost")
-				cl.UnregisterEventHandler(watchdog)
+				if watchdog != nil {
+					watchdog.Demote()
+				}
 				server.SetHandler(replica)
 			}
 
@@ -258,10 +289,18 @@ func manage(c *cli.Context) {
 	if failureRetry <= 0 {
 		log.Fatal("invalid failure retry count")
 	}
+	watchdogOpts, err := cluster.NewWatchdogOpts(c.StringSlice("watchdog-opt"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	engineOpts := &cluster.EngineOpts{
 		RefreshMinInterval: refreshMinInterval,
 		RefreshMaxInterval: refreshMaxInterval,
 		FailureRetry:       failureRetry,
+		// The reschedules weighing in the actual memory utilization need the
+		// stats of the containers.
+		RefreshMemoryUsage: watchdogOpts.RescheduleMemoryUsageWeight > 0,
 	}
 
 	uri := getDiscovery(c)
@@ -269,6 +308,9 @@ func manage(c *cli.Context) {
 		log.Fatalf("discovery required to manage a cluster. See '%s manage --help'.", c.App.Name)
 	}
 	discovery := createDiscovery(uri, c)
+	watchdogOpts.Switch = newRescheduleSwitch(discovery)
+	watchdogOpts.Checkpoints = newCheckpointStore(discovery)
+	watchdogOpts.Quarantine = cluster.NewQuarantine()
 	s, err := strategy.New(c.String("strategy"))
 	if err != nil {
 		log.Fatal(err)
@@ -285,6 +327,11 @@ func manage(c *cli.Context) {
 	}
 
 	sched := scheduler.New(s, fs)
+	minNodeHealth := c.Float64("min-node-health")
+	if minNodeHealth < 0 || minNodeHealth > 1 {
+		log.Fatalf("min-node-health should be between 0 and 1, %v is invalid", minNodeHealth)
+	}
+	sched.SetMinNodeHealth(minNodeHealth)
 	var cl cluster.Cluster
 	switch c.String("cluster-driver") {
 	case "mesos-experimental":
@@ -299,6 +346,20 @@ func manage(c *cli.Context) {
 		log.Fatal(err)
 	}
 
+	if eventLog := c.String("record-events"); eventLog != "" {
+		f, err := os.OpenFile(eventLog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("unable to open the event log: %v", err)
+		}
+		// The log is kept open until the manager exits, the recorder
+		// syncs each event as the deferred calls don't run on exit.
+		recorder, err := cluster.NewEventRecorder(f, cl.Snapshot())
+		if err != nil {
+			log.Fatalf("unable to record the events: %v", err)
+		}
+		cl.RegisterEventHandler(recorder)
+	}
+
 	// see https://github.com/urfave/cli/issues/160
 	hosts := c.StringSlice("host")
 	if c.IsSet("host") || c.IsSet("H") {
@@ -330,10 +391,10 @@ func manage(c *cli.Context) {
 		// if necessary.
 		defer candidate.Resign()
 
-		setupReplication(c, cl, server, candidate, follower, addr, tlsConfig)
+		setupReplication(c, cl, watchdogOpts, server, candidate, follower, addr, tlsConfig)
 	} else {
-		server.SetHandler(api.NewPrimary(cl, tlsConfig, &statusHandler{cl, nil, nil}, c.GlobalBool("debug"), c.Bool("cors")))
-		cluster.NewWatchdog(cl)
+		server.SetHandler(api.NewPrimary(cl, watchdogOpts, tlsConfig, &statusHandler{cl, nil, nil}, c.GlobalBool("debug"), c.Bool("cors")))
+		cluster.NewWatchdog(cl, watchdogOpts)
 	}
 
 	log.Fatal(server.ListenAndServe())
@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// BandwidthLabel is the container label reserving network bandwidth on the
+// node of the container, in bits per second, e.g. 5G or 500Mbps.
+const BandwidthLabel = SwarmLabelNamespace + ".bandwidth"
+
+// NodeBandwidthLabel is the engine label advertising the NIC capacity of a
+// node, in bits per second. The nodes without it take any bandwidth.
+const NodeBandwidthLabel = "bandwidth"
+
+// ParseBandwidth parses a bandwidth in bits per second, as a decimal size with
+// an optional bps suffix, e.g. 10G, 10Gb or 10Gbps.
+func ParseBandwidth(val string) (int64, error) {
+	size := strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(val)), "ps"), "b")
+	bandwidth, err := units.FromHumanSize(size)
+	if err != nil || bandwidth < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %s", val)
+	}
+	return int64(bandwidth), nil
+}
+
+// Bandwidth returns the bandwidth the container reserves, as set by the
+// com.docker.swarm.bandwidth label. Containers without the label, or with an
+// invalid one, reserve none.
+func (c *ContainerConfig) Bandwidth() int64 {
+	val, ok := c.Labels[BandwidthLabel]
+	if !ok {
+		return 0
+	}
+	bandwidth, _ := ParseBandwidth(val)
+	return bandwidth
+}
+
+// BandwidthCapacity returns the NIC capacity advertised by the engine labels,
+// 0 if the node has no limit.
+func BandwidthCapacity(labels map[string]string) int64 {
+	val, ok := labels[NodeBandwidthLabel]
+	if !ok {
+		return 0
+	}
+	bandwidth, _ := ParseBandwidth(val)
+	return bandwidth
+}
+
+// UsedBandwidth returns the sum of the bandwidth reserved by the containers of
+// the engine.
+func (e *Engine) UsedBandwidth() int64 {
+	var r int64
+	e.RLock()
+	for _, c := range e.containers {
+		r += c.Config.Bandwidth()
+	}
+	e.RUnlock()
+	return r
+}
+
+// TotalBandwidth returns the NIC capacity of the engine, 0 if unlimited.
+func (e *Engine) TotalBandwidth() int64 {
+	return BandwidthCapacity(e.Labels)
+}
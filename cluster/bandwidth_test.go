@@ -0,0 +1,40 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for val, expected := range map[string]int64{
+		"10G":    10000000000,
+		"10Gb":   10000000000,
+		"10Gbps": 10000000000,
+		"500M":   500000000,
+		" 1k ":   1000,
+		"800":    800,
+	} {
+		bandwidth, err := ParseBandwidth(val)
+		assert.NoError(t, err, val)
+		assert.Equal(t, expected, bandwidth, val)
+	}
+	for _, val := range []string{"", "fast", "-1G", "10Gbpss"} {
+		_, err := ParseBandwidth(val)
+		assert.Error(t, err, val)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, int64(0), config.Bandwidth())
+	config.Labels[BandwidthLabel] = "5Gbps"
+	assert.Equal(t, int64(5000000000), config.Bandwidth())
+	config.Labels[BandwidthLabel] = "lots"
+	assert.Equal(t, int64(0), config.Bandwidth())
+
+	assert.Equal(t, int64(0), BandwidthCapacity(map[string]string{}))
+	assert.Equal(t, int64(10000000000), BandwidthCapacity(map[string]string{NodeBandwidthLabel: "10G"}))
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+)
+
+// failureBreaker tracks the node failures over a sliding window, and trips
+// when too many nodes fail within it, e.g. during a bad rollout. It resets
+// once no node failed for a whole window.
+type failureBreaker struct {
+	sync.Mutex
+	failures []time.Time
+	tripped  bool
+}
+
+// prune forgets the failures older than the window, the breaker being
+// locked.
+func (b *failureBreaker) prune(window time.Duration, now time.Time) {
+	i := 0
+	for i < len(b.failures) && now.Sub(b.failures[i]) > window {
+		i++
+	}
+	b.failures = b.failures[i:]
+}
+
+// record records a node failure. It returns true if the failure tripped the
+// breaker, more than threshold nodes having failed within the window.
+func (b *failureBreaker) record(threshold int, window time.Duration, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.prune(window, now)
+	b.failures = append(b.failures, now)
+	if b.tripped || len(b.failures) <= threshold {
+		return false
+	}
+	b.tripped = true
+	return true
+}
+
+// check returns the time at which the breaker may reset, zero if it is not
+// tripped. reset is true if this check reset it.
+func (b *failureBreaker) check(window time.Duration, now time.Time) (until time.Time, reset bool) {
+	b.Lock()
+	defer b.Unlock()
+	if !b.tripped {
+		return time.Time{}, false
+	}
+	b.prune(window, now)
+	if len(b.failures) == 0 {
+		b.tripped = false
+		return time.Time{}, true
+	}
+	return b.failures[len(b.failures)-1].Add(window), false
+}
@@ -0,0 +1,96 @@
+package cluster
+
+import "sort"
+
+// NodeCapacity is the capacity of a node, as accounted by the scheduler.
+type NodeCapacity struct {
+	ID      string
+	Name    string
+	Healthy bool
+
+	TotalMemory     int64
+	ReservedMemory  int64
+	AvailableMemory int64
+	TotalCpus       int64
+	ReservedCpus    int64
+	AvailableCpus   int64
+}
+
+// CapacityReport is the capacity of the cluster, e.g. for autoscalers to add
+// nodes before the remaining ones are overwhelmed.
+type CapacityReport struct {
+	// The totals only count the healthy nodes.
+	TotalMemory     int64
+	ReservedMemory  int64
+	AvailableMemory int64
+	TotalCpus       int64
+	ReservedCpus    int64
+	AvailableCpus   int64
+	// MaxContainerMemory and MaxContainerCpus are the largest memory and
+	// CPU reservations a single container can get on a healthy node, each
+	// regardless of the other.
+	MaxContainerMemory int64
+	MaxContainerCpus   int64
+	// Nodes are all the nodes, sorted by name.
+	Nodes []NodeCapacity
+}
+
+// NewCapacityReport returns the capacity report of the nodes, whose available
+// resources are computed from their total and reserved ones. It is the
+// implementation of Cluster.Capacity shared by the clusters.
+func NewCapacityReport(nodes []NodeCapacity) CapacityReport {
+	report := CapacityReport{Nodes: make([]NodeCapacity, len(nodes))}
+	for i, n := range nodes {
+		n.AvailableMemory = n.TotalMemory - n.ReservedMemory
+		if n.AvailableMemory < 0 {
+			n.AvailableMemory = 0
+		}
+		n.AvailableCpus = n.TotalCpus - n.ReservedCpus
+		if n.AvailableCpus < 0 {
+			n.AvailableCpus = 0
+		}
+		report.Nodes[i] = n
+		if !n.Healthy {
+			continue
+		}
+
+		report.TotalMemory += n.TotalMemory
+		report.ReservedMemory += n.ReservedMemory
+		report.AvailableMemory += n.AvailableMemory
+		report.TotalCpus += n.TotalCpus
+		report.ReservedCpus += n.ReservedCpus
+		report.AvailableCpus += n.AvailableCpus
+		if n.AvailableMemory > report.MaxContainerMemory {
+			report.MaxContainerMemory = n.AvailableMemory
+		}
+		if n.AvailableCpus > report.MaxContainerCpus {
+			report.MaxContainerCpus = n.AvailableCpus
+		}
+	}
+	sort.Sort(nodeCapacitiesByName(report.Nodes))
+	return report
+}
+
+type nodeCapacitiesByName []NodeCapacity
+
+func (n nodeCapacitiesByName) Len() int           { return len(n) }
+func (n nodeCapacitiesByName) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n nodeCapacitiesByName) Less(i, j int) bool { return n[i].Name < n[j].Name }
+
+// engineCapacities returns the capacity of the engines, from the containers
+// they run.
+func engineCapacities(engines []*Engine) []NodeCapacity {
+	nodes := make([]NodeCapacity, 0, len(engines))
+	for _, e := range engines {
+		nodes = append(nodes, NodeCapacity{
+			ID:             e.ID,
+			Name:           e.Name,
+			Healthy:        e.IsHealthy(),
+			TotalMemory:    e.TotalMemory(),
+			ReservedMemory: e.UsedMemory(),
+			TotalCpus:      e.TotalCpus(),
+			ReservedCpus:   e.UsedCpus(),
+		})
+	}
+	return nodes
+}
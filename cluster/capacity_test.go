@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func reservingConfig(memory, cpus int64) *ContainerConfig {
+	return BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{
+		Resources: containertypes.Resources{Memory: memory, CPUShares: cpus},
+	}, networktypes.NetworkingConfig{})
+}
+
+func TestCapacity(t *testing.T) {
+	c := NewReplayCluster(ClusterState{Nodes: []NodeState{
+		{ID: "node2", Name: "node2", TotalMemory: 4096, TotalCpus: 4, HealthIndicator: 100, Containers: []ContainerState{
+			{ID: "c1", Names: []string{"/c1"}, Running: true, Config: reservingConfig(2048, 1)},
+		}},
+		{ID: "node1", Name: "node1", TotalMemory: 2048, TotalCpus: 8, HealthIndicator: 100, Containers: []ContainerState{
+			{ID: "c2", Names: []string{"/c2"}, Running: true, Config: reservingConfig(512, 2)},
+			{ID: "c3", Names: []string{"/c3"}, Running: true, Config: reservingConfig(512, 2)},
+		}},
+		// The unhealthy nodes are listed, but not counted.
+		{ID: "node3", Name: "node3", TotalMemory: 8192, TotalCpus: 16, Containers: []ContainerState{
+			{ID: "c4", Names: []string{"/c4"}, Running: true, Config: reservingConfig(1024, 1)},
+		}},
+	}}, nil)
+
+	report := c.Capacity()
+	assert.Equal(t, int64(6144), report.TotalMemory)
+	assert.Equal(t, int64(3072), report.ReservedMemory)
+	assert.Equal(t, int64(3072), report.AvailableMemory)
+	assert.Equal(t, int64(12), report.TotalCpus)
+	assert.Equal(t, int64(5), report.ReservedCpus)
+	assert.Equal(t, int64(7), report.AvailableCpus)
+	// The largest container fits on node2 for memory, on node1 for CPUs.
+	assert.Equal(t, int64(2048), report.MaxContainerMemory)
+	assert.Equal(t, int64(4), report.MaxContainerCpus)
+
+	assert.Equal(t, []NodeCapacity{
+		{ID: "node1", Name: "node1", Healthy: true, TotalMemory: 2048, ReservedMemory: 1024, AvailableMemory: 1024, TotalCpus: 8, ReservedCpus: 4, AvailableCpus: 4},
+		{ID: "node2", Name: "node2", Healthy: true, TotalMemory: 4096, ReservedMemory: 2048, AvailableMemory: 2048, TotalCpus: 4, ReservedCpus: 1, AvailableCpus: 3},
+		{ID: "node3", Name: "node3", TotalMemory: 8192, ReservedMemory: 1024, AvailableMemory: 7168, TotalCpus: 16, ReservedCpus: 1, AvailableCpus: 15},
+	}, report.Nodes)
+}
+
+func TestCapacityOvercommitted(t *testing.T) {
+	// The nodes reserving more than they have have nothing available.
+	report := NewCapacityReport([]NodeCapacity{
+		{ID: "node1", Name: "node1", Healthy: true, TotalMemory: 1024, ReservedMemory: 2048, TotalCpus: 1, ReservedCpus: 2},
+	})
+	assert.Equal(t, int64(0), report.AvailableMemory)
+	assert.Equal(t, int64(0), report.AvailableCpus)
+	assert.Equal(t, int64(0), report.Nodes[0].AvailableMemory)
+	assert.Equal(t, int64(0), report.MaxContainerMemory)
+}
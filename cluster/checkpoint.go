@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// Checkpoint is the in-flight state of a watchdog: the engines whose
+// containers are being rescheduled. It lets the watchdog of a new primary
+// resume the reschedules where the previous primary left them, rather than
+// start them over.
+type Checkpoint struct {
+	// Engines holds the engines being rescheduled, by engine ID.
+	Engines map[string]*EngineCheckpoint
+}
+
+// EngineCheckpoint is the state of the rescheduling of an engine.
+type EngineCheckpoint struct {
+	Trigger RescheduleTrigger
+	// Started is when the failure of the engine was detected.
+	Started time.Time
+	// Attempt is the number of failed passes since a container of the
+	// engine was last rescheduled.
+	Attempt int
+	// Attempts holds the reschedule attempts of the containers left on the
+	// engine, by container ID.
+	Attempts map[string]int
+}
+
+// CheckpointStore persists the checkpoints of a watchdog.
+type CheckpointStore interface {
+	// Load returns the last saved checkpoint, an empty one if none was.
+	Load() (*Checkpoint, error)
+	Save(checkpoint *Checkpoint) error
+}
+
+// memoryCheckpointStore keeps the checkpoints in memory, they only survive
+// the demotion of the manager, not its restart.
+type memoryCheckpointStore struct {
+	sync.Mutex
+	data []byte
+}
+
+// NewMemoryCheckpointStore creates a checkpoint store local to the manager.
+func NewMemoryCheckpointStore() CheckpointStore {
+	return &memoryCheckpointStore{}
+}
+
+func (s *memoryCheckpointStore) Load() (*Checkpoint, error) {
+	s.Lock()
+	defer s.Unlock()
+	return decodeCheckpoint(s.data)
+}
+
+func (s *memoryCheckpointStore) Save(checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.data = data
+	s.Unlock()
+	return nil
+}
+
+// kvCheckpointStore keeps the checkpoints at a key of the store shared by
+// the managers.
+type kvCheckpointStore struct {
+	store KVStore
+	key   string
+}
+
+// NewKVCheckpointStore creates a checkpoint store persisting the checkpoints
+// at the given key of the store.
+func NewKVCheckpointStore(kv KVStore, key string) CheckpointStore {
+	return &kvCheckpointStore{store: kv, key: key}
+}
+
+func (s *kvCheckpointStore) Load() (*Checkpoint, error) {
+	pair, err := s.store.Get(s.key)
+	if err == store.ErrKeyNotFound {
+		return decodeCheckpoint(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeCheckpoint(pair.Value)
+}
+
+func (s *kvCheckpointStore) Save(checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(s.key, data, nil)
+}
+
+// decodeCheckpoint decodes a saved checkpoint, nil data being an empty one.
+func decodeCheckpoint(data []byte) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, checkpoint); err != nil {
+			return nil, err
+		}
+	}
+	if checkpoint.Engines == nil {
+		checkpoint.Engines = make(map[string]*EngineCheckpoint)
+	}
+	return checkpoint, nil
+}
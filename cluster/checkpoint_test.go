@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	kv := &fakeKVStore{values: make(map[string][]byte)}
+	started := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, s := range []CheckpointStore{NewMemoryCheckpointStore(), NewKVCheckpointStore(kv, "swarm/watchdog/checkpoint")} {
+		// Nothing was saved yet.
+		checkpoint, err := s.Load()
+		assert.NoError(t, err)
+		assert.Empty(t, checkpoint.Engines)
+
+		checkpoint.Engines["node1"] = &EngineCheckpoint{
+			Trigger:  TriggerEngineDisconnect,
+			Started:  started,
+			Attempt:  2,
+			Attempts: map[string]int{"c1": 3},
+		}
+		assert.NoError(t, s.Save(checkpoint))
+
+		// The checkpoint is a copy.
+		checkpoint.Engines["node1"].Attempt = 5
+		restored, err := s.Load()
+		assert.NoError(t, err)
+		if assert.Contains(t, restored.Engines, "node1") {
+			state := restored.Engines["node1"]
+			assert.Equal(t, TriggerEngineDisconnect, state.Trigger)
+			assert.True(t, started.Equal(state.Started))
+			assert.Equal(t, 2, state.Attempt)
+			assert.Equal(t, map[string]int{"c1": 3}, state.Attempts)
+		}
+	}
+	assert.Contains(t, kv.values, "swarm/watchdog/checkpoint")
+
+	// The store errors are reported.
+	kv.err = errors.New("store unreachable")
+	s := NewKVCheckpointStore(kv, "swarm/watchdog/checkpoint")
+	_, err := s.Load()
+	assert.Error(t, err)
+	assert.Error(t, s.Save(&Checkpoint{}))
+}
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"errors"
 	"io"
 
 	"github.com/docker/docker/api/types"
@@ -8,6 +9,9 @@ import (
 	"github.com/samalba/dockerclient"
 )
 
+// ErrNoHealthyEngine is returned when no engine of the cluster is healthy.
+var ErrNoHealthyEngine = errors.New("No healthy engine available in the cluster")
+
 // Cluster is exported
 type Cluster interface {
 	// CreateContainer creates a container.
@@ -88,13 +92,39 @@ type Cluster interface {
 	// UnregisterEventHandler unregisters an event handler.
 	UnregisterEventHandler(h EventHandler)
 
+	// SelectEngine returns the healthy engine a container with the given
+	// config would be scheduled on, or ErrNoHealthyEngine.
+	SelectEngine(config *ContainerConfig) (*Engine, error)
+
+	// ReserveEngine selects the engine like SelectEngine and reserves the
+	// resources of the config on it until release is called, for the
+	// concurrent placements to account for the container about to be
+	// created there. The containers created with the config or its copies
+	// draw on the reservation.
+	ReserveEngine(config *ContainerConfig) (e *Engine, release func(), err error)
+
+	// Engine returns the engine with the given ID or name, nil if none.
+	Engine(IDOrName string) *Engine
+
+	// FreeCapacity returns the memory and CPUs not reserved by containers on
+	// the healthy engines.
+	FreeCapacity() (memory int64, cpus int64)
+
+	// Capacity returns the total, reserved and available resources of the
+	// cluster and of each of its nodes.
+	Capacity() CapacityReport
+
 	// FIXME: remove this method
-	// RANDOMENGINE returns a random engine.
+	// RANDOMENGINE returns a random healthy engine, or ErrNoHealthyEngine.
 	RANDOMENGINE() (*Engine, error)
 
 	// RenameContainer renames a container.
 	RenameContainer(container *Container, newName string) error
 
+	// MoveContainer moves a container to another engine, the target if not
+	// nil, preserving its config and its global networks.
+	MoveContainer(container *Container, target *Engine, opts MoveOpts) (*MoveResult, error)
+
 	// BuildImage builds an image.
 	BuildImage(io.Reader, *types.ImageBuildOptions, io.Writer) error
 
@@ -106,4 +136,8 @@ type Cluster interface {
 
 	// RefreshEngines refreshes all engines in the cluster.
 	RefreshEngines() error
+
+	// Snapshot returns the current placement of the containers on the
+	// engines of the cluster.
+	Snapshot() ClusterState
 }
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
@@ -163,6 +165,22 @@ func (c *ContainerConfig) SetSwarmID(id string) {
 	c.Labels[SwarmLabelNamespace+".id"] = id
 }
 
+// Reservation returns the key of the engine reservation the container is
+// created with, as set by Cluster.ReserveEngine.
+func (c *ContainerConfig) Reservation() string {
+	return c.Labels[SwarmLabelNamespace+".reservation"]
+}
+
+// SetReservation sets the key of the engine reservation the container is
+// created with, an empty key removes it.
+func (c *ContainerConfig) SetReservation(key string) {
+	if key == "" {
+		delete(c.Labels, SwarmLabelNamespace+".reservation")
+		return
+	}
+	c.Labels[SwarmLabelNamespace+".reservation"] = key
+}
+
 // Affinities returns all the affinities from the ContainerConfig
 func (c *ContainerConfig) Affinities() []string {
 	return c.extractExprs("affinities")
@@ -246,6 +264,22 @@ func (c *ContainerConfig) AddConstraint(constraint string) error {
 	return nil
 }
 
+// RemoveConstraint from config
+func (c *ContainerConfig) RemoveConstraint(constraint string) error {
+	constraints := []string{}
+	for _, e := range c.extractExprs("constraints") {
+		if e != constraint {
+			constraints = append(constraints, e)
+		}
+	}
+	labels, err := json.Marshal(constraints)
+	if err != nil {
+		return err
+	}
+	c.Labels[SwarmLabelNamespace+".constraints"] = string(labels)
+	return nil
+}
+
 // HaveNodeConstraint in config
 func (c *ContainerConfig) HaveNodeConstraint() bool {
 	constraints := c.extractExprs("constraints")
@@ -258,6 +292,35 @@ func (c *ContainerConfig) HaveNodeConstraint() bool {
 	return false
 }
 
+// HasConstraintOn returns true if the config has a constraint on the given
+// node label.
+func (c *ContainerConfig) HasConstraintOn(key string) bool {
+	for _, constraint := range c.extractExprs("constraints") {
+		if strings.HasPrefix(constraint, key) && strings.IndexAny(constraint[len(key):], "=!<>") == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PlacementOnlyConstraints returns the constraints qualified with @, e.g.
+// rack!=@r1, which apply when the container is created but not when it is
+// rescheduled.
+func (c *ContainerConfig) PlacementOnlyConstraints() []string {
+	constraints := []string{}
+	for _, constraint := range c.extractExprs("constraints") {
+		i := strings.IndexAny(constraint, "=!<>")
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimLeft(constraint[i:], "=!<>")
+		if strings.Contains(value[:len(value)-len(strings.TrimLeft(value, "~@"))], "@") {
+			constraints = append(constraints, constraint)
+		}
+	}
+	return constraints
+}
+
 // HasReschedulePolicy returns true if the specified policy is part of the config
 func (c *ContainerConfig) HasReschedulePolicy(p string) bool {
 	for _, reschedulePolicy := range c.extractExprs("reschedule-policies") {
@@ -268,22 +331,196 @@ func (c *ContainerConfig) HasReschedulePolicy(p string) bool {
 	return false
 }
 
+// ReschedulePriority returns the reschedule priority of the container, taken
+// from the com.docker.swarm.reschedule-priority label. Containers without the
+// label have a priority of 0.
+func (c *ContainerConfig) ReschedulePriority() int {
+	priority, _ := strconv.Atoi(c.Labels[SwarmLabelNamespace+".reschedule-priority"])
+	return priority
+}
+
+// MemoryUsageWeight returns the weight, between 0 and 1, the scheduler gives
+// to the actual memory utilization of the nodes over the ranking of the
+// placement strategy, as set by the com.docker.swarm.memory-usage-weight
+// label. Containers without the label have a weight of 0.
+func (c *ContainerConfig) MemoryUsageWeight() float64 {
+	weight, err := strconv.ParseFloat(c.Labels[SwarmLabelNamespace+".memory-usage-weight"], 64)
+	if err != nil || weight < 0 || weight > 1 {
+		return 0
+	}
+	return weight
+}
+
+// CPUOvercommit returns the ratio, 1 or more, of the CPUs of the nodes the
+// scheduler may reserve for a container when no node has the CPUs it
+// reserves, as set by the com.docker.swarm.cpu-overcommit label. Containers
+// without the label, or with an invalid one, have a ratio of 1: they are not
+// placed on overcommitted nodes.
+func (c *ContainerConfig) CPUOvercommit() float64 {
+	ratio, err := strconv.ParseFloat(c.Labels[SwarmLabelNamespace+".cpu-overcommit"], 64)
+	if err != nil || ratio < 1 {
+		return 1
+	}
+	return ratio
+}
+
+// SchedulingWeight returns the share of a node, in percent, a container is
+// expected to use on top of its reservations, as set by the
+// com.docker.swarm.scheduling-weight label, so that heavy containers without
+// reservations still count towards the load of their node. Containers without
+// the label, or with an invalid one, weigh 0.
+func (c *ContainerConfig) SchedulingWeight() int64 {
+	weight, err := strconv.ParseInt(c.Labels[SwarmLabelNamespace+".scheduling-weight"], 10, 64)
+	if err != nil || weight < 0 || weight > 100 {
+		return 0
+	}
+	return weight
+}
+
+// NoAutoDedup returns true if the container must not be removed when a
+// duplicate of it is found, as set by the com.docker.swarm.no-auto-dedup
+// label.
+func (c *ContainerConfig) NoAutoDedup() bool {
+	noAutoDedup, _ := strconv.ParseBool(c.Labels[SwarmLabelNamespace+".no-auto-dedup"])
+	return noAutoDedup
+}
+
+// The fence modes of the stale copies of the rescheduled containers.
+const (
+	// FenceKill kills the stale copies right away.
+	FenceKill = "kill"
+	// FenceGraceful stops the stale copies, killing them if they are still
+	// running after the fence timeout.
+	FenceGraceful = "graceful"
+)
+
+// FenceMode returns how the stale copies of the container are stopped when
+// their node returns, by the fencing and the deduplication, as set by the
+// com.docker.swarm.fence-mode label, and the timeout of a graceful stop set
+// by the com.docker.swarm.fence-timeout label, nil for the stop timeout of
+// the container. Containers without the label are stopped as the stale
+// container policy and the deduplication do by default.
+func (c *ContainerConfig) FenceMode() (string, *time.Duration) {
+	mode := c.Labels[SwarmLabelNamespace+".fence-mode"]
+	if mode != FenceKill && mode != FenceGraceful {
+		return "", nil
+	}
+	if timeout, err := time.ParseDuration(c.Labels[SwarmLabelNamespace+".fence-timeout"]); err == nil && timeout >= 0 {
+		return mode, &timeout
+	}
+	return mode, nil
+}
+
+// CpusetMaxCPU returns the highest ID of the CPUs the container is pinned to
+// by its cpuset, e.g. 3 for "0-3". ok is false if the container isn't pinned
+// or its cpuset is invalid.
+func (c *ContainerConfig) CpusetMaxCPU() (max int64, ok bool) {
+	if c.HostConfig.CpusetCpus == "" {
+		return 0, false
+	}
+	for _, cpus := range strings.Split(c.HostConfig.CpusetCpus, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(cpus), "-", 2)
+		for _, bound := range bounds {
+			cpu, err := strconv.ParseInt(bound, 10, 64)
+			if err != nil || cpu < 0 {
+				return 0, false
+			}
+			if cpu > max {
+				max = cpu
+			}
+		}
+	}
+	return max, true
+}
+
+// NetworkOrder returns the networks to attach a rescheduled container to
+// first, in order, as set by the comma separated
+// com.docker.swarm.network-order label.
+func (c *ContainerConfig) NetworkOrder() []string {
+	order := []string{}
+	for _, name := range strings.Split(c.Labels[SwarmLabelNamespace+".network-order"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			order = append(order, name)
+		}
+	}
+	return order
+}
+
+// RescheduleWindows returns the daily windows during which the container may
+// be rescheduled, taken from the com.docker.swarm.reschedule-windows label.
+// ok is false if the label isn't set.
+func (c *ContainerConfig) RescheduleWindows() (windows TimeWindows, ok bool, err error) {
+	val, ok := c.Labels[SwarmLabelNamespace+".reschedule-windows"]
+	if !ok {
+		return nil, false, nil
+	}
+	windows, err = ParseTimeWindows(val)
+	return windows, true, err
+}
+
+// Strategy returns the placement strategy requested for the container through
+// the com.docker.swarm.strategy label, or an empty string to use the default
+// strategy of the cluster.
+func (c *ContainerConfig) Strategy() string {
+	return c.Labels[SwarmLabelNamespace+".strategy"]
+}
+
 // Validate returns an error if the config isn't valid
 func (c *ContainerConfig) Validate() error {
 	//TODO: add validation for affinities and constraints
 	reschedulePolicies := c.extractExprs("reschedule-policies")
-	if len(reschedulePolicies) > 1 {
-		return errors.New("too many reschedule policies")
-	} else if len(reschedulePolicies) == 1 {
+	for _, reschedulePolicy := range reschedulePolicies {
 		valid := false
-		for _, validReschedulePolicy := range []string{"off", "on-node-failure"} {
-			if reschedulePolicies[0] == validReschedulePolicy {
+		for _, validReschedulePolicy := range []string{"off", "on-node-failure", "on-node-drain"} {
+			if reschedulePolicy == validReschedulePolicy {
 				valid = true
 			}
 		}
 		if !valid {
-			return fmt.Errorf("invalid reschedule policy: %s", reschedulePolicies[0])
+			return fmt.Errorf("invalid reschedule policy: %s", reschedulePolicy)
 		}
+		// Only the node failure and drain policies may be combined.
+		if reschedulePolicy == "off" && len(reschedulePolicies) > 1 {
+			return errors.New("too many reschedule policies")
+		}
+	}
+
+	if priority, ok := c.Labels[SwarmLabelNamespace+".reschedule-priority"]; ok {
+		if _, err := strconv.Atoi(priority); err != nil {
+			return fmt.Errorf("invalid reschedule priority: %s", priority)
+		}
+	}
+
+	if weight, ok := c.Labels[SwarmLabelNamespace+".memory-usage-weight"]; ok {
+		if val, err := strconv.ParseFloat(weight, 64); err != nil || val < 0 || val > 1 {
+			return fmt.Errorf("invalid memory usage weight: %s", weight)
+		}
+	}
+
+	if ratio, ok := c.Labels[SwarmLabelNamespace+".cpu-overcommit"]; ok {
+		if val, err := strconv.ParseFloat(ratio, 64); err != nil || val < 1 {
+			return fmt.Errorf("invalid CPU overcommit: %s", ratio)
+		}
+	}
+
+	if weight, ok := c.Labels[SwarmLabelNamespace+".scheduling-weight"]; ok {
+		if val, err := strconv.ParseInt(weight, 10, 64); err != nil || val < 0 || val > 100 {
+			return fmt.Errorf("invalid scheduling weight: %s", weight)
+		}
+	}
+
+	if mode, ok := c.Labels[SwarmLabelNamespace+".fence-mode"]; ok && mode != FenceKill && mode != FenceGraceful {
+		return fmt.Errorf("invalid fence mode: %s", mode)
+	}
+
+	if timeout, ok := c.Labels[SwarmLabelNamespace+".fence-timeout"]; ok {
+		if val, err := time.ParseDuration(timeout); err != nil || val < 0 {
+			return fmt.Errorf("invalid fence timeout: %s", timeout)
+		}
+	}
+
+	if _, _, err := c.RescheduleWindows(); err != nil {
+		return fmt.Errorf("invalid reschedule windows: %v", err)
 	}
 
 	return nil
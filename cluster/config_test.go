@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
@@ -104,3 +105,178 @@ func TestHaveNodeConstraint(t *testing.T) {
 	config = BuildContainerConfig(container.Config{Env: []string{"constraint:node==node1"}}, container.HostConfig{}, network.NetworkingConfig{})
 	assert.True(t, config.HaveNodeConstraint())
 }
+
+func TestHasConstraintOn(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.False(t, config.HasConstraintOn("lifecycle"))
+
+	config = BuildContainerConfig(container.Config{Env: []string{"constraint:lifecycle!=spot", "constraint:region==~eu"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.True(t, config.HasConstraintOn("lifecycle"))
+	assert.True(t, config.HasConstraintOn("region"))
+	assert.False(t, config.HasConstraintOn("life"))
+	assert.False(t, config.HasConstraintOn("node"))
+}
+
+func TestPlacementOnlyConstraints(t *testing.T) {
+	config := BuildContainerConfig(container.Config{Env: []string{"constraint:rack!=@r1", "constraint:region==~@eu", "constraint:region==~us", "constraint:node==node1"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, []string{"rack!=@r1", "region==~@eu"}, config.PlacementOnlyConstraints())
+
+	config = BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Empty(t, config.PlacementOnlyConstraints())
+}
+
+func TestValidateReschedulePolicies(t *testing.T) {
+	for policies, valid := range map[string]bool{
+		`["off"]`:                              true,
+		`["on-node-failure"]`:                  true,
+		`["on-node-drain"]`:                    true,
+		`["on-node-failure", "on-node-drain"]`: true,
+		`["off", "on-node-drain"]`:             false,
+		`["always"]`:                           false,
+	} {
+		config := BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".reschedule-policies": policies}}, container.HostConfig{}, network.NetworkingConfig{})
+		if valid {
+			assert.NoError(t, config.Validate(), policies)
+		} else {
+			assert.Error(t, config.Validate(), policies)
+		}
+	}
+}
+
+func TestMemoryUsageWeight(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, 0.0, config.MemoryUsageWeight())
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".memory-usage-weight": "0.3"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, 0.3, config.MemoryUsageWeight())
+	assert.NoError(t, config.Validate())
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".memory-usage-weight": "1.5"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, 0.0, config.MemoryUsageWeight())
+	assert.Error(t, config.Validate())
+}
+
+func TestCPUOvercommit(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, 1.0, config.CPUOvercommit())
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".cpu-overcommit": "1.5"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, 1.5, config.CPUOvercommit())
+	assert.NoError(t, config.Validate())
+
+	for _, ratio := range []string{"0.5", "-1", "lots"} {
+		config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".cpu-overcommit": ratio}}, container.HostConfig{}, network.NetworkingConfig{})
+		assert.Equal(t, 1.0, config.CPUOvercommit(), ratio)
+		assert.Error(t, config.Validate(), ratio)
+	}
+}
+
+func TestSchedulingWeight(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, int64(0), config.SchedulingWeight())
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".scheduling-weight": "25"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, int64(25), config.SchedulingWeight())
+	assert.NoError(t, config.Validate())
+
+	for _, weight := range []string{"150", "-1", "heavy"} {
+		config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".scheduling-weight": weight}}, container.HostConfig{}, network.NetworkingConfig{})
+		assert.Equal(t, int64(0), config.SchedulingWeight(), weight)
+		assert.Error(t, config.Validate(), weight)
+	}
+}
+
+func TestReschedulePriority(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, 0, config.ReschedulePriority())
+	assert.NoError(t, config.Validate())
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".reschedule-priority": "10"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, 10, config.ReschedulePriority())
+	assert.NoError(t, config.Validate())
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".reschedule-priority": "high"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Error(t, config.Validate())
+}
+
+func TestNoAutoDedup(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.False(t, config.NoAutoDedup())
+
+	config = BuildContainerConfig(container.Config{
+		Labels: map[string]string{"com.docker.swarm.no-auto-dedup": "true"},
+	}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.True(t, config.NoAutoDedup())
+}
+
+func TestFenceMode(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	mode, timeout := config.FenceMode()
+	assert.Equal(t, "", mode)
+	assert.Nil(t, timeout)
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".fence-mode": "kill"}}, container.HostConfig{}, network.NetworkingConfig{})
+	mode, timeout = config.FenceMode()
+	assert.Equal(t, FenceKill, mode)
+	assert.Nil(t, timeout)
+	assert.NoError(t, config.Validate())
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{
+		SwarmLabelNamespace + ".fence-mode":    "graceful",
+		SwarmLabelNamespace + ".fence-timeout": "2m",
+	}}, container.HostConfig{}, network.NetworkingConfig{})
+	mode, timeout = config.FenceMode()
+	assert.Equal(t, FenceGraceful, mode)
+	if assert.NotNil(t, timeout) {
+		assert.Equal(t, 2*time.Minute, *timeout)
+	}
+	assert.NoError(t, config.Validate())
+
+	for _, labels := range []map[string]string{
+		{SwarmLabelNamespace + ".fence-mode": "sigterm"},
+		{SwarmLabelNamespace + ".fence-timeout": "soon"},
+		{SwarmLabelNamespace + ".fence-timeout": "-1s"},
+	} {
+		config = BuildContainerConfig(container.Config{Labels: labels}, container.HostConfig{}, network.NetworkingConfig{})
+		assert.Error(t, config.Validate(), "%v", labels)
+	}
+}
+
+func TestCpusetMaxCPU(t *testing.T) {
+	for cpuset, expected := range map[string]int64{"0": 0, "0-3": 3, "1,5": 5, "0-2,7, 4-5": 7} {
+		config := BuildContainerConfig(container.Config{}, container.HostConfig{Resources: container.Resources{CpusetCpus: cpuset}}, network.NetworkingConfig{})
+		max, ok := config.CpusetMaxCPU()
+		assert.True(t, ok, cpuset)
+		assert.Equal(t, expected, max, cpuset)
+	}
+	for _, cpuset := range []string{"", "a-b", "-1", "0-"} {
+		config := BuildContainerConfig(container.Config{}, container.HostConfig{Resources: container.Resources{CpusetCpus: cpuset}}, network.NetworkingConfig{})
+		_, ok := config.CpusetMaxCPU()
+		assert.False(t, ok, cpuset)
+	}
+}
+
+func TestRescheduleWindows(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	_, ok, err := config.RescheduleWindows()
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".reschedule-windows": "22:00-06:00"}}, container.HostConfig{}, network.NetworkingConfig{})
+	windows, ok, err := config.RescheduleWindows()
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, TimeWindows{{Start: 22 * time.Hour, End: 6 * time.Hour}}, windows)
+	assert.NoError(t, config.Validate())
+
+	config = BuildContainerConfig(container.Config{Labels: map[string]string{SwarmLabelNamespace + ".reschedule-windows": "nights"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Error(t, config.Validate())
+}
+
+func TestRemoveConstraint(t *testing.T) {
+	config := BuildContainerConfig(container.Config{Env: []string{"constraint:node==node1", "constraint:region==us"}}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.NoError(t, config.RemoveConstraint("node==node1"))
+	assert.Equal(t, []string{"region==us"}, config.Constraints())
+	assert.NoError(t, config.RemoveConstraint("unknown"))
+	assert.Equal(t, []string{"region==us"}, config.Constraints())
+}
@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rescheduleCooldownLabel records the constraints deprioritizing the targets
+// in cooldown when the container was rescheduled.
+const rescheduleCooldownLabel = SwarmLabelNamespace + ".reschedule-cooldown"
+
+// targetCooldowns tracks the recent reschedules onto each target, to give the
+// targets which just took many containers the time to start them.
+type targetCooldowns struct {
+	sync.Mutex
+	placements map[string][]time.Time
+}
+
+func newTargetCooldowns() *targetCooldowns {
+	return &targetCooldowns{placements: make(map[string][]time.Time)}
+}
+
+// record records a container rescheduled onto the engine.
+func (t *targetCooldowns) record(engineID string, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	t.placements[engineID] = append(t.placements[engineID], now)
+}
+
+// cooling returns the sorted IDs of the engines which took at least limit
+// containers within the window. The placements out of the window are
+// forgotten.
+func (t *targetCooldowns) cooling(limit int, window time.Duration, now time.Time) []string {
+	t.Lock()
+	defer t.Unlock()
+	cooling := []string{}
+	for engineID, placements := range t.placements {
+		recent := placements[:0]
+		for _, at := range placements {
+			if now.Sub(at) < window {
+				recent = append(recent, at)
+			}
+		}
+		if len(recent) == 0 {
+			delete(t.placements, engineID)
+			continue
+		}
+		t.placements[engineID] = recent
+		if len(recent) >= limit {
+			cooling = append(cooling, engineID)
+		}
+	}
+	sort.Strings(cooling)
+	return cooling
+}
+
+// cooldown adds soft constraints avoiding the targets in cooldown, unless the
+// container is pinned to a node. The target a container prefers, such as the
+// last target of its group, is not avoided.
+func (w *Watchdog) cooldown(config *ContainerConfig) error {
+	if w.opts.RescheduleCooldownCount <= 0 || w.cooldowns == nil || config.HaveNodeConstraint() {
+		return nil
+	}
+	preferred := map[string]bool{}
+	for _, constraint := range config.Constraints() {
+		preferred[constraint] = true
+	}
+	constraints := []string{}
+	for _, engineID := range w.cooldowns.cooling(w.opts.RescheduleCooldownCount, w.opts.RescheduleCooldownWindow, time.Now()) {
+		if preferred["node==~"+engineID] {
+			continue
+		}
+		constraint := "node!=~" + engineID
+		if err := config.AddConstraint(constraint); err != nil {
+			return err
+		}
+		constraints = append(constraints, constraint)
+	}
+	if len(constraints) > 0 {
+		config.Labels[rescheduleCooldownLabel] = strings.Join(constraints, ",")
+	}
+	return nil
+}
+
+// dropCooldown removes the constraints avoiding the targets which were in
+// cooldown when the container was last rescheduled.
+func dropCooldown(config *ContainerConfig) error {
+	constraints, ok := config.Labels[rescheduleCooldownLabel]
+	if !ok {
+		return nil
+	}
+	for _, constraint := range strings.Split(constraints, ",") {
+		if err := config.RemoveConstraint(constraint); err != nil {
+			return err
+		}
+	}
+	delete(config.Labels, rescheduleCooldownLabel)
+	return nil
+}
@@ -0,0 +1,38 @@
+package cluster
+
+import "strings"
+
+// DedicatedLabel is the engine label reserving a node to the containers
+// tolerating it, e.g. dedicated=system for the nodes of the infrastructure
+// containers.
+const DedicatedLabel = "dedicated"
+
+// Tolerates returns true if the container may run on a node with the given
+// engine labels. The nodes without the dedicated label accept any container,
+// the dedicated ones only the containers explicitly tolerating them with a
+// constraint on the label, e.g. constraint:dedicated==system.
+func (c *ContainerConfig) Tolerates(labels map[string]string) bool {
+	dedicated, ok := labels[DedicatedLabel]
+	if !ok {
+		return true
+	}
+	for _, toleration := range c.Tolerations() {
+		if toleration == dedicated {
+			return true
+		}
+	}
+	return false
+}
+
+// Tolerations returns the dedicated nodes the container tolerates, as the
+// values of its dedicated==value constraints.
+func (c *ContainerConfig) Tolerations() []string {
+	tolerations := []string{}
+	for _, constraint := range c.Constraints() {
+		kv := strings.SplitN(constraint, "==", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == DedicatedLabel && !strings.HasPrefix(kv[1], "~") {
+			tolerations = append(tolerations, strings.TrimSpace(kv[1]))
+		}
+	}
+	return tolerations
+}
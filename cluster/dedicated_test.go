@@ -0,0 +1,30 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTolerates(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.True(t, config.Tolerates(map[string]string{}))
+	assert.False(t, config.Tolerates(map[string]string{DedicatedLabel: "system"}))
+
+	// Only a constraint matching the value of the label tolerates it.
+	for constraint, tolerates := range map[string]bool{
+		"dedicated==system":   true,
+		"dedicated == system": true,
+		"dedicated==~system":  false,
+		"dedicated!=system":   false,
+		"dedicated==gpu":      false,
+		"region==system":      false,
+	} {
+		config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+		assert.NoError(t, config.AddConstraint(constraint))
+		assert.Equal(t, tolerates, config.Tolerates(map[string]string{DedicatedLabel: "system"}), constraint)
+		assert.True(t, config.Tolerates(map[string]string{"region": "system"}), constraint)
+	}
+}
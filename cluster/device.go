@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DevicesLabel is the engine label listing the host devices of a node,
+	// e.g. devices=/dev/fuse,/dev/nvidia*. Shell patterns are accepted.
+	DevicesLabel = "devices"
+	// UlimitsLabel is the engine label listing the maximum hard ulimits a
+	// node accepts, e.g. ulimits=nofile=65536,memlock=-1. -1 is unlimited.
+	UlimitsLabel = "ulimits"
+)
+
+// UnsatisfiedRequirements returns the host devices and ulimits required by the
+// container which a node with the given engine labels doesn't provide. A
+// node without the devices or ulimits label is assumed to provide any, as
+// is a node whose ulimits label doesn't list an ulimit.
+func (c *ContainerConfig) UnsatisfiedRequirements(labels map[string]string) []string {
+	unsatisfied := []string{}
+
+	if devices, ok := labels[DevicesLabel]; ok {
+		for _, device := range c.HostConfig.Devices {
+			if !hasDevice(devices, device.PathOnHost) {
+				unsatisfied = append(unsatisfied, "device "+device.PathOnHost)
+			}
+		}
+	}
+
+	if ulimits, ok := labels[UlimitsLabel]; ok {
+		limits := parseUlimits(ulimits)
+		for _, ulimit := range c.HostConfig.Ulimits {
+			max, ok := limits[ulimit.Name]
+			if !ok || max == -1 {
+				continue
+			}
+			if ulimit.Hard == -1 || ulimit.Hard > max {
+				unsatisfied = append(unsatisfied, fmt.Sprintf("ulimit %s=%d", ulimit.Name, ulimit.Hard))
+			}
+		}
+	}
+	return unsatisfied
+}
+
+// hasDevice returns true if the comma separated list of devices holds the
+// device, or a pattern matching it.
+func hasDevice(devices, device string) bool {
+	for _, pattern := range strings.Split(devices, ",") {
+		if matched, err := path.Match(strings.TrimSpace(pattern), device); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUlimits parses a comma separated list of name=max ulimits. The invalid
+// entries are ignored.
+func parseUlimits(val string) map[string]int64 {
+	limits := make(map[string]int64)
+	for _, entry := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		max, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		limits[kv[0]] = max
+	}
+	return limits
+}
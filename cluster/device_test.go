@@ -0,0 +1,31 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-units"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsatisfiedRequirements(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{
+		Resources: container.Resources{
+			Devices: []container.DeviceMapping{{PathOnHost: "/dev/fuse"}, {PathOnHost: "/dev/nvidia0"}},
+			Ulimits: []*units.Ulimit{{Name: "nofile", Hard: 65536}, {Name: "memlock", Hard: -1}},
+		},
+	}, network.NetworkingConfig{})
+
+	assert.Empty(t, config.UnsatisfiedRequirements(map[string]string{}))
+	assert.Empty(t, config.UnsatisfiedRequirements(map[string]string{
+		DevicesLabel: "/dev/fuse, /dev/nvidia*",
+		UlimitsLabel: "nofile=65536,memlock=-1",
+	}))
+	assert.Equal(t, []string{"device /dev/nvidia0", "ulimit nofile=65536", "ulimit memlock=-1"}, config.UnsatisfiedRequirements(map[string]string{
+		DevicesLabel: "/dev/fuse",
+		UlimitsLabel: "nofile=4096,memlock=64,invalid",
+	}))
+	// The ulimits the label doesn't list are not limited.
+	assert.Empty(t, config.UnsatisfiedRequirements(map[string]string{UlimitsLabel: "nproc=10"}))
+}
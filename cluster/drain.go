@@ -0,0 +1,151 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DrainNodeOpts are the options of a node drain.
+type DrainNodeOpts struct {
+	// Hint is an optional node label constraint the moved containers
+	// prefer, e.g. "rack==r2".
+	Hint string
+	// StopTimeout is the grace period given to the moved containers to stop,
+	// their own stop timeout or RescheduleStopTimeout if 0.
+	StopTimeout time.Duration
+	// Concurrency is how many containers are moved at once, 1 if 0. The
+	// moves still take the reschedule slots of the watchdog.
+	Concurrency int
+}
+
+// DrainReport is the outcome of a node drain.
+type DrainReport struct {
+	Node string
+	// Moved are the IDs of the containers moved off the node.
+	Moved []string
+	// Left are the IDs of the containers left on the node by their
+	// reschedule policies.
+	Left []string
+	// Errors are the failures of the containers which couldn't be moved.
+	Errors RescheduleErrors
+	// Complete is true once all the containers to move are off the node.
+	Complete bool
+}
+
+// DrainNode cordons a node, so that the scheduler places no new container on
+// it, then moves its containers having the "on-node-drain" reschedule
+// policy off it, e.g. before a maintenance. Draining a node again resumes a
+// drain which failed or was canceled, with the containers still on it.
+// Canceling ctx stops the drain once the moves in progress complete, the node
+// is left cordoned. Uncordon the engine once the maintenance is over.
+func (w *Watchdog) DrainNode(ctx context.Context, engineID string, opts DrainNodeOpts) (*DrainReport, error) {
+	if !w.active() {
+		return nil, ErrWatchdogInactive
+	}
+	if opts.Hint != "" {
+		if _, err := softConstraint(opts.Hint); err != nil {
+			return nil, err
+		}
+	}
+	e := w.cluster.Engine(engineID)
+	if e == nil {
+		return nil, fmt.Errorf("no node %s in the cluster", engineID)
+	}
+
+	// The moves are made unlocked, the waves of the failed engines aren't
+	// held by the drain.
+	w.Lock()
+	cordoned := !e.IsCordoned()
+	if cordoned {
+		e.Cordon()
+	}
+	containers, left := w.drainableContainers(e)
+	w.Unlock()
+	if cordoned {
+		w.log.Infof("Cordoned node %s", e.Name)
+		w.emitEvent(e, "node_cordoned", map[string]string{"node": e.Name})
+	}
+
+	report := &DrainReport{Node: e.ID, Moved: []string{}, Left: []string{}}
+	for _, c := range left {
+		report.Left = append(report.Left, c.ID)
+	}
+	claimed := w.claimMoving(containers)
+	defer w.releaseMoving(claimed)
+	if len(claimed) < len(containers) {
+		ours := make(map[string]bool, len(claimed))
+		for _, c := range claimed {
+			ours[c.ID] = true
+		}
+		for _, c := range containers {
+			if !ours[c.ID] {
+				report.Errors = append(report.Errors, &RescheduleError{Container: c, Err: fmt.Errorf("container %s is being moved already", c.ID)})
+			}
+		}
+	}
+	w.log.Infof("Draining %d containers from cordoned node %s", len(containers), e.ID)
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		lock  sync.Mutex
+		wg    sync.WaitGroup
+		queue = make(chan *Container)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range queue {
+				err := w.moveContainer(c, TriggerDrain, opts.Hint, opts.StopTimeout)
+				w.releaseSlot()
+				lock.Lock()
+				if err != nil {
+					report.Errors = append(report.Errors, w.moveFailed(c, TriggerDrain, err))
+				} else {
+					report.Moved = append(report.Moved, c.ID)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, c := range claimed {
+		if ctx.Err() != nil || !w.active() || !w.acquireSlot(ctx, nil) {
+			break
+		}
+		select {
+		case queue <- c:
+		case <-ctx.Done():
+			w.releaseSlot()
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	report.Complete = len(report.Moved) == len(containers)
+	if !report.Complete {
+		w.log.Warnf("Drained %d of the %d containers of node %s", len(report.Moved), len(containers), e.ID)
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if len(report.Errors) == 0 {
+			return report, ErrWatchdogInactive
+		}
+		return report, report.Errors
+	}
+	w.log.Infof("Drained node %s", e.ID)
+	w.emitEvent(e, "node_drained", map[string]string{
+		"node":  e.Name,
+		"moved": strconv.Itoa(len(report.Moved)),
+		"left":  strconv.Itoa(len(report.Left)),
+	})
+	return report, nil
+}
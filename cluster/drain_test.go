@@ -0,0 +1,225 @@
+package cluster
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	engineapimock "github.com/docker/swarm/api/mockclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
+)
+
+func TestDrainNode(t *testing.T) {
+	node := createWatchdogEngine("node", true)
+	other := createWatchdogEngine("other", true)
+	handler := &recordingHandler{}
+	node.eventHandler = handler
+	other.eventHandler = handler
+	// Both containers are moved at once, the first creation waits for the
+	// second one.
+	started := make(chan struct{}, 2)
+	cl := &mockCluster{
+		engines: []*Engine{node, other},
+		createHook: func(count int) error {
+			if count > 2 {
+				return nil
+			}
+			started <- struct{}{}
+			timeout := time.After(5 * time.Second)
+			for len(started) < 2 {
+				select {
+				case <-timeout:
+					return errors.New("the containers are moved one at a time")
+				case <-time.After(time.Millisecond):
+				}
+			}
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, nil)
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("ContainerStop", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	node.apiClient = apiClient
+
+	createWatchdogContainer(node, "c1", drainable, true)
+	createWatchdogContainer(node, "c2", drainable, true)
+	createWatchdogContainer(node, "pinned", nil, true)
+
+	report, err := w.DrainNode(context.Background(), "node", DrainNodeOpts{Concurrency: 2, StopTimeout: time.Second})
+	assert.NoError(t, err)
+	assert.True(t, report.Complete)
+	assert.Equal(t, "node", report.Node)
+	sort.Strings(report.Moved)
+	assert.Equal(t, []string{"c1", "c2"}, report.Moved)
+	assert.Equal(t, []string{"pinned"}, report.Left)
+	assert.Empty(t, report.Errors)
+	assert.Len(t, other.Containers(), 2)
+	assert.Len(t, node.Containers(), 1)
+	// The containers are given the grace period of the drain to stop.
+	timeout := time.Second
+	apiClient.AssertCalled(t, "ContainerStop", mock.Anything, "c1", &timeout)
+	apiClient.AssertNumberOfCalls(t, "ContainerStop", 2)
+
+	// The cordoned node takes no new container.
+	assert.True(t, node.IsCordoned())
+	c, err := cl.CreateContainer(BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}), "new", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, other, c.Engine)
+
+	var statuses []string
+	for _, ev := range handler.without("container_rescheduled") {
+		statuses = append(statuses, ev.Status)
+	}
+	assert.Equal(t, []string{"node_cordoned", "node_drained"}, statuses)
+
+	// Draining the node again is a no-op.
+	report, err = w.DrainNode(context.Background(), "node", DrainNodeOpts{})
+	assert.NoError(t, err)
+	assert.True(t, report.Complete)
+	assert.Empty(t, report.Moved)
+
+	node.Uncordon()
+	assert.False(t, node.IsCordoned())
+
+	_, err = w.DrainNode(context.Background(), "unknown", DrainNodeOpts{})
+	assert.Error(t, err)
+	_, err = w.DrainNode(context.Background(), "node", DrainNodeOpts{Hint: "r2"})
+	assert.Error(t, err)
+	w.Stop()
+	_, err = w.DrainNode(context.Background(), "node", DrainNodeOpts{})
+	assert.Equal(t, ErrWatchdogInactive, err)
+}
+
+func TestDrainNodeResume(t *testing.T) {
+	node := createWatchdogEngine("node", true)
+	other := createWatchdogEngine("other", true)
+	// The first move fails.
+	cl := &mockCluster{
+		engines: []*Engine{node, other},
+		createHook: func(count int) error {
+			if count == 1 {
+				return errors.New("daemon is busy")
+			}
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, nil)
+
+	// The containers are moved make-before-break, for the failed move to
+	// leave the old container in place.
+	labels := map[string]string{RescheduleMakeBeforeBreakLabel: "true"}
+	for k, v := range drainable {
+		labels[k] = v
+	}
+	createWatchdogContainer(node, "c1", labels, true)
+	createWatchdogContainer(node, "c2", labels, true)
+
+	report, err := w.DrainNode(context.Background(), "node", DrainNodeOpts{})
+	assert.Error(t, err)
+	assert.False(t, report.Complete)
+	assert.Len(t, report.Moved, 1)
+	if assert.Len(t, report.Errors, 1) {
+		assert.Contains(t, report.Errors[0].Error(), "daemon is busy")
+	}
+	assert.Len(t, node.Containers(), 1)
+
+	// Draining the node again moves the container left behind.
+	report, err = w.DrainNode(context.Background(), "node", DrainNodeOpts{})
+	assert.NoError(t, err)
+	assert.True(t, report.Complete)
+	assert.Len(t, report.Moved, 1)
+	assert.Len(t, node.Containers(), 0)
+	assert.Len(t, other.Containers(), 2)
+}
+
+func TestDrainNodeCancel(t *testing.T) {
+	node := createWatchdogEngine("node", true)
+	other := createWatchdogEngine("other", true)
+	ctx, cancel := context.WithCancel(context.Background())
+	// The drain is canceled during the first move, which completes.
+	cl := &mockCluster{
+		engines: []*Engine{node, other},
+		createHook: func(count int) error {
+			if count == 1 {
+				cancel()
+			}
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, nil)
+
+	createWatchdogContainer(node, "c1", drainable, true)
+	createWatchdogContainer(node, "c2", drainable, true)
+	createWatchdogContainer(node, "c3", drainable, true)
+
+	report, err := w.DrainNode(ctx, "node", DrainNodeOpts{})
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, report.Complete)
+	assert.Len(t, report.Moved, 1)
+	assert.Empty(t, report.Errors)
+	assert.Len(t, node.Containers(), 2)
+	assert.True(t, node.IsCordoned())
+
+	// The drain resumes with a new context.
+	report, err = w.DrainNode(context.Background(), "node", DrainNodeOpts{})
+	assert.NoError(t, err)
+	assert.True(t, report.Complete)
+	assert.Len(t, report.Moved, 2)
+	assert.Len(t, other.Containers(), 3)
+}
+
+func TestDrainNodeUnlocked(t *testing.T) {
+	node := createWatchdogEngine("node", true)
+	dead := createWatchdogEngine("dead", false)
+	other := createWatchdogEngine("other", true)
+	// The move of the drain waits until the failed node is rescheduled.
+	moving, rescheduled := make(chan struct{}), make(chan struct{})
+	cl := &mockCluster{
+		engines: []*Engine{node, dead, other},
+		createHook: func(count int) error {
+			if count == 1 {
+				close(moving)
+				<-rescheduled
+			}
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, nil)
+	// The container is moved make-before-break, to stay on the node during
+	// its move.
+	labels := map[string]string{RescheduleMakeBeforeBreakLabel: "true"}
+	for k, v := range drainable {
+		labels[k] = v
+	}
+	createWatchdogContainer(node, "c1", labels, true)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		report, err := w.DrainNode(context.Background(), "node", DrainNodeOpts{})
+		assert.NoError(t, err)
+		assert.True(t, report.Complete)
+	}()
+	<-moving
+
+	// The failed node is rescheduled while the drain is in progress, and
+	// draining the node again leaves the container being moved alone.
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	report, err := w.DrainNode(context.Background(), "node", DrainNodeOpts{})
+	assert.Error(t, err)
+	assert.False(t, report.Complete)
+	if assert.Len(t, report.Errors, 1) {
+		assert.Contains(t, report.Errors[0].Error(), "being moved already")
+	}
+	close(rescheduled)
+	<-done
+
+	assert.Len(t, other.Containers(), 2)
+	assert.Len(t, node.Containers(), 0)
+}
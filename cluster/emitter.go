@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// EventEmitter publishes the events of the watchdog to an external system,
+// e.g. the message broker of an event pipeline. Emit is called concurrently
+// by the reschedules themselves, the transports must not block on the
+// endpoint but queue the events.
+type EventEmitter interface {
+	Emit(ev *Event) error
+}
+
+// EventEmitterFactory creates the emitter publishing to an endpoint.
+type EventEmitterFactory func(endpoint *url.URL) (EventEmitter, error)
+
+var (
+	emittersLock sync.Mutex
+	emitters     = map[string]EventEmitterFactory{
+		"log": func(endpoint *url.URL) (EventEmitter, error) { return logEmitter{}, nil },
+	}
+)
+
+// RegisterEventEmitter registers the emitter of a transport, chosen by the
+// scheme of the endpoints, e.g. "amqp" or "kafka".
+func RegisterEventEmitter(scheme string, factory EventEmitterFactory) {
+	emittersLock.Lock()
+	defer emittersLock.Unlock()
+	emitters[scheme] = factory
+}
+
+// NewEventEmitter creates the emitter publishing to an endpoint, e.g.
+// "amqp://broker:5672/swarm", by the transport registered for its scheme.
+// "log" logs the events instead.
+func NewEventEmitter(endpoint string) (EventEmitter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		// Allow "log" rather than "log://".
+		u.Scheme = u.Path
+	}
+	emittersLock.Lock()
+	factory, ok := emitters[u.Scheme]
+	emittersLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no event emitter for %s", u.Scheme)
+	}
+	return factory(u)
+}
+
+// logEmitter logs the events.
+type logEmitter struct{}
+
+func (logEmitter) Emit(ev *Event) error {
+	fields := log.Fields{}
+	for k, v := range ev.Actor.Attributes {
+		fields[k] = v
+	}
+	log.WithFields(fields).Infof("Watchdog event %s", ev.Status)
+	return nil
+}
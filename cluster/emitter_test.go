@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingEmitter records the events it is given to emit.
+type recordingEmitter struct {
+	recordingHandler
+}
+
+func (r *recordingEmitter) Emit(ev *Event) error {
+	return r.Handle(ev)
+}
+
+func (r *recordingEmitter) statuses() []string {
+	r.Lock()
+	defer r.Unlock()
+	var statuses []string
+	for _, ev := range r.events {
+		statuses = append(statuses, ev.Status)
+	}
+	return statuses
+}
+
+func TestNewEventEmitter(t *testing.T) {
+	for _, endpoint := range []string{"log", "log://"} {
+		emitter, err := NewEventEmitter(endpoint)
+		assert.NoError(t, err, endpoint)
+		assert.Equal(t, logEmitter{}, emitter)
+	}
+
+	_, err := NewEventEmitter("amqp://broker:5672/swarm")
+	assert.Error(t, err)
+
+	var endpoint *url.URL
+	recorder := &recordingEmitter{}
+	RegisterEventEmitter("fake", func(u *url.URL) (EventEmitter, error) {
+		endpoint = u
+		return recorder, nil
+	})
+	emitter, err := NewEventEmitter("fake://broker:5672/swarm?topic=reschedules")
+	assert.NoError(t, err)
+	assert.Equal(t, recorder, emitter)
+	assert.Equal(t, "broker:5672", endpoint.Host)
+	assert.Equal(t, "reschedules", endpoint.Query().Get("topic"))
+
+	opts, err := NewWatchdogOpts(DriverOpts{"event-emitter=fake://broker"})
+	assert.NoError(t, err)
+	assert.Equal(t, recorder, opts.Emitter)
+	_, err = NewWatchdogOpts(DriverOpts{"event-emitter=unknown://broker"})
+	assert.Error(t, err)
+}
+
+func TestWatchdogEmitter(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	// The recreation of c2 fails once.
+	cl := &mockCluster{
+		engines: []*Engine{dead, alive},
+		createHook: func(count int) error {
+			if count == 2 {
+				return errors.New("daemon is busy")
+			}
+			return nil
+		},
+	}
+	emitter := &recordingEmitter{}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1, Emitter: emitter})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+
+	// The events are emitted even without any event handler, along with the
+	// attempts.
+	assert.Error(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Equal(t, []string{
+		"container_reschedule_attempt",
+		"container_rescheduled",
+		"container_reschedule_attempt",
+		"container_reschedule_failed",
+		"reschedule_summary",
+	}, emitter.statuses())
+	attempt := emitter.events[0].Actor.Attributes
+	assert.Equal(t, "dead", attempt["node"])
+	assert.Equal(t, "1", attempt["attempt"])
+	assert.Equal(t, string(TriggerEngineDisconnect), attempt["trigger"])
+	// The second container attempted is the one which failed.
+	failed := emitter.events[2].Actor.Attributes["container"]
+	assert.NotEqual(t, attempt["container"], failed)
+	assert.Equal(t, failed, emitter.events[3].Actor.Attributes["container"])
+
+	// The moves off healthy nodes are emitted as well.
+	emitter.events = nil
+	cl.engines = append(cl.engines, createWatchdogEngine("other", true))
+	createWatchdogContainer(alive, "c3", drainable, true)
+	assert.NoError(t, w.Drain(alive, ""))
+	assert.Equal(t, []string{"container_reschedule_attempt", "container_rescheduled"}, emitter.statuses())
+}
@@ -106,6 +106,9 @@ type EngineOpts struct {
 	RefreshMinInterval time.Duration
 	RefreshMaxInterval time.Duration
 	FailureRetry       int
+	// RefreshMemoryUsage collects the actual memory usage of the running
+	// containers from their stats on every refresh.
+	RefreshMemoryUsage bool
 }
 
 // Engine represents a docker engine
@@ -136,7 +139,9 @@ type Engine struct {
 	lastError       string
 	updatedAt       time.Time
 	failureCount    int
+	cordoned        bool
 	overcommitRatio int64
+	memoryUsage     int64
 	opts            *EngineOpts
 	eventsMonitor   *EventsMonitor
 	DeltaDuration   time.Duration // swarm's systime - engine's systime
@@ -305,6 +310,28 @@ func (e *Engine) IsHealthy() bool {
 	return e.state == stateHealthy
 }
 
+// Cordon keeps the scheduler from placing new containers on the engine, e.g.
+// before a maintenance. The containers already on it are left in place.
+func (e *Engine) Cordon() {
+	e.Lock()
+	defer e.Unlock()
+	e.cordoned = true
+}
+
+// Uncordon lets the scheduler place containers on the engine again.
+func (e *Engine) Uncordon() {
+	e.Lock()
+	defer e.Unlock()
+	e.cordoned = false
+}
+
+// IsCordoned returns true if the engine is cordoned.
+func (e *Engine) IsCordoned() bool {
+	e.RLock()
+	defer e.RUnlock()
+	return e.cordoned
+}
+
 // HealthIndicator returns degree of healthiness between 0 and 100.
 // 0 means node is not healthy (unhealthy, pending), 100 means last connectivity was successful
 // other values indicate recent failures but haven't moved engine out of healthy state
@@ -713,6 +740,45 @@ func (e *Engine) RefreshVolumes() error {
 	return nil
 }
 
+// RefreshMemoryUsage refreshes the actual memory usage of the engine, the sum
+// of the memory used by its running containers according to their stats, page
+// cache excluded.
+func (e *Engine) RefreshMemoryUsage() error {
+	var usage int64
+	for _, c := range e.Containers() {
+		if c.Info.State == nil || !c.Info.State.Running {
+			continue
+		}
+		stats, err := e.apiClient.ContainerStats(context.Background(), c.ID, false)
+		e.CheckConnectionErr(err)
+		if err != nil {
+			// The container was removed since the last refresh.
+			if strings.Contains(err.Error(), "No such container") {
+				continue
+			}
+			return err
+		}
+		var s types.StatsJSON
+		err = json.NewDecoder(stats.Body).Decode(&s)
+		stats.Body.Close()
+		if err != nil {
+			return err
+		}
+		used := s.MemoryStats.Usage
+		if cache := s.MemoryStats.Stats["cache"]; cache < used {
+			used -= cache
+		}
+		if s.MemoryStats.PrivateWorkingSet > 0 {
+			used = s.MemoryStats.PrivateWorkingSet
+		}
+		usage += int64(used)
+	}
+	e.Lock()
+	e.memoryUsage = usage
+	e.Unlock()
+	return nil
+}
+
 // refreshVolume refreshes single volume on the engine.
 func (e *Engine) refreshVolume(IDOrName string) error {
 	volume, err := e.apiClient.VolumeInspect(context.Background(), IDOrName)
@@ -910,6 +976,11 @@ func (e *Engine) refreshLoop() {
 			e.RefreshVolumes()
 			e.RefreshNetworks()
 			e.RefreshImages()
+			if e.opts.RefreshMemoryUsage {
+				if err := e.RefreshMemoryUsage(); err != nil {
+					log.WithFields(log.Fields{"id": e.ID, "name": e.Name}).Debugf("Engine memory usage refresh failed: %v", err)
+				}
+			}
 			log.WithFields(log.Fields{"id": e.ID, "name": e.Name}).Debugf("Engine update succeeded")
 		} else {
 			log.WithFields(log.Fields{"id": e.ID, "name": e.Name}).Debugf("Engine refresh failed")
@@ -950,6 +1021,14 @@ func (e *Engine) UsedMemory() int64 {
 	return r
 }
 
+// MemoryUsage returns the actual memory used by the running containers, as
+// last refreshed from their stats. It is 0 if the usage isn't collected.
+func (e *Engine) MemoryUsage() int64 {
+	e.RLock()
+	defer e.RUnlock()
+	return e.memoryUsage
+}
+
 // UsedCpus returns the sum of CPUs reserved by containers.
 func (e *Engine) UsedCpus() int64 {
 	var r int64
@@ -961,6 +1040,18 @@ func (e *Engine) UsedCpus() int64 {
 	return r
 }
 
+// SchedulingLoad returns the sum of the scheduling weights of the containers
+// of the engine, in percent of the engine.
+func (e *Engine) SchedulingLoad() int64 {
+	var r int64
+	e.RLock()
+	for _, c := range e.containers {
+		r += c.Config.SchedulingWeight()
+	}
+	e.RUnlock()
+	return r
+}
+
 // TotalMemory returns the total memory + overcommit
 func (e *Engine) TotalMemory() int64 {
 	return e.Memory + (e.Memory * e.overcommitRatio / 100)
@@ -1042,6 +1133,41 @@ func (e *Engine) RemoveContainer(container *Container, force, volumes bool) erro
 	return nil
 }
 
+// HasContainer lists the containers of the engine to check whether the
+// container is still on it, leaving the state of the engine as it is.
+func (e *Engine) HasContainer(ID string) (bool, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("id", ID)
+	opts := types.ContainerListOptions{
+		All:     true,
+		Size:    false,
+		Filters: filterArgs,
+	}
+	containers, err := e.apiClient.ContainerList(context.Background(), opts)
+	e.CheckConnectionErr(err)
+	if err != nil {
+		return false, err
+	}
+	return len(containers) > 0, nil
+}
+
+// StopContainer stops a container on the engine.
+func (e *Engine) StopContainer(container *Container, timeout *time.Duration) error {
+	err := e.apiClient.ContainerStop(context.Background(), container.ID, timeout)
+	e.CheckConnectionErr(err)
+	// The state of the container is updated by the state refresh loop.
+	return err
+}
+
+// KillContainer sends a signal to the main process of a container on the
+// engine.
+func (e *Engine) KillContainer(container *Container, signal string) error {
+	err := e.apiClient.ContainerKill(context.Background(), container.ID, signal)
+	e.CheckConnectionErr(err)
+	// The state of the container is updated by the state refresh loop.
+	return err
+}
+
 // CreateNetwork creates a network in the engine
 func (e *Engine) CreateNetwork(name string, request *types.NetworkCreate) (*types.NetworkCreateResponse, error) {
 	response, err := e.apiClient.NetworkCreate(context.Background(), name, *request)
@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// EngineOrderMostContainers reschedules first the engines which failed at
+	// once hosting the most reschedulable containers.
+	EngineOrderMostContainers = "most-containers"
+	// EngineOrderHighestPriority reschedules first the engines which failed
+	// at once hosting the containers of the highest reschedule priorities.
+	EngineOrderHighestPriority = "highest-priority"
+)
+
+// rescheduleEngines reschedules the containers of engines which failed at
+// once, found by the startup scan or the reconciliation sweep, with their
+// triggers by engine ID. Without an engine order they are rescheduled
+// concurrently, in no particular order. With one, the first pass of each
+// engine is made once the first pass of the engine before it is done, so
+// that the most impactful recoveries take the capacity left first.
+func (w *Watchdog) rescheduleEngines(engines []*Engine, triggers map[string]RescheduleTrigger) {
+	if w.opts.RescheduleEngineOrder == "" || len(engines) < 2 {
+		for _, e := range engines {
+			go w.rescheduleContainers(e, triggers[e.ID])
+		}
+		return
+	}
+
+	ordered := w.orderEngines(engines)
+	go func() {
+		for _, e := range ordered {
+			var once sync.Once
+			passed := make(chan struct{})
+			pass := func() { once.Do(func() { close(passed) }) }
+			go func(e *Engine) {
+				// The engine may not be rescheduled at all, e.g. when
+				// already being rescheduled.
+				defer pass()
+				w.rescheduleInTurn(e, triggers[e.ID], pass)
+			}(e)
+			<-passed
+		}
+	}()
+}
+
+// orderEngines returns the engines in the order their containers are
+// rescheduled, per the engine order.
+func (w *Watchdog) orderEngines(engines []*Engine) []*Engine {
+	order := &engineOrder{
+		engines:    append([]*Engine{}, engines...),
+		priorities: make(map[string][]int, len(engines)),
+		byPriority: w.opts.RescheduleEngineOrder == EngineOrderHighestPriority,
+	}
+	for _, e := range engines {
+		priorities := []int{}
+		for _, c := range e.Containers() {
+			if w.reschedulable(c, "on-node-failure") {
+				priorities = append(priorities, c.Config.ReschedulePriority())
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+		order.priorities[e.ID] = priorities
+	}
+	sort.Sort(order)
+	return order.engines
+}
+
+// engineOrder sorts the engines by descending number of reschedulable
+// containers or, by priority, by descending priorities of their containers.
+// The ties are broken by engine ID.
+type engineOrder struct {
+	engines []*Engine
+	// priorities are the descending priorities of the reschedulable
+	// containers, by engine ID.
+	priorities map[string][]int
+	byPriority bool
+}
+
+func (o *engineOrder) Len() int      { return len(o.engines) }
+func (o *engineOrder) Swap(i, j int) { o.engines[i], o.engines[j] = o.engines[j], o.engines[i] }
+func (o *engineOrder) Less(i, j int) bool {
+	a, b := o.priorities[o.engines[i].ID], o.priorities[o.engines[j].ID]
+	if o.byPriority {
+		// The priorities are compared from the highest, an engine with
+		// more containers of a priority comes first.
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] > b[k]
+			}
+		}
+	}
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return o.engines[i].ID < o.engines[j].ID
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"testing"
 	"time"
@@ -836,3 +837,26 @@ func TestRemoveImage(t *testing.T) {
 	}
 	apiClient.Mock.AssertExpectations(t)
 }
+
+func TestEngineRefreshMemoryUsage(t *testing.T) {
+	engine := NewEngine("test", 0, engOpts)
+	apiClient := engineapimock.NewMockClient()
+	engine.apiClient = apiClient
+	createWatchdogContainer(engine, "running", nil, true)
+	createWatchdogContainer(engine, "stopped", nil, false)
+
+	stats := func(body string) types.ContainerStats {
+		return types.ContainerStats{Body: ioutil.NopCloser(bytes.NewBufferString(body))}
+	}
+	// The page cache is not counted.
+	apiClient.On("ContainerStats", mock.Anything, "running", false).Return(stats(`{"memory_stats": {"usage": 300, "stats": {"cache": 100}}}`), nil).Once()
+
+	assert.Equal(t, int64(0), engine.MemoryUsage())
+	assert.NoError(t, engine.RefreshMemoryUsage())
+	assert.Equal(t, int64(200), engine.MemoryUsage())
+
+	apiClient.On("ContainerStats", mock.Anything, "running", false).Return(types.ContainerStats{}, errors.New("No such container: running")).Once()
+	assert.NoError(t, engine.RefreshMemoryUsage())
+	assert.Equal(t, int64(0), engine.MemoryUsage())
+	apiClient.Mock.AssertExpectations(t)
+}
@@ -2,12 +2,26 @@ package cluster
 
 import (
 	"errors"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/api/types/events"
 )
 
+const (
+	// EventPriorityState is the priority of the event handlers updating the
+	// state of the cluster. They are called first.
+	EventPriorityState = -100
+	// EventPriorityDefault is the priority of the event handlers which don't
+	// implement PrioritizedEventHandler.
+	EventPriorityDefault = 0
+	// EventPriorityWatchdog is the priority of the watchdog, called once the
+	// state of the cluster has been updated.
+	EventPriorityWatchdog = 100
+)
+
 // Event is exported
 type Event struct {
 	events.Message
@@ -19,18 +33,68 @@ type EventHandler interface {
 	Handle(*Event) error
 }
 
-// EventHandlers is a map of EventHandler
+// PrioritizedEventHandler is an event handler with a dispatch priority. The
+// handlers are called by ascending priority, then in registration order.
+type PrioritizedEventHandler interface {
+	EventHandler
+	EventPriority() int
+}
+
+// eventHandlerPriority returns the priority of an event handler.
+func eventHandlerPriority(h EventHandler) int {
+	if p, ok := h.(PrioritizedEventHandler); ok {
+		return p.EventPriority()
+	}
+	return EventPriorityDefault
+}
+
+// EventQueueFullPolicy is what is done with an event when the queue of a
+// handler is full.
+type EventQueueFullPolicy string
+
+const (
+	// EventQueueBlock waits for the handler to make room in its queue,
+	// stalling the dispatch of the next events.
+	EventQueueBlock EventQueueFullPolicy = "block"
+	// EventQueueDrop drops the event for the handler, the other handlers
+	// still get it.
+	EventQueueDrop EventQueueFullPolicy = "drop"
+)
+
+type registeredEventHandler struct {
+	handler  EventHandler
+	priority int
+	// queue holds the events to dispatch to the handler, nil if it is
+	// called synchronously.
+	queue chan *Event
+}
+
+// EventHandlers is a list of EventHandler sorted by priority
 type EventHandlers struct {
 	sync.RWMutex
 
-	eventHandlers map[EventHandler]struct{}
+	eventHandlers []registeredEventHandler
+	queueSize     int
+	queueFull     EventQueueFullPolicy
+	// dropped is the number of events dropped because the queue of their
+	// handler was full.
+	dropped uint64
 }
 
-// NewEventHandlers returns an EventHandlers
+// NewEventHandlers returns an EventHandlers calling the handlers
+// synchronously.
 func NewEventHandlers() *EventHandlers {
-	return &EventHandlers{
-		eventHandlers: make(map[EventHandler]struct{}),
-	}
+	return &EventHandlers{}
+}
+
+// NewQueuedEventHandlers returns an EventHandlers dispatching the events to
+// each handler through its own queue, of queueSize events, so that a slow
+// handler only delays its own events. The handlers updating the state of the
+// cluster, up to EventPriorityState, are still called synchronously, so that
+// the queued ones see the state updated. The queued handlers are called
+// concurrently with each other, their priority no longer orders them.
+func NewQueuedEventHandlers(queueSize int, queueFull EventQueueFullPolicy) *EventHandlers {
+	return &EventHandlers{queueSize: queueSize, queueFull: queueFull}
 }
 
 // Handle callbacks for the events
@@ -38,10 +102,41 @@ func (eh *EventHandlers) Handle(e *Event) {
 	eh.RLock()
 	defer eh.RUnlock()
 
-	for h := range eh.eventHandlers {
-		if err := h.Handle(e); err != nil {
-			log.Error(err)
+	for _, h := range eh.eventHandlers {
+		if h.queue == nil {
+			callEventHandler(h.handler, e)
+			continue
 		}
+		if eh.queueFull != EventQueueDrop {
+			h.queue <- e
+			continue
+		}
+		select {
+		case h.queue <- e:
+		default:
+			atomic.AddUint64(&eh.dropped, 1)
+			log.Warnf("Event queue of handler %T is full, dropping event %s", h.handler, e.Status)
+		}
+	}
+}
+
+// Dropped returns the number of events dropped because the queue of their
+// handler was full.
+func (eh *EventHandlers) Dropped() uint64 {
+	return atomic.LoadUint64(&eh.dropped)
+}
+
+func callEventHandler(h EventHandler, e *Event) {
+	if err := h.Handle(e); err != nil {
+		log.Error(err)
+	}
+}
+
+// dispatch calls the handler with the events of its queue, until it is
+// closed.
+func dispatch(h EventHandler, queue chan *Event) {
+	for e := range queue {
+		callEventHandler(h, e)
 	}
 }
 
@@ -50,10 +145,22 @@ func (eh *EventHandlers) RegisterEventHandler(h EventHandler) error {
 	eh.Lock()
 	defer eh.Unlock()
 
-	if _, ok := eh.eventHandlers[h]; ok {
-		return errors.New("event handler already set")
+	for _, registered := range eh.eventHandlers {
+		if registered.handler == h {
+			return errors.New("event handler already set")
+		}
 	}
-	eh.eventHandlers[h] = struct{}{}
+	priority := eventHandlerPriority(h)
+	registered := registeredEventHandler{handler: h, priority: priority}
+	if eh.queueSize > 0 && priority > EventPriorityState {
+		registered.queue = make(chan *Event, eh.queueSize)
+		go dispatch(h, registered.queue)
+	}
+	// Insert after the handlers of the same priority.
+	i := sort.Search(len(eh.eventHandlers), func(i int) bool { return eh.eventHandlers[i].priority > priority })
+	eh.eventHandlers = append(eh.eventHandlers, registeredEventHandler{})
+	copy(eh.eventHandlers[i+1:], eh.eventHandlers[i:])
+	eh.eventHandlers[i] = registered
 	return nil
 }
 
@@ -62,5 +169,14 @@ func (eh *EventHandlers) UnregisterEventHandler(h EventHandler) {
 	eh.Lock()
 	defer eh.Unlock()
 
-	delete(eh.eventHandlers, h)
+	for i, registered := range eh.eventHandlers {
+		if registered.handler == h {
+			eh.eventHandlers = append(eh.eventHandlers[:i], eh.eventHandlers[i+1:]...)
+			// The events already queued are still dispatched.
+			if registered.queue != nil {
+				close(registered.queue)
+			}
+			return
+		}
+	}
 }
@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type orderedHandler struct {
+	name     string
+	priority int
+	calls    *[]string
+}
+
+func (h *orderedHandler) Handle(e *Event) error {
+	*h.calls = append(*h.calls, h.name)
+	return nil
+}
+
+type prioritizedHandler struct {
+	orderedHandler
+}
+
+func (h *prioritizedHandler) EventPriority() int {
+	return h.priority
+}
+
+func TestEventHandlersPriority(t *testing.T) {
+	var calls []string
+	eh := NewEventHandlers()
+
+	watchdog := &prioritizedHandler{orderedHandler{name: "watchdog", priority: EventPriorityWatchdog, calls: &calls}}
+	state := &prioritizedHandler{orderedHandler{name: "state", priority: EventPriorityState, calls: &calls}}
+	first := &orderedHandler{name: "first", calls: &calls}
+	second := &orderedHandler{name: "second", calls: &calls}
+	for _, h := range []EventHandler{watchdog, first, state, second} {
+		assert.NoError(t, eh.RegisterEventHandler(h))
+	}
+	assert.Error(t, eh.RegisterEventHandler(first))
+
+	// By priority, then by registration order.
+	eh.Handle(&Event{})
+	assert.Equal(t, []string{"state", "first", "second", "watchdog"}, calls)
+
+	calls = nil
+	eh.UnregisterEventHandler(first)
+	eh.UnregisterEventHandler(first)
+	eh.Handle(&Event{})
+	assert.Equal(t, []string{"state", "second", "watchdog"}, calls)
+
+	// The watchdog is called once the state is up to date.
+	var w EventHandler = &Watchdog{}
+	p, ok := w.(PrioritizedEventHandler)
+	assert.True(t, ok)
+	assert.Equal(t, EventPriorityWatchdog, p.EventPriority())
+}
+
+// slowHandler blocks on each event until released.
+type slowHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *slowHandler) Handle(e *Event) error {
+	h.started <- struct{}{}
+	<-h.release
+	return nil
+}
+
+// countingHandler counts the events it handles.
+type countingHandler struct {
+	sync.Mutex
+	count int
+}
+
+func (h *countingHandler) Handle(e *Event) error {
+	h.Lock()
+	defer h.Unlock()
+	h.count++
+	return nil
+}
+
+func (h *countingHandler) handled() int {
+	h.Lock()
+	defer h.Unlock()
+	return h.count
+}
+
+func TestQueuedEventHandlers(t *testing.T) {
+	for _, queueFull := range []EventQueueFullPolicy{EventQueueDrop, EventQueueBlock} {
+		eh := NewQueuedEventHandlers(1, queueFull)
+		var calls []string
+		state := &prioritizedHandler{orderedHandler{name: "state", priority: EventPriorityState, calls: &calls}}
+		slow := &slowHandler{started: make(chan struct{}, 3), release: make(chan struct{})}
+		fast := &countingHandler{}
+		for _, h := range []EventHandler{slow, state, fast} {
+			assert.NoError(t, eh.RegisterEventHandler(h))
+		}
+
+		// waitFast waits for the fast handler to handle n events.
+		waitFast := func(n int) {
+			for i := 0; i < 100 && fast.handled() < n; i++ {
+				time.Sleep(time.Millisecond)
+			}
+			assert.Equal(t, n, fast.handled())
+		}
+
+		// The slow handler is stuck on the first event, and queues the
+		// second one, while the fast handler is not stalled.
+		eh.Handle(&Event{})
+		<-slow.started
+		waitFast(1)
+		eh.Handle(&Event{})
+		waitFast(2)
+
+		handled := make(chan struct{})
+		go func() {
+			eh.Handle(&Event{})
+			close(handled)
+		}()
+		if queueFull == EventQueueDrop {
+			// The third event is dropped for the slow handler only.
+			<-handled
+			assert.Equal(t, uint64(1), eh.Dropped())
+		} else {
+			// The dispatch blocks until the slow handler makes room.
+			select {
+			case <-handled:
+				t.Fatal("the dispatch did not block on the full queue")
+			case <-time.After(20 * time.Millisecond):
+			}
+			close(slow.release)
+			<-handled
+			assert.Equal(t, uint64(0), eh.Dropped())
+		}
+
+		// The state handler is called synchronously.
+		assert.Len(t, calls, 3)
+		waitFast(3)
+
+		if queueFull == EventQueueDrop {
+			close(slow.release)
+		}
+		eh.UnregisterEventHandler(slow)
+	}
+}
@@ -0,0 +1,49 @@
+package cluster
+
+import "strconv"
+
+// GPUsLabel is the container label reserving GPUs on the node of the
+// container, as a count.
+const GPUsLabel = SwarmLabelNamespace + ".gpus"
+
+// NodeGPUsLabel is the engine label advertising the number of GPUs of a node.
+// The nodes without it have none, and take no container reserving GPUs.
+const NodeGPUsLabel = "gpus"
+
+// parseGPUs parses a GPU count, invalid or negative counts being 0.
+func parseGPUs(val string) int64 {
+	gpus, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || gpus < 0 {
+		return 0
+	}
+	return gpus
+}
+
+// GPUs returns the number of GPUs the container reserves, as set by the
+// com.docker.swarm.gpus label. Containers without the label, or with an
+// invalid one, reserve none.
+func (c *ContainerConfig) GPUs() int64 {
+	return parseGPUs(c.Labels[GPUsLabel])
+}
+
+// GPUCapacity returns the number of GPUs advertised by the engine labels.
+func GPUCapacity(labels map[string]string) int64 {
+	return parseGPUs(labels[NodeGPUsLabel])
+}
+
+// UsedGPUs returns the number of GPUs reserved by the containers of the
+// engine.
+func (e *Engine) UsedGPUs() int64 {
+	var r int64
+	e.RLock()
+	for _, c := range e.containers {
+		r += c.Config.GPUs()
+	}
+	e.RUnlock()
+	return r
+}
+
+// TotalGPUs returns the number of GPUs of the engine.
+func (e *Engine) TotalGPUs() int64 {
+	return GPUCapacity(e.Labels)
+}
@@ -0,0 +1,23 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGPUs(t *testing.T) {
+	config := BuildContainerConfig(container.Config{}, container.HostConfig{}, network.NetworkingConfig{})
+	assert.Equal(t, int64(0), config.GPUs())
+	config.Labels[GPUsLabel] = "2"
+	assert.Equal(t, int64(2), config.GPUs())
+	for _, val := range []string{"many", "-1", "1.5"} {
+		config.Labels[GPUsLabel] = val
+		assert.Equal(t, int64(0), config.GPUs(), val)
+	}
+
+	assert.Equal(t, int64(0), GPUCapacity(map[string]string{}))
+	assert.Equal(t, int64(8), GPUCapacity(map[string]string{NodeGPUsLabel: "8"}))
+}
@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// namespaceDependencies returns the containers whose network, IPC or PID
+// namespaces a container joins, as referenced by --net=container:,
+// --ipc=container: and --pid=container:.
+func namespaceDependencies(config *ContainerConfig) []string {
+	dependencies := []string{}
+	for _, mode := range []string{string(config.HostConfig.NetworkMode), string(config.HostConfig.IpcMode), string(config.HostConfig.PidMode)} {
+		if strings.HasPrefix(mode, "container:") {
+			dependencies = append(dependencies, strings.TrimPrefix(mode, "container:"))
+		}
+	}
+	return dependencies
+}
+
+// containerAffinity parses an affinity on a container, e.g.
+// container==~db, into its operator, softness and container reference.
+func containerAffinity(affinity string) (operator string, soft bool, ref string, ok bool) {
+	m := drainHintRegexp.FindStringSubmatch(affinity)
+	if m == nil || strings.TrimSpace(m[1]) != "container" || (m[2] != "==" && m[2] != "!=") {
+		return "", false, "", false
+	}
+	soft = strings.HasPrefix(affinity[len(m[1])+len(m[2]):], "~")
+	return m[2], soft, strings.TrimSpace(m[3]), true
+}
+
+// affinityDependencies returns the references to the containers a container
+// has a hard affinity with.
+func affinityDependencies(config *ContainerConfig) []string {
+	dependencies := []string{}
+	for _, affinity := range config.Affinities() {
+		if operator, soft, ref, ok := containerAffinity(affinity); ok && operator == "==" && !soft {
+			dependencies = append(dependencies, ref)
+		}
+	}
+	return dependencies
+}
+
+// namespaceGroups splits containers into the groups of containers sharing
+// namespaces, which must be colocated. The members of a group come after the
+// containers whose namespaces they join, and the groups after the containers
+// their members have a hard affinity with. The containers sharing no
+// namespace are groups of their own.
+func namespaceGroups(containers Containers) []Containers {
+	parent := make(map[string]string, len(containers))
+	for _, c := range containers {
+		parent[c.ID] = c.ID
+	}
+	root := func(id string) string {
+		for parent[id] != id {
+			id = parent[id]
+		}
+		return id
+	}
+	for _, c := range containers {
+		for _, ref := range namespaceDependencies(c.Config) {
+			if owner := containers.Get(ref); owner != nil {
+				parent[root(c.ID)] = root(owner.ID)
+			}
+		}
+	}
+
+	var (
+		order   []string
+		groups  = make(map[string]Containers)
+		visited = make(map[string]bool)
+	)
+	var visit func(c *Container)
+	visit = func(c *Container) {
+		if visited[c.ID] {
+			return
+		}
+		visited[c.ID] = true
+		for _, ref := range append(namespaceDependencies(c.Config), affinityDependencies(c.Config)...) {
+			if owner := containers.Get(ref); owner != nil {
+				visit(owner)
+			}
+		}
+		id := root(c.ID)
+		if _, ok := groups[id]; !ok {
+			order = append(order, id)
+		}
+		groups[id] = append(groups[id], c)
+	}
+	for _, c := range containers {
+		visit(c)
+	}
+
+	result := make([]Containers, len(order))
+	for i, id := range order {
+		result[i] = groups[id]
+	}
+	return result
+}
+
+// groupConfig returns the config a node must satisfy to take all the
+// containers of a namespace group: the config of the first member, with the
+// resources, bandwidth, GPUs, constraints and devices of all of them.
+func groupConfig(members Containers) *ContainerConfig {
+	config := copyContainerConfig(members[0].Config)
+	config.HostConfig.Devices = append([]container.DeviceMapping{}, config.HostConfig.Devices...)
+	config.HostConfig.Ulimits = append([]*units.Ulimit{}, config.HostConfig.Ulimits...)
+	bandwidth, gpus := config.Bandwidth(), config.GPUs()
+	for _, c := range members[1:] {
+		bandwidth += c.Config.Bandwidth()
+		gpus += c.Config.GPUs()
+		config.HostConfig.Memory += c.Config.HostConfig.Memory
+		config.HostConfig.CPUShares += c.Config.HostConfig.CPUShares
+		config.HostConfig.Devices = append(config.HostConfig.Devices, c.Config.HostConfig.Devices...)
+		config.HostConfig.Ulimits = append(config.HostConfig.Ulimits, c.Config.HostConfig.Ulimits...)
+		for _, constraint := range c.Config.Constraints() {
+			config.AddConstraint(constraint)
+		}
+	}
+	if bandwidth > 0 {
+		config.Labels[BandwidthLabel] = strconv.FormatInt(bandwidth, 10)
+	}
+	if gpus > 0 {
+		config.Labels[GPUsLabel] = strconv.FormatInt(gpus, 10)
+	}
+	return config
+}
+
+// rescheduleGroup is a group of containers sharing namespaces, rescheduled
+// together onto a single node.
+type rescheduleGroup struct {
+	members Containers
+	// target is the engine the whole group is rescheduled onto.
+	target *Engine
+	// reservation is the key of the reservation of the target, release
+	// releases it.
+	reservation string
+	release     func()
+	// replacements holds the new containers of the members rescheduled so
+	// far, by ID of the old ones.
+	replacements map[string]*Container
+	// broken is the first member which wasn't rescheduled in this pass, the
+	// members after it are not attempted.
+	broken *Container
+}
+
+// pin pins the config of a member to the target of the group, and points
+// its namespaces to the new containers of the members they join.
+func (g *rescheduleGroup) pin(config *ContainerConfig) error {
+	config.HostConfig.NetworkMode = container.NetworkMode(g.rewrite(string(config.HostConfig.NetworkMode)))
+	config.HostConfig.IpcMode = container.IpcMode(g.rewrite(string(config.HostConfig.IpcMode)))
+	config.HostConfig.PidMode = container.PidMode(g.rewrite(string(config.HostConfig.PidMode)))
+
+	if err := config.AddConstraint("node==" + g.target.ID); err != nil {
+		return err
+	}
+	config.Labels[rescheduleTargetLabel] = g.target.ID
+	config.SetReservation(g.reservation)
+	return nil
+}
+
+// rewrite points a container: namespace mode to the new container of the
+// member it references.
+func (g *rescheduleGroup) rewrite(mode string) string {
+	if !strings.HasPrefix(mode, "container:") {
+		return mode
+	}
+	if owner := g.members.Get(strings.TrimPrefix(mode, "container:")); owner != nil {
+		if replacement, ok := g.replacements[owner.ID]; ok {
+			return "container:" + replacement.ID
+		}
+	}
+	return mode
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func groupIDs(groups []Containers) [][]string {
+	ids := [][]string{}
+	for _, group := range groups {
+		members := []string{}
+		for _, c := range group {
+			members = append(members, c.ID)
+		}
+		ids = append(ids, members)
+	}
+	return ids
+}
+
+func TestNamespaceGroups(t *testing.T) {
+	e := createWatchdogEngine("e", true)
+	sidecar := createWatchdogContainer(e, "sidecar", nil, true)
+	sidecar.Config.HostConfig.PidMode = containertypes.PidMode("container:app")
+	app := createWatchdogContainer(e, "app", nil, true)
+	app.Config.HostConfig.NetworkMode = "container:pause"
+	pause := createWatchdogContainer(e, "pause", nil, true)
+	alone := createWatchdogContainer(e, "alone", nil, true)
+	// A namespace of a container of another engine.
+	outside := createWatchdogContainer(e, "outside", nil, true)
+	outside.Config.HostConfig.IpcMode = containertypes.IpcMode("container:elsewhere")
+
+	// The owners of the namespaces come first.
+	groups := namespaceGroups(Containers{sidecar, app, pause, alone, outside})
+	assert.Equal(t, [][]string{{"pause", "app", "sidecar"}, {"alone"}, {"outside"}}, groupIDs(groups))
+}
+
+func TestNamespaceGroupsAffinityOrder(t *testing.T) {
+	e := createWatchdogEngine("e", true)
+	web := createWatchdogContainer(e, "web", nil, true)
+	assert.NoError(t, web.Config.AddAffinity("container==db"))
+	cache := createWatchdogContainer(e, "cache", nil, true)
+	assert.NoError(t, cache.Config.AddAffinity("container==~db"))
+	db := createWatchdogContainer(e, "db", nil, true)
+
+	// The containers with a hard affinity come after their dependency, but
+	// are not grouped with it.
+	groups := namespaceGroups(Containers{web, cache, db})
+	assert.Equal(t, [][]string{{"db"}, {"web"}, {"cache"}}, groupIDs(groups))
+}
+
+func TestContainerAffinity(t *testing.T) {
+	for affinity, expected := range map[string][]interface{}{
+		"container==db":   {"==", false, "db", true},
+		"container!=~db":  {"!=", true, "db", true},
+		"container==~db1": {"==", true, "db1", true},
+		"image==redis":    {"", false, "", false},
+		"container>db":    {"", false, "", false},
+	} {
+		operator, soft, ref, ok := containerAffinity(affinity)
+		assert.Equal(t, expected, []interface{}{operator, soft, ref, ok}, affinity)
+	}
+}
+
+func TestGroupConfig(t *testing.T) {
+	e := createWatchdogEngine("e", true)
+	owner := createWatchdogContainer(e, "owner", nil, true)
+	owner.Config.HostConfig.Memory = 100
+	owner.Config.HostConfig.Devices = []containertypes.DeviceMapping{{PathOnHost: "/dev/fuse"}}
+	member := createWatchdogContainer(e, "member", nil, true)
+	member.Config.HostConfig.Memory = 200
+	member.Config.HostConfig.CPUShares = 2
+	member.Config.HostConfig.Devices = []containertypes.DeviceMapping{{PathOnHost: "/dev/nvidia0"}}
+	assert.NoError(t, member.Config.AddConstraint("zone==z1"))
+	setContainerLabel(owner, BandwidthLabel, "1G")
+	setContainerLabel(member, BandwidthLabel, "500M")
+	setContainerLabel(member, GPUsLabel, "2")
+
+	config := groupConfig(Containers{owner, member})
+	assert.Equal(t, int64(300), config.HostConfig.Memory)
+	assert.Equal(t, int64(2), config.HostConfig.CPUShares)
+	assert.Len(t, config.HostConfig.Devices, 2)
+	assert.Equal(t, []string{"zone==z1"}, config.Constraints())
+	assert.Equal(t, int64(1500000000), config.Bandwidth())
+	assert.Equal(t, int64(2), config.GPUs())
+	// The owner is left untouched.
+	assert.Equal(t, int64(100), owner.Config.HostConfig.Memory)
+	assert.Len(t, owner.Config.HostConfig.Devices, 1)
+	assert.Empty(t, owner.Config.Constraints())
+	assert.Equal(t, int64(1000000000), owner.Config.Bandwidth())
+}
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultDowntimeBuckets are the upper bounds of the buckets of the
+// histogram of the downtime of the rescheduled containers.
+var DefaultDowntimeBuckets = []time.Duration{
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+}
+
+// Histogram counts durations in buckets. It is safe for concurrent use.
+type Histogram struct {
+	sync.Mutex
+	// bounds are the sorted upper bounds of the buckets, the last bucket
+	// has no upper bound.
+	bounds []time.Duration
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds.
+func NewHistogram(bounds []time.Duration) *Histogram {
+	sorted := make([]time.Duration, len(bounds))
+	copy(sorted, bounds)
+	sort.Sort(durations(sorted))
+	return &Histogram{
+		bounds: sorted,
+		counts: make([]uint64, len(sorted)+1),
+	}
+}
+
+// Observe records a duration.
+func (h *Histogram) Observe(d time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	h.counts[i]++
+	h.count++
+	h.sum += d
+}
+
+// Count returns the number of recorded durations.
+func (h *Histogram) Count() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.count
+}
+
+// Sum returns the sum of the recorded durations.
+func (h *Histogram) Sum() time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	return h.sum
+}
+
+// Buckets returns the cumulative counts of the recorded durations by bucket
+// upper bound. The durations above the last bound are only part of Count.
+func (h *Histogram) Buckets() map[time.Duration]uint64 {
+	h.Lock()
+	defer h.Unlock()
+	buckets := make(map[time.Duration]uint64, len(h.bounds))
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		buckets[bound] = cumulative
+	}
+	return buckets
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile of
+// the recorded durations, e.g. 0.99 for the p99. It returns 0 if nothing was
+// recorded, or the last bound if the quantile is above it.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.count == 0 || len(h.bounds) == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		if cumulative >= rank {
+			return bound
+		}
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
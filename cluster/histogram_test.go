@@ -0,0 +1,26 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]time.Duration{10 * time.Second, time.Second, 5 * time.Second})
+	assert.Equal(t, time.Duration(0), h.Quantile(0.5))
+
+	for _, d := range []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 3 * time.Second, time.Minute} {
+		h.Observe(d)
+	}
+	assert.Equal(t, uint64(5), h.Count())
+	assert.Equal(t, 66*time.Second+500*time.Millisecond, h.Sum())
+	assert.Equal(t, map[time.Duration]uint64{time.Second: 2, 5 * time.Second: 4, 10 * time.Second: 4}, h.Buckets())
+
+	assert.Equal(t, time.Second, h.Quantile(0))
+	assert.Equal(t, time.Second, h.Quantile(0.4))
+	assert.Equal(t, 5*time.Second, h.Quantile(0.5))
+	// Above the last bound.
+	assert.Equal(t, 10*time.Second, h.Quantile(0.99))
+}
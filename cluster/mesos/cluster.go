@@ -394,6 +394,11 @@ func (c *Cluster) RenameContainer(container *cluster.Container, newName string)
 	return nil
 }
 
+// MoveContainer moves a container to another agent.
+func (c *Cluster) MoveContainer(container *cluster.Container, target *cluster.Engine, opts cluster.MoveOpts) (*cluster.MoveResult, error) {
+	return cluster.MoveContainer(c, container, target, opts)
+}
+
 // Networks returns all the networks in the cluster.
 func (c *Cluster) Networks() cluster.Networks {
 	c.RLock()
@@ -418,6 +423,13 @@ func (c *Cluster) listNodes() []*node.Node {
 	c.RLock()
 	defer c.RUnlock()
 
+	return c.listNodesLocked()
+}
+
+// listNodesLocked returns all the nodes in the cluster, the caller holding the
+// cluster lock. The lock is not taken again, as a recursive read lock
+// deadlocks with a writer queued in between.
+func (c *Cluster) listNodesLocked() []*node.Node {
 	out := []*node.Node{}
 	for _, s := range c.agents {
 		n := node.NewNode(s.engine)
@@ -466,6 +478,47 @@ func (c *Cluster) TotalCpus() int64 {
 	return totalCpus
 }
 
+// FreeCapacity returns the memory and CPUs offered by the healthy agents.
+func (c *Cluster) FreeCapacity() (memory int64, cpus int64) {
+	for _, n := range c.listNodes() {
+		if n.IsHealthy() {
+			memory += n.TotalMemory - n.UsedMemory
+			cpus += n.TotalCpus - n.UsedCpus
+		}
+	}
+	return memory, cpus
+}
+
+// Engine returns the engine of the agent with the given ID or name.
+func (c *Cluster) Engine(IDOrName string) *cluster.Engine {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, s := range c.agents {
+		if s.id == IDOrName || s.engine.ID == IDOrName || s.engine.Name == IDOrName {
+			return s.engine
+		}
+	}
+	return nil
+}
+
+// Capacity returns the capacity of the agents, as accounted by the scheduler.
+func (c *Cluster) Capacity() cluster.CapacityReport {
+	nodes := []cluster.NodeCapacity{}
+	for _, n := range c.listNodes() {
+		nodes = append(nodes, cluster.NodeCapacity{
+			ID:             n.ID,
+			Name:           n.Name,
+			Healthy:        n.IsHealthy(),
+			TotalMemory:    n.TotalMemory,
+			ReservedMemory: n.UsedMemory,
+			TotalCpus:      n.TotalCpus,
+			ReservedCpus:   n.UsedCpus,
+		})
+	}
+	return cluster.NewCapacityReport(nodes)
+}
+
 // Info gives minimal information about containers and resources on the mesos cluster
 func (c *Cluster) Info() [][2]string {
 	offers := c.listOffers()
@@ -635,12 +688,28 @@ func (c *Cluster) LaunchTask(t *task.Task) bool {
 	return true
 }
 
-// RANDOMENGINE returns a random engine.
+// RANDOMENGINE returns a random healthy engine.
 func (c *Cluster) RANDOMENGINE() (*cluster.Engine, error) {
+	return c.SelectEngine(&cluster.ContainerConfig{})
+}
+
+// SelectEngine returns the healthy engine a container with the given config
+// would be scheduled on.
+func (c *Cluster) SelectEngine(config *cluster.ContainerConfig) (*cluster.Engine, error) {
 	c.RLock()
 	defer c.RUnlock()
 
-	nodes, err := c.scheduler.SelectNodesForContainer(c.listNodes(), &cluster.ContainerConfig{})
+	healthy := []*node.Node{}
+	for _, n := range c.listNodesLocked() {
+		if n.IsHealthy() {
+			healthy = append(healthy, n)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, cluster.ErrNoHealthyEngine
+	}
+
+	nodes, err := c.scheduler.SelectNodesForContainer(healthy, config)
 	if err != nil {
 		return nil, err
 	}
@@ -648,6 +717,16 @@ func (c *Cluster) RANDOMENGINE() (*cluster.Engine, error) {
 	return c.agents[n.ID].engine, nil
 }
 
+// ReserveEngine selects the engine like SelectEngine. Nothing is reserved,
+// the offers of the agents account for the tasks being launched.
+func (c *Cluster) ReserveEngine(config *cluster.ContainerConfig) (*cluster.Engine, func(), error) {
+	e, err := c.SelectEngine(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, func() {}, nil
+}
+
 // BuildImage builds an image
 func (c *Cluster) BuildImage(buildContext io.Reader, buildImage *types.ImageBuildOptions, out io.Writer) error {
 	c.scheduler.Lock()
@@ -723,6 +802,23 @@ func (c *Cluster) RefreshEngine(hostname string) error {
 	return nil
 }
 
+// Snapshot returns the current placement of the containers on the mesos
+// agents, with the resources they offer.
+func (c *Cluster) Snapshot() cluster.ClusterState {
+	c.RLock()
+	defer c.RUnlock()
+
+	state := cluster.ClusterState{}
+	for _, s := range c.agents {
+		n := cluster.NewNodeState(s.engine)
+		n.ID = s.id
+		n.TotalCpus = int64(sumScalarResourceValue(s.offers, "cpus"))
+		n.TotalMemory = int64(sumScalarResourceValue(s.offers, "mem")) * 1024 * 1024
+		state.Nodes = append(state.Nodes, n)
+	}
+	return state
+}
+
 func (c *Cluster) RefreshEngines() error {
 	return nil
 }
@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// mountTypeNamedPipe is the type of the mounts of Windows named pipes, which
+// newer engines support.
+const mountTypeNamedPipe mount.Type = "npipe"
+
+// windowsPathRegexp matches the Windows host paths, e.g. C:\data or
+// \\.\pipe\docker_engine.
+var windowsPathRegexp = regexp.MustCompile(`^([a-zA-Z]:\\|\\\\)`)
+
+// localMounts returns the mounts of a container which are local to its node:
+// the host paths, the named pipes and the named volumes of local drivers. The
+// tmpfs mounts and anonymous volumes are not, they are ephemeral and created
+// again with the container. Both the mounts and the legacy binds are read.
+func localMounts(c *Container) []string {
+	hostConfig := c.Config.HostConfig
+	local := []string{}
+
+	for _, bind := range hostConfig.Binds {
+		source := bindSource(bind)
+		switch {
+		case strings.HasPrefix(source, "/") || windowsPathRegexp.MatchString(source):
+			local = append(local, fmt.Sprintf("bind %s", source))
+		case localVolume(c, source, hostConfig.VolumeDriver):
+			local = append(local, fmt.Sprintf("volume %s", source))
+		}
+	}
+
+	for _, m := range hostConfig.Mounts {
+		switch m.Type {
+		case mount.TypeBind:
+			local = append(local, fmt.Sprintf("bind %s", m.Source))
+		case mountTypeNamedPipe:
+			local = append(local, fmt.Sprintf("npipe %s", m.Source))
+		case mount.TypeVolume:
+			// A volume without source is anonymous.
+			if m.Source == "" {
+				continue
+			}
+			driver := hostConfig.VolumeDriver
+			if m.VolumeOptions != nil && m.VolumeOptions.DriverConfig != nil && m.VolumeOptions.DriverConfig.Name != "" {
+				driver = m.VolumeOptions.DriverConfig.Name
+			}
+			if localVolume(c, m.Source, driver) {
+				local = append(local, fmt.Sprintf("volume %s", m.Source))
+			}
+		}
+	}
+	return local
+}
+
+// bindSource returns the source of a bind, either a host path or a volume
+// name. The Windows host paths start with a drive letter followed by a colon.
+func bindSource(bind string) string {
+	if loc := windowsPathRegexp.FindStringIndex(bind); loc != nil {
+		if i := strings.Index(bind[loc[1]:], ":"); i >= 0 {
+			return bind[:loc[1]+i]
+		}
+		return bind
+	}
+	return strings.SplitN(bind, ":", 2)[0]
+}
+
+// localVolume returns true if the named volume is local to the node of the
+// container. The scope reported by the engine is used if the volume is
+// known, otherwise only the local driver is considered local.
+func localVolume(c *Container, name, driver string) bool {
+	if c.Engine != nil {
+		for _, v := range c.Engine.Volumes() {
+			if v.Name == name {
+				if v.Scope != "" {
+					return v.Scope == "local"
+				}
+				driver = v.Driver
+				break
+			}
+		}
+	}
+	return driver == "" || driver == "local"
+}
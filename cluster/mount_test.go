@@ -0,0 +1,66 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func mountingContainer(e *Engine, hostConfig containertypes.HostConfig) *Container {
+	return &Container{
+		Config: BuildContainerConfig(containertypes.Config{Volumes: map[string]struct{}{"/anonymous": {}}}, hostConfig, networktypes.NetworkingConfig{}),
+		Engine: e,
+	}
+}
+
+func TestLocalMounts(t *testing.T) {
+	e := NewEngine("test", 0, engOpts)
+	e.volumes = map[string]*Volume{
+		"shared":  {Volume: types.Volume{Name: "shared", Driver: "local", Scope: "global"}, Engine: e},
+		"scratch": {Volume: types.Volume{Name: "scratch", Driver: "rexray", Scope: "local"}, Engine: e},
+		"legacy":  {Volume: types.Volume{Name: "legacy", Driver: "flocker"}, Engine: e},
+	}
+
+	// tmpfs mounts and anonymous volumes are ephemeral.
+	c := mountingContainer(e, containertypes.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeTmpfs, Target: "/tmp"},
+			{Type: mount.TypeVolume, Target: "/cache"},
+		},
+	})
+	assert.Empty(t, localMounts(c))
+
+	// Host paths are local, in binds and in mounts.
+	c = mountingContainer(e, containertypes.HostConfig{
+		Binds:  []string{"/srv/data:/data:ro", `C:\data:C:\data`},
+		Mounts: []mount.Mount{{Type: mount.TypeBind, Source: "/var/log", Target: "/logs"}},
+	})
+	assert.Equal(t, []string{"bind /srv/data", `bind C:\data`, "bind /var/log"}, localMounts(c))
+
+	// So are the named pipes.
+	c = mountingContainer(e, containertypes.HostConfig{
+		Mounts: []mount.Mount{{Type: mountTypeNamedPipe, Source: `\\.\pipe\docker_engine`, Target: `\\.\pipe\docker_engine`}},
+	})
+	assert.Equal(t, []string{`npipe \\.\pipe\docker_engine`}, localMounts(c))
+
+	// Named volumes are local depending on the scope of the volume or, if
+	// unknown, of the driver.
+	c = mountingContainer(e, containertypes.HostConfig{
+		Binds: []string{"shared:/shared", "scratch:/scratch", "legacy:/legacy", "unknown:/unknown"},
+	})
+	assert.Equal(t, []string{"volume scratch", "volume unknown"}, localMounts(c))
+
+	c = mountingContainer(e, containertypes.HostConfig{
+		VolumeDriver: "convoy",
+		Binds:        []string{"unknown:/unknown"},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeVolume, Source: "other", Target: "/other"},
+			{Type: mount.TypeVolume, Source: "data", Target: "/data", VolumeOptions: &mount.VolumeOptions{DriverConfig: &mount.Driver{Name: "local"}}},
+		},
+	})
+	assert.Equal(t, []string{"volume data"}, localMounts(c))
+}
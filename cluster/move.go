@@ -0,0 +1,553 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"golang.org/x/net/context"
+)
+
+// The steps of a container move, as reported by MoveError.
+const (
+	MoveStepPrepare = "prepare"
+	MoveStepCleanup = "cleanup"
+	MoveStepRemove  = "remove"
+	MoveStepCreate  = "create"
+	MoveStepNetwork = "network"
+	MoveStepStart   = "start"
+	MoveStepHealth  = "health"
+	MoveStepRename  = "rename"
+)
+
+// moveSuffix is appended to the name of the new container of a
+// make-before-break move until the old one is removed.
+const moveSuffix = "-rescheduling"
+
+// MoveOpts are the options of MoveContainer.
+type MoveOpts struct {
+	// Config is the config of the new container, the config of the old one
+	// if nil. Its endpoints on global networks are dropped, the new
+	// container is attached to the global networks of the old one once
+	// created.
+	Config *ContainerConfig
+	// Unreachable is set when the engine of the old container is down: the
+	// old container is only dropped from the cluster view, and its endpoints
+	// on global networks are released through another engine.
+	Unreachable bool
+	// MakeBeforeBreak creates and starts the new container under a temporary
+	// name, and only removes the old one once the new one is healthy. It
+	// doesn't apply to unreachable containers, nor to the containers with
+	// static addresses on global networks, which the old container holds
+	// until it is removed.
+	MakeBeforeBreak bool
+	// Start starts the new container if the old one was running.
+	Start bool
+	// StopTimeout is the grace period given to the old container of a
+	// reachable engine to shut down cleanly, once sent its stop signal,
+	// before it is removed. 0 removes it right away.
+	StopTimeout time.Duration
+	// HealthTimeout is how long to wait for the started container to be
+	// healthy, 0 not to wait. Its health is polled every HealthInterval.
+	HealthTimeout  time.Duration
+	HealthInterval time.Duration
+	// NetworkAttachAttempts is how many times the new container is attached
+	// to each global network before giving up, every
+	// NetworkAttachRetryInterval.
+	NetworkAttachAttempts      int
+	NetworkAttachRetryInterval time.Duration
+	// Cancel interrupts the retries when closed.
+	Cancel <-chan struct{}
+	// Log is the logger of the move, the standard logger if nil.
+	Log *log.Entry
+}
+
+// MoveResult is the outcome of a container move.
+type MoveResult struct {
+	// Container is the new container.
+	Container *Container
+	// Created is when the new container was created, and Started when it was
+	// started and healthy, zero if it wasn't started.
+	Created time.Time
+	Started time.Time
+	// FailedNetworks are the global networks the new container couldn't be
+	// attached to, and FailedEndpoints its endpoints on them, by network
+	// name, to retry with ReattachNetworks.
+	FailedNetworks  []string
+	FailedEndpoints map[string]*network.EndpointSettings
+	// Unhealthy is true if the started container was not healthy within the
+	// health timeout.
+	Unhealthy bool
+}
+
+// MoveError is the error of a container move, with the step which failed.
+type MoveError struct {
+	Step string
+	Err  error
+}
+
+// Error returns the error of the step.
+func (e *MoveError) Error() string {
+	return e.Err.Error()
+}
+
+// MoveContainer moves a container of the cluster to another engine, the
+// target if not nil, preserving its config and its global networks. The old
+// container is removed before the new one is created, unless moved
+// make-before-break. Without result, the old container is left in place,
+// but for the break-before-make moves failing after its removal. A result
+// may come with an error, e.g. if the new container couldn't be attached to
+// all its networks. It is the implementation of Cluster.MoveContainer shared
+// by the clusters.
+func MoveContainer(cluster Cluster, c *Container, target *Engine, opts MoveOpts) (*MoveResult, error) {
+	if opts.Log == nil {
+		opts.Log = log.NewEntry(log.StandardLogger())
+	}
+	if opts.Config == nil {
+		opts.Config = c.Config
+	}
+	name, err := containerName(c)
+	if err != nil {
+		return nil, &MoveError{Step: MoveStepPrepare, Err: err}
+	}
+
+	// use the same view of the networks for the whole move
+	m := &containerMove{
+		cluster:         cluster,
+		container:       c,
+		name:            name,
+		opts:            opts,
+		clusterNetworks: cluster.Networks().Uniq(),
+	}
+	m.config = copyContainerConfig(opts.Config)
+	m.config.NetworkingConfig.EndpointsConfig = localEndpointsConfig(opts.Config, m.clusterNetworks)
+	if target != nil {
+		if err := m.config.AddConstraint("node==" + target.ID); err != nil {
+			return nil, &MoveError{Step: MoveStepPrepare, Err: err}
+		}
+	}
+	m.recordGlobalNetworks()
+
+	switch {
+	case opts.Unreachable:
+		return m.replaceUnreachable()
+	case opts.MakeBeforeBreak && !m.hasStaticAddresses():
+		return m.makeBeforeBreak()
+	default:
+		return m.breakBeforeMake()
+	}
+}
+
+// containerMove is a container move in progress.
+type containerMove struct {
+	cluster   Cluster
+	container *Container
+	name      string
+	config    *ContainerConfig
+	opts      MoveOpts
+
+	clusterNetworks Networks
+	// globalNetworks holds the endpoints of the old container on global
+	// networks, reattached to the new container, by network name.
+	globalNetworks map[string]*network.EndpointSettings
+}
+
+// endpoints returns the endpoints of the old container, if it has any to
+// reattach. A container using the host network has none.
+func (m *containerMove) endpoints() map[string]*network.EndpointSettings {
+	c := m.container
+	if c.Config.HostConfig.NetworkMode == "host" || c.Info.NetworkSettings == nil {
+		return nil
+	}
+	return c.Info.NetworkSettings.Networks
+}
+
+// recordGlobalNetworks records the endpoints of the old container on global
+// networks, to reconstruct them on the new container.
+func (m *containerMove) recordGlobalNetworks() {
+	m.globalNetworks = make(map[string]*network.EndpointSettings)
+	for networkName, endpoint := range m.endpoints() {
+		if endpoint == nil {
+			continue
+		}
+		net := m.clusterNetworks.Get(endpoint.NetworkID)
+		if net != nil && (net.Scope == "global" || net.Scope == "swarm") {
+			m.globalNetworks[networkName] = endpoint
+		}
+	}
+}
+
+// hasStaticAddresses returns true if the old container has static addresses
+// on global networks.
+func (m *containerMove) hasStaticAddresses() bool {
+	for networkName, endpoint := range m.globalNetworks {
+		if endpoint.IPAMConfig != nil && (endpoint.IPAMConfig.IPv4Address != "" || endpoint.IPAMConfig.IPv6Address != "") {
+			m.opts.Log.Infof("Container %s has a static address on network %s, moving it break-before-make", m.container.ID, networkName)
+			return true
+		}
+	}
+	return false
+}
+
+// replaceUnreachable replaces a container whose engine is down.
+func (m *containerMove) replaceUnreachable() (*MoveResult, error) {
+	c := m.container
+
+	// find a healthy engine to do disconnect work, the cleanup would
+	// silently fail on a dead one. This is done before the container is
+	// removed from its engine, so that it stays in the cluster view as is
+	// for the next attempt.
+	var cleanupEngine *Engine
+	if len(m.endpoints()) > 0 {
+		var err error
+		cleanupEngine, err = m.cluster.RANDOMENGINE()
+		if err == nil && !cleanupEngine.IsHealthy() {
+			err = ErrNoHealthyEngine
+		}
+		if err != nil {
+			return nil, &MoveError{Step: MoveStepCleanup, Err: err}
+		}
+	}
+
+	// Remove the container from the dead engine. If we don't, then both
+	// the old and new one will show up in docker ps.
+	// We have to do this before calling `CreateContainer`, otherwise it
+	// will abort because the name is already taken.
+	c.Engine.removeContainer(c)
+
+	// if the existing container has global network endpoints,
+	// they need to be removed with force option
+	for networkName := range m.globalNetworks {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := cleanupEngine.apiClient.NetworkDisconnect(ctx, networkName, m.name, true)
+		cancel()
+		if err != nil {
+			// do not abort here as this endpoint might have been removed before
+			m.opts.Log.Warnf("Failed to remove network endpoint from old container %s: %v", m.name, err)
+		}
+	}
+
+	result, err := m.create(c.Info.Name, m.name)
+	if result == nil {
+		// add the container back, so we can retry later
+		c.Engine.AddContainer(c)
+		return nil, err
+	}
+	m.start(result)
+	return result, err
+}
+
+// breakBeforeMake removes the old container, so that its name is available,
+// before creating the new one.
+func (m *containerMove) breakBeforeMake() (*MoveResult, error) {
+	c := m.container
+
+	// The old container is still reachable, removing it releases its
+	// global network endpoints.
+	if err := m.removeOld(); err != nil {
+		return nil, &MoveError{Step: MoveStepRemove, Err: err}
+	}
+
+	result, err := m.create(c.Info.Name, m.name)
+	if result == nil {
+		return nil, err
+	}
+	m.start(result)
+	return result, err
+}
+
+// makeBeforeBreak only removes the old container once the new one, created
+// under a temporary name, is healthy. The new container then takes the name
+// of the old one, which is left in place if the new one doesn't come up.
+func (m *containerMove) makeBeforeBreak() (*MoveResult, error) {
+	c := m.container
+	tempName := m.name + moveSuffix
+	result, err := m.create(tempName, tempName)
+	if result == nil {
+		return nil, err
+	}
+	newContainer := result.Container
+
+	if m.opts.Start && isRunning(c) {
+		// A container taken over may already be started.
+		if !isRunning(newContainer) {
+			if serr := m.cluster.StartContainer(newContainer, nil); serr != nil {
+				m.discard(newContainer)
+				return nil, &MoveError{Step: MoveStepStart, Err: fmt.Errorf("failed to start the replacement of container %s: %v", c.ID, serr)}
+			}
+		}
+		if m.opts.HealthTimeout > 0 && !m.waitHealthy(newContainer) {
+			m.discard(newContainer)
+			return nil, &MoveError{Step: MoveStepHealth, Err: fmt.Errorf("the replacement of container %s is not healthy after %s", c.ID, m.opts.HealthTimeout)}
+		}
+		result.Started = time.Now()
+	}
+
+	if rerr := m.removeOld(); rerr != nil {
+		m.discard(newContainer)
+		return nil, &MoveError{Step: MoveStepRemove, Err: rerr}
+	}
+	if rerr := m.cluster.RenameContainer(newContainer, m.name); rerr != nil {
+		m.opts.Log.Errorf("Failed to rename container %s to %s: %v", newContainer.ID, m.name, rerr)
+		err = &MoveError{Step: MoveStepRename, Err: fmt.Errorf("failed to rename container %s to %s: %v", newContainer.ID, m.name, rerr)}
+	}
+	return result, err
+}
+
+// removeOld removes the old container of a reachable engine, stopping it
+// first within the stop timeout so that it can shut down cleanly. It is
+// force removed if it fails to stop.
+func (m *containerMove) removeOld() error {
+	c := m.container
+	if m.opts.StopTimeout > 0 && isRunning(c) {
+		timeout := m.opts.StopTimeout
+		if err := c.Engine.StopContainer(c, &timeout); err != nil {
+			m.opts.Log.Warnf("Failed to stop container %s within %s, removing it: %v", c.ID, timeout, err)
+		}
+	}
+	return m.cluster.RemoveContainer(c, true, false)
+}
+
+// discard removes the new container of a make-before-break move which didn't
+// come up.
+func (m *containerMove) discard(newContainer *Container) {
+	if err := m.cluster.RemoveContainer(newContainer, true, false); err != nil {
+		m.opts.Log.Warnf("Failed to remove container %s: %v", newContainer.ID, err)
+	}
+}
+
+// create creates the new container and connects it to the global networks
+// of the old one. If the container is created but some networks can't be
+// attached, both the result and an error are returned. The container already
+// created with the reschedule key of the config is taken over instead.
+func (m *containerMove) create(fullName, name string) (*MoveResult, error) {
+	newContainer := m.created()
+	if newContainer != nil {
+		m.opts.Log.Infof("Container %s was already created as %s, taking it over", m.container.ID, newContainer.ID)
+	} else {
+		var err error
+		if newContainer, err = m.cluster.CreateContainer(m.config, fullName, nil); err != nil {
+			return nil, &MoveError{Step: MoveStepCreate, Err: err}
+		}
+	}
+	result := &MoveResult{Container: newContainer, Created: time.Now()}
+
+	// Docker create command cannot create a container with multiple networks
+	// see https://github.com/docker/docker/issues/17750
+	// Add the global networks one by one
+	for _, networkName := range networkAttachOrder(m.config, m.globalNetworks) {
+		if attached(newContainer, networkName) {
+			continue
+		}
+		endpoint := m.globalNetworks[networkName]
+		hasSubnet := false
+		if n := m.clusterNetworks.Get(networkName); n != nil {
+			for _, config := range n.IPAM.Config {
+				if config.Subnet != "" {
+					hasSubnet = true
+					break
+				}
+			}
+		}
+		// If this network did not have a defined subnet, we
+		// cannot connect to it with an explicit IP address.
+		if !hasSubnet && endpoint.IPAMConfig != nil {
+			endpoint.IPAMConfig.IPv4Address = ""
+			endpoint.IPAMConfig.IPv6Address = ""
+		}
+
+		if err := m.connectNetwork(newContainer, networkName, name, endpoint); err != nil {
+			m.opts.Log.Warnf("Failed to connect network %s to container %s: %v", networkName, name, err)
+			result.FailedNetworks = append(result.FailedNetworks, networkName)
+			if result.FailedEndpoints == nil {
+				result.FailedEndpoints = make(map[string]*network.EndpointSettings)
+			}
+			result.FailedEndpoints[networkName] = endpoint
+		}
+	}
+	if len(result.FailedNetworks) > 0 {
+		sort.Strings(result.FailedNetworks)
+		return result, &MoveError{Step: MoveStepNetwork, Err: fmt.Errorf("failed to connect container %s to networks %s", name, strings.Join(result.FailedNetworks, ", "))}
+	}
+	return result, nil
+}
+
+// created returns the container of a healthy engine created with the
+// reschedule key of the config, e.g. by the previous primary before it failed
+// over, nil if none.
+func (m *containerMove) created() *Container {
+	key := m.config.Labels[RescheduleKeyLabel]
+	if key == "" {
+		return nil
+	}
+	// The labels of the containers of failed engines are updated by their
+	// reschedules, the engine is checked first.
+	for _, c := range m.cluster.Containers() {
+		if c.Engine != m.container.Engine && c.Engine.IsHealthy() && c.Config != nil && c.Config.Labels[RescheduleKeyLabel] == key {
+			return c
+		}
+	}
+	return nil
+}
+
+// attached returns true if a container is connected to a network.
+func attached(c *Container, networkName string) bool {
+	if c.Info.NetworkSettings == nil {
+		return false
+	}
+	_, ok := c.Info.NetworkSettings.Networks[networkName]
+	return ok
+}
+
+// ReattachNetworks connects a moved container to the networks it couldn't be
+// attached to, given its endpoints on them by network name. The networks are
+// attempted independently, it returns the endpoints of the ones still
+// failing.
+func ReattachNetworks(c *Container, endpoints map[string]*network.EndpointSettings, opts MoveOpts) (map[string]*network.EndpointSettings, error) {
+	if opts.Log == nil {
+		opts.Log = log.NewEntry(log.StandardLogger())
+	}
+	name, err := containerName(c)
+	if err != nil {
+		return endpoints, err
+	}
+
+	m := &containerMove{container: c, name: name, opts: opts}
+	names := []string{}
+	for networkName := range endpoints {
+		names = append(names, networkName)
+	}
+	sort.Strings(names)
+
+	failed := make(map[string]*network.EndpointSettings)
+	for _, networkName := range names {
+		if err := m.connectNetwork(c, networkName, name, endpoints[networkName]); err != nil {
+			opts.Log.Warnf("Failed to reconnect network %s to container %s: %v", networkName, name, err)
+			failed[networkName] = endpoints[networkName]
+		}
+	}
+	return failed, nil
+}
+
+// connectNetwork connects the new container to a network, retrying to get
+// over transient failures.
+func (m *containerMove) connectNetwork(c *Container, networkName, name string, endpoint *network.EndpointSettings) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = c.Engine.apiClient.NetworkConnect(ctx, networkName, name, endpoint)
+		cancel()
+		if err == nil || attempt >= m.opts.NetworkAttachAttempts {
+			return err
+		}
+
+		m.opts.Log.Debugf("Retrying to connect network %s to container %s in %s: %v", networkName, name, m.opts.NetworkAttachRetryInterval, err)
+		select {
+		case <-time.After(m.opts.NetworkAttachRetryInterval):
+		case <-m.opts.Cancel:
+			return err
+		}
+	}
+}
+
+// start starts the new container if the old one was running. The new
+// container is kept if it fails to start.
+func (m *containerMove) start(result *MoveResult) {
+	c, newContainer := m.container, result.Container
+	if !m.opts.Start || !isRunning(c) {
+		return
+	}
+	// A container taken over may already be started.
+	if !isRunning(newContainer) {
+		m.opts.Log.Infof("Container %s was running, starting container %s", c.ID, newContainer.ID)
+		if err := m.cluster.StartContainer(newContainer, nil); err != nil {
+			m.opts.Log.Errorf("Failed to start rescheduled container %s: %v", newContainer.ID, err)
+			return
+		}
+	}
+	result.Started = time.Now()
+	if m.opts.HealthTimeout <= 0 {
+		return
+	}
+
+	if !m.waitHealthy(newContainer) {
+		m.opts.Log.Warnf("Rescheduled container %s is not healthy after %s", newContainer.ID, m.opts.HealthTimeout)
+		result.Unhealthy = true
+		return
+	}
+	result.Started = time.Now()
+}
+
+// waitHealthy polls the engine of a started container until its healthcheck
+// reports it healthy. It returns false if it didn't within the health
+// timeout, or as soon as the move is canceled. The containers without
+// healthcheck are healthy right away.
+func (m *containerMove) waitHealthy(c *Container) bool {
+	deadline := time.Now().Add(m.opts.HealthTimeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		info, err := c.Engine.apiClient.ContainerInspect(ctx, c.ID)
+		cancel()
+		if err != nil {
+			m.opts.Log.Debugf("Failed to inspect the health of container %s: %v", c.ID, err)
+		} else if info.ContainerJSONBase == nil || info.State == nil || info.State.Health == nil ||
+			info.State.Health.Status == types.NoHealthcheck || info.State.Health.Status == types.Healthy {
+			return true
+		}
+
+		if time.Now().Add(m.opts.HealthInterval).After(deadline) {
+			return false
+		}
+		select {
+		case <-time.After(m.opts.HealthInterval):
+		case <-m.opts.Cancel:
+			return false
+		}
+	}
+}
+
+// localEndpointsConfig returns the endpoints of the config, excluding the ones
+// on global networks which are reattached after the container is created.
+func localEndpointsConfig(config *ContainerConfig, clusterNetworks Networks) map[string]*network.EndpointSettings {
+	endpointsConfig := map[string]*network.EndpointSettings{}
+	for k, v := range config.NetworkingConfig.EndpointsConfig {
+		if v == nil {
+			continue
+		}
+		net := clusterNetworks.Get(v.NetworkID)
+		if net != nil && (net.Scope == "global" || net.Scope == "swarm") {
+			// These networks are already in globalNetworks
+			// and thus will be reattached later.
+			continue
+		}
+		endpointsConfig[k] = v
+	}
+	return endpointsConfig
+}
+
+// networkAttachOrder returns the order in which a moved container is
+// attached to its global networks: the networks of its network order label
+// first, then the others by name.
+func networkAttachOrder(config *ContainerConfig, globalNetworks map[string]*network.EndpointSettings) []string {
+	order := []string{}
+	listed := make(map[string]bool)
+	for _, name := range config.NetworkOrder() {
+		if _, ok := globalNetworks[name]; ok && !listed[name] {
+			order = append(order, name)
+			listed[name] = true
+		}
+	}
+
+	others := []string{}
+	for name := range globalNetworks {
+		if !listed[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(order, others...)
+}
@@ -0,0 +1,240 @@
+package cluster
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	networktypes "github.com/docker/docker/api/types/network"
+	engineapimock "github.com/docker/swarm/api/mockclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// moveNetworks returns cluster networks: overlay and backend are swarm
+// networks, the latter with a subnet, bridge a local one.
+func moveNetworks(e *Engine) Networks {
+	return Networks{
+		&Network{NetworkResource: types.NetworkResource{ID: "overlay", Name: "overlay", Scope: "swarm"}, Engine: e},
+		&Network{NetworkResource: types.NetworkResource{ID: "backend", Name: "backend", Scope: "swarm", IPAM: networktypes.IPAM{Config: []networktypes.IPAMConfig{{Subnet: "10.0.0.0/24"}}}}, Engine: e},
+		&Network{NetworkResource: types.NetworkResource{ID: "bridge", Name: "bridge", Scope: "local"}, Engine: e},
+	}
+}
+
+// connectContainer connects a container to networks, with the given static
+// IPv4 address if not empty.
+func connectContainer(c *Container, networks map[string]string) {
+	c.Info.NetworkSettings = &types.NetworkSettings{Networks: map[string]*networktypes.EndpointSettings{}}
+	c.Config.NetworkingConfig.EndpointsConfig = map[string]*networktypes.EndpointSettings{}
+	for name, address := range networks {
+		endpoint := &networktypes.EndpointSettings{NetworkID: name}
+		if address != "" {
+			endpoint.IPAMConfig = &networktypes.EndpointIPAMConfig{IPv4Address: address}
+		}
+		c.Info.NetworkSettings.Networks[name] = endpoint
+		c.Config.NetworkingConfig.EndpointsConfig[name] = endpoint
+	}
+}
+
+// networkCalls returns the networks of the calls of the method to the engine.
+func networkCalls(e *Engine, method string) []string {
+	networks := []string{}
+	for _, call := range e.apiClient.(*engineapimock.MockClient).Calls {
+		if call.Method == method {
+			networks = append(networks, call.Arguments.String(1))
+		}
+	}
+	return networks
+}
+
+func TestMoveContainerUnreachable(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}, networks: moveNetworks(alive)}
+	c := createWatchdogContainer(dead, "c1", nil, true)
+	connectContainer(c, map[string]string{"overlay": "10.1.0.5", "backend": "10.0.0.5", "bridge": ""})
+
+	result, err := cl.MoveContainer(c, nil, MoveOpts{Unreachable: true, Start: true, NetworkAttachAttempts: 1})
+	assert.NoError(t, err)
+	newContainer := result.Container
+	assert.Equal(t, alive, newContainer.Engine)
+	assert.False(t, result.Created.IsZero())
+	assert.False(t, result.Started.IsZero())
+	assert.Nil(t, dead.Containers().Get("c1"))
+
+	// The global endpoints of the old container are released through a
+	// healthy engine, and reattached to the new one. Only the local network
+	// is part of the config.
+	disconnected := networkCalls(alive, "NetworkDisconnect")
+	sort.Strings(disconnected)
+	assert.Equal(t, []string{"backend", "overlay"}, disconnected)
+	assert.Equal(t, []string{"backend", "overlay"}, networkCalls(alive, "NetworkConnect"))
+	assert.Equal(t, []string{"bridge"}, keys(newContainer.Config.NetworkingConfig.EndpointsConfig))
+	// The static address is dropped on the network without subnet.
+	assert.Equal(t, "10.0.0.5", c.Info.NetworkSettings.Networks["backend"].IPAMConfig.IPv4Address)
+	assert.Equal(t, "", c.Info.NetworkSettings.Networks["overlay"].IPAMConfig.IPv4Address)
+	// The config of the old container is left untouched.
+	assert.Len(t, c.Config.NetworkingConfig.EndpointsConfig, 3)
+}
+
+func TestMoveContainerUnreachableCleanup(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	cl := &mockCluster{engines: []*Engine{dead}, networks: moveNetworks(dead)}
+
+	// Without a healthy engine to release its endpoints, the container is
+	// left in place.
+	c := createWatchdogContainer(dead, "c1", nil, true)
+	connectContainer(c, map[string]string{"overlay": ""})
+	result, err := cl.MoveContainer(c, nil, MoveOpts{Unreachable: true})
+	assert.Nil(t, result)
+	if merr, ok := err.(*MoveError); assert.True(t, ok) {
+		assert.Equal(t, MoveStepCleanup, merr.Step)
+		assert.Equal(t, ErrNoHealthyEngine, merr.Err)
+	}
+	assert.NotNil(t, dead.Containers().Get("c1"))
+	assert.Equal(t, 0, cl.calls)
+
+	// The containers on the host network have no endpoint to release, nor
+	// do the containers without network settings. A failed creation leaves
+	// them in place.
+	host := createWatchdogContainer(dead, "host", nil, true)
+	connectContainer(host, map[string]string{"overlay": ""})
+	host.Config.HostConfig.NetworkMode = "host"
+	for _, c := range []*Container{host, createWatchdogContainer(dead, "none", nil, true)} {
+		result, err = cl.MoveContainer(c, nil, MoveOpts{Unreachable: true})
+		assert.Nil(t, result)
+		if merr, ok := err.(*MoveError); assert.True(t, ok) {
+			assert.Equal(t, MoveStepCreate, merr.Step)
+		}
+		assert.NotNil(t, dead.Containers().Get(c.ID))
+	}
+	assert.Equal(t, 2, cl.calls)
+}
+
+func TestMoveContainerNetworkFailures(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("NetworkConnect", mock.Anything, "overlay", mock.Anything, mock.Anything).Return(errors.New("overlay down"))
+	apiClient.On("NetworkConnect", mock.Anything, "backend", mock.Anything, mock.Anything).Return(errors.New("hiccup")).Once()
+	apiClient.On("NetworkConnect", mock.Anything, "backend", mock.Anything, mock.Anything).Return(nil)
+	apiClient.On("NetworkDisconnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	alive.apiClient = apiClient
+	cl := &mockCluster{engines: []*Engine{dead, alive}, networks: moveNetworks(alive)}
+	c := createWatchdogContainer(dead, "c1", nil, false)
+	connectContainer(c, map[string]string{"overlay": "", "backend": ""})
+
+	// The transient failures are retried, the container is moved anyway
+	// and the networks it couldn't be attached to reported.
+	result, err := cl.MoveContainer(c, nil, MoveOpts{Unreachable: true, Start: true, NetworkAttachAttempts: 2, NetworkAttachRetryInterval: time.Millisecond})
+	if assert.NotNil(t, result) {
+		assert.Equal(t, []string{"overlay"}, result.FailedNetworks)
+		// The stopped container is not started.
+		assert.True(t, result.Started.IsZero())
+	}
+	if merr, ok := err.(*MoveError); assert.True(t, ok) {
+		assert.Equal(t, MoveStepNetwork, merr.Step)
+		assert.Contains(t, merr.Error(), "overlay")
+	}
+	assert.Equal(t, []string{"backend", "backend", "overlay", "overlay"}, networkCalls(alive, "NetworkConnect"))
+	assert.Empty(t, cl.started)
+}
+
+func TestMoveContainerTarget(t *testing.T) {
+	origin := createWatchdogEngine("origin", true)
+	first := createWatchdogEngine("first", true)
+	second := createWatchdogEngine("second", true)
+	cl := &mockCluster{engines: []*Engine{origin, first, second}, networks: moveNetworks(second)}
+	c := createWatchdogContainer(origin, "c1", nil, true)
+	connectContainer(c, map[string]string{"overlay": "10.1.0.5"})
+
+	// The container of a reachable engine is removed first, and moved to
+	// the target.
+	result, err := cl.MoveContainer(c, second, MoveOpts{Start: true, NetworkAttachAttempts: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, second, result.Container.Engine)
+	assert.Contains(t, result.Container.Config.Constraints(), "node==second")
+	assert.Equal(t, []string{"remove c1", "create /c1", "start new-1"}, cl.ops)
+	// Removing the old container released its endpoints.
+	assert.Empty(t, networkCalls(second, "NetworkDisconnect"))
+	assert.Equal(t, []string{"overlay"}, networkCalls(second, "NetworkConnect"))
+	assert.Empty(t, c.Config.Constraints())
+}
+
+func TestMoveContainerStopTimeout(t *testing.T) {
+	origin := createWatchdogEngine("origin", true)
+	target := createWatchdogEngine("target", true)
+	cl := &mockCluster{engines: []*Engine{origin, target}}
+	apiClient := origin.apiClient.(*engineapimock.MockClient)
+	timeout := 10 * time.Second
+	stopped := -1
+	apiClient.On("ContainerStop", mock.Anything, "c1", &timeout).Return(nil).Run(func(mock.Arguments) { stopped = len(cl.ops) })
+	apiClient.On("ContainerStop", mock.Anything, "c2", mock.Anything).Return(errors.New("stuck"))
+
+	// The old container is given the grace period to stop before it is
+	// removed.
+	c := createWatchdogContainer(origin, "c1", nil, true)
+	_, err := cl.MoveContainer(c, target, MoveOpts{Start: true, StopTimeout: timeout})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stopped)
+	assert.Equal(t, []string{"remove c1", "create /c1", "start new-1"}, cl.ops)
+
+	// The make-before-break moves stop it once the new one is up.
+	c = createWatchdogContainer(origin, "c1", nil, true)
+	cl.ops = nil
+	_, err = cl.MoveContainer(c, target, MoveOpts{Start: true, MakeBeforeBreak: true, StopTimeout: timeout})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stopped)
+	assert.Equal(t, "remove c1", cl.ops[2])
+
+	// A container failing to stop is force removed anyway.
+	c = createWatchdogContainer(origin, "c2", nil, true)
+	cl.ops = nil
+	_, err = cl.MoveContainer(c, target, MoveOpts{StopTimeout: timeout})
+	assert.NoError(t, err)
+	assert.Equal(t, "remove c2", cl.ops[0])
+
+	// Without timeout, nor for stopped containers, there is nothing to stop.
+	apiClient.Calls = nil
+	for _, opts := range []MoveOpts{{}, {StopTimeout: timeout}} {
+		c = createWatchdogContainer(origin, "c3", nil, opts.StopTimeout == 0)
+		_, err = cl.MoveContainer(c, target, opts)
+		assert.NoError(t, err)
+	}
+	apiClient.AssertNotCalled(t, "ContainerStop", mock.Anything, mock.Anything, mock.Anything)
+
+	// The stop timeout of the container overrides the one of the watchdog.
+	w := &Watchdog{opts: &WatchdogOpts{RescheduleStopTimeout: time.Minute}}
+	assert.Equal(t, time.Minute, w.stopTimeout(c.Config))
+	seconds := 5
+	c.Config.StopTimeout = &seconds
+	assert.Equal(t, 5*time.Second, w.moveOpts(c.Config).StopTimeout)
+}
+
+func TestMoveContainerHealthCanceled(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	starting := healthEngine("starting", types.Starting)
+	cl := &mockCluster{engines: []*Engine{dead, starting}}
+	c := createWatchdogContainer(dead, "c1", nil, true)
+
+	// A canceled move stops waiting for the health of the new container.
+	cancel := make(chan struct{})
+	close(cancel)
+	start := time.Now()
+	result, err := cl.MoveContainer(c, nil, MoveOpts{Unreachable: true, Start: true, HealthTimeout: time.Minute, HealthInterval: 10 * time.Second, Cancel: cancel})
+	assert.NoError(t, err)
+	assert.True(t, result.Unhealthy)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+// keys returns the sorted keys of the endpoints.
+func keys(endpoints map[string]*networktypes.EndpointSettings) []string {
+	names := []string{}
+	for name := range endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
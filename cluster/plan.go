@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReschedulePlan is what the watchdog would do with the containers of an
+// engine if it failed now, computed without moving anything.
+type ReschedulePlan struct {
+	Engine string
+	// Moves are the containers which would be rescheduled.
+	Moves []PlannedMove
+	// Skipped are the containers which would be left in place.
+	Skipped []PlannedSkip
+}
+
+// PlannedMove is a container a reschedule would move.
+type PlannedMove struct {
+	Container string
+	Name      string
+	// Target is the ID of the engine the scheduler would place it on.
+	Target string
+}
+
+// PlannedSkip is a container a reschedule would leave in place, with the
+// reason why: one of the reasons of the reschedule summaries, or
+// local_mount, outside_window, namespace_group or no_target if it would be
+// attempted but fail. Error details the failures.
+type PlannedSkip struct {
+	Container string
+	Name      string
+	Reason    string
+	Error     string `json:",omitempty"`
+}
+
+// PlanReschedule returns the reschedule plan of an engine against the
+// current state of the cluster, with the decisions of a watchdog with the
+// given options. The target of each container is the engine the scheduler
+// would select for it alone: the plan doesn't account for the resources the
+// other moves would take, nor for the safe mode deferrals and the config
+// mutators.
+func PlanReschedule(cluster Cluster, opts *WatchdogOpts, e *Engine) *ReschedulePlan {
+	return planWatchdog(cluster, opts).plan(e, time.Now())
+}
+
+// RescheduleEligibility tells whether a container would be rescheduled if its
+// node failed now.
+type RescheduleEligibility struct {
+	Eligible bool
+	// Policies are the reschedule policies of the container, the default
+	// one if it has none.
+	Policies []string
+	// Reason is why the container wouldn't be rescheduled: policy, stopped,
+	// auto_remove, already_rescheduled, quarantined or local_mount, as in the
+	// reschedule plans. Error details it.
+	Reason string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// ContainerRescheduleEligibility returns whether a watchdog with the given
+// options would reschedule a container if its node failed now. Whether a
+// node could take it is not accounted for, see PlanReschedule.
+func ContainerRescheduleEligibility(cluster Cluster, opts *WatchdogOpts, c *Container) RescheduleEligibility {
+	return planWatchdog(cluster, opts).eligibility(c)
+}
+
+// planWatchdog returns a watchdog with the given options, the default ones if
+// nil, to make decisions without acting on them.
+func planWatchdog(cluster Cluster, opts *WatchdogOpts) *Watchdog {
+	if opts == nil {
+		opts, _ = NewWatchdogOpts(nil)
+	}
+	return &Watchdog{cluster: cluster, opts: opts, log: watchdogLogger(opts.LogLevel)}
+}
+
+// eligibility returns whether the container would be rescheduled if its node
+// failed now.
+func (w *Watchdog) eligibility(c *Container) RescheduleEligibility {
+	eligibility := RescheduleEligibility{Policies: []string{}}
+	if c.Config != nil {
+		if policies := c.Config.extractExprs("reschedule-policies"); len(policies) > 0 {
+			eligibility.Policies = policies
+		} else if w.opts.DefaultReschedulePolicy != "" {
+			eligibility.Policies = []string{w.opts.DefaultReschedulePolicy}
+		}
+	}
+
+	if reason := w.skipReason(c); reason != "" {
+		eligibility.Reason = reason
+	} else if err := w.checkLocalMounts(c); err != nil {
+		eligibility.Reason, eligibility.Error = "local_mount", err.Err.Error()
+	} else {
+		eligibility.Eligible = true
+	}
+	return eligibility
+}
+
+// PlanEngine returns the engine of the containers of the cluster with the
+// given ID or name, nil if no container runs on such an engine.
+func PlanEngine(cluster Cluster, IDOrName string) *Engine {
+	for _, c := range cluster.Containers() {
+		if c.Engine != nil && (c.Engine.ID == IDOrName || c.Engine.Name == IDOrName) {
+			return c.Engine
+		}
+	}
+	return nil
+}
+
+// plan makes the decisions of a rescheduling pass over the containers of an
+// engine at the given time.
+func (w *Watchdog) plan(e *Engine, now time.Time) *ReschedulePlan {
+	plan := &ReschedulePlan{Engine: e.ID, Moves: []PlannedMove{}, Skipped: []PlannedSkip{}}
+	skip := func(c *Container, name, reason string, err error) {
+		skipped := PlannedSkip{Container: c.ID, Name: name, Reason: reason}
+		if err != nil {
+			skipped.Error = err.Error()
+		}
+		plan.Skipped = append(plan.Skipped, skipped)
+	}
+
+	for _, group := range namespaceGroups(e.Containers()) {
+		members := Containers{}
+		for _, c := range group {
+			if w.eligibility(c).Eligible {
+				members = append(members, c)
+			}
+		}
+		var (
+			target   *Engine
+			groupErr error
+		)
+		if len(members) > 1 {
+			target, groupErr = w.planTarget(e, members[0], groupConfig(members))
+		}
+
+		for _, c := range group {
+			name, _ := containerName(c)
+			if reason := w.skipReason(c); reason != "" {
+				skip(c, name, reason, nil)
+				continue
+			}
+			if err := w.checkLocalMounts(c); err != nil {
+				skip(c, name, "local_mount", err.Err)
+				continue
+			}
+			if windows := w.rescheduleWindows(c); !windows.Contains(now) && !w.opts.RescheduleWindowEscalate {
+				skip(c, name, "outside_window", fmt.Errorf("next window opens at %s", windows.NextOpening(now).Format(time.RFC3339)))
+				continue
+			}
+			if groupErr != nil {
+				skip(c, name, "namespace_group", groupErr)
+				continue
+			}
+
+			t := target
+			if t == nil {
+				var err error
+				if t, err = w.planTarget(e, c, c.Config); err != nil {
+					skip(c, name, "no_target", err)
+					continue
+				}
+			}
+			plan.Moves = append(plan.Moves, PlannedMove{Container: c.ID, Name: name, Target: t.ID})
+		}
+	}
+	return plan
+}
+
+// planTarget returns the engine the scheduler would reschedule a container
+// of the engine onto with the config, never the engine itself.
+func (w *Watchdog) planTarget(e *Engine, c *Container, config *ContainerConfig) (*Engine, error) {
+	config, err := w.rescheduleConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.dropSelfAffinities(c, config); err != nil {
+		return nil, err
+	}
+	if err := config.AddConstraint("node!=" + e.ID); err != nil {
+		return nil, err
+	}
+	w.relaxCpuset(c, config)
+	return w.cluster.SelectEngine(config)
+}
@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanReschedule(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	a := createWatchdogEngine("a", true)
+	a.Labels["zone"] = "a"
+	b := createWatchdogEngine("b", true)
+	b.Labels["zone"] = "b"
+	cl := &mockCluster{engines: []*Engine{dead, a, b}}
+	opts := &WatchdogOpts{RescheduleRetryLimit: 1}
+
+	createWatchdogContainer(dead, "web", reschedulable, true)
+	assert.NoError(t, createWatchdogContainer(dead, "db", reschedulable, true).Config.AddConstraint("zone==b"))
+	createWatchdogContainer(dead, "static", nil, true)
+	createWatchdogContainer(dead, "tmp", reschedulable, true).Config.HostConfig.AutoRemove = true
+	createWatchdogContainer(dead, "bind", reschedulable, true).Config.HostConfig.Binds = []string{"/srv/data:/data"}
+	assert.NoError(t, createWatchdogContainer(dead, "gpu", reschedulable, true).Config.AddConstraint("zone==c"))
+	assert.NoError(t, createWatchdogContainer(dead, "pause", reschedulable, true).Config.AddConstraint("zone==b"))
+	createWatchdogContainer(dead, "app", reschedulable, true).Config.HostConfig.NetworkMode = "container:pause"
+
+	plan := PlanReschedule(cl, opts, PlanEngine(cl, "dead"))
+	assert.Equal(t, "dead", plan.Engine)
+	targets := make(map[string]string)
+	for _, move := range plan.Moves {
+		assert.Equal(t, move.Container, move.Name)
+		targets[move.Container] = move.Target
+	}
+	assert.Equal(t, map[string]string{"web": "a", "db": "b", "pause": "b", "app": "b"}, targets)
+	reasons := make(map[string]string)
+	for _, skipped := range plan.Skipped {
+		reasons[skipped.Container] = skipped.Reason
+		if skipped.Reason == "no_target" || skipped.Reason == "local_mount" {
+			assert.NotEmpty(t, skipped.Error, skipped.Container)
+		}
+	}
+	assert.Equal(t, map[string]string{"static": "policy", "tmp": "auto_remove", "bind": "local_mount", "gpu": "no_target"}, reasons)
+	// Nothing was moved.
+	assert.Len(t, dead.Containers(), 8)
+	assert.Equal(t, 0, cl.calls)
+
+	// The reschedule of the same state follows the plan.
+	w := NewWatchdog(cl, opts)
+	w.RescheduleEngine(dead, TriggerEngineDisconnect)
+	for id, target := range targets {
+		c := cl.Container("swarm-" + id)
+		if assert.NotNil(t, c, id) {
+			assert.Equal(t, target, c.Engine.ID, id)
+		}
+	}
+	for id := range reasons {
+		assert.NotNil(t, dead.Containers().Get(id), id)
+	}
+
+	// The containers outside of their windows are planned to wait.
+	dead = createWatchdogEngine("dead", false)
+	cl.engines = []*Engine{dead, a, b}
+	night := createWatchdogContainer(dead, "night", reschedulable, true)
+	night.Config.Labels[SwarmLabelNamespace+".reschedule-windows"] = "22:00-06:00"
+	noon := time.Date(2016, 10, 14, 12, 0, 0, 0, time.Local)
+	w = &Watchdog{cluster: cl, opts: opts, log: watchdogLogger("")}
+	plan = w.plan(dead, noon)
+	assert.Empty(t, plan.Moves)
+	if assert.Len(t, plan.Skipped, 1) {
+		assert.Equal(t, "outside_window", plan.Skipped[0].Reason)
+	}
+	opts.RescheduleWindowEscalate = true
+	assert.Len(t, w.plan(dead, noon).Moves, 1)
+
+	assert.Nil(t, PlanEngine(cl, "unknown"))
+}
+
+func TestContainerRescheduleEligibility(t *testing.T) {
+	e := createWatchdogEngine("node", true)
+	cl := &mockCluster{engines: []*Engine{e}}
+
+	web := createWatchdogContainer(e, "web", reschedulable, true)
+	static := createWatchdogContainer(e, "static", nil, true)
+	tmp := createWatchdogContainer(e, "tmp", reschedulable, true)
+	tmp.Config.HostConfig.AutoRemove = true
+	bind := createWatchdogContainer(e, "bind", reschedulable, true)
+	bind.Config.HostConfig.Binds = []string{"/srv/data:/data"}
+
+	assert.Equal(t, RescheduleEligibility{Eligible: true, Policies: []string{"on-node-failure"}}, ContainerRescheduleEligibility(cl, nil, web))
+	assert.Equal(t, RescheduleEligibility{Policies: []string{}, Reason: "policy"}, ContainerRescheduleEligibility(cl, nil, static))
+	assert.Equal(t, "auto_remove", ContainerRescheduleEligibility(cl, nil, tmp).Reason)
+
+	// The policy is set, but the local mount keeps the container in place.
+	eligibility := ContainerRescheduleEligibility(cl, nil, bind)
+	assert.False(t, eligibility.Eligible)
+	assert.Equal(t, []string{"on-node-failure"}, eligibility.Policies)
+	assert.Equal(t, "local_mount", eligibility.Reason)
+	assert.Contains(t, eligibility.Error, "/srv/data")
+
+	// The containers without policy get the default one.
+	opts := &WatchdogOpts{DefaultReschedulePolicy: "on-node-failure", RescheduleLocalMounts: true}
+	assert.Equal(t, RescheduleEligibility{Eligible: true, Policies: []string{"on-node-failure"}}, ContainerRescheduleEligibility(cl, opts, static))
+	assert.True(t, ContainerRescheduleEligibility(cl, opts, bind).Eligible)
+}
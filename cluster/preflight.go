@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+)
+
+// PreflightReport classifies the containers of an engine by what a
+// reschedule would do with them if the engine failed now.
+type PreflightReport struct {
+	Engine string
+	// Reschedule are the containers which would be rescheduled.
+	Reschedule []PlannedMove
+	// Fail are the containers a reschedule would attempt but likely fail
+	// to move, with the reason why: local_mount, namespace_group,
+	// no_target or missing_image.
+	Fail []PlannedSkip
+	// Skip are the containers a reschedule would leave alone, with the
+	// reason why: one of the reasons of the reschedule summaries,
+	// outside_window or source_excluded.
+	Skip []PlannedSkip
+}
+
+// The reasons of the plans of the containers a reschedule would fail to
+// move, the other reasons are the ones of the containers it would skip.
+var preflightFailures = map[string]bool{
+	"local_mount":     true,
+	"namespace_group": true,
+	"no_target":       true,
+}
+
+// ReschedulePreflight checks the containers of an engine for the known
+// blockers of their reschedule, with the decisions of a watchdog with the
+// given options, before a reschedule starts. It is the reschedule plan of the
+// engine, with the containers whose image is on no other healthy node seen
+// as likely failures: the image is pulled on the target, which fails for
+// the images built or loaded on the engine.
+func ReschedulePreflight(cluster Cluster, opts *WatchdogOpts, engineID string) (*PreflightReport, error) {
+	e := cluster.Engine(engineID)
+	if e == nil {
+		return nil, fmt.Errorf("no node %s in the cluster", engineID)
+	}
+	return planWatchdog(cluster, opts).preflight(e, time.Now()), nil
+}
+
+// preflight classifies the containers of an engine by what a rescheduling
+// pass at the given time would do with them.
+func (w *Watchdog) preflight(e *Engine, now time.Time) *PreflightReport {
+	report := &PreflightReport{Engine: e.ID, Reschedule: []PlannedMove{}, Fail: []PlannedSkip{}, Skip: []PlannedSkip{}}
+	if w.sourceExcluded(e) {
+		for _, c := range e.Containers() {
+			name, _ := containerName(c)
+			report.Skip = append(report.Skip, PlannedSkip{Container: c.ID, Name: name, Reason: "source_excluded"})
+		}
+		return report
+	}
+
+	plan := w.plan(e, now)
+	for _, skipped := range plan.Skipped {
+		if preflightFailures[skipped.Reason] {
+			report.Fail = append(report.Fail, skipped)
+		} else {
+			report.Skip = append(report.Skip, skipped)
+		}
+	}
+	for _, move := range plan.Moves {
+		c := e.Containers().Get(move.Container)
+		if c != nil && c.Config != nil && c.Config.Image != "" && !w.imageAvailable(e, c.Config.Image) {
+			report.Fail = append(report.Fail, PlannedSkip{
+				Container: move.Container,
+				Name:      move.Name,
+				Reason:    "missing_image",
+				Error:     fmt.Sprintf("image %s is on no other healthy node", c.Config.Image),
+			})
+			continue
+		}
+		report.Reschedule = append(report.Reschedule, move)
+	}
+	return report
+}
+
+// imageAvailable returns true if a healthy engine other than e has the
+// image.
+func (w *Watchdog) imageAvailable(e *Engine, image string) bool {
+	for _, i := range w.cluster.Images() {
+		if i.Engine != nil && i.Engine != e && i.Engine.IsHealthy() && i.Match(image, true) {
+			return true
+		}
+	}
+	return false
+}
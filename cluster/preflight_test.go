@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReschedulePreflight(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	a := createWatchdogEngine("a", true)
+	a.Labels["zone"] = "a"
+	down := createWatchdogEngine("down", false)
+	a.images = []*Image{{ImageSummary: types.ImageSummary{ID: "sha256:web", RepoTags: []string{"web:1.0"}}, Engine: a}}
+	// The image of the dead node itself or of another failed node can't be
+	// used.
+	dead.images = []*Image{{ImageSummary: types.ImageSummary{ID: "sha256:local", RepoTags: []string{"local:latest"}}, Engine: dead}}
+	down.images = []*Image{{ImageSummary: types.ImageSummary{ID: "sha256:down", RepoTags: []string{"down:latest"}}, Engine: down}}
+	cl := &mockCluster{engines: []*Engine{dead, a, down}}
+
+	createWatchdogContainer(dead, "web", reschedulable, true).Config.Image = "web:1.0"
+	createWatchdogContainer(dead, "plain", reschedulable, true)
+	createWatchdogContainer(dead, "local", reschedulable, true).Config.Image = "local"
+	createWatchdogContainer(dead, "down", reschedulable, true).Config.Image = "down:latest"
+	createWatchdogContainer(dead, "bind", reschedulable, true).Config.HostConfig.Binds = []string{"/srv/data:/data"}
+	assert.NoError(t, createWatchdogContainer(dead, "gpu", reschedulable, true).Config.AddConstraint("zone==c"))
+	createWatchdogContainer(dead, "static", nil, true)
+	createWatchdogContainer(dead, "tmp", reschedulable, true).Config.HostConfig.AutoRemove = true
+
+	report, err := ReschedulePreflight(cl, nil, "dead")
+	assert.NoError(t, err)
+	assert.Equal(t, "dead", report.Engine)
+	moves := map[string]string{}
+	for _, move := range report.Reschedule {
+		moves[move.Container] = move.Target
+	}
+	assert.Equal(t, map[string]string{"web": "a", "plain": "a"}, moves)
+	failures := map[string]string{}
+	for _, failure := range report.Fail {
+		assert.NotEmpty(t, failure.Error, failure.Container)
+		failures[failure.Container] = failure.Reason
+	}
+	assert.Equal(t, map[string]string{"local": "missing_image", "down": "missing_image", "bind": "local_mount", "gpu": "no_target"}, failures)
+	skips := map[string]string{}
+	for _, skipped := range report.Skip {
+		skips[skipped.Container] = skipped.Reason
+	}
+	assert.Equal(t, map[string]string{"static": "policy", "tmp": "auto_remove"}, skips)
+	// Nothing was moved.
+	assert.Len(t, dead.Containers(), 8)
+	assert.Equal(t, 0, cl.calls)
+
+	// The containers of the excluded nodes are all skipped.
+	dead.Labels[RescheduleSourceExcludedLabel] = "true"
+	report, err = ReschedulePreflight(cl, nil, "dead")
+	assert.NoError(t, err)
+	assert.Empty(t, report.Reschedule)
+	assert.Empty(t, report.Fail)
+	assert.Len(t, report.Skip, 8)
+	for _, skipped := range report.Skip {
+		assert.Equal(t, "source_excluded", skipped.Reason)
+	}
+
+	_, err = ReschedulePreflight(cl, nil, "unknown")
+	assert.Error(t, err)
+}
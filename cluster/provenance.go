@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// rescheduleStampedLabel records the labels stamped on a rescheduled
+// container by the label templates, so that the next reschedules update them
+// while leaving the labels of the user alone.
+const rescheduleStampedLabel = SwarmLabelNamespace + ".reschedule.stamped"
+
+// RescheduleLabelData is what the reschedule label templates are expanded
+// with, e.g. "{{.OldEngine}}".
+type RescheduleLabelData struct {
+	// OldEngine is the name of the node the container is moved off.
+	OldEngine string
+	// Reason is the trigger of the move, e.g. "engine_disconnect".
+	Reason string
+	// Timestamp is when the container is moved, in RFC 3339 in UTC.
+	Timestamp string
+}
+
+// parseLabelTemplates parses the reschedule label templates, by label.
+func parseLabelTemplates(templates map[string]string) (map[string]*template.Template, error) {
+	parsed := make(map[string]*template.Template, len(templates))
+	for label, text := range templates {
+		if label == "" {
+			return nil, fmt.Errorf("reschedule-label-template should have a label, %s is invalid", text)
+		}
+		if strings.HasPrefix(label, SwarmLabelNamespace+".") {
+			return nil, fmt.Errorf("reschedule-label-template can't set the labels of swarm, %s is invalid", label)
+		}
+		tmpl, err := template.New(label).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("reschedule-label-template of %s is invalid: %v", label, err)
+		}
+		// Catch the references to unknown fields upfront.
+		if err := tmpl.Execute(&bytes.Buffer{}, RescheduleLabelData{}); err != nil {
+			return nil, fmt.Errorf("reschedule-label-template of %s is invalid: %v", label, err)
+		}
+		parsed[label] = tmpl
+	}
+	return parsed, nil
+}
+
+// stampLabels expands the reschedule label templates for the move of c and
+// merges them into the labels of the config of its replacement. The labels
+// the user set are kept, only the ones stamped by a previous move are
+// updated.
+func (w *Watchdog) stampLabels(c *Container, config *ContainerConfig, trigger RescheduleTrigger, now time.Time) error {
+	if len(w.labelTemplates) == 0 {
+		return nil
+	}
+
+	stamped := make(map[string]bool)
+	if value := config.Labels[rescheduleStampedLabel]; value != "" {
+		for _, label := range strings.Split(value, ",") {
+			stamped[label] = true
+		}
+	}
+
+	data := RescheduleLabelData{
+		OldEngine: c.Engine.Name,
+		Reason:    string(trigger),
+		Timestamp: now.UTC().Format(time.RFC3339),
+	}
+	labels := []string{}
+	for label, tmpl := range w.labelTemplates {
+		if _, ok := config.Labels[label]; ok && !stamped[label] {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("cannot expand the label template of %s: %v", label, err)
+		}
+		config.Labels[label] = buf.String()
+		labels = append(labels, label)
+	}
+
+	// The stale stamps, e.g. of a template since removed, are left as they
+	// are, still not the labels of the user.
+	for label := range stamped {
+		if _, ok := w.labelTemplates[label]; !ok {
+			if _, ok := config.Labels[label]; ok {
+				labels = append(labels, label)
+			}
+		}
+	}
+	if len(labels) == 0 {
+		delete(config.Labels, rescheduleStampedLabel)
+		return nil
+	}
+	sort.Strings(labels)
+	config.Labels[rescheduleStampedLabel] = strings.Join(labels, ",")
+	return nil
+}
@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabelTemplates(t *testing.T) {
+	templates, err := parseLabelTemplates(map[string]string{"origin": "{{.OldEngine}}/{{.Reason}}", "static": "moved"})
+	assert.NoError(t, err)
+	assert.Len(t, templates, 2)
+
+	for _, invalid := range []map[string]string{
+		{"": "{{.OldEngine}}"},
+		{"origin": "{{.OldEngine"},
+		{"origin": "{{.NewEngine}}"},
+		{SwarmLabelNamespace + ".reschedule-policies": "{{.Reason}}"},
+	} {
+		_, err = parseLabelTemplates(invalid)
+		assert.Error(t, err, "%v", invalid)
+	}
+
+	opts, err := NewWatchdogOpts(DriverOpts{"reschedule-label-template=origin={{.OldEngine}},moved-at={{.Timestamp}}"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"origin": "{{.OldEngine}}", "moved-at": "{{.Timestamp}}"}, opts.RescheduleLabelTemplate)
+
+	for _, invalid := range []string{"origin", "={{.OldEngine}}", "origin={{.Unknown}}"} {
+		_, err = NewWatchdogOpts(DriverOpts{"reschedule-label-template=" + invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestWatchdogRescheduleLabelTemplate(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	first := createWatchdogEngine("first", true)
+	cl := &mockCluster{engines: []*Engine{dead, first}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleLabelTemplate: map[string]string{
+		"origin.node":   "{{.OldEngine}}",
+		"origin.reason": "moved on {{.Reason}}",
+		"origin.at":     "{{.Timestamp}}",
+		"owner":         "watchdog",
+	}})
+
+	// The label of the user is kept.
+	labels := map[string]string{"owner": "team-a"}
+	for k, v := range reschedulable {
+		labels[k] = v
+	}
+	createWatchdogContainer(dead, "c1", labels, true)
+	before := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	if !assert.Len(t, first.Containers(), 1) {
+		return
+	}
+	labels = first.Containers()[0].Config.Labels
+	assert.Equal(t, "dead", labels["origin.node"])
+	assert.Equal(t, "moved on engine_disconnect", labels["origin.reason"])
+	at, err := time.Parse(time.RFC3339, labels["origin.at"])
+	assert.NoError(t, err)
+	assert.False(t, at.Before(before))
+	assert.Equal(t, "team-a", labels["owner"])
+	assert.Equal(t, "origin.at,origin.node,origin.reason", labels[rescheduleStampedLabel])
+
+	// The labels stamped by the previous reschedule are updated.
+	second := createWatchdogEngine("second", true)
+	cl.engines = append(cl.engines, second)
+	first.setState(stateUnhealthy)
+	assert.NoError(t, w.RescheduleEngine(first, TriggerEngineDisconnect))
+	if assert.Len(t, second.Containers(), 1) {
+		labels := second.Containers()[0].Config.Labels
+		assert.Equal(t, "first", labels["origin.node"])
+		assert.Equal(t, "team-a", labels["owner"])
+	}
+}
+
+func TestWatchdogDrainLabelTemplate(t *testing.T) {
+	drained := createWatchdogEngine("drained", true)
+	target := createWatchdogEngine("target", true)
+	cl := &mockCluster{engines: []*Engine{drained, target}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleLabelTemplate: map[string]string{"origin": "{{.OldEngine}}:{{.Reason}}"}})
+
+	createWatchdogContainer(drained, "c1", drainable, true)
+	assert.NoError(t, w.Drain(drained, ""))
+	if assert.Len(t, target.Containers(), 1) {
+		assert.Equal(t, "drained:"+string(TriggerDrain), target.Containers()[0].Config.Labels["origin"])
+	}
+}
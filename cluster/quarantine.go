@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuarantinedContainer is a container of a failed node the watchdog stopped
+// trying to reschedule after too many failed attempts.
+type QuarantinedContainer struct {
+	ID     string
+	Name   string
+	Engine string
+	// Attempts is the number of failed attempts to reschedule the
+	// container, and Reason the failure of the last one.
+	Attempts int
+	Reason   string
+	Since    time.Time
+}
+
+// Quarantine holds the containers quarantined by the watchdog, until an
+// operator releases them. It is shared by the watchdog and the API, and local
+// to the manager: the watchdog of a new primary quarantines the containers
+// again after their next failed attempt.
+type Quarantine struct {
+	sync.Mutex
+
+	containers map[string]*QuarantinedContainer
+	// released is called with the containers released from quarantine.
+	released func(qc *QuarantinedContainer)
+}
+
+// NewQuarantine creates an empty quarantine.
+func NewQuarantine() *Quarantine {
+	return &Quarantine{containers: make(map[string]*QuarantinedContainer)}
+}
+
+// Quarantined returns true if the container with the given ID is
+// quarantined. Nothing is quarantined in a nil quarantine.
+func (q *Quarantine) Quarantined(ID string) bool {
+	if q == nil {
+		return false
+	}
+	q.Lock()
+	defer q.Unlock()
+	_, ok := q.containers[ID]
+	return ok
+}
+
+// List returns the quarantined containers, oldest first.
+func (q *Quarantine) List() []QuarantinedContainer {
+	list := []QuarantinedContainer{}
+	if q == nil {
+		return list
+	}
+	q.Lock()
+	for _, qc := range q.containers {
+		list = append(list, *qc)
+	}
+	q.Unlock()
+	sort.Sort(quarantinedContainers(list))
+	return list
+}
+
+// Release releases the container with the given ID or name from quarantine,
+// for the watchdog to retry it. It returns false if no such container is
+// quarantined.
+func (q *Quarantine) Release(IDOrName string) bool {
+	if q == nil {
+		return false
+	}
+	q.Lock()
+	var released *QuarantinedContainer
+	for id, qc := range q.containers {
+		if id == IDOrName || qc.Name == strings.TrimPrefix(IDOrName, "/") {
+			released = qc
+			delete(q.containers, id)
+			break
+		}
+	}
+	handler := q.released
+	q.Unlock()
+
+	if released == nil {
+		return false
+	}
+	if handler != nil {
+		handler(released)
+	}
+	return true
+}
+
+// Add quarantines a container.
+func (q *Quarantine) Add(qc *QuarantinedContainer) {
+	q.Lock()
+	q.containers[qc.ID] = qc
+	q.Unlock()
+}
+
+// setReleaseHandler sets the function called with the containers released
+// from quarantine.
+func (q *Quarantine) setReleaseHandler(handler func(qc *QuarantinedContainer)) {
+	q.Lock()
+	q.released = handler
+	q.Unlock()
+}
+
+// quarantinedContainers sorts quarantined containers by quarantine time.
+type quarantinedContainers []QuarantinedContainer
+
+func (c quarantinedContainers) Len() int      { return len(c) }
+func (c quarantinedContainers) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c quarantinedContainers) Less(i, j int) bool {
+	if !c[i].Since.Equal(c[j].Since) {
+		return c[i].Since.Before(c[j].Since)
+	}
+	return c[i].ID < c[j].ID
+}
@@ -0,0 +1,36 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	var nilQuarantine *Quarantine
+	assert.False(t, nilQuarantine.Quarantined("c1"))
+	assert.Empty(t, nilQuarantine.List())
+	assert.False(t, nilQuarantine.Release("c1"))
+
+	q := NewQuarantine()
+	now := time.Now()
+	q.Add(&QuarantinedContainer{ID: "c2", Name: "web", Since: now})
+	q.Add(&QuarantinedContainer{ID: "c1", Name: "db", Since: now.Add(-time.Minute)})
+	assert.True(t, q.Quarantined("c1"))
+	assert.False(t, q.Quarantined("db"))
+	list := q.List()
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "c1", list[0].ID)
+		assert.Equal(t, "c2", list[1].ID)
+	}
+
+	// The containers are released by ID or name, once.
+	released := []string{}
+	q.setReleaseHandler(func(qc *QuarantinedContainer) { released = append(released, qc.ID) })
+	assert.True(t, q.Release("/web"))
+	assert.False(t, q.Release("web"))
+	assert.True(t, q.Release("c1"))
+	assert.Equal(t, []string{"c2", "c1"}, released)
+	assert.Empty(t, q.List())
+}
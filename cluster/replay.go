@@ -0,0 +1,635 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/volume"
+	engineapinop "github.com/docker/swarm/api/nopclient"
+	"github.com/samalba/dockerclient"
+	"golang.org/x/net/context"
+)
+
+// replayEngineOpts are the options of the engines of a ReplayCluster.
+var replayEngineOpts = &EngineOpts{
+	RefreshMinInterval: time.Second,
+	RefreshMaxInterval: time.Second,
+	FailureRetry:       3,
+}
+
+// RecordedEvent is a cluster event recorded by an EventRecorder.
+type RecordedEvent struct {
+	// Engine is the ID of the engine of the event.
+	Engine  string         `json:"engine,omitempty"`
+	Message events.Message `json:"message"`
+	// Node is the state of the engine when it connects, so that the replay
+	// knows its containers.
+	Node *NodeState `json:"node,omitempty"`
+}
+
+// EventLog is a recorded sequence of cluster events, starting from a
+// snapshot of the cluster.
+type EventLog struct {
+	State  ClusterState
+	Events []RecordedEvent
+}
+
+// eventLogEntry is a line of an event log, holding either the initial state
+// of the cluster or an event.
+type eventLogEntry struct {
+	State *ClusterState  `json:"state,omitempty"`
+	Event *RecordedEvent `json:"event,omitempty"`
+}
+
+// ReadEventLog reads an event log written by an EventRecorder.
+func ReadEventLog(r io.Reader) (*EventLog, error) {
+	eventLog := &EventLog{}
+	decoder := json.NewDecoder(r)
+	for {
+		var entry eventLogEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return eventLog, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid event log: %v", err)
+		}
+		if entry.State != nil {
+			eventLog.State = *entry.State
+		}
+		if entry.Event != nil {
+			eventLog.Events = append(eventLog.Events, *entry.Event)
+		}
+	}
+}
+
+// EventRecorder is an event handler writing the cluster events to an event
+// log, one JSON object per line, for them to be replayed later.
+type EventRecorder struct {
+	sync.Mutex
+	encoder *json.Encoder
+	// syncer, if set, commits the entries written to stable storage.
+	syncer interface {
+		Sync() error
+	}
+}
+
+// NewEventRecorder creates an event recorder writing to w, starting with the
+// state of the cluster. If w can be synced, e.g. a file, each entry is synced
+// once written, so that the log is complete whenever the manager exits.
+func NewEventRecorder(w io.Writer, state ClusterState) (*EventRecorder, error) {
+	r := &EventRecorder{encoder: json.NewEncoder(w)}
+	r.syncer, _ = w.(interface {
+		Sync() error
+	})
+	if err := r.write(eventLogEntry{State: &state}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// write writes an entry to the event log, and syncs it.
+func (r *EventRecorder) write(entry eventLogEntry) error {
+	if err := r.encoder.Encode(entry); err != nil {
+		return err
+	}
+	if r.syncer != nil {
+		return r.syncer.Sync()
+	}
+	return nil
+}
+
+// Handle records an event.
+func (r *EventRecorder) Handle(e *Event) error {
+	recorded := RecordedEvent{Message: e.Message}
+	if e.Engine != nil {
+		recorded.Engine = e.Engine.ID
+		if e.From == "swarm" && (e.Status == "engine_connect" || e.Status == "engine_reconnect") {
+			node := NewNodeState(e.Engine)
+			recorded.Node = &node
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	return r.write(eventLogEntry{Event: &recorded})
+}
+
+// EventPriority makes the recorder handle the events first, in the order the
+// cluster dispatches them.
+func (r *EventRecorder) EventPriority() int {
+	return EventPriorityState - 1
+}
+
+// ReplayAction is an action taken on a ReplayCluster.
+type ReplayAction struct {
+	// Action is create, start, stop or remove for the actions on the
+	// containers, or the status of the events emitted by the watchdog,
+	// e.g. container_rescheduled.
+	Action string `json:"action"`
+	// Container is the name of the container, without the preceding '/'.
+	Container string `json:"container,omitempty"`
+	// Engine is the ID of the engine.
+	Engine string `json:"engine"`
+}
+
+func (a ReplayAction) String() string {
+	if a.Container == "" {
+		return fmt.Sprintf("%s on %s", a.Action, a.Engine)
+	}
+	return fmt.Sprintf("%s %s on %s", a.Action, a.Container, a.Engine)
+}
+
+// PlaceFunc selects the engine of a new container among the healthy engines
+// of a ReplayCluster, e.g. with the scheduler.
+type PlaceFunc func(config *ContainerConfig, engines []*Engine) (*Engine, error)
+
+// ReplayCluster is an in-memory Cluster without any engine behind it. The
+// recorded events are replayed against it, and it keeps the actions taken on
+// its containers.
+type ReplayCluster struct {
+	sync.Mutex
+	engines []*Engine
+	place   PlaceFunc
+	actions []ReplayAction
+	created int
+}
+
+// NewReplayCluster creates a replay cluster from the state of a cluster. A
+// nil place puts the new containers on the healthy engine with the fewest
+// containers.
+func NewReplayCluster(state ClusterState, place PlaceFunc) *ReplayCluster {
+	if place == nil {
+		place = placeFewestContainers
+	}
+	c := &ReplayCluster{place: place}
+	for _, n := range state.Nodes {
+		c.setNode(n)
+	}
+	return c
+}
+
+// placeFewestContainers returns the engine with the fewest containers, the
+// first by name if several have as many.
+func placeFewestContainers(config *ContainerConfig, engines []*Engine) (*Engine, error) {
+	var selected *Engine
+	for _, e := range engines {
+		if selected == nil || len(e.Containers()) < len(selected.Containers()) {
+			selected = e
+		}
+	}
+	if selected == nil {
+		return nil, ErrNoHealthyEngine
+	}
+	return selected, nil
+}
+
+// setNode creates or replaces the engine of a node. A node which isn't
+// healthy is created unhealthy.
+func (c *ReplayCluster) setNode(n NodeState) *Engine {
+	e := c.engine(n.ID)
+	if e == nil {
+		e = NewEngine(n.Addr, 0, replayEngineOpts)
+		e.ID = n.ID
+		e.apiClient = &replayAPIClient{cluster: c, engine: e}
+		e.eventHandler = c
+		c.engines = append(c.engines, e)
+	}
+
+	e.Lock()
+	e.Name = n.Name
+	e.IP = n.IP
+	e.Memory = n.TotalMemory
+	e.Cpus = n.TotalCpus
+	e.Labels = make(map[string]string, len(n.Labels))
+	for k, v := range n.Labels {
+		e.Labels[k] = v
+	}
+	e.images = nil
+	for _, image := range n.Images {
+		img := image.ToImage()
+		img.Engine = e
+		e.images = append(e.images, img)
+	}
+	e.containers = make(map[string]*Container)
+	for _, state := range n.Containers {
+		container := state.ToContainer()
+		container.Engine = e
+		e.containers[container.ID] = container
+	}
+	if n.HealthIndicator > 0 {
+		e.state = stateHealthy
+	} else {
+		e.state = stateUnhealthy
+	}
+	e.Unlock()
+
+	sort.Sort(EngineSorter(c.engines))
+	return e
+}
+
+// engine returns the engine with the given ID, or nil.
+func (c *ReplayCluster) engine(ID string) *Engine {
+	for _, e := range c.engines {
+		if e.ID == ID {
+			return e
+		}
+	}
+	return nil
+}
+
+// healthyEngines returns the healthy engines, by name.
+func (c *ReplayCluster) healthyEngines() []*Engine {
+	healthy := []*Engine{}
+	for _, e := range c.engines {
+		if e.IsHealthy() {
+			healthy = append(healthy, e)
+		}
+	}
+	return healthy
+}
+
+// apply updates the cluster with a recorded event, and returns the event to
+// dispatch, or nil if its engine is unknown.
+func (c *ReplayCluster) apply(recorded RecordedEvent) *Event {
+	c.Lock()
+	defer c.Unlock()
+
+	e := c.engine(recorded.Engine)
+	if recorded.Node != nil {
+		e = c.setNode(*recorded.Node)
+	}
+	if e == nil {
+		return nil
+	}
+
+	message := recorded.Message
+	switch {
+	case message.From == "swarm" && (message.Status == "engine_connect" || message.Status == "engine_reconnect"):
+		e.setState(stateHealthy)
+	case message.From == "swarm" && message.Status == "engine_disconnect":
+		e.setState(stateUnhealthy)
+	case message.From != "swarm":
+		if container := e.Containers().Get(message.ID); container != nil && container.Info.ContainerJSONBase != nil && container.Info.State != nil {
+			switch message.Status {
+			case "start":
+				container.Info.State.Running = true
+			case "die", "stop", "kill":
+				container.Info.State.Running = false
+			case "destroy":
+				e.removeContainer(container)
+			}
+		}
+	}
+	return &Event{Message: message, Engine: e}
+}
+
+// record adds an action taken on the cluster.
+func (c *ReplayCluster) record(action string, container *Container, e *Engine) {
+	a := ReplayAction{Action: action}
+	if container != nil {
+		if len(container.Names) > 0 {
+			a.Container = strings.TrimPrefix(container.Names[0], "/")
+		} else {
+			a.Container = container.ID
+		}
+	}
+	if e != nil {
+		a.Engine = e.ID
+	}
+	c.Lock()
+	c.actions = append(c.actions, a)
+	c.Unlock()
+}
+
+// Actions returns the actions taken on the cluster, in order.
+func (c *ReplayCluster) Actions() []ReplayAction {
+	c.Lock()
+	defer c.Unlock()
+	actions := make([]ReplayAction, len(c.actions))
+	copy(actions, c.actions)
+	return actions
+}
+
+// Handle records the events emitted by the watchdog.
+func (c *ReplayCluster) Handle(e *Event) error {
+	c.record(e.Status, nil, e.Engine)
+	return nil
+}
+
+// CreateContainer places a container on an engine.
+func (c *ReplayCluster) CreateContainer(config *ContainerConfig, name string, authConfig *types.AuthConfig) (*Container, error) {
+	c.Lock()
+	e, err := c.place(config, c.healthyEngines())
+	if err != nil {
+		c.Unlock()
+		return nil, err
+	}
+	c.created++
+	fullName := "/" + strings.TrimPrefix(name, "/")
+	container := &Container{
+		Container: types.Container{ID: fmt.Sprintf("replayed-%d", c.created), Names: []string{fullName}, Image: config.Image, Labels: config.Labels},
+		Config:    config,
+		Info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				Name:       fullName,
+				State:      &types.ContainerState{},
+				HostConfig: &config.HostConfig,
+			},
+			Config: &config.Config,
+		},
+		Engine: e,
+	}
+	c.Unlock()
+
+	e.AddContainer(container)
+	c.record("create", container, e)
+	return container, nil
+}
+
+// RemoveContainer removes a container from its engine.
+func (c *ReplayCluster) RemoveContainer(container *Container, force, volumes bool) error {
+	return container.Engine.RemoveContainer(container, force, volumes)
+}
+
+// Images returns no image.
+func (c *ReplayCluster) Images() Images { return Images{} }
+
+// Image returns no image.
+func (c *ReplayCluster) Image(IDOrName string) *Image { return nil }
+
+// RemoveImages removes no image.
+func (c *ReplayCluster) RemoveImages(name string, force bool) ([]types.ImageDelete, error) {
+	return nil, nil
+}
+
+// Containers returns the containers of all the engines.
+func (c *ReplayCluster) Containers() Containers {
+	c.Lock()
+	defer c.Unlock()
+	out := Containers{}
+	for _, e := range c.engines {
+		out = append(out, e.Containers()...)
+	}
+	return out
+}
+
+// StartContainer starts a container.
+func (c *ReplayCluster) StartContainer(container *Container, hostConfig *dockerclient.HostConfig) error {
+	if container.Info.ContainerJSONBase != nil && container.Info.State != nil {
+		container.Info.State.Running = true
+	}
+	c.record("start", container, container.Engine)
+	return nil
+}
+
+// Container returns the container matching IDOrName.
+func (c *ReplayCluster) Container(IDOrName string) *Container {
+	return c.Containers().Get(IDOrName)
+}
+
+// Networks returns no network.
+func (c *ReplayCluster) Networks() Networks { return Networks{} }
+
+// CreateNetwork isn't supported.
+func (c *ReplayCluster) CreateNetwork(name string, request *types.NetworkCreate) (*types.NetworkCreateResponse, error) {
+	return nil, errNotSupportedByReplay
+}
+
+// RemoveNetwork isn't supported.
+func (c *ReplayCluster) RemoveNetwork(network *Network) error { return errNotSupportedByReplay }
+
+// CreateVolume isn't supported.
+func (c *ReplayCluster) CreateVolume(request *volume.VolumesCreateBody) (*types.Volume, error) {
+	return nil, errNotSupportedByReplay
+}
+
+// Volumes returns no volume.
+func (c *ReplayCluster) Volumes() Volumes { return Volumes{} }
+
+// RemoveVolumes removes no volume.
+func (c *ReplayCluster) RemoveVolumes(name string) (bool, error) { return false, nil }
+
+// Pull does nothing, the images are assumed to be available.
+func (c *ReplayCluster) Pull(name string, authConfig *types.AuthConfig, callback func(where, status string, err error)) {
+}
+
+// Import does nothing.
+func (c *ReplayCluster) Import(source string, ref string, tag string, imageReader io.Reader, callback func(where, status string, err error)) {
+}
+
+// Load does nothing.
+func (c *ReplayCluster) Load(imageReader io.Reader, callback func(what, status string, err error)) {}
+
+// Info returns no info.
+func (c *ReplayCluster) Info() [][2]string { return nil }
+
+// TotalMemory returns the total memory of the cluster.
+func (c *ReplayCluster) TotalMemory() int64 {
+	c.Lock()
+	defer c.Unlock()
+	var memory int64
+	for _, e := range c.engines {
+		memory += e.TotalMemory()
+	}
+	return memory
+}
+
+// TotalCpus returns the number of CPUs in the cluster.
+func (c *ReplayCluster) TotalCpus() int64 {
+	c.Lock()
+	defer c.Unlock()
+	var cpus int64
+	for _, e := range c.engines {
+		cpus += e.TotalCpus()
+	}
+	return cpus
+}
+
+// RegisterEventHandler does nothing, the events are dispatched by Replay.
+func (c *ReplayCluster) RegisterEventHandler(h EventHandler) error { return nil }
+
+// UnregisterEventHandler does nothing.
+func (c *ReplayCluster) UnregisterEventHandler(h EventHandler) {}
+
+// SelectEngine returns the healthy engine a container would be placed on.
+func (c *ReplayCluster) SelectEngine(config *ContainerConfig) (*Engine, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.place(config, c.healthyEngines())
+}
+
+// ReserveEngine selects the engine like SelectEngine. Nothing is reserved,
+// the replay places the containers one at a time.
+func (c *ReplayCluster) ReserveEngine(config *ContainerConfig) (*Engine, func(), error) {
+	e, err := c.SelectEngine(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, func() {}, nil
+}
+
+// FreeCapacity returns the memory and CPUs not reserved by containers on the
+// healthy engines.
+func (c *ReplayCluster) FreeCapacity() (memory int64, cpus int64) {
+	c.Lock()
+	defer c.Unlock()
+	for _, e := range c.healthyEngines() {
+		memory += e.TotalMemory() - e.UsedMemory()
+		cpus += e.TotalCpus() - e.UsedCpus()
+	}
+	return memory, cpus
+}
+
+// Engine returns the engine with the given ID or name.
+func (c *ReplayCluster) Engine(IDOrName string) *Engine {
+	c.Lock()
+	defer c.Unlock()
+	for _, e := range c.engines {
+		if e.ID == IDOrName || e.Name == IDOrName {
+			return e
+		}
+	}
+	return nil
+}
+
+// Capacity returns the capacity of the engines.
+func (c *ReplayCluster) Capacity() CapacityReport {
+	c.Lock()
+	defer c.Unlock()
+	return NewCapacityReport(engineCapacities(c.engines))
+}
+
+// RANDOMENGINE returns the first healthy engine, for the replays to be
+// deterministic.
+func (c *ReplayCluster) RANDOMENGINE() (*Engine, error) {
+	c.Lock()
+	defer c.Unlock()
+	if healthy := c.healthyEngines(); len(healthy) > 0 {
+		return healthy[0], nil
+	}
+	return nil, ErrNoHealthyEngine
+}
+
+// RenameContainer isn't supported.
+func (c *ReplayCluster) RenameContainer(container *Container, newName string) error {
+	return errNotSupportedByReplay
+}
+
+// MoveContainer moves a container to another engine.
+func (c *ReplayCluster) MoveContainer(container *Container, target *Engine, opts MoveOpts) (*MoveResult, error) {
+	return MoveContainer(c, container, target, opts)
+}
+
+// BuildImage isn't supported.
+func (c *ReplayCluster) BuildImage(io.Reader, *types.ImageBuildOptions, io.Writer) error {
+	return errNotSupportedByReplay
+}
+
+// TagImage isn't supported.
+func (c *ReplayCluster) TagImage(IDOrName string, ref string, force bool) error {
+	return errNotSupportedByReplay
+}
+
+// RefreshEngine does nothing, the state only changes with the events.
+func (c *ReplayCluster) RefreshEngine(hostname string) error { return nil }
+
+// RefreshEngines does nothing, the state only changes with the events.
+func (c *ReplayCluster) RefreshEngines() error { return nil }
+
+// Snapshot returns the current placement of the containers.
+func (c *ReplayCluster) Snapshot() ClusterState {
+	c.Lock()
+	defer c.Unlock()
+	state := ClusterState{}
+	for _, e := range c.engines {
+		state.Nodes = append(state.Nodes, NewNodeState(e))
+	}
+	return state
+}
+
+var errNotSupportedByReplay = errors.New("not supported by the replay cluster")
+
+// replayAPIClient is the API client of the engines of a ReplayCluster. It
+// records the stops and removals of containers done through the engines.
+type replayAPIClient struct {
+	engineapinop.NopClient
+	cluster *ReplayCluster
+	engine  *Engine
+}
+
+func (client *replayAPIClient) ContainerStop(ctx context.Context, container string, timeout *time.Duration) error {
+	c := client.engine.Containers().Get(container)
+	if c == nil {
+		return fmt.Errorf("No such container: %s", container)
+	}
+	if c.Info.ContainerJSONBase != nil && c.Info.State != nil {
+		c.Info.State.Running = false
+	}
+	client.cluster.record("stop", c, client.engine)
+	return nil
+}
+
+// ContainerList lists the containers of the engine as they are, by ID if
+// filtered, so that refreshing them changes nothing.
+func (client *replayAPIClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	IDs := options.Filters.Get("id")
+	containers := []types.Container{}
+	for _, c := range client.engine.Containers() {
+		if len(IDs) == 0 || IDs[0] == c.ID {
+			containers = append(containers, c.Container)
+		}
+	}
+	return containers, nil
+}
+
+func (client *replayAPIClient) ContainerRemove(ctx context.Context, container string, options types.ContainerRemoveOptions) error {
+	c := client.engine.Containers().Get(container)
+	if c == nil {
+		return fmt.Errorf("No such container: %s", container)
+	}
+	client.cluster.record("remove", c, client.engine)
+	return nil
+}
+
+// Replay replays the events of a log into a watchdog running against a
+// ReplayCluster built from the initial state of the log, and returns the
+// actions the watchdog took. Each event is dispatched once the watchdog is
+// done with the previous one. The reconciliation sweep is disabled and the
+// reschedules are attempted once unless opts limits the retries, so that a
+// reschedule the cluster can't satisfy doesn't block the replay.
+func Replay(eventLog *EventLog, opts *WatchdogOpts, place PlaceFunc) ([]ReplayAction, error) {
+	if opts == nil {
+		var err error
+		if opts, err = NewWatchdogOpts(nil); err != nil {
+			return nil, err
+		}
+	}
+	replayOpts := *opts
+	replayOpts.ReconcileInterval = 0
+	if replayOpts.RescheduleRetryLimit == 0 {
+		replayOpts.RescheduleRetryLimit = 1
+	}
+
+	c := NewReplayCluster(eventLog.State, place)
+	w := NewWatchdog(c, &replayOpts)
+	defer w.Stop()
+
+	for _, recorded := range eventLog.Events {
+		e := c.apply(recorded)
+		if e == nil {
+			return c.Actions(), fmt.Errorf("unknown engine %q in event %s", recorded.Engine, recorded.Message.Status)
+		}
+		if err := w.Handle(e); err != nil {
+			return c.Actions(), err
+		}
+		w.pending.Wait()
+	}
+	return c.Actions(), nil
+}
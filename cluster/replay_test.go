@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func replayState() ClusterState {
+	node1 := createWatchdogEngine("node1", true)
+	node1.Memory = 1024
+	createWatchdogContainer(node1, "web", reschedulable, true)
+	createWatchdogContainer(node1, "pinned", nil, true)
+	node2 := createWatchdogEngine("node2", true)
+	node2.Memory = 1024
+	return ClusterState{Nodes: []NodeState{NewNodeState(node1), NewNodeState(node2)}}
+}
+
+func engineEvent(engine *Engine, status string) *Event {
+	return &Event{
+		Message: events.Message{From: "swarm", Status: status, Type: "swarm", Action: status},
+		Engine:  engine,
+	}
+}
+
+func TestEventRecorder(t *testing.T) {
+	state := replayState()
+	buf := &bytes.Buffer{}
+	recorder, err := NewEventRecorder(buf, state)
+	assert.NoError(t, err)
+
+	node1 := createWatchdogEngine("node1", false)
+	assert.NoError(t, recorder.Handle(engineEvent(node1, "engine_disconnect")))
+	createWatchdogContainer(node1, "web", reschedulable, true)
+	assert.NoError(t, recorder.Handle(engineEvent(node1, "engine_reconnect")))
+
+	eventLog, err := ReadEventLog(buf)
+	assert.NoError(t, err)
+	assert.Len(t, eventLog.State.Nodes, 2)
+	assert.Equal(t, "node1", eventLog.State.Nodes[0].ID)
+	assert.Len(t, eventLog.State.Nodes[0].Containers, 2)
+	assert.Len(t, eventLog.Events, 2)
+	assert.Equal(t, "node1", eventLog.Events[0].Engine)
+	assert.Equal(t, "engine_disconnect", eventLog.Events[0].Message.Status)
+	assert.Nil(t, eventLog.Events[0].Node)
+	// The connections record the containers of the engine.
+	assert.Equal(t, "engine_reconnect", eventLog.Events[1].Message.Status)
+	assert.Len(t, eventLog.Events[1].Node.Containers, 1)
+
+	_, err = ReadEventLog(bytes.NewBufferString("{"))
+	assert.Error(t, err)
+}
+
+// syncedBuffer is a buffer counting its syncs, as a file.
+type syncedBuffer struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (b *syncedBuffer) Sync() error {
+	b.syncs++
+	return nil
+}
+
+func TestEventRecorderSync(t *testing.T) {
+	buf := &syncedBuffer{}
+	recorder, err := NewEventRecorder(buf, replayState())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, buf.syncs)
+
+	// Each event is synced as soon as it is written.
+	assert.NoError(t, recorder.Handle(engineEvent(createWatchdogEngine("node1", false), "engine_disconnect")))
+	assert.Equal(t, 2, buf.syncs)
+	eventLog, err := ReadEventLog(&buf.Buffer)
+	assert.NoError(t, err)
+	assert.Len(t, eventLog.Events, 1)
+}
+
+func TestReplay(t *testing.T) {
+	state := replayState()
+	// node1 fails, then comes back with the stale copy of web.
+	eventLog := &EventLog{
+		State: state,
+		Events: []RecordedEvent{
+			{Engine: "node1", Message: events.Message{From: "swarm", Status: "engine_disconnect"}},
+			{Engine: "node1", Message: events.Message{From: "swarm", Status: "engine_reconnect"}, Node: &state.Nodes[0]},
+		},
+	}
+	expected := []ReplayAction{
+		{Action: "create", Container: "web", Engine: "node2"},
+		{Action: "start", Container: "web", Engine: "node2"},
+		{Action: "container_rescheduled", Engine: "node2"},
+		{Action: "reschedule_summary", Engine: "node1"},
+		{Action: "remove", Container: "web", Engine: "node1"},
+	}
+
+	// The replays are deterministic.
+	for i := 0; i < 3; i++ {
+		actions, err := Replay(eventLog, &WatchdogOpts{}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actions)
+	}
+
+	// The events of unknown engines are reported.
+	eventLog.Events = append(eventLog.Events, RecordedEvent{Engine: "node3", Message: events.Message{From: "swarm", Status: "engine_disconnect"}})
+	actions, err := Replay(eventLog, &WatchdogOpts{}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, expected, actions)
+}
+
+func TestReplayPlace(t *testing.T) {
+	state := replayState()
+	state.Nodes = append(state.Nodes, NewNodeState(createWatchdogEngine("node3", true)))
+	eventLog := &EventLog{
+		State:  state,
+		Events: []RecordedEvent{{Engine: "node1", Message: events.Message{From: "swarm", Status: "engine_disconnect"}}},
+	}
+
+	// The placement of the new containers can be replaced, e.g. by the
+	// scheduler.
+	place := func(config *ContainerConfig, engines []*Engine) (*Engine, error) {
+		return engines[len(engines)-1], nil
+	}
+	actions, err := Replay(eventLog, &WatchdogOpts{}, place)
+	assert.NoError(t, err)
+	assert.Equal(t, ReplayAction{Action: "create", Container: "web", Engine: "node3"}, actions[0])
+	assert.Equal(t, "create web on node3", actions[0].String())
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libkv/store"
+)
+
+// KVStore is the store, shared by the managers, in which the watchdog persists
+// its state. The libkv stores implement it.
+type KVStore interface {
+	Get(key string) (*store.KVPair, error)
+	Put(key string, value []byte, options *store.WriteOptions) error
+}
+
+// RescheduleSwitch enables or disables the rescheduling of containers cluster
+// wide, e.g. during a maintenance of the control plane. Unlike the demotion of
+// a watchdog, the state is kept in the store shared by the managers, so that
+// it survives restarts and failovers. Without store the state is local to the
+// manager. A nil switch is always enabled.
+type RescheduleSwitch struct {
+	sync.RWMutex
+
+	store   KVStore
+	key     string
+	enabled bool
+}
+
+// NewRescheduleSwitch creates a reschedule switch persisting its state at the
+// given key of the store. kv may be nil.
+func NewRescheduleSwitch(kv KVStore, key string) *RescheduleSwitch {
+	return &RescheduleSwitch{
+		store:   kv,
+		key:     key,
+		enabled: true,
+	}
+}
+
+// Enabled returns true if rescheduling is enabled. The state is read from the
+// store, the last known state is used if the store is unreachable.
+func (s *RescheduleSwitch) Enabled() bool {
+	if s == nil {
+		return true
+	}
+	if s.store == nil {
+		s.RLock()
+		defer s.RUnlock()
+		return s.enabled
+	}
+
+	pair, err := s.store.Get(s.key)
+	s.Lock()
+	defer s.Unlock()
+	switch {
+	case err == store.ErrKeyNotFound:
+		s.enabled = true
+	case err != nil:
+		log.Warnf("Unable to read the reschedule switch, assuming it is still enabled=%t: %v", s.enabled, err)
+	default:
+		enabled, err := strconv.ParseBool(string(pair.Value))
+		if err != nil {
+			log.Warnf("Ignoring invalid reschedule switch %q", pair.Value)
+			break
+		}
+		s.enabled = enabled
+	}
+	return s.enabled
+}
+
+// SetEnabled enables or disables rescheduling.
+func (s *RescheduleSwitch) SetEnabled(enabled bool) error {
+	if s.store != nil {
+		if err := s.store.Put(s.key, []byte(strconv.FormatBool(enabled)), nil); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	s.enabled = enabled
+	s.Unlock()
+	return nil
+}
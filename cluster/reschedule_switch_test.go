@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKVStore struct {
+	values map[string][]byte
+	err    error
+}
+
+func (s *fakeKVStore) Get(key string) (*store.KVPair, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	value, ok := s.values[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return &store.KVPair{Key: key, Value: value}, nil
+}
+
+func (s *fakeKVStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.values[key] = value
+	return nil
+}
+
+func TestRescheduleSwitch(t *testing.T) {
+	var none *RescheduleSwitch
+	assert.True(t, none.Enabled())
+
+	local := NewRescheduleSwitch(nil, "")
+	assert.True(t, local.Enabled())
+	assert.NoError(t, local.SetEnabled(false))
+	assert.False(t, local.Enabled())
+
+	kv := &fakeKVStore{values: make(map[string][]byte)}
+	s := NewRescheduleSwitch(kv, "swarm/reschedule")
+	assert.True(t, s.Enabled())
+	assert.NoError(t, s.SetEnabled(false))
+	assert.Equal(t, "false", string(kv.values["swarm/reschedule"]))
+	assert.False(t, s.Enabled())
+
+	// Another manager sharing the store honors the switch.
+	other := NewRescheduleSwitch(kv, "swarm/reschedule")
+	assert.False(t, other.Enabled())
+	assert.NoError(t, other.SetEnabled(true))
+	assert.True(t, s.Enabled())
+
+	// The last known state is kept when the store is unreachable.
+	assert.NoError(t, s.SetEnabled(false))
+	kv.err = errors.New("unreachable")
+	assert.False(t, s.Enabled())
+	assert.Error(t, s.SetEnabled(true))
+	assert.False(t, s.Enabled())
+}
@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	engineapimock "github.com/docker/swarm/api/mockclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// The benchmarks below simulate the recovery of a failed node, to estimate
+// the time to reschedule its containers for a cluster size and guard the
+// cost of the rescheduling against regressions. Run them with:
+//
+//	go test -run NONE -bench Simulation ./cluster
+
+// rescheduleSimulation is a failed node whose containers are rescheduled
+// over the surviving nodes of a cluster spreading them.
+type rescheduleSimulation struct {
+	cluster   *mockCluster
+	dead      *Engine
+	survivors []*Engine
+}
+
+// newRescheduleSimulation creates a cluster of the given number of surviving
+// nodes and a failed one running the given number of containers.
+func newRescheduleSimulation(containers, nodes int) *rescheduleSimulation {
+	s := &rescheduleSimulation{dead: createWatchdogEngine("dead", false)}
+	s.cluster = &mockCluster{engines: []*Engine{s.dead}, spread: true}
+	for i := 0; i < nodes; i++ {
+		e := createWatchdogEngine(fmt.Sprintf("node%d", i), true)
+		s.survivors = append(s.survivors, e)
+		s.cluster.engines = append(s.cluster.engines, e)
+	}
+	for i := 0; i < containers; i++ {
+		createWatchdogContainer(s.dead, fmt.Sprintf("c%d", i), reschedulable, true)
+	}
+	return s
+}
+
+// placement returns the fewest and most containers a surviving node took.
+func (s *rescheduleSimulation) placement() (min, max int) {
+	for i, e := range s.survivors {
+		n := len(e.Containers())
+		if i == 0 || n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return min, max
+}
+
+// simulateReschedule reschedules the containers of a failed node over the
+// surviving nodes, once per iteration, and reports the placement of the
+// last one.
+func simulateReschedule(b *testing.B, containers, nodes int) {
+	var s *rescheduleSimulation
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s = newRescheduleSimulation(containers, nodes)
+		w := NewWatchdog(s.cluster, &WatchdogOpts{RescheduleRetryLimit: 1})
+		b.StartTimer()
+
+		if err := w.RescheduleEngine(s.dead, TriggerEngineDisconnect); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	min, max := s.placement()
+	b.ReportMetric(float64(min), "min-containers/node")
+	b.ReportMetric(float64(max), "max-containers/node")
+}
+
+// simulateDeduplication removes the duplicates of the containers rescheduled
+// over the surviving nodes from the failed node coming back, once per
+// iteration.
+func simulateDeduplication(b *testing.B, containers, nodes int) {
+	apiClient := engineapimock.NewMockClient()
+	// Keep the containers of the returning node as they are.
+	apiClient.On("ContainerList", mock.Anything, mock.Anything).Return([]types.Container{}, errors.New("refresh failed"))
+	apiClient.On("ContainerRemove", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s := newRescheduleSimulation(containers, nodes)
+		w := NewWatchdog(s.cluster, &WatchdogOpts{RescheduleRetryLimit: 1})
+		if err := w.RescheduleEngine(s.dead, TriggerEngineDisconnect); err != nil {
+			b.Fatal(err)
+		}
+		back := createWatchdogEngine("back", true)
+		back.apiClient = apiClient
+		for j := 0; j < containers; j++ {
+			createWatchdogContainer(back, fmt.Sprintf("c%d", j), reschedulable, true)
+		}
+		s.cluster.engines = append(s.cluster.engines, back)
+		b.StartTimer()
+
+		w.removeDuplicateContainers(back)
+		if n := len(back.Containers()); n > 0 {
+			b.Fatalf("%d duplicates left", n)
+		}
+	}
+}
+
+func TestRescheduleSimulation(t *testing.T) {
+	s := newRescheduleSimulation(100, 8)
+	w := NewWatchdog(s.cluster, &WatchdogOpts{RescheduleRetryLimit: 1})
+	assert.NoError(t, w.RescheduleEngine(s.dead, TriggerEngineDisconnect))
+
+	// The containers are spread evenly over the surviving nodes.
+	min, max := s.placement()
+	assert.Equal(t, 12, min)
+	assert.Equal(t, 13, max)
+	total := 0
+	for _, e := range s.survivors {
+		total += len(e.Containers())
+	}
+	assert.Equal(t, 100, total)
+}
+
+func BenchmarkRescheduleSimulation100Containers10Nodes(b *testing.B) {
+	simulateReschedule(b, 100, 10)
+}
+
+func BenchmarkRescheduleSimulation1000Containers50Nodes(b *testing.B) {
+	simulateReschedule(b, 1000, 50)
+}
+
+func BenchmarkDeduplicationSimulation1000Containers50Nodes(b *testing.B) {
+	simulateDeduplication(b, 1000, 50)
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"github.com/docker/docker/api/types"
+)
+
+// ClusterState is a serializable snapshot of the placement of the containers
+// on the engines of a cluster.
+type ClusterState struct {
+	Nodes []NodeState `json:"nodes"`
+}
+
+// NodeState is the state of an engine in a ClusterState.
+type NodeState struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	IP              string            `json:"ip"`
+	Addr            string            `json:"addr"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	TotalMemory     int64             `json:"total_memory"`
+	TotalCpus       int64             `json:"total_cpus"`
+	HealthIndicator int64             `json:"health_indicator"`
+	Cordoned        bool              `json:"cordoned,omitempty"`
+	Images          []ImageState      `json:"images,omitempty"`
+	Containers      []ContainerState  `json:"containers,omitempty"`
+}
+
+// ImageState is the state of an image in a ClusterState.
+type ImageState struct {
+	ID       string   `json:"id"`
+	RepoTags []string `json:"repo_tags,omitempty"`
+}
+
+// ContainerState is the state of a container in a ClusterState. The config
+// holds the reservations of the container.
+type ContainerState struct {
+	ID      string           `json:"id"`
+	Names   []string         `json:"names,omitempty"`
+	Image   string           `json:"image"`
+	Running bool             `json:"running"`
+	Config  *ContainerConfig `json:"config"`
+}
+
+// NewNodeState returns the state of an engine.
+func NewNodeState(e *Engine) NodeState {
+	state := NodeState{
+		ID:              e.ID,
+		Name:            e.Name,
+		IP:              e.IP,
+		Addr:            e.Addr,
+		Labels:          make(map[string]string, len(e.Labels)),
+		TotalMemory:     e.TotalMemory(),
+		TotalCpus:       e.TotalCpus(),
+		HealthIndicator: e.HealthIndicator(),
+		Cordoned:        e.IsCordoned(),
+	}
+	for k, v := range e.Labels {
+		state.Labels[k] = v
+	}
+	for _, image := range e.Images() {
+		state.Images = append(state.Images, ImageState{ID: image.ID, RepoTags: image.RepoTags})
+	}
+	for _, c := range e.Containers() {
+		state.Containers = append(state.Containers, NewContainerState(c))
+	}
+	return state
+}
+
+// NewContainerState returns the state of a container.
+func NewContainerState(c *Container) ContainerState {
+	state := ContainerState{
+		ID:    c.ID,
+		Names: c.Names,
+		Image: c.Image,
+	}
+	if c.Info.ContainerJSONBase != nil && c.Info.State != nil {
+		state.Running = c.Info.State.Running
+	}
+	if c.Config != nil {
+		config := *c.Config
+		state.Config = &config
+	}
+	return state
+}
+
+// ToImage returns an image built from the state, not attached to an engine.
+func (s ImageState) ToImage() *Image {
+	return &Image{
+		ImageSummary: types.ImageSummary{ID: s.ID, RepoTags: s.RepoTags},
+	}
+}
+
+// ToContainer returns a container built from the state, not attached to an
+// engine.
+func (s ContainerState) ToContainer() *Container {
+	config := s.Config
+	if config == nil {
+		config = &ContainerConfig{}
+	}
+	name := ""
+	if len(s.Names) > 0 {
+		name = s.Names[0]
+	}
+	return &Container{
+		Container: types.Container{
+			ID:     s.ID,
+			Names:  s.Names,
+			Image:  s.Image,
+			Labels: config.Labels,
+		},
+		Config: config,
+		Info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         s.ID,
+				Name:       name,
+				State:      &types.ContainerState{Running: s.Running},
+				HostConfig: &config.HostConfig,
+			},
+			Config: &config.Config,
+		},
+	}
+}
@@ -0,0 +1,159 @@
+package cluster
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// The phases of the rescheduling of an engine.
+const (
+	// PhasePending is the phase of the reschedules waiting for their first
+	// pass, e.g. during the pre-outage grace of their engine.
+	PhasePending = "pending"
+	// PhaseRunning is the phase of the reschedules making a pass.
+	PhaseRunning = "running"
+	// PhaseWaiting is the phase of the reschedules waiting for their next
+	// pass, backing off or until a reschedule window opens.
+	PhaseWaiting = "waiting"
+)
+
+// EngineRescheduleStatus is the progress of the rescheduling of the
+// containers of an engine.
+type EngineRescheduleStatus struct {
+	Engine  string
+	Name    string
+	Trigger RescheduleTrigger
+	Phase   string
+	// Started is when the rescheduling was triggered.
+	Started time.Time
+	// Total is the number of containers of the engine, the ones examined
+	// by the rescheduling and the ones left to examine.
+	Total       int
+	Rescheduled int
+	Skipped     int
+	// Failed is the number of containers which failed to be rescheduled by
+	// the current pass, or by the last one while waiting.
+	Failed int
+	// Attempt is the number of failed passes since a container was last
+	// rescheduled.
+	Attempt int
+	// NextPass is when the next pass starts while waiting, and Backoff the
+	// time left until then.
+	NextPass time.Time
+	Backoff  time.Duration
+}
+
+// waveProgress is the progress of a wave, recorded by the wave as it goes
+// and read concurrently.
+type waveProgress struct {
+	sync.Mutex
+	status EngineRescheduleStatus
+}
+
+// RescheduleStatus returns the progress of the reschedules in progress, by
+// engine ID.
+func (w *Watchdog) RescheduleStatus() []EngineRescheduleStatus {
+	now := time.Now()
+	w.enginesLock.Lock()
+	defer w.enginesLock.Unlock()
+
+	statuses := []EngineRescheduleStatus{}
+	for _, wave := range w.waves {
+		wave.progress.Lock()
+		status := wave.progress.status
+		wave.progress.Unlock()
+		if status.Phase == PhaseWaiting && status.NextPass.After(now) {
+			status.Backoff = status.NextPass.Sub(now)
+		}
+		statuses = append(statuses, status)
+	}
+	for id, inflight := range w.inflight {
+		if _, ok := w.waves[id]; ok {
+			continue
+		}
+		statuses = append(statuses, EngineRescheduleStatus{
+			Engine:  id,
+			Name:    inflight.engine.Name,
+			Trigger: inflight.trigger,
+			Phase:   PhasePending,
+			Started: inflight.started,
+		})
+	}
+	sort.Sort(reschedulesByEngine(statuses))
+	return statuses
+}
+
+type reschedulesByEngine []EngineRescheduleStatus
+
+func (s reschedulesByEngine) Len() int           { return len(s) }
+func (s reschedulesByEngine) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s reschedulesByEngine) Less(i, j int) bool { return s[i].Engine < s[j].Engine }
+
+// trackWave makes a wave in progress reported by RescheduleStatus. The first
+// wave in progress calls OnReschedulingStarted.
+func (w *Watchdog) trackWave(wave *rescheduleWave) {
+	w.recordProgress(wave, 0, time.Time{})
+	w.transitionsLock.Lock()
+	defer w.transitionsLock.Unlock()
+
+	w.enginesLock.Lock()
+	started := len(w.waves) == 0
+	w.waves[wave.engine.ID] = wave
+	w.enginesLock.Unlock()
+
+	if started && w.opts.OnReschedulingStarted != nil {
+		w.opts.OnReschedulingStarted()
+	}
+}
+
+// untrackWave stops reporting a wave once it is over. The last wave in
+// progress calls OnReschedulingIdle.
+func (w *Watchdog) untrackWave(wave *rescheduleWave) {
+	w.transitionsLock.Lock()
+	defer w.transitionsLock.Unlock()
+
+	w.enginesLock.Lock()
+	idle := false
+	if w.waves[wave.engine.ID] == wave {
+		delete(w.waves, wave.engine.ID)
+		idle = len(w.waves) == 0
+	}
+	w.enginesLock.Unlock()
+
+	if idle && w.opts.OnReschedulingIdle != nil {
+		w.opts.OnReschedulingIdle()
+	}
+}
+
+// recordProgress records the progress of a wave, from the wave. failed is the
+// number of failures of the current pass, next when the next pass starts if
+// the wave is waiting for it.
+func (w *Watchdog) recordProgress(wave *rescheduleWave, failed int, next time.Time) {
+	total := len(wave.seen)
+	for _, c := range wave.engine.Containers() {
+		if !wave.seen[c.ID] {
+			total++
+		}
+	}
+	phase := PhaseRunning
+	if !next.IsZero() {
+		phase = PhaseWaiting
+	}
+
+	wave.progress.Lock()
+	defer wave.progress.Unlock()
+	wave.progress.status = EngineRescheduleStatus{
+		Engine:      wave.engine.ID,
+		Name:        wave.engine.Name,
+		Trigger:     wave.trigger,
+		Phase:       phase,
+		Started:     wave.started,
+		Total:       total,
+		Rescheduled: wave.rescheduled,
+		Skipped:     len(wave.skipped),
+		Failed:      failed,
+		Attempt:     wave.attempt,
+		NextPass:    next,
+	}
+}
@@ -0,0 +1,154 @@
+package cluster
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitStatus waits until the status of the reschedules satisfies ready.
+func waitStatus(t *testing.T, w *Watchdog, ready func([]EngineRescheduleStatus) bool) []EngineRescheduleStatus {
+	for i := 0; i < 500; i++ {
+		if statuses := w.RescheduleStatus(); ready(statuses) {
+			return statuses
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("reschedule status not reached")
+	return nil
+}
+
+func TestWatchdogRescheduleStatus(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	slow := createWatchdogEngine("slow", false)
+	other := createWatchdogEngine("other", true)
+	// The second creation blocks until the test is done looking, then
+	// fails once.
+	blocked := make(chan struct{})
+	unblock := make(chan struct{})
+	cl := &mockCluster{
+		engines: []*Engine{dead, slow, other},
+		createHook: func(count int) error {
+			if count == 2 {
+				close(blocked)
+				<-unblock
+				return errors.New("daemon is busy")
+			}
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: time.Hour, ReschedulePreOutageGrace: time.Hour})
+	assert.Empty(t, w.RescheduleStatus())
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+	createWatchdogContainer(dead, "c3", reschedulable, true)
+	createWatchdogContainer(dead, "static", nil, true)
+	createWatchdogContainer(slow, "s1", reschedulable, true)
+
+	// The wave of the dead engine is in the middle of its first pass, the
+	// slow engine is still within its pre-outage grace.
+	done := make(chan struct{})
+	go func() {
+		w.rescheduleContainers(dead, TriggerReconcile)
+		close(done)
+	}()
+	go w.rescheduleContainers(slow, TriggerEngineDisconnect)
+	<-blocked
+	statuses := waitStatus(t, w, func(statuses []EngineRescheduleStatus) bool { return len(statuses) == 2 })
+	running, pending := statuses[0], statuses[1]
+	assert.Equal(t, "dead", running.Engine)
+	assert.Equal(t, TriggerReconcile, running.Trigger)
+	assert.Equal(t, PhaseRunning, running.Phase)
+	assert.Equal(t, 4, running.Total)
+	assert.Equal(t, 1, running.Rescheduled)
+	assert.Equal(t, 0, running.Attempt)
+	assert.True(t, running.NextPass.IsZero())
+	assert.False(t, running.Started.IsZero())
+	assert.Equal(t, EngineRescheduleStatus{Engine: "slow", Name: "slow", Trigger: TriggerEngineDisconnect, Phase: PhasePending, Started: pending.Started}, pending)
+
+	// The pass completes with a failure, the wave backs off.
+	close(unblock)
+	statuses = waitStatus(t, w, func(statuses []EngineRescheduleStatus) bool {
+		return len(statuses) == 2 && statuses[0].Phase == PhaseWaiting
+	})
+	waiting := statuses[0]
+	assert.Equal(t, 4, waiting.Total)
+	assert.Equal(t, 2, waiting.Rescheduled)
+	assert.Equal(t, 1, waiting.Skipped)
+	assert.Equal(t, 1, waiting.Failed)
+	assert.Equal(t, 1, waiting.Attempt)
+	assert.True(t, waiting.NextPass.After(time.Now().Add(59*time.Minute)))
+	assert.True(t, waiting.Backoff > 59*time.Minute && waiting.Backoff <= time.Hour, waiting.Backoff.String())
+
+	// The canceled reschedules are no longer reported.
+	assert.True(t, w.CancelReschedule("dead"))
+	assert.True(t, w.CancelReschedule("slow"))
+	<-done
+	waitStatus(t, w, func(statuses []EngineRescheduleStatus) bool { return len(statuses) == 0 })
+}
+
+func TestWatchdogReschedulingCallbacks(t *testing.T) {
+	first := createWatchdogEngine("first", false)
+	second := createWatchdogEngine("second", false)
+	other := createWatchdogEngine("other", true)
+	// The first creation of each wave blocks until both waves started.
+	blocked := make(chan struct{}, 2)
+	unblock := make(chan struct{})
+	cl := &mockCluster{
+		engines: []*Engine{first, second, other},
+		createHook: func(count int) error {
+			if count <= 2 {
+				blocked <- struct{}{}
+				<-unblock
+			}
+			return nil
+		},
+	}
+	var (
+		lock        sync.Mutex
+		transitions []string
+	)
+	record := func(transition string) func() {
+		return func() {
+			lock.Lock()
+			defer lock.Unlock()
+			transitions = append(transitions, transition)
+		}
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{
+		OnReschedulingStarted: record("started"),
+		OnReschedulingIdle:    record("idle"),
+	})
+	for _, ID := range []string{"c1", "c2", "c3"} {
+		createWatchdogContainer(first, ID, reschedulable, true)
+	}
+	for _, ID := range []string{"s1", "s2"} {
+		createWatchdogContainer(second, ID, reschedulable, true)
+	}
+
+	// The waves overlap, the callbacks are called once for both, not per
+	// container nor per wave.
+	done := make(chan error)
+	go func() { done <- w.RescheduleEngine(first, TriggerEngineDisconnect) }()
+	<-blocked
+	go func() { done <- w.RescheduleEngine(second, TriggerEngineDisconnect) }()
+	<-blocked
+	waitStatus(t, w, func(statuses []EngineRescheduleStatus) bool { return len(statuses) == 2 })
+	lock.Lock()
+	assert.Equal(t, []string{"started"}, transitions)
+	lock.Unlock()
+	close(unblock)
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+	assert.Len(t, other.Containers(), 5)
+	assert.Equal(t, []string{"started", "idle"}, transitions)
+
+	// The next reschedule is another transition.
+	createWatchdogContainer(first, "c4", reschedulable, true)
+	assert.NoError(t, w.RescheduleEngine(first, TriggerEngineDisconnect))
+	assert.Equal(t, []string{"started", "idle", "started", "idle"}, transitions)
+}
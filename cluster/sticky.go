@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+)
+
+// RescheduleGroupLabel is the label grouping the containers whose reschedules
+// stick to the same target, e.g. the replicas of a service sharing a warm
+// cache.
+const RescheduleGroupLabel = SwarmLabelNamespace + ".reschedule-group"
+
+// rescheduleStickyLabel records the constraint sticking a rescheduled
+// container to the last target of its group.
+const rescheduleStickyLabel = SwarmLabelNamespace + ".reschedule-sticky"
+
+// Stickiness is how strongly the rescheduled containers of a group stick to
+// the last target of the group.
+type Stickiness string
+
+const (
+	// StickinessPrefer prefers the last target, the other nodes are used
+	// when it can't take the container.
+	StickinessPrefer Stickiness = "prefer"
+	// StickinessRequire only reschedules onto the last target while it is
+	// healthy, the container waits for capacity there.
+	StickinessRequire Stickiness = "require"
+)
+
+// stickyTarget is the last engine the containers of a group were rescheduled
+// onto.
+type stickyTarget struct {
+	engine *Engine
+	at     time.Time
+}
+
+// stickyTargets tracks the last reschedule targets of the groups.
+type stickyTargets struct {
+	sync.Mutex
+	targets map[string]stickyTarget
+}
+
+func newStickyTargets() *stickyTargets {
+	return &stickyTargets{targets: make(map[string]stickyTarget)}
+}
+
+// record records the engine a container of the group was rescheduled onto.
+func (s *stickyTargets) record(group string, e *Engine, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.targets[group] = stickyTarget{engine: e, at: now}
+}
+
+// get returns the last target of the group, if it was chosen within the
+// window and is still healthy. The expired targets are forgotten.
+func (s *stickyTargets) get(group string, window time.Duration, now time.Time) *Engine {
+	s.Lock()
+	defer s.Unlock()
+	target, ok := s.targets[group]
+	if !ok {
+		return nil
+	}
+	if now.Sub(target.at) > window {
+		delete(s.targets, group)
+		return nil
+	}
+	if !target.engine.IsHealthy() {
+		return nil
+	}
+	return target.engine
+}
@@ -54,6 +54,10 @@ func (p *pendingContainer) ToContainer() *cluster.Container {
 	return container
 }
 
+// defaultEventQueueSize is the number of events queued for each event
+// handler before the dispatch blocks or drops them.
+const defaultEventQueueSize = 1000
+
 // Cluster is exported.
 type Cluster struct {
 	sync.RWMutex
@@ -64,6 +68,9 @@ type Cluster struct {
 	scheduler         *scheduler.Scheduler
 	discovery         discovery.Backend
 	pendingContainers map[string]*pendingContainer
+	// reservations holds the engine reservations of ReserveEngine, by key.
+	// Like the pending containers, they are guarded by the scheduler lock.
+	reservations map[string]*reservation
 
 	overcommitRatio float64
 	engineOpts      *cluster.EngineOpts
@@ -75,14 +82,29 @@ type Cluster struct {
 func NewCluster(scheduler *scheduler.Scheduler, TLSConfig *tls.Config, discovery discovery.Backend, options cluster.DriverOpts, engineOptions *cluster.EngineOpts) (cluster.Cluster, error) {
 	log.WithFields(log.Fields{"name": "swarm"}).Debug("Initializing cluster")
 
+	queueSize, queueFull := defaultEventQueueSize, cluster.EventQueueBlock
+	if val, ok := options.Int("swarm.eventqueuesize", ""); ok {
+		if val < 0 {
+			log.Fatalf("swarm.eventqueuesize can not be negative, %d is invalid", val)
+		}
+		queueSize = int(val)
+	}
+	if val, ok := options.String("swarm.eventqueuefull", ""); ok {
+		queueFull = cluster.EventQueueFullPolicy(val)
+		if queueFull != cluster.EventQueueBlock && queueFull != cluster.EventQueueDrop {
+			log.Fatalf("swarm.eventqueuefull should be either block or drop, %s is invalid", val)
+		}
+	}
+
 	cluster := &Cluster{
-		eventHandlers:     cluster.NewEventHandlers(),
+		eventHandlers:     cluster.NewQueuedEventHandlers(queueSize, queueFull),
 		engines:           make(map[string]*cluster.Engine),
 		pendingEngines:    make(map[string]*cluster.Engine),
 		scheduler:         scheduler,
 		TLSConfig:         TLSConfig,
 		discovery:         discovery,
 		pendingContainers: make(map[string]*pendingContainer),
+		reservations:      make(map[string]*reservation),
 		overcommitRatio:   0.05,
 		engineOpts:        engineOptions,
 		createRetry:       0,
@@ -189,6 +211,15 @@ func (c *Cluster) createContainer(config *cluster.ContainerConfig, name string,
 		config.SetSwarmID(swarmID)
 	}
 
+	// The container draws on the reservation it is created with, instead of
+	// being accounted twice.
+	if key := config.Reservation(); key != "" {
+		config.SetReservation("")
+		if r, ok := c.reservations[key]; ok {
+			r.consume(config)
+		}
+	}
+
 	if network := c.Networks().Get(string(config.HostConfig.NetworkMode)); network != nil && network.Scope == "local" {
 		if !config.HaveNodeConstraint() {
 			config.AddConstraint("node==~" + network.Engine.Name)
@@ -841,6 +872,11 @@ func (c *Cluster) listNodes() []*node.Node {
 				node.AddContainer(pc.ToContainer())
 			}
 		}
+		for _, r := range c.reservations {
+			if r.engine.ID == e.ID {
+				r.reserve(node)
+			}
+		}
 		out = append(out, node)
 	}
 
@@ -881,6 +917,47 @@ func (c *Cluster) TotalCpus() int64 {
 	return totalCpus
 }
 
+// FreeCapacity returns the memory and CPUs not reserved by containers on the
+// healthy engines, including the pending containers.
+func (c *Cluster) FreeCapacity() (memory int64, cpus int64) {
+	for _, n := range c.listNodes() {
+		if n.IsHealthy() {
+			memory += n.TotalMemory - n.UsedMemory
+			cpus += n.TotalCpus - n.UsedCpus
+		}
+	}
+	return memory, cpus
+}
+
+// Engine returns the engine with the given ID or name, the pending ones
+// included.
+func (c *Cluster) Engine(IDOrName string) *cluster.Engine {
+	for _, e := range c.listEngines() {
+		if e.ID == IDOrName || e.Name == IDOrName {
+			return e
+		}
+	}
+	return nil
+}
+
+// Capacity returns the capacity of the cluster, as accounted by the
+// scheduler, including the pending containers.
+func (c *Cluster) Capacity() cluster.CapacityReport {
+	nodes := []cluster.NodeCapacity{}
+	for _, n := range c.listNodes() {
+		nodes = append(nodes, cluster.NodeCapacity{
+			ID:             n.ID,
+			Name:           n.Name,
+			Healthy:        n.IsHealthy(),
+			TotalMemory:    n.TotalMemory,
+			ReservedMemory: n.UsedMemory,
+			TotalCpus:      n.TotalCpus,
+			ReservedCpus:   n.UsedCpus,
+		})
+	}
+	return cluster.NewCapacityReport(nodes)
+}
+
 // Info returns some info about the cluster, like nb or containers / images.
 func (c *Cluster) Info() [][2]string {
 	info := [][2]string{
@@ -888,6 +965,9 @@ func (c *Cluster) Info() [][2]string {
 		{"Filters", c.scheduler.Filters()},
 		{"Nodes", fmt.Sprintf("%d", len(c.engines)+len(c.pendingEngines))},
 	}
+	if dropped := c.eventHandlers.Dropped(); dropped > 0 {
+		info = append(info, [2]string{"Dropped Events", fmt.Sprintf("%d", dropped)})
+	}
 
 	engines := c.listEngines()
 	sort.Sort(cluster.EngineSorter(engines))
@@ -937,9 +1017,61 @@ func (c *Cluster) Info() [][2]string {
 	return info
 }
 
-// RANDOMENGINE returns a random engine.
+// RANDOMENGINE returns a random healthy engine.
 func (c *Cluster) RANDOMENGINE() (*cluster.Engine, error) {
-	nodes, err := c.scheduler.SelectNodesForContainer(c.listNodes(), &cluster.ContainerConfig{})
+	return c.SelectEngine(&cluster.ContainerConfig{})
+}
+
+// SelectEngine returns the healthy engine a container with the given config
+// would be scheduled on.
+func (c *Cluster) SelectEngine(config *cluster.ContainerConfig) (*cluster.Engine, error) {
+	c.scheduler.Lock()
+	defer c.scheduler.Unlock()
+	return c.selectEngine(config)
+}
+
+// ReserveEngine selects the engine like SelectEngine and reserves the
+// resources of the config on it until release is called. The config is
+// marked with the reservation, the containers created with it or its copies
+// draw on the reservation instead of being accounted twice.
+func (c *Cluster) ReserveEngine(config *cluster.ContainerConfig) (*cluster.Engine, func(), error) {
+	c.scheduler.Lock()
+	defer c.scheduler.Unlock()
+
+	engine, err := c.selectEngine(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := stringid.GenerateRandomID()
+	c.reservations[key] = newReservation(engine, config)
+	config.SetReservation(key)
+
+	release := func() {
+		c.scheduler.Lock()
+		delete(c.reservations, key)
+		c.scheduler.Unlock()
+	}
+	return engine, release, nil
+}
+
+// selectEngine returns the healthy engine a container would be placed on,
+// the scheduler lock must be held.
+func (c *Cluster) selectEngine(config *cluster.ContainerConfig) (*cluster.Engine, error) {
+	r := c.reservations[config.Reservation()]
+	healthy := []*node.Node{}
+	for _, n := range c.listNodes() {
+		if r != nil && r.engine.ID == n.ID {
+			r.unreserve(n, config)
+		}
+		if n.IsHealthy() {
+			healthy = append(healthy, n)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, cluster.ErrNoHealthyEngine
+	}
+
+	nodes, err := c.scheduler.SelectNodesForContainer(healthy, config)
 	if err != nil {
 		return nil, err
 	}
@@ -958,6 +1090,11 @@ func (c *Cluster) RenameContainer(container *cluster.Container, newName string)
 	return err
 }
 
+// MoveContainer moves a container to another engine.
+func (c *Cluster) MoveContainer(container *cluster.Container, target *cluster.Engine, opts cluster.MoveOpts) (*cluster.MoveResult, error) {
+	return cluster.MoveContainer(c, container, target, opts)
+}
+
 // BuildImage builds an image
 func (c *Cluster) BuildImage(buildContext io.Reader, buildImage *types.ImageBuildOptions, out io.Writer) error {
 	c.scheduler.Lock()
@@ -987,6 +1124,27 @@ func (c *Cluster) BuildImage(buildContext io.Reader, buildImage *types.ImageBuil
 	return nil
 }
 
+// Snapshot returns the current placement of the containers on the engines of
+// the cluster. Pending engines are not part of it as they can't be scheduled
+// on.
+func (c *Cluster) Snapshot() cluster.ClusterState {
+	c.RLock()
+	defer c.RUnlock()
+
+	state := cluster.ClusterState{Nodes: make([]cluster.NodeState, 0, len(c.engines))}
+	for _, e := range c.engines {
+		state.Nodes = append(state.Nodes, cluster.NewNodeState(e))
+	}
+	sort.Sort(nodeStatesByID(state.Nodes))
+	return state
+}
+
+type nodeStatesByID []cluster.NodeState
+
+func (n nodeStatesByID) Len() int           { return len(n) }
+func (n nodeStatesByID) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n nodeStatesByID) Less(i, j int) bool { return n[i].ID < n[j].ID }
+
 // RefreshEngines refreshes all containers in the cluster.
 func (c *Cluster) RefreshEngines() error {
 	for _, e := range c.engines {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,6 +15,9 @@ import (
 	"github.com/docker/docker/api/types/volume"
 	engineapimock "github.com/docker/swarm/api/mockclient"
 	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler"
+	"github.com/docker/swarm/scheduler/filter"
+	"github.com/docker/swarm/scheduler/strategy"
 	"github.com/samalba/dockerclient/mockclient"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -269,3 +273,290 @@ func TestTagImage(t *testing.T) {
 	assert.Nil(t, c.TagImage("busybox", "test_busybox:latest", false))
 	assert.NotNil(t, c.TagImage("busybox_not_exists", "test_busybox:latest", false))
 }
+
+func TestSnapshot(t *testing.T) {
+	c := &Cluster{
+		engines: make(map[string]*cluster.Engine),
+	}
+	container := &cluster.Container{
+		Container: types.Container{
+			ID:    "container-id",
+			Names: []string{"/container-name"},
+			Image: "busybox",
+		},
+		Config: cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{
+			Resources: containertypes.Resources{Memory: 1024, CPUShares: 1},
+		}, networktypes.NetworkingConfig{}),
+	}
+	c.engines["engine-b"] = createEngine(t, "engine-b")
+	c.engines["engine-a"] = createEngine(t, "engine-a", container)
+
+	state := c.Snapshot()
+	assert.Len(t, state.Nodes, 2)
+	assert.Equal(t, "engine-a", state.Nodes[0].ID)
+	assert.Equal(t, "engine-b", state.Nodes[1].ID)
+	assert.Len(t, state.Nodes[0].Containers, 1)
+	assert.Equal(t, "container-id", state.Nodes[0].Containers[0].ID)
+	assert.Equal(t, "busybox", state.Nodes[0].Containers[0].Image)
+	assert.Equal(t, int64(1024), state.Nodes[0].Containers[0].Config.HostConfig.Memory)
+	assert.Len(t, state.Nodes[1].Containers, 0)
+}
+
+func TestRANDOMENGINE(t *testing.T) {
+	c := &Cluster{
+		engines:   make(map[string]*cluster.Engine),
+		scheduler: scheduler.New(&strategy.SpreadPlacementStrategy{}, nil),
+	}
+
+	// Engines which are not connected are never returned.
+	c.engines["engine-a"] = createEngine(t, "engine-a")
+	c.engines["engine-b"] = createEngine(t, "engine-b")
+	_, err := c.RANDOMENGINE()
+	assert.Equal(t, cluster.ErrNoHealthyEngine, err)
+
+	client := mockclient.NewMockClient()
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("Info", mock.Anything).Return(mockInfo, nil)
+	apiClient.On("ServerVersion", mock.Anything).Return(mockVersion, nil)
+	apiClient.On("NetworkList", mock.Anything,
+		mock.AnythingOfType("NetworkListOptions"),
+	).Return([]types.NetworkResource{}, nil)
+	apiClient.On("VolumeList", mock.Anything, mock.Anything).Return(volume.VolumesListOKBody{}, nil)
+	apiClient.On("Events", mock.Anything, mock.AnythingOfType("EventsOptions")).Return(make(chan events.Message), make(chan error))
+	apiClient.On("ImageList", mock.Anything, mock.AnythingOfType("ImageListOptions")).Return([]types.ImageSummary{}, nil)
+	apiClient.On("ContainerList", mock.Anything, types.ContainerListOptions{All: true, Size: false}).Return([]types.Container{}, nil).Once()
+
+	engine := cluster.NewEngine("test-engine", 0, engOpts)
+	engine.Name = "test-engine"
+	engine.ID = "test-engine"
+	assert.NoError(t, engine.ConnectWithClient(client, apiClient))
+	engine.ValidationComplete()
+	c.engines[engine.ID] = engine
+
+	for i := 0; i < 10; i++ {
+		e, err := c.RANDOMENGINE()
+		assert.NoError(t, err)
+		assert.Equal(t, engine, e)
+	}
+}
+
+func createHealthyEngine(t *testing.T, ID string) *cluster.Engine {
+	info := mockInfo
+	info.ID, info.Name = ID, ID
+	client := mockclient.NewMockClient()
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("Info", mock.Anything).Return(info, nil)
+	apiClient.On("ServerVersion", mock.Anything).Return(mockVersion, nil)
+	apiClient.On("NetworkList", mock.Anything,
+		mock.AnythingOfType("NetworkListOptions"),
+	).Return([]types.NetworkResource{}, nil)
+	apiClient.On("VolumeList", mock.Anything, mock.Anything).Return(volume.VolumesListOKBody{}, nil)
+	apiClient.On("Events", mock.Anything, mock.AnythingOfType("EventsOptions")).Return(make(chan events.Message), make(chan error))
+	apiClient.On("ImageList", mock.Anything, mock.AnythingOfType("ImageListOptions")).Return([]types.ImageSummary{}, nil)
+	apiClient.On("ContainerList", mock.Anything, types.ContainerListOptions{All: true, Size: false}).Return([]types.Container{}, nil).Once()
+
+	engine := cluster.NewEngine(ID, 0, engOpts)
+	engine.Name = ID
+	engine.ID = ID
+	assert.NoError(t, engine.ConnectWithClient(client, apiClient))
+	engine.ValidationComplete()
+	return engine
+}
+
+func TestReserveEngine(t *testing.T) {
+	c := &Cluster{
+		engines:           make(map[string]*cluster.Engine),
+		pendingContainers: make(map[string]*pendingContainer),
+		reservations:      make(map[string]*reservation),
+		scheduler:         scheduler.New(&strategy.SpreadPlacementStrategy{}, nil),
+	}
+	for _, ID := range []string{"engine-a", "engine-b", "engine-c"} {
+		c.engines[ID] = createHealthyEngine(t, ID)
+	}
+	newConfig := func() *cluster.ContainerConfig {
+		return cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{
+			Resources: containertypes.Resources{Memory: 10, CPUShares: 1},
+		}, networktypes.NetworkingConfig{})
+	}
+
+	// The concurrent placements account for each other, and spread instead
+	// of all taking the emptiest engine.
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		reserved = make(map[string]int)
+		releases []func()
+	)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, release, err := c.ReserveEngine(newConfig())
+			if assert.NoError(t, err) {
+				lock.Lock()
+				reserved[e.ID]++
+				releases = append(releases, release)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, map[string]int{"engine-a": 1, "engine-b": 1, "engine-c": 1}, reserved)
+	for _, n := range c.listNodes() {
+		assert.Equal(t, int64(10), n.UsedMemory)
+	}
+
+	// Each engine has room for a single other container.
+	_, err := c.SelectEngine(cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{
+		Resources: containertypes.Resources{Memory: 15},
+	}, networktypes.NetworkingConfig{}))
+	assert.Error(t, err)
+
+	for _, release := range releases {
+		release()
+	}
+	assert.Empty(t, c.reservations)
+	for _, n := range c.listNodes() {
+		assert.Equal(t, int64(0), n.UsedMemory)
+	}
+}
+
+func TestReserveEngineDraw(t *testing.T) {
+	c := &Cluster{
+		engines:           make(map[string]*cluster.Engine),
+		pendingContainers: make(map[string]*pendingContainer),
+		reservations:      make(map[string]*reservation),
+		scheduler:         scheduler.New(&strategy.SpreadPlacementStrategy{}, nil),
+	}
+	c.engines["engine-a"] = createHealthyEngine(t, "engine-a")
+
+	// The engine is reserved for two containers, which take all its memory.
+	group := cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{
+		Resources: containertypes.Resources{Memory: 20},
+	}, networktypes.NetworkingConfig{})
+	e, release, err := c.ReserveEngine(group)
+	assert.NoError(t, err)
+	defer release()
+	assert.Equal(t, "engine-a", e.ID)
+	key := group.Reservation()
+	assert.NotEmpty(t, key)
+
+	// A container without the reservation doesn't fit.
+	member := cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{
+		Resources: containertypes.Resources{Memory: 10},
+	}, networktypes.NetworkingConfig{})
+	_, err = c.SelectEngine(member)
+	assert.Error(t, err)
+
+	// The members of the reservation draw on it.
+	member.SetReservation(key)
+	e, err = c.SelectEngine(member)
+	assert.NoError(t, err)
+	assert.Equal(t, "engine-a", e.ID)
+	c.reservations[key].consume(member)
+	assert.Equal(t, int64(10), c.listNodes()[0].UsedMemory)
+	c.reservations[key].consume(member)
+	assert.Equal(t, int64(0), c.listNodes()[0].UsedMemory)
+}
+
+func TestSelectEngineAntiAffinity(t *testing.T) {
+	filters, err := filter.New([]string{"affinity"})
+	assert.NoError(t, err)
+	c := &Cluster{
+		engines:           make(map[string]*cluster.Engine),
+		pendingContainers: make(map[string]*pendingContainer),
+		reservations:      make(map[string]*reservation),
+		scheduler:         scheduler.New(&strategy.SpreadPlacementStrategy{}, filters),
+	}
+	for _, ID := range []string{"engine-a", "engine-b"} {
+		c.engines[ID] = createHealthyEngine(t, ID)
+	}
+	newConfig := func(labels map[string]string, affinity string) *cluster.ContainerConfig {
+		config := cluster.BuildContainerConfig(containertypes.Config{Labels: labels}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+		assert.NoError(t, config.AddAffinity(affinity))
+		return config
+	}
+
+	// The container rescheduled off a failed node keeps away from the
+	// container it is anti-affine with, running on a surviving node.
+	db := &cluster.Container{
+		Container: types.Container{ID: "db-id", Names: []string{"/db"}},
+		Config:    cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}),
+		Engine:    c.engines["engine-a"],
+	}
+	assert.NoError(t, c.engines["engine-a"].AddContainer(db))
+	for i := 0; i < 10; i++ {
+		e, err := c.SelectEngine(newConfig(nil, "container!=db"))
+		assert.NoError(t, err)
+		assert.Equal(t, "engine-b", e.ID)
+	}
+
+	// The containers about to be created on a reserved engine are seen by
+	// the affinities of the other placements, not by their own.
+	web := func() *cluster.ContainerConfig {
+		config := newConfig(map[string]string{"service": "web"}, "service!=web")
+		assert.NoError(t, config.AddAffinity("container!=db"))
+		return config
+	}
+	group := web()
+	reserved, release, err := c.ReserveEngine(group)
+	assert.NoError(t, err)
+	defer release()
+	assert.Equal(t, "engine-b", reserved.ID)
+	_, err = c.SelectEngine(web())
+	assert.Error(t, err)
+	e, err := c.SelectEngine(newConfig(map[string]string{"service": "web"}, "service!=web"))
+	assert.NoError(t, err)
+	assert.Equal(t, "engine-a", e.ID)
+	key := group.Reservation()
+	member := web()
+	member.SetReservation(key)
+	e, err = c.SelectEngine(member)
+	assert.NoError(t, err)
+	assert.Equal(t, "engine-b", e.ID)
+
+	// Once one is created, it is seen as pending instead.
+	c.reservations[key].consume(member)
+	e, err = c.SelectEngine(web())
+	assert.NoError(t, err)
+	assert.Equal(t, "engine-b", e.ID)
+}
+
+func TestRescheduleGPUShortage(t *testing.T) {
+	filters, err := filter.New([]string{"gpu"})
+	assert.NoError(t, err)
+	c := &Cluster{
+		eventHandlers:     cluster.NewEventHandlers(),
+		engines:           make(map[string]*cluster.Engine),
+		pendingContainers: make(map[string]*pendingContainer),
+		reservations:      make(map[string]*reservation),
+		scheduler:         scheduler.New(&strategy.SpreadPlacementStrategy{}, filters),
+	}
+	c.engines["engine-a"] = createHealthyEngine(t, "engine-a")
+	config := cluster.BuildContainerConfig(containertypes.Config{Labels: map[string]string{
+		cluster.GPUsLabel: "1",
+		cluster.SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure"]`,
+	}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	config.SetSwarmID("swarm-train")
+	train := &cluster.Container{
+		Container: types.Container{ID: "train-id", Names: []string{"/train"}},
+		Config:    config,
+		Info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				Name:       "/train",
+				State:      &types.ContainerState{Running: true},
+				HostConfig: &config.HostConfig,
+			},
+			Config: &config.Config,
+		},
+	}
+	c.engines["dead"] = createEngine(t, "dead", train)
+
+	// No surviving node has a free GPU, the scheduler reports it as such.
+	w := cluster.NewWatchdog(c, &cluster.WatchdogOpts{RescheduleRetryLimit: 1})
+	err = w.RescheduleEngine(c.engines["dead"], cluster.TriggerEngineDisconnect)
+	if errs, ok := err.(cluster.RescheduleErrors); assert.True(t, ok, "%v", err) && assert.Len(t, errs, 1) {
+		assert.Equal(t, cluster.ErrNoGPUCapacity, errs[0].Reason)
+		assert.True(t, errs[0].Retryable())
+	}
+}
@@ -0,0 +1,93 @@
+package swarm
+
+import (
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+// reservation holds the resources reserved on an engine for containers about
+// to be created there, e.g. the members of a namespace group.
+type reservation struct {
+	engine    *cluster.Engine
+	memory    int64
+	cpus      int64
+	bandwidth int64
+	gpus      int64
+	weight    int64
+	// container stands for the containers about to be created, for the
+	// affinities of the other placements to see them on the engine until
+	// the first one is created.
+	container *cluster.Container
+}
+
+func newReservation(engine *cluster.Engine, config *cluster.ContainerConfig) *reservation {
+	// The config is marked with the reservation afterwards, the labels are
+	// the ones it is reserved with.
+	copied := *config
+	copied.Labels = make(map[string]string, len(config.Labels))
+	for k, v := range config.Labels {
+		copied.Labels[k] = v
+	}
+	return &reservation{
+		engine:    engine,
+		memory:    config.HostConfig.Memory,
+		cpus:      config.HostConfig.CPUShares,
+		bandwidth: config.Bandwidth(),
+		gpus:      config.GPUs(),
+		weight:    config.SchedulingWeight(),
+		container: (&pendingContainer{Config: &copied, Engine: engine}).ToContainer(),
+	}
+}
+
+// consume draws the resources of a container created with the reservation
+// from it, the container being accounted as pending instead.
+func (r *reservation) consume(config *cluster.ContainerConfig) {
+	r.container = nil
+	r.memory = drawn(r.memory, config.HostConfig.Memory)
+	r.cpus = drawn(r.cpus, config.HostConfig.CPUShares)
+	r.bandwidth = drawn(r.bandwidth, config.Bandwidth())
+	r.gpus = drawn(r.gpus, config.GPUs())
+	r.weight = drawn(r.weight, config.SchedulingWeight())
+}
+
+// reserve accounts the resources left in the reservation as used on the
+// node of its engine, with the containers it stands for.
+func (r *reservation) reserve(n *node.Node) {
+	if r.container != nil {
+		n.Containers = append(n.Containers, r.container)
+	}
+	n.UsedMemory += r.memory
+	n.UsedCpus += r.cpus
+	n.UsedBandwidth += r.bandwidth
+	n.UsedGPUs += r.gpus
+	n.SchedulingLoad += r.weight
+}
+
+// unreserve gives back to the node the resources a container created with
+// the reservation would draw from it, for the container placed there not to
+// be accounted twice, nor to be kept off by its own affinities.
+func (r *reservation) unreserve(n *node.Node, config *cluster.ContainerConfig) {
+	if r.container != nil {
+		containers := cluster.Containers{}
+		for _, c := range n.Containers {
+			if c != r.container {
+				containers = append(containers, c)
+			}
+		}
+		n.Containers = containers
+	}
+	left := *r
+	left.consume(config)
+	n.UsedMemory -= r.memory - left.memory
+	n.UsedCpus -= r.cpus - left.cpus
+	n.UsedBandwidth -= r.bandwidth - left.bandwidth
+	n.UsedGPUs -= r.gpus - left.gpus
+	n.SchedulingLoad -= r.weight - left.weight
+}
+
+func drawn(reserved, used int64) int64 {
+	if used >= reserved {
+		return 0
+	}
+	return reserved - used
+}
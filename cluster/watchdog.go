@@ -1,192 +1,3870 @@
 package cluster
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/network"
+	engineapi "github.com/docker/docker/client"
+	"github.com/samalba/dockerclient"
 	"golang.org/x/net/context"
 )
 
+var (
+	// ErrNoCapacity is the reason of a reschedule failure when no node can
+	// accept the container.
+	ErrNoCapacity = errors.New("no capacity to reschedule container")
+	// ErrImagePull is the reason of a reschedule failure when the image of
+	// the container can't be pulled on the target node.
+	ErrImagePull = errors.New("failed to pull image of rescheduled container")
+	// ErrNetworkAttach is the reason of a reschedule failure when the new
+	// container can't be connected to some of its networks.
+	ErrNetworkAttach = errors.New("failed to attach rescheduled container to its networks")
+	// ErrNetworkCleanup is the reason of a reschedule failure when no engine
+	// is available to remove the network endpoints of the old container.
+	ErrNetworkCleanup = errors.New("no engine available for network cleanup")
+	// ErrWatchdogInactive is returned when a rescheduling is abandoned
+	// because the watchdog has been stopped or this manager is no longer
+	// the primary.
+	ErrWatchdogInactive = errors.New("watchdog is not active")
+	// ErrPassDeadline is the reason of a reschedule failure when the
+	// rescheduling pass ran out of time before the container could be
+	// rescheduled.
+	ErrPassDeadline = errors.New("reschedule pass deadline exceeded")
+	// ErrOutsideWindow is the reason of a reschedule failure when the
+	// container may only be rescheduled during time windows and none is
+	// active.
+	ErrOutsideWindow = errors.New("outside of the reschedule windows")
+	// ErrPullRateLimit is the reason of a reschedule failure when the
+	// registry rate limits the pulls of the image of the container. It is
+	// retried after the longer RescheduleRateLimitBackoff.
+	ErrPullRateLimit = errors.New("image pull rate limited by the registry")
+	// ErrConfigMutation is the reason of a reschedule failure when the
+	// RescheduleConfigMutator option rejected the container.
+	ErrConfigMutation = errors.New("failed to rewrite config of rescheduled container")
+	// ErrInsufficientCapacity is the reason of a reschedule failure when the
+	// safe mode defers the container because the healthy engines lack the
+	// capacity to take all the containers of the failed engine.
+	ErrInsufficientCapacity = errors.New("insufficient cluster capacity to reschedule container")
+	// ErrTooFewHealthyEngines is the reason of a reschedule failure when
+	// fewer engines than MinHealthyEngines are healthy.
+	ErrTooFewHealthyEngines = errors.New("too few healthy engines to reschedule container")
+	// ErrLocalMount is the reason of a reschedule failure when the container
+	// mounts host paths, named pipes or local volumes of its failed node.
+	ErrLocalMount = errors.New("container has mounts local to its node")
+	// ErrDeviceUnavailable is the reason of a reschedule failure when no
+	// node provides the host devices or ulimits the container requires.
+	ErrDeviceUnavailable = errors.New("no node provides the devices of the container")
+	// ErrNoGPUCapacity is the reason of a reschedule failure when no node
+	// has enough free GPUs for a container reserving GPUs.
+	ErrNoGPUCapacity = errors.New("no GPU capacity to reschedule container")
+	// ErrCpusetUnavailable is the reason of a reschedule failure when no
+	// node has the CPUs the container is pinned to by its cpuset.
+	ErrCpusetUnavailable = errors.New("no node has the CPUs of the cpuset of the container")
+	// ErrNamespaceGroup is the reason of a reschedule failure when the
+	// containers sharing namespaces with the container can't all be
+	// rescheduled together onto a single node.
+	ErrNamespaceGroup = errors.New("failed to reschedule the containers sharing its namespaces together")
+	// ErrRescheduleCanceled is returned when the rescheduling of an engine
+	// is canceled with CancelReschedule.
+	ErrRescheduleCanceled = errors.New("reschedule canceled")
+
+	drainHintRegexp      = regexp.MustCompile(`^([^=!<>~]+)(==|!=|>=|<=|>|<)~?(.+)$`)
+	imagePullErrorRegexp = regexp.MustCompile(`(image|repository|manifest for) \S* not found|pull access denied|manifest unknown`)
+	rateLimitErrorRegexp = regexp.MustCompile(`(?i)toomanyrequests|too many requests|pull rate limit`)
+	noCapacityErrors     = []string{
+		"no resources available to schedule container",
+		"No healthy node available in the cluster",
+		"No healthy engine available in the cluster",
+		"No nodes available in the cluster",
+		"No node above the minimum health available in the cluster",
+		"Unable to find a node that satisfies",
+	}
+	noDeviceError = "No node satisfies the device and ulimit requirements"
+	noGPUError    = "No node with enough free GPUs available in the cluster"
+	noCpusetError = "No node has the CPUs of the cpuset of the container"
+)
+
+// RescheduleTrigger is the cause of a rescheduling.
+type RescheduleTrigger string
+
+const (
+	// TriggerEngineDisconnect is the trigger of the rescheduling of the
+	// containers of an engine which got disconnected.
+	TriggerEngineDisconnect RescheduleTrigger = "engine_disconnect"
+	// TriggerStartupScan is the trigger of the rescheduling of the
+	// containers of the engines found unhealthy when the manager becomes
+	// the primary.
+	TriggerStartupScan RescheduleTrigger = "startup_scan"
+	// TriggerMemoryPressure is the trigger of the eviction of containers
+	// from an engine under memory pressure.
+	TriggerMemoryPressure RescheduleTrigger = "memory_pressure"
+	// TriggerDiskPressure is the trigger of the eviction of containers from
+	// an engine under disk pressure.
+	TriggerDiskPressure RescheduleTrigger = "disk_pressure"
+	// TriggerRestartLoop is the trigger of the move of a container
+	// restarting in a loop.
+	TriggerRestartLoop RescheduleTrigger = "restart_loop"
+	// TriggerDrain is the trigger of the move of the containers of an engine
+	// being drained.
+	TriggerDrain RescheduleTrigger = "drain"
+	// TriggerReconcile is the trigger of the rescheduling of the containers
+	// of an unhealthy engine found by the reconciliation sweep.
+	TriggerReconcile RescheduleTrigger = "reconcile"
+	// TriggerQuarantineRelease is the trigger of the rescheduling of a
+	// container released from quarantine.
+	TriggerQuarantineRelease RescheduleTrigger = "quarantine_release"
+)
+
+// RescheduleError describes the failure to reschedule a container.
+type RescheduleError struct {
+	Container *Container
+	// Engine is the engine on which the failure happened, if any.
+	Engine *Engine
+	// Reason is one of the ErrNoCapacity, ErrImagePull, ErrPullRateLimit,
+	// ErrNetworkAttach, ErrNetworkCleanup, ErrPassDeadline, ErrOutsideWindow,
+	// ErrConfigMutation, ErrInsufficientCapacity, ErrTooFewHealthyEngines,
+	// ErrLocalMount, ErrDeviceUnavailable, ErrNoGPUCapacity or
+	// ErrCpusetUnavailable errors,
+	// or nil if the failure is not categorized.
+	Reason error
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *RescheduleError) Error() string {
+	return fmt.Sprintf("Failed to reschedule container %s: %s", e.Container.ID, e.cause())
+}
+
+// cause describes the failure, without the container.
+func (e *RescheduleError) cause() string {
+	if e.Reason != nil {
+		return fmt.Sprintf("%v: %v", e.Reason, e.Err)
+	}
+	return fmt.Sprint(e.Err)
+}
+
+// Retryable returns true if rescheduling the container again may succeed.
+// Image pulls are not retried as the image is unlikely to show up, a
+// container which failed to attach to its networks has already been
+// recreated, a rejected config or a container with local mounts is skipped,
+// and the nodes are unlikely to gain devices or CPUs.
+func (e *RescheduleError) Retryable() bool {
+	return e.Reason != ErrImagePull && e.Reason != ErrNetworkAttach && e.Reason != ErrConfigMutation && e.Reason != ErrLocalMount && e.Reason != ErrDeviceUnavailable && e.Reason != ErrCpusetUnavailable
+}
+
+// RescheduleErrors is the list of failures of a rescheduling attempt.
+type RescheduleErrors []*RescheduleError
+
+func (errs RescheduleErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Retryable returns true if at least one of the failures can be retried.
+func (errs RescheduleErrors) Retryable() bool {
+	for _, err := range errs {
+		if err.Retryable() {
+			return true
+		}
+	}
+	return false
+}
+
+// toError returns nil for an empty list, so that it is not mistaken for an
+// error when converted to the error interface.
+func toError(errs RescheduleErrors) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// classifyCreateError returns the reason of a failed container creation.
+func classifyCreateError(err error) error {
+	if rateLimitErrorRegexp.MatchString(err.Error()) {
+		return ErrPullRateLimit
+	}
+	if err == dockerclient.ErrImageNotFound || engineapi.IsErrImageNotFound(err) || imagePullErrorRegexp.MatchString(err.Error()) {
+		return ErrImagePull
+	}
+	if strings.Contains(err.Error(), noDeviceError) {
+		return ErrDeviceUnavailable
+	}
+	if strings.Contains(err.Error(), noGPUError) {
+		return ErrNoGPUCapacity
+	}
+	if strings.Contains(err.Error(), noCpusetError) {
+		return ErrCpusetUnavailable
+	}
+	for _, msg := range noCapacityErrors {
+		if strings.Contains(err.Error(), msg) {
+			return ErrNoCapacity
+		}
+	}
+	return nil
+}
+
+// WatchdogOpts represents the options for the watchdog
+type WatchdogOpts struct {
+	// MemoryPressureThreshold is the memory usage ratio (between 0 and 1)
+	// reported by an engine_memory_pressure event at or above which
+	// containers are evicted from the node. 0 disables eviction.
+	MemoryPressureThreshold float64
+	// DiskPressureThreshold is the disk usage ratio (between 0 and 1)
+	// reported by an engine_disk_pressure event at or above which
+	// containers are evicted from the node. 0 disables eviction.
+	DiskPressureThreshold float64
+	// PressureEvictionMaxPriority is the highest reschedule priority a
+	// container may have and still be evicted from a node under pressure.
+	PressureEvictionMaxPriority int
+	// PressureEvictionLimit is the maximum number of containers evicted for
+	// a single pressure event. 0 means no limit.
+	PressureEvictionLimit int
+	// RescheduleRetryInterval is the delay before the first retry to
+	// reschedule the containers of a failed engine. Following retries back
+	// off exponentially.
+	RescheduleRetryInterval time.Duration
+	// RescheduleRetryMaxInterval caps the delay between two retries.
+	RescheduleRetryMaxInterval time.Duration
+	// RescheduleRateLimitBackoff is how long the containers whose image
+	// pulls were rate limited by their registry are left alone before being
+	// retried, so that the retries don't compound the limit.
+	RescheduleRateLimitBackoff time.Duration
+	// RescheduleRetryLimit is the maximum number of consecutive attempts to
+	// reschedule the containers of a failed engine without any of them being
+	// rescheduled. 0 means no limit.
+	RescheduleRetryLimit int
+	// RescheduleQuarantineAttempts is the number of failed attempts to
+	// reschedule a container after which it is quarantined: it is no longer
+	// retried, until released through the API. 0 never quarantines.
+	RescheduleQuarantineAttempts int
+	// RescheduleMaxInflight caps the number of containers being rescheduled
+	// or moved at once across the whole cluster, to protect the shared
+	// dependencies such as the registry. The engines are rescheduled
+	// concurrently, their passes, the drains and the evictions wait for a
+	// free slot. 0 means no limit.
+	RescheduleMaxInflight int
+	// ReschedulePassTimeout is the time a single rescheduling pass may take
+	// before the remaining containers are left to the next pass, so that a
+	// hanging engine doesn't block the other reschedules.
+	ReschedulePassTimeout time.Duration
+	// ReschedulePreOutageGrace is the time the watchdog waits after an
+	// engine disconnects before rescheduling its containers. The
+	// rescheduling is canceled if the engine reconnects in the meantime, so
+	// that flapping engines keep their containers. 0 reschedules right away.
+	ReschedulePreOutageGrace time.Duration
+	// RescheduleSafeMode checks, before each rescheduling pass, that the
+	// healthy engines have the capacity to take the containers of the failed
+	// engine. If they don't, only the highest priority containers fitting in
+	// the capacity are rescheduled, and a reschedule_capacity_shortfall
+	// event is emitted.
+	RescheduleSafeMode bool
+	// RescheduleCapacityMargin is the ratio (between 0 and 1) of the free
+	// capacity of the cluster the safe mode keeps unreserved.
+	RescheduleCapacityMargin float64
+	// MinHealthyEngines is the number of healthy engines, besides the failed
+	// one, below which no container is rescheduled and a
+	// reschedule_min_healthy_engines event is emitted, so that the last
+	// nodes of a small cluster are not overwhelmed by the containers of the
+	// failed ones. The containers are rescheduled once enough engines are
+	// healthy again. 0 disables it.
+	MinHealthyEngines int
+	// RescheduleMemoryUsageWeight is the weight, between 0 and 1, given to
+	// the actual memory utilization of the nodes when choosing the targets
+	// of the rescheduled containers, over the ranking of the placement
+	// strategy by reservation. 0 disables it. The engines collect the memory
+	// usage of their containers when it is enabled.
+	RescheduleMemoryUsageWeight float64
+	// RescheduleCPUOvercommit is the ratio, 1 or more, of the CPUs of the
+	// nodes the rescheduled containers may reserve when no node has the
+	// CPUs they reserve, for them to run degraded rather than stay down
+	// until the cluster is rebalanced. The memory is never overcommitted. 0
+	// or 1 disables it.
+	RescheduleCPUOvercommit float64
+	// ReschedulePreferCachedImage prefers the targets which already have the
+	// image of a rescheduled container, sparing a pull. The other nodes are
+	// used when none of these can take it.
+	ReschedulePreferCachedImage bool
+	// RescheduleRelaxCpuset reschedules the containers pinned to CPUs by
+	// their cpuset without it when no node has these CPUs, rather than
+	// failing to reschedule them.
+	RescheduleRelaxCpuset bool
+	// RescheduleStickyWindow is how long the containers of a group, as set
+	// by the com.docker.swarm.reschedule-group label, stick to the node the
+	// last of them was rescheduled onto, where they have warmed caches. 0
+	// disables the stickiness.
+	RescheduleStickyWindow time.Duration
+	// RescheduleStickiness is how strongly they stick to it, "prefer" by
+	// default.
+	RescheduleStickiness Stickiness
+	// RescheduleCooldownCount is how many containers a node takes within
+	// RescheduleCooldownWindow before the reschedules avoid it for the rest
+	// of the window, giving it the time to start them. It is only used when
+	// no other node fits. 0 disables the cooldown.
+	RescheduleCooldownCount int
+	// RescheduleCooldownWindow is the window of the cooldown, 30s by
+	// default.
+	RescheduleCooldownWindow time.Duration
+	// RescheduleLocalMounts enables the rescheduling of the containers
+	// mounting host paths, named pipes or volumes of local drivers, whose
+	// data is left behind on the failed node. The containers with tmpfs
+	// mounts or anonymous volumes are always rescheduled.
+	RescheduleLocalMounts bool
+	// RescheduleStoppedContainers enables the rescheduling of the containers
+	// which were not running when their engine failed. They are recreated
+	// but not started.
+	RescheduleStoppedContainers bool
+	// RescheduleHonorRestartNo keeps the containers with the "no" restart
+	// policy down once moved: they are recreated but not started, even if
+	// they were running. The docker CLI sets this policy unless --restart is
+	// given, the containers created through the API without a policy are
+	// started as usual.
+	RescheduleHonorRestartNo bool
+	// RescheduleAutoRemove enables the rescheduling of the containers started
+	// with --rm. They are transient, so they are left out by default.
+	RescheduleAutoRemove bool
+	// RestartLoopThreshold is the number of restarts within
+	// RestartLoopWindow after which a container crash looping on a healthy
+	// node is rescheduled on another node. 0 disables the detection.
+	RestartLoopThreshold int
+	// RestartLoopWindow is the period over which restarts are counted.
+	RestartLoopWindow time.Duration
+	// RestartLoopCooldown is the period during which a container moved
+	// because of a restart loop is not moved again, to avoid ping-ponging
+	// between nodes.
+	RestartLoopCooldown time.Duration
+	// NodeFailureBreakerThreshold is the number of node failures within
+	// NodeFailureBreakerWindow over which the reschedules of the nodes
+	// failing next are paused, with a reschedule_breaker_tripped event,
+	// rather than amplifying an ongoing incident. They resume once no node
+	// failed for a whole window. 0 disables the breaker.
+	NodeFailureBreakerThreshold int
+	// NodeFailureBreakerWindow is the period over which node failures are
+	// counted.
+	NodeFailureBreakerWindow time.Duration
+	// DefaultReschedulePolicy is the reschedule policy of the containers
+	// without an explicit one, either "off", "on-node-failure" or
+	// "on-node-drain". Empty means "off".
+	DefaultReschedulePolicy string
+	// DisableDuplicateRemoval leaves the duplicates found on a returning
+	// node in place, whatever their labels. They are only reported, with a
+	// container_duplicate event.
+	DisableDuplicateRemoval bool
+	// DuplicateRemovalPolicy, if set, is consulted before removing a
+	// duplicate found on a returning node, along with the container it
+	// duplicates. Returning false keeps the duplicate, e.g. to keep the one
+	// with the newer image. It is called with the watchdog locked and can't
+	// be set from the command line.
+	DuplicateRemovalPolicy func(dup, survivor *Container) bool
+	// DuplicateRemovalConcurrency is how many duplicates of a returning
+	// node are removed at once. 0 removes them one at a time.
+	DuplicateRemovalConcurrency int
+	// DuplicateRemovalGrace is how long the watchdog waits after a node
+	// comes back before removing its duplicates, to check that the
+	// containers they duplicate are stable, e.g. not on flapping nodes.
+	// 0 removes them right away.
+	DuplicateRemovalGrace time.Duration
+	// DuplicateRemovalRetries enables the verification that the removed
+	// duplicates are gone from their node, for the engines which report a
+	// removal they didn't do. It is how many times a duplicate still there
+	// is removed again. 0 doesn't verify the removals.
+	DuplicateRemovalRetries int
+	// DuplicateRefreshFull inspects every container of a returning node when
+	// refreshing them before looking for its duplicates. Otherwise they are
+	// only listed: the containers the manager doesn't know are inspected,
+	// the known ones keep the config they had before the node failed, and
+	// miss e.g. the labels changed on the node in the meantime.
+	DuplicateRefreshFull bool
+	// DuplicateRefreshRetries is how many times a failed refresh of the
+	// containers of a returning node is retried before looking for its
+	// duplicates. If it still fails, the duplicates are looked for among the
+	// containers known before the node failed, which may miss some, and a
+	// duplicate_refresh_failed event is emitted.
+	DuplicateRefreshRetries int
+	// IdentityLabel, if set, is the label identifying the containers, e.g.
+	// the ones created directly on the engines, which have no swarm ID: the
+	// containers with the same value of the label are the same container
+	// to the deduplication and the rescheduling. The containers without
+	// the label are identified by their swarm ID.
+	IdentityLabel string
+	// RescheduleEngineOrder is the order the containers of the engines
+	// which failed at once, found by the startup scan or the reconciliation
+	// sweep, are rescheduled in, so that the most impactful recoveries take
+	// the capacity first: EngineOrderMostContainers or
+	// EngineOrderHighestPriority. Empty reschedules them concurrently, in no
+	// particular order.
+	RescheduleEngineOrder string
+	// StaleContainerPolicy is what is done to the stale containers of a
+	// returning node which have no counterpart in the cluster, either
+	// "remove" or "stop". Empty means "remove".
+	StaleContainerPolicy string
+	// RescheduleNodeSuitability maps node labels, as key=value, to how
+	// suitable the nodes having them are to receive rescheduled containers.
+	// It only applies to the containers without a constraint on the label.
+	RescheduleNodeSuitability map[string]NodeSuitability
+	// RescheduleSourceExclude are node labels, as key=value, whose nodes
+	// never have their containers rescheduled when they fail, as are the
+	// nodes with the reschedule-source-excluded label.
+	RescheduleSourceExclude []string
+	// RescheduleLabelTemplate maps labels to the templates of their values,
+	// stamped on the rescheduled containers, e.g. "{{.OldEngine}}", with the
+	// fields of RescheduleLabelData. The labels the containers already have
+	// are kept, unless stamped by a previous reschedule.
+	RescheduleLabelTemplate map[string]string
+	// RescheduleActiveWindows are the daily windows during which containers
+	// are rescheduled, unless overridden by their
+	// com.docker.swarm.reschedule-windows label. Failed engines are queued
+	// until a window opens. Empty means at any time.
+	RescheduleActiveWindows TimeWindows
+	// RescheduleWindowEscalate reschedules the containers right away outside
+	// of their windows, emitting a container_reschedule_escalated event
+	// instead of queueing them.
+	RescheduleWindowEscalate bool
+	// RescheduleConfigMutator, if set, rewrites the config of a container
+	// before it is recreated on the target engine, e.g. to drop a node
+	// specific bind mount. Returning an error skips the rescheduling of the
+	// container. It can't be set from the command line.
+	RescheduleConfigMutator func(c *Container, target *Engine) (*ContainerConfig, error)
+	// OnReschedulingStarted, if set, is called when a reschedule starts
+	// while none is in progress, e.g. to suppress the alerts during the
+	// recovery. OnReschedulingIdle, if set, is called when the last one in
+	// progress is over. They are called one at a time, in order, and must
+	// not block. They can't be set from the command line.
+	OnReschedulingStarted func()
+	OnReschedulingIdle    func()
+	// NetworkAttachAttempts is the number of attempts to connect a
+	// rescheduled container to each of its global networks.
+	NetworkAttachAttempts int
+	// NetworkAttachRetryInterval is the delay between two attempts to
+	// connect a rescheduled container to a network.
+	NetworkAttachRetryInterval time.Duration
+	// RescheduleHealthTimeout is how long the watchdog waits for the
+	// healthcheck of a restarted container to pass before considering it
+	// up. The containers still unhealthy afterwards are reported degraded. 0
+	// considers the containers up as soon as they started.
+	RescheduleHealthTimeout time.Duration
+	// RescheduleHealthInterval is the delay between two polls of the health
+	// of a restarted container.
+	RescheduleHealthInterval time.Duration
+	// RescheduleStopTimeout is the grace period given to the containers
+	// moved off a reachable engine, e.g. drained, to stop before they are
+	// removed. The stop timeout of the container, if set, takes precedence.
+	// 0 removes them right away.
+	RescheduleStopTimeout time.Duration
+	// ReconcileInterval is the period of the sweep rescheduling the
+	// containers of the unhealthy engines whose failure went unnoticed, e.g.
+	// because an engine_disconnect event was missed, and retrying the
+	// networks the moved containers couldn't be attached to. 0, the default,
+	// disables the sweep.
+	ReconcileInterval time.Duration
+	// LogLevel is the level of the logs of the watchdog, independently of
+	// the level of the manager, e.g. "debug". Empty means the level of the
+	// manager.
+	LogLevel string
+	// Switch, set by the manager, enables or disables rescheduling cluster
+	// wide at runtime. Nil means always enabled.
+	Switch *RescheduleSwitch
+	// Checkpoints, set by the manager, persists the engines being
+	// rescheduled, so that a new primary resumes them. Nil keeps them in
+	// memory.
+	Checkpoints CheckpointStore
+	// Quarantine, set by the manager, holds the quarantined containers for
+	// the API to list and release them. Nil keeps them to the watchdog.
+	Quarantine *Quarantine
+	// Emitter publishes the events of the watchdog, along with the attempts
+	// of the reschedules, to an external system, as set by the
+	// event-emitter endpoint. Nil publishes nothing.
+	Emitter EventEmitter
+}
+
+// NodeSuitability is how suitable nodes are to receive rescheduled
+// containers.
+type NodeSuitability string
+
+const (
+	// SuitabilityAvoid discourages rescheduling on the nodes, they are only
+	// used when no other node fits.
+	SuitabilityAvoid NodeSuitability = "avoid"
+	// SuitabilityForbid prevents rescheduling on the nodes.
+	SuitabilityForbid NodeSuitability = "forbid"
+)
+
+const (
+	defaultRescheduleRetryInterval    = 5 * time.Second
+	defaultRescheduleRetryMaxInterval = 5 * time.Minute
+	defaultRescheduleRateLimitBackoff = 15 * time.Minute
+	defaultReschedulePassTimeout      = 5 * time.Minute
+	defaultNetworkAttachAttempts      = 3
+	defaultNetworkAttachRetryInterval = time.Second
+	defaultRescheduleHealthInterval   = time.Second
+	rescheduleBackoffFactor           = 2
+	defaultRestartLoopWindow          = 5 * time.Minute
+	defaultRestartLoopCooldown        = 30 * time.Minute
+	defaultNodeFailureBreakerWindow   = 10 * time.Minute
+	defaultRescheduleCooldownWindow   = 30 * time.Second
+)
+
+// NewWatchdogOpts creates the watchdog options from key=value options
+func NewWatchdogOpts(options DriverOpts) (*WatchdogOpts, error) {
+	opts := &WatchdogOpts{
+		RescheduleStoppedContainers: true,
+	}
+
+	if val, ok := options.Float("memory-pressure-threshold", ""); ok {
+		if val < 0 || val > 1 {
+			return nil, fmt.Errorf("memory-pressure-threshold should be between 0 and 1, %f is invalid", val)
+		}
+		opts.MemoryPressureThreshold = val
+	}
+
+	if val, ok := options.Float("disk-pressure-threshold", ""); ok {
+		if val < 0 || val > 1 {
+			return nil, fmt.Errorf("disk-pressure-threshold should be between 0 and 1, %f is invalid", val)
+		}
+		opts.DiskPressureThreshold = val
+	}
+
+	if val, ok := options.Int("pressure-eviction-max-priority", ""); ok {
+		opts.PressureEvictionMaxPriority = int(val)
+	}
+
+	if val, ok := options.Int("pressure-eviction-limit", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("pressure-eviction-limit can not be negative, %d is invalid", val)
+		}
+		opts.PressureEvictionLimit = int(val)
+	}
+
+	if val, ok := options.String("reschedule-retry-interval", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("reschedule-retry-interval should be a positive duration, %s is invalid", val)
+		}
+		opts.RescheduleRetryInterval = d
+	}
+
+	if val, ok := options.String("reschedule-retry-max-interval", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("reschedule-retry-max-interval should be a positive duration, %s is invalid", val)
+		}
+		opts.RescheduleRetryMaxInterval = d
+	}
+
+	if val, ok := options.String("reschedule-rate-limit-backoff", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("reschedule-rate-limit-backoff should be a positive duration, %s is invalid", val)
+		}
+		opts.RescheduleRateLimitBackoff = d
+	}
+
+	if val, ok := options.Int("reschedule-retry-limit", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("reschedule-retry-limit can not be negative, %d is invalid", val)
+		}
+		opts.RescheduleRetryLimit = int(val)
+	}
+
+	if val, ok := options.Int("reschedule-quarantine-attempts", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("reschedule-quarantine-attempts can not be negative, %d is invalid", val)
+		}
+		opts.RescheduleQuarantineAttempts = int(val)
+	}
+
+	if val, ok := options.Int("reschedule-max-inflight", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("reschedule-max-inflight can not be negative, %d is invalid", val)
+		}
+		opts.RescheduleMaxInflight = int(val)
+	}
+
+	if val, ok := options.String("reschedule-pass-timeout", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("reschedule-pass-timeout should be a positive duration, %s is invalid", val)
+		}
+		opts.ReschedulePassTimeout = d
+	}
+
+	if val, ok := options.String("reschedule-pre-outage-grace", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("reschedule-pre-outage-grace should be a non-negative duration, %s is invalid", val)
+		}
+		opts.ReschedulePreOutageGrace = d
+	}
+
+	if val, ok := options.Int("network-attach-attempts", ""); ok {
+		if val < 1 {
+			return nil, fmt.Errorf("network-attach-attempts should be at least 1, %d is invalid", val)
+		}
+		opts.NetworkAttachAttempts = int(val)
+	}
+
+	if val, ok := options.String("network-attach-retry-interval", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("network-attach-retry-interval should be a positive duration, %s is invalid", val)
+		}
+		opts.NetworkAttachRetryInterval = d
+	}
+
+	if val, ok := options.String("reschedule-health-timeout", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("reschedule-health-timeout should be a duration, 0 to disable, %s is invalid", val)
+		}
+		opts.RescheduleHealthTimeout = d
+	}
+
+	if val, ok := options.String("reschedule-stop-timeout", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("reschedule-stop-timeout should be a duration, 0 to disable, %s is invalid", val)
+		}
+		opts.RescheduleStopTimeout = d
+	}
+
+	if val, ok := options.String("reschedule-health-interval", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("reschedule-health-interval should be a positive duration, %s is invalid", val)
+		}
+		opts.RescheduleHealthInterval = d
+	}
+
+	if val, ok := options.String("reconcile-interval", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("reconcile-interval should be a duration, 0 to disable, %s is invalid", val)
+		}
+		opts.ReconcileInterval = d
+	}
+
+	if val, ok := options.String("log-level", ""); ok {
+		if _, err := log.ParseLevel(val); err != nil {
+			return nil, fmt.Errorf("log-level should be a log level, %s is invalid", val)
+		}
+		opts.LogLevel = val
+	}
+
+	if val, ok := options.Bool("reschedule-safe-mode", ""); ok {
+		opts.RescheduleSafeMode = val
+	}
+
+	if val, ok := options.Int("min-healthy-engines", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("min-healthy-engines can not be negative, %d is invalid", val)
+		}
+		opts.MinHealthyEngines = int(val)
+	}
+
+	if val, ok := options.Float("reschedule-capacity-margin", ""); ok {
+		if val < 0 || val >= 1 {
+			return nil, fmt.Errorf("reschedule-capacity-margin should be between 0 and 1, %f is invalid", val)
+		}
+		opts.RescheduleCapacityMargin = val
+	}
+
+	if val, ok := options.Float("reschedule-memory-usage-weight", ""); ok {
+		if val < 0 || val > 1 {
+			return nil, fmt.Errorf("reschedule-memory-usage-weight should be between 0 and 1, %f is invalid", val)
+		}
+		opts.RescheduleMemoryUsageWeight = val
+	}
+
+	if val, ok := options.Float("reschedule-cpu-overcommit", ""); ok {
+		if val != 0 && val < 1 {
+			return nil, fmt.Errorf("reschedule-cpu-overcommit should be 1 or more, 0 to disable, %f is invalid", val)
+		}
+		opts.RescheduleCPUOvercommit = val
+	}
+
+	if val, ok := options.Bool("reschedule-prefer-cached-image", ""); ok {
+		opts.ReschedulePreferCachedImage = val
+	}
+
+	if val, ok := options.Bool("reschedule-relax-cpuset", ""); ok {
+		opts.RescheduleRelaxCpuset = val
+	}
+
+	if val, ok := options.String("reschedule-sticky-window", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("reschedule-sticky-window should be a duration, 0 to disable, %s is invalid", val)
+		}
+		opts.RescheduleStickyWindow = d
+	}
+
+	if val, ok := options.Int("reschedule-cooldown-count", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("reschedule-cooldown-count can not be negative, %d is invalid", val)
+		}
+		opts.RescheduleCooldownCount = int(val)
+	}
+
+	if val, ok := options.String("reschedule-cooldown-window", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("reschedule-cooldown-window should be a positive duration, %s is invalid", val)
+		}
+		opts.RescheduleCooldownWindow = d
+	}
+
+	if val, ok := options.String("reschedule-stickiness", ""); ok {
+		if s := Stickiness(val); s != StickinessPrefer && s != StickinessRequire {
+			return nil, fmt.Errorf("reschedule-stickiness should be prefer or require, %s is invalid", val)
+		}
+		opts.RescheduleStickiness = Stickiness(val)
+	}
+
+	if val, ok := options.Bool("reschedule-local-mounts", ""); ok {
+		opts.RescheduleLocalMounts = val
+	}
+
+	if val, ok := options.Bool("reschedule-stopped-containers", ""); ok {
+		opts.RescheduleStoppedContainers = val
+	}
+
+	if val, ok := options.Bool("reschedule-honor-restart-no", ""); ok {
+		opts.RescheduleHonorRestartNo = val
+	}
+
+	if val, ok := options.Bool("reschedule-auto-remove", ""); ok {
+		opts.RescheduleAutoRemove = val
+	}
+
+	if val, ok := options.Bool("disable-duplicate-removal", ""); ok {
+		opts.DisableDuplicateRemoval = val
+	}
+
+	if val, ok := options.Int("duplicate-removal-concurrency", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("duplicate-removal-concurrency can not be negative, %d is invalid", val)
+		}
+		opts.DuplicateRemovalConcurrency = int(val)
+	}
+
+	if val, ok := options.Int("duplicate-removal-retries", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("duplicate-removal-retries can not be negative, %d is invalid", val)
+		}
+		opts.DuplicateRemovalRetries = int(val)
+	}
+
+	if val, ok := options.Bool("duplicate-refresh-full", ""); ok {
+		opts.DuplicateRefreshFull = val
+	}
+
+	if val, ok := options.Int("duplicate-refresh-retries", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("duplicate-refresh-retries can not be negative, %d is invalid", val)
+		}
+		opts.DuplicateRefreshRetries = int(val)
+	}
+
+	if val, ok := options.String("duplicate-removal-grace", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("duplicate-removal-grace should be a non-negative duration, %s is invalid", val)
+		}
+		opts.DuplicateRemovalGrace = d
+	}
+
+	if val, ok := options.String("identity-label", ""); ok {
+		opts.IdentityLabel = val
+	}
+
+	if val, ok := options.String("event-emitter", ""); ok {
+		emitter, err := NewEventEmitter(val)
+		if err != nil {
+			return nil, fmt.Errorf("event-emitter should be the endpoint of a registered event emitter, %s is invalid: %v", val, err)
+		}
+		opts.Emitter = emitter
+	}
+
+	if val, ok := options.String("reschedule-engine-order", ""); ok {
+		if val != EngineOrderMostContainers && val != EngineOrderHighestPriority {
+			return nil, fmt.Errorf("reschedule-engine-order should be %s or %s, %s is invalid", EngineOrderMostContainers, EngineOrderHighestPriority, val)
+		}
+		opts.RescheduleEngineOrder = val
+	}
+
+	if val, ok := options.String("stale-container-policy", ""); ok {
+		if val != "remove" && val != "stop" {
+			return nil, fmt.Errorf("stale-container-policy should be remove or stop, %s is invalid", val)
+		}
+		opts.StaleContainerPolicy = val
+	}
+
+	if val, ok := options.String("default-reschedule-policy", ""); ok {
+		if val != "off" && val != "on-node-failure" && val != "on-node-drain" {
+			return nil, fmt.Errorf("default-reschedule-policy should be off, on-node-failure or on-node-drain, %s is invalid", val)
+		}
+		opts.DefaultReschedulePolicy = val
+	}
+
+	if val, ok := options.String("reschedule-active-windows", ""); ok {
+		windows, err := ParseTimeWindows(val)
+		if err != nil {
+			return nil, fmt.Errorf("reschedule-active-windows should be a list of HH:MM-HH:MM windows: %v", err)
+		}
+		opts.RescheduleActiveWindows = windows
+	}
+
+	if val, ok := options.Bool("reschedule-window-escalate", ""); ok {
+		opts.RescheduleWindowEscalate = val
+	}
+
+	if val, ok := options.String("reschedule-node-suitability", ""); ok {
+		suitability, err := parseNodeSuitability(val)
+		if err != nil {
+			return nil, err
+		}
+		opts.RescheduleNodeSuitability = suitability
+	}
+
+	if val, ok := options.String("reschedule-source-exclude", ""); ok {
+		for _, label := range strings.Split(val, ",") {
+			if kv := strings.SplitN(label, "=", 2); len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return nil, fmt.Errorf("reschedule-source-exclude should be a list of key=value, %s is invalid", label)
+			}
+			opts.RescheduleSourceExclude = append(opts.RescheduleSourceExclude, label)
+		}
+	}
+
+	if val, ok := options.String("reschedule-label-template", ""); ok {
+		opts.RescheduleLabelTemplate = make(map[string]string)
+		for _, label := range strings.Split(val, ",") {
+			kv := strings.SplitN(label, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("reschedule-label-template should be a list of label=template, %s is invalid", label)
+			}
+			opts.RescheduleLabelTemplate[kv[0]] = kv[1]
+		}
+		if _, err := parseLabelTemplates(opts.RescheduleLabelTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	if val, ok := options.Int("restart-loop-threshold", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("restart-loop-threshold can not be negative, %d is invalid", val)
+		}
+		opts.RestartLoopThreshold = int(val)
+	}
+
+	if val, ok := options.String("restart-loop-window", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("restart-loop-window should be a positive duration, %s is invalid", val)
+		}
+		opts.RestartLoopWindow = d
+	}
+
+	if val, ok := options.String("restart-loop-cooldown", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("restart-loop-cooldown should be a positive duration, %s is invalid", val)
+		}
+		opts.RestartLoopCooldown = d
+	}
+
+	if val, ok := options.Int("node-failure-breaker-threshold", ""); ok {
+		if val < 0 {
+			return nil, fmt.Errorf("node-failure-breaker-threshold can not be negative, %d is invalid", val)
+		}
+		opts.NodeFailureBreakerThreshold = int(val)
+	}
+
+	if val, ok := options.String("node-failure-breaker-window", ""); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("node-failure-breaker-window should be a positive duration, %s is invalid", val)
+		}
+		opts.NodeFailureBreakerWindow = d
+	}
+
+	return opts, nil
+}
+
+// parseNodeSuitability parses a comma separated list of key=value:suitability
+// node label policies, e.g. "lifecycle=spot:avoid,tier=preemptible:forbid".
+func parseNodeSuitability(val string) (map[string]NodeSuitability, error) {
+	suitability := make(map[string]NodeSuitability)
+	for _, policy := range strings.Split(val, ",") {
+		i := strings.LastIndex(policy, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("reschedule-node-suitability should be a list of key=value:suitability, %s is invalid", policy)
+		}
+		label, value := policy[:i], NodeSuitability(policy[i+1:])
+		if kv := strings.SplitN(label, "=", 2); len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("reschedule-node-suitability should be a list of key=value:suitability, %s is invalid", policy)
+		}
+		if value != SuitabilityAvoid && value != SuitabilityForbid {
+			return nil, fmt.Errorf("reschedule-node-suitability should be avoid or forbid, %s is invalid", value)
+		}
+		suitability[label] = value
+	}
+	return suitability, nil
+}
+
 // Watchdog listens to cluster events and handles container rescheduling
 type Watchdog struct {
 	sync.Mutex
 	cluster Cluster
+	opts    *WatchdogOpts
+	log     *log.Entry
+
+	stateLock sync.RWMutex
+	// running is false once the watchdog has been stopped.
+	running bool
+	// leader is false while this manager is not the primary.
+	leader bool
+	// abandon is closed when the watchdog becomes inactive, to interrupt
+	// in-flight reschedules.
+	abandon chan struct{}
+	// stopped is closed when the watchdog is stopped.
+	stopped chan struct{}
+
+	enginesLock sync.Mutex
+	// inflight holds the reschedules of the engines in progress, by engine
+	// ID.
+	inflight map[string]*inflightReschedule
+	// waves holds the waves in progress, by engine ID.
+	waves map[string]*rescheduleWave
+	// transitionsLock orders the calls of the callbacks of the transitions
+	// from and to no wave in progress.
+	transitionsLock sync.Mutex
+	// handled holds the unhealthy engines whose rescheduling completed, by
+	// engine ID, so that the reconciliation sweep leaves them alone.
+	handled map[string]bool
+	// grace holds the disconnected engines within their pre-outage grace,
+	// by engine ID.
+	grace map[string]bool
+
+	checkpointLock sync.Mutex
+	// checkpoint is the in-flight state of the watchdog, as last saved.
+	checkpoint *Checkpoint
+
+	staleLock sync.Mutex
+	// stale holds the IDs of the containers replaced by a rescheduled
+	// container, which are fenced if their node comes back.
+	stale map[string]bool
+
+	networksLock sync.Mutex
+	// detachedNetworks holds the endpoints of the moved containers on the
+	// networks they couldn't be attached to, by container ID, then network
+	// name. Only those networks are retried by the reconciliation sweep.
+	detachedNetworks map[string]map[string]*network.EndpointSettings
+
+	// labelTemplates are the parsed RescheduleLabelTemplate, by label.
+	labelTemplates map[string]*template.Template
+
+	// sticky holds the last reschedule targets of the container groups.
+	sticky *stickyTargets
+	// cooldowns holds the recent reschedules onto each target.
+	cooldowns *targetCooldowns
+	// breaker pauses the reschedules when too many nodes fail at once.
+	breaker *failureBreaker
+	// slots holds a token per container being rescheduled, if their number
+	// is capped.
+	slots chan struct{}
+
+	movingLock sync.Mutex
+	// moving holds the IDs of the containers whose rescheduling outlived its
+	// pass, they are left alone by the next passes until it completes, and
+	// of the containers being moved off healthy nodes.
+	moving map[string]bool
+
+	// restarts tracks the restarts of the containers, by container ID.
+	restarts map[string]*restartRecord
+	// restartLoopMoves holds when containers were last moved because of a
+	// restart loop, by identity.
+	restartLoopMoves map[string]time.Time
+
+	// downtime is the histogram of the downtime of the rescheduled
+	// containers.
+	downtime *Histogram
+
+	// pending tracks the events being handled in the background, so that
+	// the replays handle the events one at a time.
+	pending sync.WaitGroup
 }
 
-// Handle handles cluster callbacks
-func (w *Watchdog) Handle(e *Event) error {
-	// Skip non-swarm events.
-	if e.From != "swarm" {
+// restartRecord holds the restart count of a container at the beginning of
+// the restart loop detection window.
+type restartRecord struct {
+	since time.Time
+	count int
+}
+
+// active returns true if the watchdog is running on the primary manager.
+func (w *Watchdog) active() bool {
+	w.stateLock.RLock()
+	defer w.stateLock.RUnlock()
+	return w.running && w.leader
+}
+
+// abandonCh returns the channel closed when the watchdog becomes inactive,
+// or nil if it is already inactive.
+func (w *Watchdog) abandonCh() chan struct{} {
+	w.stateLock.RLock()
+	defer w.stateLock.RUnlock()
+	if !w.running || !w.leader {
 		return nil
 	}
+	return w.abandon
+}
+
+// Stop stops the watchdog for good. In-flight reschedules are abandoned.
+func (w *Watchdog) Stop() {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
+	if !w.running {
+		return
+	}
+	if w.leader {
+		close(w.abandon)
+	}
+	close(w.stopped)
+	w.running = false
+	w.cluster.UnregisterEventHandler(w)
+}
+
+// Demote is called when this manager loses the primary status. The watchdog
+// abandons in-flight reschedules and ignores cluster events until promoted.
+func (w *Watchdog) Demote() {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
+	if !w.leader {
+		return
+	}
+	w.leader = false
+	if w.running {
+		w.log.Info("Watchdog paused: manager is no longer the primary")
+		close(w.abandon)
+	}
+}
+
+// Promote is called when this manager becomes the primary. The watchdog
+// resumes handling cluster events and reschedules the containers of the
+// engines which failed in the meantime.
+func (w *Watchdog) Promote() {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
+	if w.leader {
+		return
+	}
+	w.leader = true
+	if w.running {
+		w.log.Info("Watchdog resumed: manager is the primary")
+		w.abandon = make(chan struct{})
+		go w.rescheduleFailedEngines()
+	}
+}
+
+// rescheduleFailedEngines reschedules the containers of every engine which
+// is not healthy. The reschedules left by the previous primary are resumed.
+func (w *Watchdog) rescheduleFailedEngines() {
+	engines := w.unhealthyEngines()
+	resumed := w.restoreCheckpoint(engines)
+	failed := make([]*Engine, 0, len(engines))
+	triggers := make(map[string]RescheduleTrigger, len(engines))
+	for id, e := range engines {
+		trigger, ok := resumed[id]
+		if !ok {
+			trigger = TriggerStartupScan
+		}
+		failed = append(failed, e)
+		triggers[id] = trigger
+	}
+	w.rescheduleEngines(failed, triggers)
+}
+
+// unhealthyEngines returns the engines which are not healthy, by engine ID.
+func (w *Watchdog) unhealthyEngines() map[string]*Engine {
+	engines := make(map[string]*Engine)
+	for _, c := range w.cluster.Containers() {
+		if c.Engine != nil && !c.Engine.IsHealthy() {
+			engines[c.Engine.ID] = c.Engine
+		}
+	}
+	return engines
+}
+
+// restoreCheckpoint loads the in-flight state saved by the previous primary.
+// It returns the triggers of the engines to resume, the engines which are no
+// longer unhealthy are forgotten.
+func (w *Watchdog) restoreCheckpoint(engines map[string]*Engine) map[string]RescheduleTrigger {
+	checkpoint, err := w.opts.Checkpoints.Load()
+	if err != nil {
+		w.log.Warnf("Unable to load the reschedule checkpoint, starting afresh: %v", err)
+		checkpoint, _ = decodeCheckpoint(nil)
+	}
+
+	w.checkpointLock.Lock()
+	defer w.checkpointLock.Unlock()
+	w.checkpoint = checkpoint
+	resumed := make(map[string]RescheduleTrigger)
+	forgotten := false
+	for id, state := range checkpoint.Engines {
+		if _, ok := engines[id]; !ok {
+			delete(checkpoint.Engines, id)
+			forgotten = true
+			continue
+		}
+		w.log.Infof("Resuming rescheduling of containers of node %s detected at %s (trigger: %s)", id, state.Started.Format(time.RFC3339), state.Trigger)
+		resumed[id] = state.Trigger
+	}
+	if forgotten {
+		w.saveCheckpoint()
+	}
+	return resumed
+}
+
+// saveCheckpoint saves the in-flight state. It must be called with the
+// checkpoint lock held.
+func (w *Watchdog) saveCheckpoint() {
+	if err := w.opts.Checkpoints.Save(w.checkpoint); err != nil {
+		w.log.Warnf("Unable to save the reschedule checkpoint: %v", err)
+	}
+}
+
+// resumeWave resumes a wave from the checkpoint of its engine, if any: the
+// attempts made so far carry on.
+func (w *Watchdog) resumeWave(wave *rescheduleWave) {
+	w.checkpointLock.Lock()
+	state, ok := w.checkpoint.Engines[wave.engine.ID]
+	w.checkpointLock.Unlock()
+	if !ok {
+		return
+	}
+
+	wave.started = state.Started
+	wave.attempt = state.Attempt
+	for _, c := range wave.engine.Containers() {
+		attempts, ok := state.Attempts[c.ID]
+		if current, _ := strconv.Atoi(c.Config.Labels[RescheduleAttemptLabel]); ok && attempts > current {
+			setContainerLabel(c, RescheduleAttemptLabel, strconv.Itoa(attempts))
+		}
+	}
+}
+
+// checkpointWave saves the progress of a wave.
+func (w *Watchdog) checkpointWave(wave *rescheduleWave) {
+	state := &EngineCheckpoint{
+		Trigger:  wave.trigger,
+		Started:  wave.started,
+		Attempt:  wave.attempt,
+		Attempts: make(map[string]int),
+	}
+	for _, c := range wave.engine.Containers() {
+		if attempts, _ := strconv.Atoi(c.Config.Labels[RescheduleAttemptLabel]); attempts > 0 {
+			state.Attempts[c.ID] = attempts
+		}
+	}
+
+	w.checkpointLock.Lock()
+	defer w.checkpointLock.Unlock()
+	w.checkpoint.Engines[wave.engine.ID] = state
+	w.saveCheckpoint()
+}
+
+// forgetWave removes a completed wave from the checkpoint.
+func (w *Watchdog) forgetWave(wave *rescheduleWave) {
+	w.checkpointLock.Lock()
+	defer w.checkpointLock.Unlock()
+	if _, ok := w.checkpoint.Engines[wave.engine.ID]; ok {
+		delete(w.checkpoint.Engines, wave.engine.ID)
+		w.saveCheckpoint()
+	}
+}
+
+// EventPriority makes the watchdog handle the events after the handlers
+// updating the state of the cluster, so that it doesn't act on stale data.
+func (w *Watchdog) EventPriority() int {
+	return EventPriorityWatchdog
+}
+
+// Handle handles cluster callbacks
+func (w *Watchdog) Handle(e *Event) error {
+	// Container starts are reported by the engines, including the restarts.
+	if e.From != "swarm" && e.Status == "start" && w.opts.RestartLoopThreshold > 0 && w.active() {
+		w.background(func() { w.checkRestartLoop(e.Engine, e.ID, time.Now()) })
+		return nil
+	}
+
+	// Skip non-swarm events.
+	if e.From != "swarm" {
+		return nil
+	}
+
+	// Only the primary manager handles events.
+	if !w.active() {
+		return nil
+	}
+
+	switch e.Status {
+	case "engine_connect", "engine_reconnect":
+		w.enginesLock.Lock()
+		delete(w.handled, e.Engine.ID)
+		// The engine is back, the containers not rescheduled yet are left
+		// in place. Within its pre-outage grace, none was.
+		inflight := w.inflight[e.Engine.ID]
+		if inflight != nil {
+			if !w.grace[e.Engine.ID] {
+				w.log.Infof("Node %s reconnected, stopping the rescheduling of its containers", e.Engine.ID)
+			}
+			inflight.cancel()
+		}
+		w.enginesLock.Unlock()
+		w.background(func() {
+			// The containers rescheduled until the rescheduling stops
+			// are duplicates.
+			if inflight != nil {
+				<-inflight.done
+				w.enginesLock.Lock()
+				delete(w.handled, e.Engine.ID)
+				w.enginesLock.Unlock()
+			}
+			w.removeDuplicateContainers(e.Engine)
+			w.fenceStaleContainers(e.Engine)
+		})
+	case "engine_disconnect":
+		w.background(func() { w.rescheduleContainers(e.Engine, TriggerEngineDisconnect) })
+	case "engine_memory_pressure":
+		w.background(func() {
+			w.relievePressure(e.Engine, TriggerMemoryPressure, w.opts.MemoryPressureThreshold, e.Actor.Attributes["usage"])
+		})
+	case "engine_disk_pressure":
+		w.background(func() {
+			w.relievePressure(e.Engine, TriggerDiskPressure, w.opts.DiskPressureThreshold, e.Actor.Attributes["usage"])
+		})
+	}
+	return nil
+}
+
+// background handles an event in the background, tracked by pending.
+func (w *Watchdog) background(f func()) {
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		f()
+	}()
+}
+
+// removeDuplicateContainers removes duplicate containers when a node comes
+// back. With a duplicate removal grace, the duplicates are only removed once
+// the containers they duplicate are still there at the end of the grace.
+func (w *Watchdog) removeDuplicateContainers(e *Engine) {
+	w.log.Debugf("removing duplicate containers from Node %s", e.ID)
+
+	if err := w.refreshReturningEngine(e); err != nil {
+		w.log.Errorf("Failed to refresh the containers of node %s, looking for its duplicates among the containers known before it failed: %v", e.ID, err)
+		w.emitEvent(e, "duplicate_refresh_failed", map[string]string{
+			"error":    err.Error(),
+			"full":     strconv.FormatBool(w.opts.DuplicateRefreshFull),
+			"attempts": strconv.Itoa(w.opts.DuplicateRefreshRetries + 1),
+			"known":    strconv.Itoa(len(e.Containers())),
+		})
+	}
+
+	duplicates := w.findDuplicates(e)
+	if len(duplicates) > 0 && w.opts.DuplicateRemovalGrace > 0 && !w.waitDuplicateRemovalGrace(e, len(duplicates)) {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.opts.DuplicateRemovalGrace > 0 {
+		duplicates = w.stableDuplicates(e, duplicates)
+	}
+	w.removeDuplicates(e, duplicates)
+}
+
+// refreshReturningEngine refreshes the containers of a returning node, for
+// the containers rescheduled while it was gone, which the manager forgot, to
+// be found again. A failed refresh is retried DuplicateRefreshRetries times.
+func (w *Watchdog) refreshReturningEngine(e *Engine) error {
+	for retry := 0; ; retry++ {
+		err := e.RefreshContainers(w.opts.DuplicateRefreshFull)
+		if err == nil || retry == w.opts.DuplicateRefreshRetries {
+			return err
+		}
+		w.log.Warnf("Failed to refresh the containers of node %s, retrying (retry %d of %d): %v", e.ID, retry+1, w.opts.DuplicateRefreshRetries, err)
+	}
+}
+
+// findDuplicates returns the duplicates of a returning node to remove.
+func (w *Watchdog) findDuplicates(e *Engine) []duplicateContainer {
+	w.Lock()
+	defer w.Unlock()
+
+	// The duplicates are all matched against the same view of the cluster,
+	// whatever the order of their removals.
+	clusterContainers := w.cluster.Containers()
+	duplicates := []duplicateContainer{}
+	for _, container := range e.Containers() {
+		// skip the containers which are neither swarm containers nor
+		// identified by the identity label
+		identity := w.identity(container)
+		if identity == "" {
+			continue
+		}
+
+		for _, containerInCluster := range clusterContainers {
+			if w.identity(containerInCluster) == identity && containerInCluster.Engine.ID != container.Engine.ID {
+				if w.opts.DisableDuplicateRemoval {
+					w.log.Warnf("container %s is a duplicate of container %s on node %s, duplicate removal is disabled", container.ID, containerInCluster.ID, containerInCluster.Engine.Name)
+					w.emitEvent(e, "container_duplicate", map[string]string{
+						"container":    container.ID,
+						"duplicate_of": containerInCluster.ID,
+						"node":         containerInCluster.Engine.Name,
+					})
+					continue
+				}
+				if container.Config.NoAutoDedup() {
+					w.log.Warnf("container %s is a duplicate of container %s on node %s, leaving it in place", container.ID, containerInCluster.ID, containerInCluster.Engine.Name)
+					continue
+				}
+				if w.opts.DuplicateRemovalPolicy != nil && !w.opts.DuplicateRemovalPolicy(container, containerInCluster) {
+					w.log.Warnf("container %s is a duplicate of container %s on node %s, kept by the duplicate removal policy", container.ID, containerInCluster.ID, containerInCluster.Engine.Name)
+					continue
+				}
+				duplicates = append(duplicates, duplicateContainer{container: container, of: containerInCluster})
+				break
+			}
+		}
+	}
+	return duplicates
+}
+
+// waitDuplicateRemovalGrace waits for the duplicate removal grace of a
+// returning node. It returns false if the node went away again, or the
+// watchdog became inactive, before the end of the grace.
+func (w *Watchdog) waitDuplicateRemovalGrace(e *Engine, duplicates int) bool {
+	abandon := w.abandonCh()
+	if abandon == nil {
+		return false
+	}
+	w.log.Infof("Node %s came back with %d duplicate containers, waiting %s before removing them", e.ID, duplicates, w.opts.DuplicateRemovalGrace)
+	select {
+	case <-time.After(w.opts.DuplicateRemovalGrace):
+	case <-abandon:
+		return false
+	}
+	if !e.IsHealthy() {
+		w.log.Infof("Node %s went away during the duplicate removal grace, leaving its duplicates in place", e.ID)
+		return false
+	}
+	return true
+}
+
+// stableDuplicates returns the duplicates whose containers they duplicate
+// are still on a healthy node after the duplicate removal grace, and still
+// running if they were. The other duplicates are left in place, lest no copy
+// of the container remains.
+func (w *Watchdog) stableDuplicates(e *Engine, duplicates []duplicateContainer) []duplicateContainer {
+	stable := []duplicateContainer{}
+	for _, dup := range duplicates {
+		if e.Containers().Get(dup.container.ID) == nil {
+			continue
+		}
+		survivor := dup.of.Engine.Containers().Get(dup.of.ID)
+		if survivor == nil || !survivor.Engine.IsHealthy() || isRunning(dup.of) && !isRunning(survivor) {
+			w.log.Warnf("container %s duplicated by container %s on node %s is not stable, leaving the duplicate in place", dup.of.ID, dup.container.ID, e.Name)
+			continue
+		}
+		stable = append(stable, dup)
+	}
+	return stable
+}
+
+// duplicateContainer is a container of a returning node which was rescheduled
+// while the node was gone.
+type duplicateContainer struct {
+	container *Container
+	// of is the container it duplicates.
+	of *Container
+}
+
+// removeDuplicates removes the duplicates of a returning node,
+// DuplicateRemovalConcurrency at once.
+func (w *Watchdog) removeDuplicates(e *Engine, duplicates []duplicateContainer) {
+	concurrency := w.opts.DuplicateRemovalConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if concurrency > len(duplicates) {
+		concurrency = len(duplicates)
+	}
+
+	var wg sync.WaitGroup
+	queue := make(chan duplicateContainer)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dup := range queue {
+				w.log.Debugf("container %s was rescheduled on node %s, removing it", dup.container.ID, dup.of.Engine.Name)
+				// container already exists in the cluster, destroy it
+				if err := w.removeDuplicate(e, dup.container); err != nil {
+					w.log.Errorf("Failed to remove duplicate container %s on node %s: %v", dup.container.ID, dup.of.Engine.Name, err)
+				}
+			}
+		}()
+	}
+	for _, dup := range duplicates {
+		queue <- dup
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// removeDuplicate removes a duplicate container. If the removals are
+// verified, it then removes it again while it is still on its engine, up to
+// DuplicateRemovalRetries times.
+func (w *Watchdog) removeDuplicate(e *Engine, c *Container) error {
+	if err := w.removeStale(e, c); err != nil {
+		return err
+	}
+	if w.opts.DuplicateRemovalRetries <= 0 {
+		return nil
+	}
+	for retry := 0; ; retry++ {
+		present, err := e.HasContainer(c.ID)
+		if err != nil {
+			return fmt.Errorf("unable to verify the removal: %v", err)
+		}
+		if !present {
+			return nil
+		}
+		if retry == w.opts.DuplicateRemovalRetries {
+			return fmt.Errorf("still on the node after %d removals", retry+1)
+		}
+		w.log.Warnf("Duplicate container %s is still on node %s after its removal, removing it again (retry %d of %d)", c.ID, e.Name, retry+1, w.opts.DuplicateRemovalRetries)
+		if err := w.removeStale(e, c); err != nil {
+			return err
+		}
+	}
+}
+
+// FenceStaleContainers stops or removes, according to the stale container
+// policy, the containers of a returning node which were rescheduled while it
+// was gone and have no counterpart in the cluster anymore, e.g. because the
+// rescheduled container was removed since. The duplicates of a container
+// running elsewhere are left to the deduplication of the returning nodes.
+func (w *Watchdog) FenceStaleContainers(e *Engine) {
+	e.RefreshContainers(false)
+	w.fenceStaleContainers(e)
+}
+
+// fenceStaleContainers fences the stale containers of a refreshed engine.
+func (w *Watchdog) fenceStaleContainers(e *Engine) {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, container := range e.Containers() {
+		if w.identity(container) == "" || !w.isStale(container) {
+			continue
+		}
+		if w.hasCounterpart(container) {
+			w.forgetStale(container)
+			continue
+		}
+		if container.Config.NoAutoDedup() {
+			w.log.Warnf("container %s on node %s is stale, leaving it in place", container.ID, e.Name)
+			continue
+		}
+
+		var err error
+		if w.opts.StaleContainerPolicy == "stop" {
+			if !isRunning(container) {
+				w.forgetStale(container)
+				continue
+			}
+			w.log.Infof("container %s on node %s is stale, stopping it", container.ID, e.Name)
+			err = w.stopStale(e, container)
+		} else {
+			w.log.Infof("container %s on node %s is stale, removing it", container.ID, e.Name)
+			err = w.removeStale(e, container)
+		}
+		if err != nil {
+			w.log.Errorf("Failed to fence stale container %s on node %s: %v", container.ID, e.Name, err)
+			continue
+		}
+		w.forgetStale(container)
+		attributes := map[string]string{
+			"container": container.ID,
+			"swarm_id":  container.Config.SwarmID(),
+			"policy":    w.staleContainerPolicy(),
+		}
+		if mode, _ := container.Config.FenceMode(); mode != "" {
+			attributes["mode"] = mode
+		}
+		w.emitEvent(e, "container_fenced", attributes)
+	}
+}
+
+// stopStale stops a stale container of a returning node, killing it or
+// stopping it gracefully per its fence mode, with its stop timeout by
+// default.
+func (w *Watchdog) stopStale(e *Engine, c *Container) error {
+	mode, timeout := c.Config.FenceMode()
+	if mode == FenceKill {
+		return e.KillContainer(c, "KILL")
+	}
+	return e.StopContainer(c, timeout)
+}
+
+// removeStale removes a stale container of a returning node, which is killed
+// unless its fence mode stops it gracefully first.
+func (w *Watchdog) removeStale(e *Engine, c *Container) error {
+	if mode, timeout := c.Config.FenceMode(); mode == FenceGraceful && isRunning(c) {
+		if err := e.StopContainer(c, timeout); err != nil {
+			return err
+		}
+	}
+	return e.RemoveContainer(c, true, true)
+}
+
+// staleContainerPolicy returns the effective stale container policy.
+func (w *Watchdog) staleContainerPolicy() string {
+	if w.opts.StaleContainerPolicy == "" {
+		return "remove"
+	}
+	return w.opts.StaleContainerPolicy
+}
+
+// hasCounterpart returns true if a container with the same identity exists on
+// another engine.
+func (w *Watchdog) hasCounterpart(c *Container) bool {
+	identity := w.identity(c)
+	for _, containerInCluster := range w.cluster.Containers() {
+		if containerInCluster.Engine != c.Engine && w.identity(containerInCluster) == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// markStale records that a container has been replaced.
+func (w *Watchdog) markStale(c *Container) {
+	w.staleLock.Lock()
+	defer w.staleLock.Unlock()
+	w.stale[c.ID] = true
+}
+
+// isStale returns true if a container has been replaced.
+func (w *Watchdog) isStale(c *Container) bool {
+	w.staleLock.Lock()
+	defer w.staleLock.Unlock()
+	return w.stale[c.ID]
+}
+
+// forgetStale forgets a container once it doesn't need fencing anymore.
+func (w *Watchdog) forgetStale(c *Container) {
+	w.staleLock.Lock()
+	defer w.staleLock.Unlock()
+	delete(w.stale, c.ID)
+}
+
+// inflightReschedule is the rescheduling of the containers of an engine in
+// progress.
+type inflightReschedule struct {
+	engine  *Engine
+	trigger RescheduleTrigger
+	started time.Time
+	cancel  context.CancelFunc
+	// done is closed once the rescheduling stopped.
+	done chan struct{}
+}
+
+// rescheduleContainers reschedules containers as soon as a node fails. An
+// engine is only rescheduled once at a time, whatever the triggers.
+func (w *Watchdog) rescheduleContainers(e *Engine, trigger RescheduleTrigger) {
+	w.rescheduleInTurn(e, trigger, nil)
+}
+
+// rescheduleInTurn reschedules the containers of a failed engine as
+// rescheduleContainers, calling passed, if set, once the first pass is done.
+func (w *Watchdog) rescheduleInTurn(e *Engine, trigger RescheduleTrigger, passed func()) {
+	w.enginesLock.Lock()
+	if _, ok := w.inflight[e.ID]; ok {
+		w.enginesLock.Unlock()
+		w.log.Debugf("Containers of node %s are already being rescheduled, ignoring trigger %s", e.ID, trigger)
+		return
+	}
+	// The engine is marked handled, so that the reconciliation sweep leaves
+	// it alone too.
+	if w.sourceExcluded(e) {
+		w.handled[e.ID] = true
+		w.enginesLock.Unlock()
+		w.log.Infof("Node %s is excluded from rescheduling, leaving its containers in place (trigger: %s)", e.ID, trigger)
+		w.emitEvent(e, "reschedule_source_excluded", map[string]string{"trigger": string(trigger)})
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	inflight := &inflightReschedule{engine: e, trigger: trigger, started: time.Now(), cancel: cancel, done: make(chan struct{})}
+	w.inflight[e.ID] = inflight
+	w.enginesLock.Unlock()
+	defer close(inflight.done)
+
+	// The engine is not marked handled, the reconciliation sweep reschedules
+	// it once rescheduling is enabled again.
+	if w.rescheduleDisabled(e, trigger) {
+		cancel()
+		w.enginesLock.Lock()
+		delete(w.inflight, e.ID)
+		w.enginesLock.Unlock()
+		return
+	}
+
+	if trigger == TriggerEngineDisconnect && w.opts.NodeFailureBreakerThreshold > 0 && !w.waitBreaker(ctx, e) {
+		cancel()
+		w.enginesLock.Lock()
+		delete(w.inflight, e.ID)
+		w.enginesLock.Unlock()
+		return
+	}
+
+	if trigger == TriggerEngineDisconnect && w.opts.ReschedulePreOutageGrace > 0 && !w.waitOutageGrace(ctx, e) {
+		cancel()
+		w.enginesLock.Lock()
+		delete(w.inflight, e.ID)
+		w.enginesLock.Unlock()
+		return
+	}
+
+	err := w.rescheduleEngine(ctx, e, trigger, passed)
+	cancel()
+
+	w.enginesLock.Lock()
+	delete(w.inflight, e.ID)
+	if err != ErrWatchdogInactive {
+		w.handled[e.ID] = true
+	}
+	w.enginesLock.Unlock()
+
+	if err != nil {
+		w.log.Errorf("Failed to reschedule all containers of node %s (trigger: %s): %v", e.ID, trigger, err)
+	}
+}
+
+// sourceExcluded returns true if the containers of an engine are never
+// rescheduled, per its reschedule-source-excluded label or the
+// reschedule-source-exclude option.
+func (w *Watchdog) sourceExcluded(e *Engine) bool {
+	if excluded, _ := strconv.ParseBool(e.Labels[RescheduleSourceExcludedLabel]); excluded {
+		return true
+	}
+	for _, label := range w.opts.RescheduleSourceExclude {
+		kv := strings.SplitN(label, "=", 2)
+		if value, ok := e.Labels[kv[0]]; ok && value == kv[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// rescheduleDisabled returns true, and reports it, if rescheduling is disabled
+// cluster wide.
+func (w *Watchdog) rescheduleDisabled(e *Engine, trigger RescheduleTrigger) bool {
+	if w.opts.Switch.Enabled() {
+		return false
+	}
+	w.log.Warnf("Rescheduling is disabled, leaving the containers of node %s in place (trigger: %s)", e.ID, trigger)
+	w.emitEvent(e, "reschedule_disabled", map[string]string{"trigger": string(trigger)})
+	return true
+}
+
+// waitBreaker records the failure of a disconnected engine, and waits for the
+// node failure breaker to reset if it is tripped. It returns false if the
+// engine reconnected, or the watchdog became inactive or the reschedule was
+// canceled, before the breaker reset.
+func (w *Watchdog) waitBreaker(ctx context.Context, e *Engine) bool {
+	threshold, window := w.opts.NodeFailureBreakerThreshold, w.opts.NodeFailureBreakerWindow
+	if w.breaker.record(threshold, window, time.Now()) {
+		w.log.Warnf("More than %d nodes failed within %s, pausing rescheduling", threshold, window)
+		w.emitEvent(e, "reschedule_breaker_tripped", map[string]string{
+			"threshold": strconv.Itoa(threshold),
+			"window":    window.String(),
+		})
+	}
+
+	abandon := w.abandonCh()
+	if abandon == nil {
+		return false
+	}
+	for {
+		until, reset := w.breaker.check(window, time.Now())
+		if reset {
+			w.log.Infof("No node failed within %s, resuming rescheduling", window)
+			w.emitEvent(e, "reschedule_breaker_reset", map[string]string{"window": window.String()})
+		}
+		if until.IsZero() {
+			break
+		}
+		w.log.Infof("Node failure breaker is tripped, waiting until %s to reschedule the containers of node %s", until.Format(time.RFC3339), e.ID)
+		select {
+		case <-time.After(until.Sub(time.Now())):
+		case <-ctx.Done():
+			return false
+		case <-abandon:
+			return false
+		}
+	}
+
+	if e.IsHealthy() {
+		w.log.Infof("Node %s came back while rescheduling was paused, leaving its containers in place", e.ID)
+		return false
+	}
+	return true
+}
+
+// waitOutageGrace waits for the pre-outage grace of a disconnected engine. It
+// returns false if the engine reconnected, or the watchdog became inactive,
+// before the end of the grace.
+func (w *Watchdog) waitOutageGrace(ctx context.Context, e *Engine) bool {
+	abandon := w.abandonCh()
+	if abandon == nil {
+		return false
+	}
+
+	w.enginesLock.Lock()
+	w.grace[e.ID] = true
+	w.enginesLock.Unlock()
+	defer func() {
+		w.enginesLock.Lock()
+		delete(w.grace, e.ID)
+		w.enginesLock.Unlock()
+	}()
+
+	w.log.Infof("Node %s disconnected, waiting %s before rescheduling its containers", e.ID, w.opts.ReschedulePreOutageGrace)
+	select {
+	case <-time.After(w.opts.ReschedulePreOutageGrace):
+	case <-ctx.Done():
+		w.log.Infof("Node %s came back within the grace period, leaving its containers in place", e.ID)
+		return false
+	case <-abandon:
+		return false
+	}
+
+	// The reconnection may have raced with the end of the grace.
+	if e.IsHealthy() {
+		w.log.Infof("Node %s came back within the grace period, leaving its containers in place", e.ID)
+		return false
+	}
+	return true
+}
+
+// CancelReschedule stops the rescheduling of the containers of an engine, e.g.
+// because it is coming back. The containers already rescheduled are left in
+// place, the container being rescheduled completes, and no other is. It
+// returns false if the engine was not being rescheduled.
+func (w *Watchdog) CancelReschedule(engineID string) bool {
+	w.enginesLock.Lock()
+	defer w.enginesLock.Unlock()
+	inflight, ok := w.inflight[engineID]
+	if ok {
+		w.log.Infof("Canceling rescheduling of containers of node %s", engineID)
+		inflight.cancel()
+	}
+	return ok
+}
+
+// reconcileLoop periodically reconciles the cluster until the watchdog is
+// stopped.
+func (w *Watchdog) reconcileLoop() {
+	ticker := time.NewTicker(w.opts.ReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if w.active() {
+				w.reattachNetworks()
+				w.reconcile()
+			}
+		case <-w.stopped:
+			return
+		}
+	}
+}
+
+// reconcile reschedules the containers of the unhealthy engines which are
+// neither being rescheduled nor already handled. The engines found healthy are
+// forgotten, so that their next failure is handled again.
+func (w *Watchdog) reconcile() {
+	if !w.opts.Switch.Enabled() {
+		w.log.Debug("Rescheduling is disabled, skipping the reconciliation sweep")
+		return
+	}
+
+	engines := make(map[string]*Engine)
+	healthy := make(map[string]bool)
+	for _, c := range w.cluster.Containers() {
+		if c.Engine == nil {
+			continue
+		}
+		if c.Engine.IsHealthy() {
+			healthy[c.Engine.ID] = true
+		} else if w.reschedulable(c, "on-node-failure") {
+			engines[c.Engine.ID] = c.Engine
+		}
+	}
+
+	w.enginesLock.Lock()
+	defer w.enginesLock.Unlock()
+	for id := range w.handled {
+		if healthy[id] {
+			delete(w.handled, id)
+		}
+	}
+	failed := []*Engine{}
+	triggers := make(map[string]RescheduleTrigger)
+	for id, e := range engines {
+		if _, ok := w.inflight[id]; ok || w.handled[id] {
+			continue
+		}
+		w.log.Warnf("Node %s is unhealthy but its containers were not rescheduled, rescheduling them", id)
+		failed = append(failed, e)
+		triggers[id] = TriggerReconcile
+	}
+	w.rescheduleEngines(failed, triggers)
+}
+
+// reattachNetworks retries to connect the moved containers to the networks
+// they couldn't be attached to. The networks they were attached to are left
+// alone, and the containers which are not running are retried once started.
+func (w *Watchdog) reattachNetworks() {
+	w.networksLock.Lock()
+	detached := make(map[string]map[string]*network.EndpointSettings, len(w.detachedNetworks))
+	for id, endpoints := range w.detachedNetworks {
+		detached[id] = endpoints
+	}
+	w.networksLock.Unlock()
+
+	for id, endpoints := range detached {
+		c := w.cluster.Container(id)
+		if c == nil {
+			w.forgetDetachedNetworks(id)
+			continue
+		}
+		if c.Engine == nil || !c.Engine.IsHealthy() || !isRunning(c) {
+			continue
+		}
+		failed, err := ReattachNetworks(c, endpoints, w.moveOpts(c.Config))
+		if err != nil {
+			w.log.Warnf("Failed to reconnect the networks of container %s: %v", id, err)
+			continue
+		}
+
+		w.networksLock.Lock()
+		if len(failed) == 0 {
+			delete(w.detachedNetworks, id)
+		} else {
+			w.detachedNetworks[id] = failed
+		}
+		w.networksLock.Unlock()
+		if len(failed) == 0 {
+			name, _ := containerName(c)
+			w.emitEvent(c.Engine, "container_network_restored", map[string]string{
+				"container": id,
+				"name":      name,
+			})
+		}
+	}
+}
+
+// forgetDetachedNetworks stops retrying the networks of a container.
+func (w *Watchdog) forgetDetachedNetworks(id string) {
+	w.networksLock.Lock()
+	delete(w.detachedNetworks, id)
+	w.networksLock.Unlock()
+}
+
+// RescheduleEngine reschedules the containers of a failed engine, retrying
+// with an exponential backoff until every container has been handled or the
+// retry limit is reached. It returns the errors of the last attempt.
+func (w *Watchdog) RescheduleEngine(e *Engine, trigger RescheduleTrigger) error {
+	return w.rescheduleEngine(context.Background(), e, trigger, nil)
+}
+
+// rescheduleEngine reschedules the containers of a failed engine until done
+// or the context is canceled, calling passed, if set, once the first pass is
+// done.
+func (w *Watchdog) rescheduleEngine(ctx context.Context, e *Engine, trigger RescheduleTrigger, passed func()) error {
+	wave := newRescheduleWave(ctx, e, trigger)
+	wave.passed = passed
+	w.resumeWave(wave)
+	w.checkpointWave(wave)
+	w.trackWave(wave)
+	err := w.runWave(wave)
+	w.untrackWave(wave)
+	// An abandoned wave is left to the next primary.
+	if err != ErrWatchdogInactive {
+		w.forgetWave(wave)
+	}
+	w.summarize(wave, err)
+	return err
+}
+
+// runWave makes the rescheduling passes of a wave until every container has
+// been handled, the retry limit is reached or the wave is abandoned.
+func (w *Watchdog) runWave(wave *rescheduleWave) error {
+	ctx, e, trigger := wave.ctx, wave.engine, wave.trigger
+	for {
+		abandon := w.abandonCh()
+		if abandon == nil {
+			return ErrWatchdogInactive
+		}
+
+		rescheduled := wave.rescheduled
+		w.recordProgress(wave, 0, time.Time{})
+		w.Lock()
+		err := w.rescheduleContainersHelper(wave)
+		wave.lastErrs = err
+		w.Unlock()
+		if wave.passed != nil {
+			wave.passed()
+			wave.passed = nil
+		}
+
+		if !w.active() {
+			return ErrWatchdogInactive
+		}
+		if ctx.Err() != nil {
+			w.log.Infof("Rescheduling of containers of node %s canceled (trigger: %s)", e.ID, trigger)
+			return ErrRescheduleCanceled
+		}
+		if err == nil || !err.Retryable() {
+			return toError(err)
+		}
+
+		// The engine is queued until a reschedule window opens, this
+		// doesn't count as a failed attempt.
+		if outsideWindows(err) {
+			delay := wave.nextWindow.Sub(time.Now())
+			w.recordProgress(wave, len(err), wave.nextWindow)
+			w.log.Infof("Queueing rescheduling of containers of node %s (trigger: %s) until %s", e.ID, trigger, wave.nextWindow.Format(time.RFC3339))
+			select {
+			case <-time.After(delay):
+			case <-abandon:
+				return ErrWatchdogInactive
+			case <-ctx.Done():
+				return ErrRescheduleCanceled
+			}
+			continue
+		}
+
+		// The cluster accepts some of the containers, retry the others
+		// promptly as capacity frees up.
+		if wave.rescheduled > rescheduled {
+			wave.attempt = 0
+		}
+		wave.attempt++
+		if w.opts.RescheduleRetryLimit > 0 && wave.attempt >= w.opts.RescheduleRetryLimit {
+			return err
+		}
+		w.checkpointWave(wave)
+
+		delay := w.rescheduleBackoff(wave.attempt)
+		if until, ok := rateLimitedUntil(wave, err); ok {
+			delay = until.Sub(time.Now())
+		}
+		w.recordProgress(wave, len(err), time.Now().Add(delay))
+		w.log.Infof("Retrying to reschedule containers of node %s (trigger: %s) in %s: %v", e.ID, trigger, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-abandon:
+			return ErrWatchdogInactive
+		case <-ctx.Done():
+			return ErrRescheduleCanceled
+		}
+	}
+}
+
+// summarize logs and emits the outcome of a wave: how many of the containers
+// it examined were rescheduled, failed or were skipped, and why.
+func (w *Watchdog) summarize(wave *rescheduleWave, err error) {
+	outcome := "completed"
+	switch {
+	case err == ErrWatchdogInactive:
+		outcome = "abandoned"
+	case err == ErrRescheduleCanceled:
+		outcome = "canceled"
+	case err != nil:
+		outcome = "failed"
+	}
+
+	reasons := make(map[string]int)
+	for _, reason := range wave.skipped {
+		reasons[reason]++
+	}
+	names := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		names = append(names, reason)
+	}
+	sort.Strings(names)
+	skippedReasons := make([]string, len(names))
+	for i, reason := range names {
+		skippedReasons[i] = fmt.Sprintf("%s=%d", reason, reasons[reason])
+	}
+
+	attributes := map[string]string{
+		"trigger":         string(wave.trigger),
+		"outcome":         outcome,
+		"total":           strconv.Itoa(len(wave.seen)),
+		"rescheduled":     strconv.Itoa(wave.rescheduled),
+		"failed":          strconv.Itoa(len(wave.lastErrs)),
+		"skipped":         strconv.Itoa(len(wave.skipped)),
+		"skipped_reasons": strings.Join(skippedReasons, ","),
+		"duration":        time.Since(wave.started).String(),
+	}
+	w.log.Infof("Rescheduling of node %s %s (trigger: %s): %s of %s containers rescheduled, %s failed, %s skipped [%s] in %s",
+		wave.engine.ID, outcome, wave.trigger, attributes["rescheduled"], attributes["total"], attributes["failed"], attributes["skipped"], attributes["skipped_reasons"], attributes["duration"])
+	w.emitEvent(wave.engine, "reschedule_summary", attributes)
+}
+
+// outsideWindows returns true if all the failures are due to the reschedule
+// windows.
+func outsideWindows(errs RescheduleErrors) bool {
+	for _, err := range errs {
+		if err.Reason != ErrOutsideWindow {
+			return false
+		}
+	}
+	return true
+}
+
+// rateLimitedUntil returns when the first of the containers left by a pass
+// may be retried, if they are all backing off from rate limited pulls. The
+// containers failing for other reasons are retried with the usual backoff.
+func rateLimitedUntil(wave *rescheduleWave, errs RescheduleErrors) (time.Time, bool) {
+	var until time.Time
+	for _, err := range errs {
+		if !err.Retryable() {
+			continue
+		}
+		t, ok := wave.rateLimited[err.Container.ID]
+		if err.Reason != ErrPullRateLimit || !ok {
+			return time.Time{}, false
+		}
+		if until.IsZero() || t.Before(until) {
+			until = t
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// rescheduleBackoff returns how long to wait before the given retry attempt.
+// The computation stays in time.Duration to honor sub-second intervals.
+func (w *Watchdog) rescheduleBackoff(attempt int) time.Duration {
+	delay := w.opts.RescheduleRetryInterval
+	for i := 1; i < attempt && delay < w.opts.RescheduleRetryMaxInterval; i++ {
+		delay *= rescheduleBackoffFactor
+	}
+	if delay > w.opts.RescheduleRetryMaxInterval {
+		delay = w.opts.RescheduleRetryMaxInterval
+	}
+	return delay
+}
+
+// rescheduleWave holds the state of the rescheduling of a failed engine
+// across retry attempts.
+type rescheduleWave struct {
+	// ctx is canceled to stop the rescheduling.
+	ctx     context.Context
+	engine  *Engine
+	trigger RescheduleTrigger
+	// started is when the rescheduling was triggered.
+	started time.Time
+	// attempt counts the passes since some progress was made.
+	attempt int
+	// rescheduled is the number of containers rescheduled so far.
+	rescheduled int
+	// failed holds the containers which can't be rescheduled, they are not
+	// attempted again.
+	failed map[string]*RescheduleError
+	// nextWindow is when the first window opens for the containers left out
+	// of the last pass because of their reschedule windows.
+	nextWindow time.Time
+	// deferred holds the containers left out of the current pass by the
+	// safe mode.
+	deferred map[string]*RescheduleError
+	// rateLimited holds when the containers whose image pulls were rate
+	// limited may be retried, by container ID.
+	rateLimited map[string]time.Time
+	// groups holds the namespace groups of the current pass, by ID of their
+	// members.
+	groups map[string]*rescheduleGroup
+	// replacements holds the new containers of the containers rescheduled
+	// by the wave, by ID of the old ones.
+	replacements map[string]*Container
+	// seen holds the containers examined by the wave, and skipped the reason
+	// why the skipped ones were left in place, by container ID.
+	seen    map[string]bool
+	skipped map[string]string
+	// lastErrs are the failures of the last pass.
+	lastErrs RescheduleErrors
+	// progress is the progress of the wave, as read by RescheduleStatus.
+	progress *waveProgress
+	// passed, if set, is called once the first pass is done.
+	passed func()
+}
+
+// newRescheduleWave creates the wave rescheduling the containers of a failed
+// engine, triggered now.
+func newRescheduleWave(ctx context.Context, e *Engine, trigger RescheduleTrigger) *rescheduleWave {
+	return &rescheduleWave{
+		ctx:          ctx,
+		engine:       e,
+		trigger:      trigger,
+		started:      time.Now(),
+		failed:       make(map[string]*RescheduleError),
+		rateLimited:  make(map[string]time.Time),
+		replacements: make(map[string]*Container),
+		seen:         make(map[string]bool),
+		skipped:      make(map[string]string),
+		progress:     &waveProgress{},
+	}
+}
+
+// skip records that the wave leaves a container in place.
+func (wave *rescheduleWave) skip(c *Container, reason string) {
+	wave.skipped[c.ID] = reason
+}
+
+// releaseGroup releases the reservation of the target of a namespace group,
+// once its members were attempted.
+func (wave *rescheduleWave) releaseGroup(group Containers) {
+	for _, c := range group {
+		if g := wave.groups[c.ID]; g != nil {
+			g.release()
+			return
+		}
+	}
+}
+
+// rescheduleContainersHelper makes a single rescheduling attempt for the
+// containers of a failed engine. It returns nil once there is nothing left to
+// reschedule. Once the pass deadline is exceeded, the remaining containers are
+// left to the next pass. It is called with the watchdog locked.
+func (w *Watchdog) rescheduleContainersHelper(wave *rescheduleWave) RescheduleErrors {
+	e := wave.engine
+	w.log.Debugf("Node %s failed - rescheduling containers (trigger: %s at %s)", e.ID, wave.trigger, wave.started)
+
+	deadline := time.NewTimer(w.opts.ReschedulePassTimeout)
+	defer deadline.Stop()
+	expired := false
+	wave.nextWindow = time.Time{}
+	if !w.checkHealthyEngines(wave) {
+		w.checkCapacity(wave)
+	}
+
+	var errs RescheduleErrors
+	wave.groups = make(map[string]*rescheduleGroup)
+	for _, group := range namespaceGroups(e.Containers()) {
+		unplaced := make(map[string]*RescheduleError)
+		if len(group) > 1 {
+			for _, err := range w.placeGroup(group, wave) {
+				unplaced[err.Container.ID] = err
+			}
+		}
+
+		for _, c := range group {
+			// Another manager may have become the primary, or the
+			// rescheduling may have been canceled.
+			if !w.active() || wave.ctx.Err() != nil {
+				wave.releaseGroup(group)
+				return errs
+			}
+			wave.seen[c.ID] = true
+
+			var err *RescheduleError
+			moved := false
+			if g := wave.groups[c.ID]; g != nil && g.broken != nil {
+				err = &RescheduleError{Container: c, Reason: ErrNamespaceGroup, Err: fmt.Errorf("container %s of its group was not rescheduled", g.broken.ID)}
+			} else {
+				err, moved = w.attemptReschedule(c, wave, unplaced[c.ID], &expired, deadline)
+			}
+			if err != nil {
+				// The config of a container still being rescheduled
+				// is read by its rescheduling.
+				if !w.isMoving(c) {
+					setContainerLabel(c, RescheduleLastErrorLabel, err.cause())
+				}
+				errs = append(errs, err)
+			}
+			if g := wave.groups[c.ID]; g != nil && !moved && g.broken == nil {
+				g.broken = c
+			}
+			w.recordProgress(wave, len(errs), time.Time{})
+		}
+		wave.releaseGroup(group)
+	}
+	return errs
+}
+
+// attemptReschedule makes a single attempt to reschedule a container in a
+// pass, unless it is skipped. It returns whether the container was moved.
+// unplaced is the error of its namespace group, if no node can take it.
+func (w *Watchdog) attemptReschedule(c *Container, wave *rescheduleWave, unplaced *RescheduleError, expired *bool, deadline *time.Timer) (*RescheduleError, bool) {
+	e := wave.engine
+
+	// The rescheduling of the container by a previous pass is still in
+	// progress, it owns the container until it completes.
+	if w.isMoving(c) {
+		return &RescheduleError{Container: c, Reason: ErrPassDeadline, Err: errors.New("rescheduling of a previous pass still in progress")}, false
+	}
+
+	switch reason := w.skipReason(c); reason {
+	case "":
+	case "policy":
+		w.log.Debugf("Skipping rescheduling of %s based on rescheduling policies", c.ID)
+		wave.skip(c, reason)
+		return nil, false
+	case "stopped":
+		w.log.Debugf("Skipping rescheduling of stopped container %s", c.ID)
+		wave.skip(c, reason)
+		return nil, false
+	case "auto_remove":
+		w.log.Infof("Skipping rescheduling of container %s started with --rm", c.ID)
+		wave.skip(c, reason)
+		return nil, false
+	case "already_rescheduled":
+		w.log.Debugf("Container %s was already rescheduled", c.ID)
+		c.Engine.removeContainer(c)
+		wave.skip(c, reason)
+		return nil, true
+	default:
+		w.log.Debugf("Skipping rescheduling of %s container %s", reason, c.ID)
+		wave.skip(c, reason)
+		return nil, false
+	}
+
+	if err, ok := wave.failed[c.ID]; ok {
+		return err, false
+	}
+
+	if err := w.checkLocalMounts(c); err != nil {
+		w.rescheduleFailed(wave, err)
+		wave.failed[c.ID] = err
+		return err, false
+	}
+
+	if err, ok := wave.deferred[c.ID]; ok {
+		return err, false
+	}
+
+	if unplaced != nil {
+		w.rescheduleFailed(wave, unplaced)
+		return unplaced, false
+	}
+
+	if err := w.checkRescheduleWindows(c, wave, time.Now()); err != nil {
+		return err, false
+	}
+
+	if until, ok := wave.rateLimited[c.ID]; ok {
+		if time.Now().Before(until) {
+			return &RescheduleError{Container: c, Reason: ErrPullRateLimit, Err: fmt.Errorf("backing off until %s", until.Format(time.RFC3339))}, false
+		}
+		delete(wave.rateLimited, c.ID)
+	}
+
+	if *expired {
+		return &RescheduleError{Container: c, Reason: ErrPassDeadline, Err: fmt.Errorf("not attempted within %s", w.opts.ReschedulePassTimeout)}, false
+	}
+
+	// The watchdog is unlocked while the pass waits for a reschedule slot
+	// and for the rescheduling of the container, so that the passes of the
+	// other engines reschedule theirs meanwhile, up to the slots.
+	w.Unlock()
+	acquired := w.acquireSlot(wave.ctx, deadline.C)
+	w.Lock()
+	if !acquired {
+		*expired = true
+		return &RescheduleError{Container: c, Reason: ErrPassDeadline, Err: fmt.Errorf("no reschedule slot within %s", w.opts.ReschedulePassTimeout)}, false
+	}
+
+	attempt, _ := strconv.Atoi(c.Config.Labels[RescheduleAttemptLabel])
+	setContainerLabel(c, RescheduleAttemptLabel, strconv.Itoa(attempt+1))
+	// The attempts are only published, the cluster events would be flooded
+	// by the retries.
+	w.publish(e, "container_reschedule_attempt", map[string]string{
+		"container": c.ID,
+		"node":      e.Name,
+		"attempt":   strconv.Itoa(attempt + 1),
+		"trigger":   string(wave.trigger),
+	})
+
+	result := make(chan *RescheduleError, 1)
+	go func() {
+		err := w.safeRescheduleContainer(c, wave)
+		w.releaseSlot()
+		result <- err
+	}()
+
+	var (
+		err     *RescheduleError
+		overdue bool
+	)
+	w.Unlock()
+	select {
+	case err = <-result:
+	case <-deadline.C:
+		overdue = true
+	}
+	w.Lock()
+	if overdue {
+		// The container is off the engine until its rescheduling
+		// completes, it is only retried if it fails.
+		w.log.Warnf("Rescheduling containers of node %s exceeded %s, leaving the remaining containers to the next pass", e.ID, w.opts.ReschedulePassTimeout)
+		*expired = true
+		w.setMoving(c, true)
+		go func() {
+			err := <-result
+			w.setMoving(c, false)
+			if err != nil {
+				w.rescheduleFailed(wave, err)
+			}
+		}()
+		return &RescheduleError{Container: c, Reason: ErrPassDeadline, Err: fmt.Errorf("still in progress after %s", w.opts.ReschedulePassTimeout)}, false
+	}
+
+	if err != nil {
+		w.rescheduleFailed(wave, err)
+		if err.Reason == ErrPullRateLimit {
+			w.backOffRateLimit(c, wave, err)
+		}
+		if !err.Retryable() {
+			wave.failed[c.ID] = err
+		} else if w.opts.RescheduleQuarantineAttempts > 0 && attempt+1 >= w.opts.RescheduleQuarantineAttempts {
+			w.quarantine(c, wave, attempt+1, err)
+			return nil, false
+		}
+		return err, false
+	}
+	wave.rescheduled++
+	return nil, true
+}
+
+// backOffRateLimit backs off from a container whose image pulls were rate limited
+// by its registry, and warns the operators about the limit.
+func (w *Watchdog) backOffRateLimit(c *Container, wave *rescheduleWave, err *RescheduleError) {
+	until := time.Now().Add(w.opts.RescheduleRateLimitBackoff)
+	wave.rateLimited[c.ID] = until
+	w.log.Warnf("Image pulls of container %s are rate limited by the registry, retrying in %s", c.ID, w.opts.RescheduleRateLimitBackoff)
+	attributes := map[string]string{
+		"container": c.ID,
+		"image":     c.Config.Image,
+		"retry_at":  until.Format(time.RFC3339),
+		"trigger":   string(wave.trigger),
+	}
+	if err.Engine != nil {
+		attributes["node"] = err.Engine.Name
+	}
+	w.emitEvent(wave.engine, "image_pull_rate_limited", attributes)
+}
+
+// acquireSlot waits for a free reschedule slot, if their number is capped. It
+// returns false if none freed up before the deadline, the cancellation of the
+// context or the watchdog being abandoned.
+func (w *Watchdog) acquireSlot(ctx context.Context, deadline <-chan time.Time) bool {
+	if w.slots == nil {
+		return true
+	}
+	select {
+	case w.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	abandon := w.abandonCh()
+	if abandon == nil {
+		return false
+	}
+	w.log.Debugf("Waiting for one of the %d reschedule slots", cap(w.slots))
+	select {
+	case w.slots <- struct{}{}:
+		return true
+	case <-deadline:
+	case <-ctx.Done():
+	case <-abandon:
+	}
+	return false
+}
+
+// releaseSlot frees the reschedule slot of a container once rescheduled.
+func (w *Watchdog) releaseSlot() {
+	if w.slots != nil {
+		<-w.slots
+	}
+}
+
+// isMoving returns true if the rescheduling of the container by a previous
+// pass is still in progress.
+func (w *Watchdog) isMoving(c *Container) bool {
+	w.movingLock.Lock()
+	defer w.movingLock.Unlock()
+	return w.moving[c.ID]
+}
+
+// setMoving records whether the rescheduling of the container outlived its
+// pass.
+func (w *Watchdog) setMoving(c *Container, moving bool) {
+	w.movingLock.Lock()
+	defer w.movingLock.Unlock()
+	if moving {
+		w.moving[c.ID] = true
+	} else {
+		delete(w.moving, c.ID)
+	}
+}
+
+// claimMoving marks the containers moved off a healthy node as moving, so
+// that neither the passes nor the other moves pick them until released. It
+// returns the ones not moving already.
+func (w *Watchdog) claimMoving(containers Containers) Containers {
+	w.movingLock.Lock()
+	defer w.movingLock.Unlock()
+	claimed := Containers{}
+	for _, c := range containers {
+		if w.moving[c.ID] {
+			w.log.Debugf("Container %s is being moved already", c.ID)
+			continue
+		}
+		w.moving[c.ID] = true
+		claimed = append(claimed, c)
+	}
+	return claimed
+}
+
+// releaseMoving releases the containers claimed by claimMoving.
+func (w *Watchdog) releaseMoving(containers Containers) {
+	for _, c := range containers {
+		w.setMoving(c, false)
+	}
+}
+
+// quarantine stops retrying a container which failed to be rescheduled too
+// many times, so that it doesn't hold the rescheduling of its engine forever.
+func (w *Watchdog) quarantine(c *Container, wave *rescheduleWave, attempts int, err *RescheduleError) {
+	name, _ := containerName(c)
+	w.opts.Quarantine.Add(&QuarantinedContainer{
+		ID:       c.ID,
+		Name:     name,
+		Engine:   wave.engine.ID,
+		Attempts: attempts,
+		Reason:   err.cause(),
+		Since:    time.Now(),
+	})
+	wave.skip(c, "quarantined")
+	w.log.Errorf("Quarantining container %s after %d failed reschedule attempts: %s", c.ID, attempts, err.cause())
+	w.emitEvent(wave.engine, "container_quarantined", map[string]string{
+		"container": c.ID,
+		"name":      name,
+		"attempts":  strconv.Itoa(attempts),
+		"error":     err.cause(),
+		"trigger":   string(wave.trigger),
+	})
+}
+
+// releaseQuarantined retries a container released from quarantine, with a
+// fresh attempt count. The rescheduling of its engine is resumed if over.
+func (w *Watchdog) releaseQuarantined(qc *QuarantinedContainer) {
+	c := w.cluster.Container(qc.ID)
+	if c == nil || c.Engine == nil {
+		w.log.Infof("Container %s released from quarantine is gone", qc.ID)
+		return
+	}
+	e := c.Engine
+	w.log.Infof("Container %s released from quarantine", qc.ID)
+	w.emitEvent(e, "container_quarantine_released", map[string]string{
+		"container": qc.ID,
+		"name":      qc.Name,
+	})
+
+	w.background(func() {
+		w.Lock()
+		setContainerLabel(c, RescheduleAttemptLabel, "0")
+		w.Unlock()
+		if !w.active() || e.IsHealthy() {
+			return
+		}
+		w.enginesLock.Lock()
+		delete(w.handled, e.ID)
+		w.enginesLock.Unlock()
+		w.rescheduleContainers(e, TriggerQuarantineRelease)
+	})
+}
+
+// skipReason returns why the containers of failed engines are left in place
+// without any attempt, or an empty string if they are to be rescheduled:
+//   - policy, if they don't have an "on-node-failure" reschedule policy,
+//   - stopped, if they were not running and those are not rescheduled,
+//   - auto_remove, if they were started with --rm and those are not
+//     rescheduled,
+//   - already_rescheduled, if a previous primary rescheduled them already,
+//   - quarantined, until they are released from quarantine.
+func (w *Watchdog) skipReason(c *Container) string {
+	switch {
+	case !w.reschedulable(c, "on-node-failure"):
+		return "policy"
+	case !w.opts.RescheduleStoppedContainers && !isRunning(c):
+		return "stopped"
+	case w.skipAutoRemove(c):
+		return "auto_remove"
+	case w.rescheduledElsewhere(c):
+		return "already_rescheduled"
+	case w.opts.Quarantine.Quarantined(c.ID):
+		return "quarantined"
+	}
+	return ""
+}
+
+// placeGroup selects the engine a group of containers sharing namespaces is
+// rescheduled onto, all of them together. It returns the errors of the
+// members if no engine can take the whole group.
+func (w *Watchdog) placeGroup(group Containers, wave *rescheduleWave) RescheduleErrors {
+	members := Containers{}
+	for _, c := range group {
+		if w.toReschedule(c, wave) {
+			members = append(members, c)
+		}
+	}
+	if len(members) < 2 {
+		return nil
+	}
+
+	config, err := w.rescheduleConfig(groupConfig(members))
+	if err == nil {
+		err = w.dropSelfAffinities(members[0], config)
+	}
+	if err == nil {
+		var (
+			target  *Engine
+			release func()
+		)
+		// The target is reserved for the whole group, as its members are
+		// created one after the other.
+		if target, release, err = w.cluster.ReserveEngine(config); err == nil {
+			g := &rescheduleGroup{members: members, target: target, reservation: config.Reservation(), release: release, replacements: make(map[string]*Container)}
+			for _, c := range members {
+				wave.groups[c.ID] = g
+			}
+			return nil
+		}
+	}
+
+	w.log.Warnf("No node can take the %d containers sharing namespaces with %s: %v", len(members), members[0].ID, err)
+	errs := RescheduleErrors{}
+	for _, c := range members {
+		errs = append(errs, &RescheduleError{Container: c, Reason: ErrNamespaceGroup, Err: err})
+	}
+	return errs
+}
+
+// checkLocalMounts returns an error if the container has mounts local to its
+// node and their rescheduling is not enabled.
+func (w *Watchdog) checkLocalMounts(c *Container) *RescheduleError {
+	if w.opts.RescheduleLocalMounts {
+		return nil
+	}
+	if local := localMounts(c); len(local) > 0 {
+		return &RescheduleError{Container: c, Reason: ErrLocalMount, Err: fmt.Errorf("mounts %s", strings.Join(local, ", "))}
+	}
+	return nil
+}
+
+// checkCapacity defers, in safe mode, the containers of the failed engine the
+// healthy engines can't take, lowest priority first. The other containers
+// would be left without capacity by a cascade of out of memory kills.
+func (w *Watchdog) checkCapacity(wave *rescheduleWave) {
+	wave.deferred = nil
+	if !w.opts.RescheduleSafeMode {
+		return
+	}
+
+	var (
+		candidates           Containers
+		neededMem, neededCPU int64
+	)
+	for _, c := range wave.engine.Containers() {
+		if !w.toReschedule(c, wave) {
+			continue
+		}
+		candidates = append(candidates, c)
+		neededMem += c.Config.HostConfig.Memory
+		neededCPU += c.Config.HostConfig.CPUShares
+	}
+
+	freeMem, freeCPU := w.cluster.FreeCapacity()
+	if w.opts.RescheduleCPUOvercommit > 1 {
+		// The CPUs the containers may overcommit count as free.
+		freeCPU += int64(float64(w.cluster.Capacity().TotalCpus) * (w.opts.RescheduleCPUOvercommit - 1))
+	}
+	availableMem := int64(float64(freeMem) * (1 - w.opts.RescheduleCapacityMargin))
+	availableCPU := int64(float64(freeCPU) * (1 - w.opts.RescheduleCapacityMargin))
+	if neededMem <= availableMem && neededCPU <= availableCPU {
+		return
+	}
+
+	shortfall := fmt.Errorf("%d bytes of memory and %d CPUs are missing to reschedule all the containers", neededMem-availableMem, neededCPU-availableCPU)
+
+	// Stop at the first container which doesn't fit, so that no container
+	// goes before a container of higher priority.
+	sort.Stable(sort.Reverse(containersByPriority(candidates)))
+	wave.deferred = make(map[string]*RescheduleError)
+	for _, c := range candidates {
+		mem, cpu := c.Config.HostConfig.Memory, c.Config.HostConfig.CPUShares
+		if len(wave.deferred) == 0 && mem <= availableMem && cpu <= availableCPU {
+			availableMem -= mem
+			availableCPU -= cpu
+			continue
+		}
+		wave.deferred[c.ID] = &RescheduleError{
+			Container: c,
+			Reason:    ErrInsufficientCapacity,
+			Err:       shortfall,
+		}
+	}
+
+	w.log.Warnf("Node %s failed but the cluster lacks the capacity to take its containers (needed: %d bytes of memory and %d CPUs, free: %d bytes of memory and %d CPUs), deferring %d of %d containers", wave.engine.ID, neededMem, neededCPU, freeMem, freeCPU, len(wave.deferred), len(candidates))
+	w.emitEvent(wave.engine, "reschedule_capacity_shortfall", map[string]string{
+		"needed_memory": strconv.FormatInt(neededMem, 10),
+		"needed_cpus":   strconv.FormatInt(neededCPU, 10),
+		"free_memory":   strconv.FormatInt(freeMem, 10),
+		"free_cpus":     strconv.FormatInt(freeCPU, 10),
+		"deferred":      strconv.Itoa(len(wave.deferred)),
+		"trigger":       string(wave.trigger),
+	})
+}
+
+// checkHealthyEngines defers all the containers of the failed engine, and
+// returns true, if fewer engines than the minimum are healthy, preferring to
+// leave them down over overwhelming the remaining engines.
+func (w *Watchdog) checkHealthyEngines(wave *rescheduleWave) bool {
+	if w.opts.MinHealthyEngines <= 0 {
+		return false
+	}
+	healthy := 0
+	for _, n := range w.cluster.Capacity().Nodes {
+		if n.Healthy && n.ID != wave.engine.ID {
+			healthy++
+		}
+	}
+	if healthy >= w.opts.MinHealthyEngines {
+		return false
+	}
+
+	err := fmt.Errorf("%d engines are healthy, at least %d are required", healthy, w.opts.MinHealthyEngines)
+	wave.deferred = make(map[string]*RescheduleError)
+	for _, c := range wave.engine.Containers() {
+		if w.toReschedule(c, wave) {
+			wave.deferred[c.ID] = &RescheduleError{Container: c, Reason: ErrTooFewHealthyEngines, Err: err}
+		}
+	}
+
+	w.log.Warnf("Node %s failed but only %d engines are healthy, at least %d are required, leaving its %d containers down", wave.engine.ID, healthy, w.opts.MinHealthyEngines, len(wave.deferred))
+	w.emitEvent(wave.engine, "reschedule_min_healthy_engines", map[string]string{
+		"healthy":  strconv.Itoa(healthy),
+		"minimum":  strconv.Itoa(w.opts.MinHealthyEngines),
+		"deferred": strconv.Itoa(len(wave.deferred)),
+		"trigger":  string(wave.trigger),
+	})
+	return true
+}
+
+// toReschedule returns true if the container of the failed engine is to be
+// rescheduled by the wave, whether or not its windows allow it now.
+func (w *Watchdog) toReschedule(c *Container, wave *rescheduleWave) bool {
+	_, failed := wave.failed[c.ID]
+	return !failed && w.skipReason(c) == "" && w.checkLocalMounts(c) == nil
+}
+
+// skipAutoRemove returns true if the container was started with --rm and
+// such containers are not rescheduled.
+func (w *Watchdog) skipAutoRemove(c *Container) bool {
+	return c.Config.HostConfig.AutoRemove && !w.opts.RescheduleAutoRemove
+}
+
+// checkRescheduleWindows returns an error if the container may not be
+// rescheduled at the given time because of its reschedule windows, and
+// records when its next window opens.
+func (w *Watchdog) checkRescheduleWindows(c *Container, wave *rescheduleWave, now time.Time) *RescheduleError {
+	windows := w.rescheduleWindows(c)
+	if windows.Contains(now) {
+		return nil
+	}
+
+	if w.opts.RescheduleWindowEscalate {
+		w.log.Warnf("Escalating rescheduling of container %s outside of its reschedule windows", c.ID)
+		w.emitEvent(wave.engine, "container_reschedule_escalated", map[string]string{
+			"container":    c.ID,
+			"trigger":      string(wave.trigger),
+			"trigger_time": wave.started.Format(time.RFC3339Nano),
+		})
+		return nil
+	}
+
+	next := windows.NextOpening(now)
+	if wave.nextWindow.IsZero() || next.Before(wave.nextWindow) {
+		wave.nextWindow = next
+	}
+	return &RescheduleError{Container: c, Reason: ErrOutsideWindow, Err: fmt.Errorf("next window opens at %s", next.Format(time.RFC3339))}
+}
+
+// rescheduleWindows returns the reschedule windows of a container, its own or
+// the default ones.
+func (w *Watchdog) rescheduleWindows(c *Container) TimeWindows {
+	windows, ok, err := c.Config.RescheduleWindows()
+	if err != nil {
+		w.log.Warnf("Ignoring invalid reschedule windows of container %s: %v", c.ID, err)
+	}
+	if !ok || err != nil {
+		return w.opts.RescheduleActiveWindows
+	}
+	return windows
+}
+
+// rescheduleFailed reports the failure to reschedule a container.
+func (w *Watchdog) rescheduleFailed(wave *rescheduleWave, err *RescheduleError) {
+	w.log.Error(err)
+	w.emitEvent(wave.engine, "container_reschedule_failed", map[string]string{
+		"container":    err.Container.ID,
+		"error":        err.Error(),
+		"trigger":      string(wave.trigger),
+		"trigger_time": wave.started.Format(time.RFC3339Nano),
+	})
+}
+
+// safeRescheduleContainer reschedules a container, recovering from any panic
+// so that a single malformed container doesn't prevent the rescheduling of
+// the other containers of the engine. A container whose rescheduling panicked
+// is kept on the engine to be retried.
+func (w *Watchdog) safeRescheduleContainer(c *Container, wave *rescheduleWave) (err *RescheduleError) {
+	defer func() {
+		if r := recover(); r != nil {
+			w.log.Errorf("Recovered from panic while rescheduling container %s: %v\n%s", c.ID, r, debug.Stack())
+			if c.Engine != nil {
+				c.Engine.AddContainer(c)
+			}
+			err = &RescheduleError{Container: c, Err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+	return w.rescheduleContainer(c, wave)
+}
+
+// reschedulable returns true if the container has the given reschedule
+// policy, either explicitly or through the default policy. The failures of a
+// node honor "on-node-failure" and its drains "on-node-drain".
+func (w *Watchdog) reschedulable(c *Container, policy string) bool {
+	if c.Config == nil {
+		return false
+	}
+	if len(c.Config.extractExprs("reschedule-policies")) == 0 {
+		return w.opts.DefaultReschedulePolicy == policy
+	}
+	return c.Config.HasReschedulePolicy(policy)
+}
+
+// rescheduledElsewhere returns true if a container with the same identity
+// runs on another healthy engine.
+func (w *Watchdog) rescheduledElsewhere(c *Container) bool {
+	identity := w.identity(c)
+	if identity == "" {
+		return false
+	}
+	for _, containerInCluster := range w.cluster.Containers() {
+		if containerInCluster.Engine != c.Engine && containerInCluster.Engine.IsHealthy() &&
+			w.identity(containerInCluster) == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// identity returns what identifies a container across the engines: the value
+// of its identity label if any, prefixed by the label, its swarm ID
+// otherwise. It is empty for the containers with neither.
+func (w *Watchdog) identity(c *Container) string {
+	if c.Config == nil {
+		return ""
+	}
+	return w.configIdentity(c.Config)
+}
+
+// configIdentity returns the identity of the containers created with a
+// config.
+func (w *Watchdog) configIdentity(config *ContainerConfig) string {
+	if w.opts.IdentityLabel != "" {
+		if value := config.Labels[w.opts.IdentityLabel]; value != "" {
+			return w.opts.IdentityLabel + "=" + value
+		}
+	}
+	return config.SwarmID()
+}
+
+// rescheduleContainer recreates a container of a failed engine on another
+// engine.
+func (w *Watchdog) rescheduleContainer(c *Container, wave *rescheduleWave) *RescheduleError {
+	config, err := w.rescheduleConfig(c.Config)
+	if err != nil {
+		return &RescheduleError{Container: c, Err: err}
+	}
+	if err := w.dropSelfAffinities(c, config); err != nil {
+		return &RescheduleError{Container: c, Err: err}
+	}
+	if err := w.followAffinities(c, config, wave); err != nil {
+		return &RescheduleError{Container: c, Err: err}
+	}
+	if err := w.stampLabels(c, config, wave.trigger, time.Now()); err != nil {
+		return &RescheduleError{Container: c, Err: err}
+	}
+	group := wave.groups[c.ID]
+	if group != nil {
+		if err := group.pin(config); err != nil {
+			return &RescheduleError{Container: c, Err: err}
+		}
+	}
+	w.relaxCpuset(c, config)
+	config, release, rerr := w.mutateConfig(c, config)
+	if rerr != nil {
+		return rerr
+	}
+	defer release()
+
+	opts := w.moveOpts(config)
+	opts.Unreachable = true
+	result, err := w.cluster.MoveContainer(c, nil, opts)
+	if result == nil {
+		return &RescheduleError{Container: c, Reason: moveFailure(err), Err: moveCause(err)}
+	}
+	newContainer := result.Container
+
+	w.log.Infof("Rescheduled container %s from %s to %s as %s (trigger: %s)", c.ID, c.Engine.Name, newContainer.Engine.Name, newContainer.ID, wave.trigger)
+	if group != nil {
+		group.replacements[c.ID] = newContainer
+	}
+	wave.replacements[c.ID] = newContainer
+	w.markStale(c)
+	w.reportMove(c, result, rescheduleTimeline{detected: wave.started}, wave.trigger)
+
+	if err != nil {
+		return &RescheduleError{Container: c, Engine: newContainer.Engine, Reason: moveFailure(err), Err: moveCause(err)}
+	}
+	return nil
+}
+
+// relievePressure evicts low priority containers from a node reporting
+// memory or disk pressure, before the node fails completely.
+func (w *Watchdog) relievePressure(e *Engine, trigger RescheduleTrigger, threshold float64, usage string) {
+	// Pressure eviction is disabled for this resource.
+	if threshold == 0 {
+		return
+	}
+
+	value, err := strconv.ParseFloat(usage, 64)
+	if err != nil {
+		w.log.Warnf("Ignoring %s event from node %s with invalid usage %q", trigger, e.ID, usage)
+		return
+	}
+	if value < threshold {
+		w.log.Debugf("Node %s reported %s %.2f below threshold %.2f", e.ID, trigger, value, threshold)
+		return
+	}
+
+	if w.rescheduleDisabled(e, trigger) {
+		return
+	}
+
+	// The evictions are made unlocked, the waves of the failed engines
+	// aren't held by them.
+	w.Lock()
+	containers := w.evictableContainers(e)
+	w.Unlock()
+
+	w.log.Infof("Node %s reported %s %.2f - evicting containers", e.ID, trigger, value)
+	w.drainContainers(containers, trigger, "")
+}
+
+// Drain moves the containers having the "on-node-drain" reschedule policy off
+// a node, e.g. for maintenance. hint is an optional node label constraint,
+// e.g. "rack==r2", the moved containers prefer. The containers for which no
+// node satisfies it are scheduled normally.
+func (w *Watchdog) Drain(e *Engine, hint string) error {
+	if !w.active() {
+		return ErrWatchdogInactive
+	}
+	if hint != "" {
+		if _, err := softConstraint(hint); err != nil {
+			return err
+		}
+	}
+
+	// The moves are made unlocked, the waves of the failed engines aren't
+	// held by the drain.
+	w.Lock()
+	containers, _ := w.drainableContainers(e)
+	w.Unlock()
+
+	w.log.Infof("Draining %d containers from node %s", len(containers), e.ID)
+	return toError(w.drainContainers(containers, TriggerDrain, hint))
+}
+
+// drainableContainers splits the containers of a drained node into the ones
+// to move and the ones left in place.
+func (w *Watchdog) drainableContainers(e *Engine) (containers Containers, left Containers) {
+	containers, left = Containers{}, Containers{}
+	for _, c := range e.Containers() {
+		if !w.reschedulable(c, "on-node-drain") {
+			w.log.Debugf("Leaving container %s on drained node %s based on rescheduling policies", c.ID, e.ID)
+			left = append(left, c)
+			continue
+		}
+		if w.skipAutoRemove(c) {
+			w.log.Infof("Leaving container %s started with --rm on drained node %s", c.ID, e.ID)
+			left = append(left, c)
+			continue
+		}
+		containers = append(containers, c)
+	}
+	return containers, left
+}
 
-	switch e.Status {
-	case "engine_connect", "engine_reconnect":
-		go w.removeDuplicateContainers(e.Engine)
-	case "engine_disconnect":
-		go w.rescheduleContainers(e.Engine)
+// softConstraint returns the soft version of a constraint, e.g. rack==~r2 for
+// rack==r2.
+func softConstraint(constraint string) (string, error) {
+	matches := drainHintRegexp.FindStringSubmatch(constraint)
+	if matches == nil {
+		return "", fmt.Errorf("invalid node constraint %q, expected key==value", constraint)
 	}
-	return nil
+	return matches[1] + matches[2] + "~" + matches[3], nil
 }
 
-// removeDuplicateContainers removes duplicate containers when a node comes back
-func (w *Watchdog) removeDuplicateContainers(e *Engine) {
-	log.Debugf("removing duplicate containers from Node %s", e.ID)
-
-	e.RefreshContainers(false)
+// checkRestartLoop moves a container off its node if it restarted more than
+// RestartLoopThreshold times within RestartLoopWindow. The node is healthy
+// but the repeated failures hint at a node-local issue.
+func (w *Watchdog) checkRestartLoop(e *Engine, containerID string, now time.Time) {
+	if e == nil {
+		return
+	}
+	c := e.Containers().Get(containerID)
+	if c == nil || c.Info.ContainerJSONBase == nil {
+		return
+	}
 
 	w.Lock()
 	defer w.Unlock()
 
-	for _, container := range e.Containers() {
-		// skip non-swarm containers
-		if container.Config.SwarmID() == "" {
+	// Forget the containers which stopped restarting.
+	for id, record := range w.restarts {
+		if now.Sub(record.since) > w.opts.RestartLoopWindow {
+			delete(w.restarts, id)
+		}
+	}
+
+	record, ok := w.restarts[c.ID]
+	if !ok || c.Info.RestartCount < record.count {
+		w.restarts[c.ID] = &restartRecord{since: now, count: c.Info.RestartCount}
+		return
+	}
+	if c.Info.RestartCount-record.count < w.opts.RestartLoopThreshold {
+		return
+	}
+
+	if !w.reschedulable(c, "on-node-failure") {
+		w.log.Debugf("Container %s is restarting in a loop on node %s but has no reschedule policy", c.ID, e.Name)
+		return
+	}
+
+	identity := w.identity(c)
+	if moved, ok := w.restartLoopMoves[identity]; ok && now.Sub(moved) < w.opts.RestartLoopCooldown {
+		w.log.Warnf("Container %s is restarting in a loop on node %s but was moved %s ago, leaving it in place", c.ID, e.Name, now.Sub(moved))
+		return
+	}
+	for id, moved := range w.restartLoopMoves {
+		if now.Sub(moved) >= w.opts.RestartLoopCooldown {
+			delete(w.restartLoopMoves, id)
+		}
+	}
+
+	if w.rescheduleDisabled(e, TriggerRestartLoop) {
+		return
+	}
+
+	w.log.Infof("Container %s restarted %d times in %s on node %s - moving it", c.ID, c.Info.RestartCount-record.count, now.Sub(record.since), e.Name)
+	delete(w.restarts, c.ID)
+	if err := w.moveContainer(c, TriggerRestartLoop, "", 0); err != nil {
+		w.log.Errorf("Failed to move container %s off node %s: %v", c.ID, e.Name, err)
+		return
+	}
+	if identity != "" {
+		w.restartLoopMoves[identity] = now
+	}
+}
+
+// evictableContainers returns the running containers of a node that may be
+// evicted to relieve pressure, lowest reschedule priority first.
+func (w *Watchdog) evictableContainers(e *Engine) Containers {
+	evictable := Containers{}
+	for _, c := range e.Containers() {
+		if !w.reschedulable(c, "on-node-failure") || c.Config.ReschedulePriority() > w.opts.PressureEvictionMaxPriority {
+			continue
+		}
+		if !isRunning(c) || w.skipAutoRemove(c) {
 			continue
 		}
+		evictable = append(evictable, c)
+	}
 
-		for _, containerInCluster := range w.cluster.Containers() {
-			if containerInCluster.Config.SwarmID() == container.Config.SwarmID() && containerInCluster.Engine.ID != container.Engine.ID {
-				log.Debugf("container %s was rescheduled on node %s, removing it", container.ID, containerInCluster.Engine.Name)
-				// container already exists in the cluster, destroy it
-				if err := e.RemoveContainer(container, true, true); err != nil {
-					log.Errorf("Failed to remove duplicate container %s on node %s: %v", container.ID, containerInCluster.Engine.Name, err)
-				}
-			}
+	sort.Sort(containersByPriority(evictable))
+	if w.opts.PressureEvictionLimit > 0 && len(evictable) > w.opts.PressureEvictionLimit {
+		evictable = evictable[:w.opts.PressureEvictionLimit]
+	}
+	return evictable
+}
+
+// drainContainers moves containers away from the healthy node they are
+// running on, preferring the nodes satisfying the hint constraint if any. The
+// containers being moved already are left alone. A container no node can take
+// is left in place.
+func (w *Watchdog) drainContainers(containers Containers, trigger RescheduleTrigger, hint string) RescheduleErrors {
+	var errs RescheduleErrors
+	containers = w.claimMoving(containers)
+	defer w.releaseMoving(containers)
+	for _, c := range containers {
+		if !w.active() || !w.acquireSlot(context.Background(), nil) {
+			break
+		}
+		err := w.moveContainer(c, trigger, hint, 0)
+		w.releaseSlot()
+		if err != nil {
+			errs = append(errs, w.moveFailed(c, trigger, err))
 		}
 	}
+	return errs
 }
 
-// rescheduleContainers reschedules containers as soon as a node fails
-func (w *Watchdog) rescheduleContainers(e *Engine) {
-	w.Lock()
-	defer w.Unlock()
+// moveFailed reports the failed move of a container off a healthy node.
+func (w *Watchdog) moveFailed(c *Container, trigger RescheduleTrigger, err error) *RescheduleError {
+	w.log.Errorf("Failed to move container %s off node %s (trigger: %s): %v", c.ID, c.Engine.Name, trigger, err)
+	w.emitEvent(c.Engine, "container_reschedule_failed", map[string]string{
+		"container": c.ID,
+		"error":     err.Error(),
+		"trigger":   string(trigger),
+	})
+	return &RescheduleError{Container: c, Err: err}
+}
+
+// moveContainer recreates a container of a healthy node on another node and
+// removes the original one. The new container prefers the nodes satisfying
+// the hint constraint if any. The old container is given stopTimeout to stop,
+// or its default stop timeout if 0.
+func (w *Watchdog) moveContainer(c *Container, trigger RescheduleTrigger, hint string, stopTimeout time.Duration) error {
+	w.publish(c.Engine, "container_reschedule_attempt", map[string]string{
+		"container": c.ID,
+		"node":      c.Engine.Name,
+		"trigger":   string(trigger),
+	})
+	config, err := w.rescheduleConfig(c.Config)
+	if err != nil {
+		return err
+	}
+	if err := w.dropSelfAffinities(c, config); err != nil {
+		return err
+	}
+	if err := w.stampLabels(c, config, trigger, time.Now()); err != nil {
+		return err
+	}
+	// Keep the scheduler from placing the container back on the same node.
+	if err := config.AddConstraint("node!=" + c.Engine.ID); err != nil {
+		return err
+	}
+	if hint != "" {
+		if err := w.addHint(c, config, hint); err != nil {
+			return err
+		}
+	}
+	w.relaxCpuset(c, config)
+	config, release, rerr := w.mutateConfig(c, config)
+	if rerr != nil {
+		return rerr
+	}
+	defer release()
+	target, unreserve, err := w.reserveTarget(config)
+	if err != nil {
+		return &MoveError{Step: MoveStepPrepare, Err: err}
+	}
+	defer unreserve()
 
-	log.Debugf("Node %s failed - rescheduling containers", e.ID)
+	opts := w.moveOpts(config)
+	opts.MakeBeforeBreak, _ = strconv.ParseBool(c.Config.Labels[RescheduleMakeBeforeBreakLabel])
+	if stopTimeout > 0 {
+		opts.StopTimeout = stopTimeout
+	}
+	timeline := rescheduleTimeline{detected: time.Now()}
+	result, err := w.cluster.MoveContainer(c, target, opts)
+	if result == nil {
+		return err
+	}
 
-	for _, c := range e.Containers() {
+	w.log.Infof("Moved container %s from %s to %s as %s (trigger: %s)", c.ID, c.Engine.Name, result.Container.Engine.Name, result.Container.ID, trigger)
+	w.reportMove(c, result, timeline, trigger)
+	return err
+}
 
-		// Skip containers which don't have an "on-node-failure" reschedule policy.
-		if !c.Config.HasReschedulePolicy("on-node-failure") {
-			log.Debugf("Skipping rescheduling of %s based on rescheduling policies", c.ID)
-			continue
+// reserveTarget selects and reserves the target of a container moved off a
+// reachable engine before the old container is removed, so that a container
+// no engine can take is left in place. The target the mutator pinned the
+// config to, or the target of its namespace group, is reserved already.
+func (w *Watchdog) reserveTarget(config *ContainerConfig) (*Engine, func(), error) {
+	if ID := config.Labels[rescheduleTargetLabel]; ID != "" {
+		if target := w.cluster.Engine(ID); target != nil {
+			return target, func() {}, nil
+		}
+	}
+	if config.Reservation() != "" {
+		target, err := w.cluster.SelectEngine(config)
+		return target, func() {}, err
+	}
+	return w.cluster.ReserveEngine(config)
+}
+
+// The reasons why the new container of a moved container is started or not,
+// as reported by the container_rescheduled events.
+const (
+	restartWasRunning    = "was-running"
+	restartWasStopped    = "was-stopped"
+	restartPolicyNo      = "restart-policy-no"
+	restartUnlessStopped = "unless-stopped"
+)
+
+// moveOpts returns the options of the moves of the watchdog, creating the
+// new container from config. The new container is started if the old one was
+// running, unless RescheduleHonorRestartNo keeps it down.
+func (w *Watchdog) moveOpts(config *ContainerConfig) MoveOpts {
+	return MoveOpts{
+		Config:                     config,
+		Start:                      !w.keptDown(config),
+		StopTimeout:                w.stopTimeout(config),
+		HealthTimeout:              w.opts.RescheduleHealthTimeout,
+		HealthInterval:             w.opts.RescheduleHealthInterval,
+		NetworkAttachAttempts:      w.opts.NetworkAttachAttempts,
+		NetworkAttachRetryInterval: w.opts.NetworkAttachRetryInterval,
+		Cancel:                     w.abandonCh(),
+		Log:                        w.log,
+	}
+}
+
+// keptDown returns true if RescheduleHonorRestartNo keeps the containers
+// created from config down.
+func (w *Watchdog) keptDown(config *ContainerConfig) bool {
+	return w.opts.RescheduleHonorRestartNo && config.HostConfig.RestartPolicy.Name == "no"
+}
+
+// shouldRestart returns whether the new container of a container moved with
+// config is started, and why. A stopped container with the unless-stopped
+// restart policy was stopped on purpose and is reported as such.
+func (w *Watchdog) shouldRestart(c *Container, config *ContainerConfig) (bool, string) {
+	switch {
+	case !isRunning(c) && config.HostConfig.RestartPolicy.Name == "unless-stopped":
+		return false, restartUnlessStopped
+	case !isRunning(c):
+		return false, restartWasStopped
+	case w.keptDown(config):
+		return false, restartPolicyNo
+	}
+	return true, restartWasRunning
+}
+
+// stopTimeout returns the grace period of a container to stop: its own stop
+// timeout if set, the default of the watchdog otherwise.
+func (w *Watchdog) stopTimeout(config *ContainerConfig) time.Duration {
+	if config.StopTimeout != nil && *config.StopTimeout >= 0 {
+		return time.Duration(*config.StopTimeout) * time.Second
+	}
+	return w.opts.RescheduleStopTimeout
+}
+
+// reportMove records the downtime of a moved container, and emits its
+// events: the networks and health of the new container, if degraded, then
+// its rescheduling.
+func (w *Watchdog) reportMove(c *Container, result *MoveResult, timeline rescheduleTimeline, trigger RescheduleTrigger) {
+	newContainer := result.Container
+	if len(result.FailedNetworks) > 0 {
+		name, _ := containerName(c)
+		w.emitEvent(newContainer.Engine, "container_network_degraded", map[string]string{
+			"container": newContainer.ID,
+			"name":      name,
+			"networks":  strings.Join(result.FailedNetworks, ","),
+		})
+		w.networksLock.Lock()
+		w.detachedNetworks[newContainer.ID] = result.FailedEndpoints
+		w.networksLock.Unlock()
+	}
+	if result.Unhealthy {
+		w.emitEvent(newContainer.Engine, "container_health_degraded", map[string]string{
+			"container": newContainer.ID,
+			"timeout":   w.opts.RescheduleHealthTimeout.String(),
+		})
+	}
+
+	if group := c.Config.Labels[RescheduleGroupLabel]; group != "" && w.opts.RescheduleStickyWindow > 0 {
+		w.sticky.record(group, newContainer.Engine, time.Now())
+	}
+	if w.opts.RescheduleCooldownCount > 0 {
+		w.cooldowns.record(newContainer.Engine.ID, time.Now())
+	}
+
+	timeline.created, timeline.started = result.Created, result.Started
+	w.recordDowntime(timeline)
+	w.emitRescheduledEvent(c, newContainer, trigger, timeline)
+}
+
+// moveFailure returns the reschedule failure reason of the error of a move.
+func moveFailure(err error) error {
+	merr, ok := err.(*MoveError)
+	if !ok {
+		return nil
+	}
+	switch merr.Step {
+	case MoveStepCleanup:
+		return ErrNetworkCleanup
+	case MoveStepCreate:
+		return classifyCreateError(merr.Err)
+	case MoveStepNetwork:
+		return ErrNetworkAttach
+	}
+	return nil
+}
+
+// moveCause returns the underlying error of the error of a move.
+func moveCause(err error) error {
+	if merr, ok := err.(*MoveError); ok {
+		return merr.Err
+	}
+	return err
+}
+
+// rescheduleConfig returns a copy of the config of a container to recreate it
+// on another node, constrained by the node suitability policy on the labels
+// the container has no constraint on.
+func (w *Watchdog) rescheduleConfig(config *ContainerConfig) (*ContainerConfig, error) {
+	copied := copyContainerConfig(config)
+
+	// The containers joining the network namespace of another one inherit
+	// its hostname, which the engines report but refuse at creation.
+	if copied.HostConfig.NetworkMode.IsContainer() {
+		copied.Hostname = ""
+	}
+
+	// The new container starts afresh.
+	delete(copied.Labels, RescheduleAttemptLabel)
+	delete(copied.Labels, RescheduleLastErrorLabel)
+
+	// The new container is the next generation, keyed for a reschedule
+	// resumed by another primary not to create it again.
+	generation, _ := strconv.Atoi(copied.Labels[RescheduleGenerationLabel])
+	copied.Labels[RescheduleGenerationLabel] = strconv.Itoa(generation + 1)
+	delete(copied.Labels, RescheduleKeyLabel)
+	if identity := w.configIdentity(copied); identity != "" {
+		copied.Labels[RescheduleKeyLabel] = identity + "/" + strconv.Itoa(generation+1)
+	}
+
+	// Drop the pin to the target of a previous rescheduling.
+	if target, ok := copied.Labels[rescheduleTargetLabel]; ok {
+		if err := copied.RemoveConstraint("node==" + target); err != nil {
+			return nil, err
 		}
+		delete(copied.Labels, rescheduleTargetLabel)
+	}
 
-		// Remove the container from the dead engine. If we don't, then both
-		// the old and new one will show up in docker ps.
-		// We have to do this before calling `CreateContainer`, otherwise it
-		// will abort because the name is already taken.
-		c.Engine.removeContainer(c)
+	// Drop the stickiness to the target of a previous rescheduling, and
+	// stick to the last target of the group instead.
+	if sticky, ok := copied.Labels[rescheduleStickyLabel]; ok {
+		if err := copied.RemoveConstraint(sticky); err != nil {
+			return nil, err
+		}
+		delete(copied.Labels, rescheduleStickyLabel)
+	}
+	if err := w.stick(copied); err != nil {
+		return nil, err
+	}
+
+	// Avoid the targets in cooldown rather than the ones of a previous
+	// rescheduling.
+	if err := dropCooldown(copied); err != nil {
+		return nil, err
+	}
+	if err := w.cooldown(copied); err != nil {
+		return nil, err
+	}
+
+	// Better violate the placement-only constraints than not reschedule.
+	for _, constraint := range copied.PlacementOnlyConstraints() {
+		if err := copied.RemoveConstraint(constraint); err != nil {
+			return nil, err
+		}
+	}
+
+	labels := make([]string, 0, len(w.opts.RescheduleNodeSuitability))
+	for label := range w.opts.RescheduleNodeSuitability {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
 
-		// keep track of all global networks this container is connected to
-		globalNetworks := make(map[string]*network.EndpointSettings)
-		// if the existing container has global network endpoints,
-		// they need to be removed with force option
-		// "docker network disconnect -f network containername" only takes containername
-		name := c.Info.Name
-		if len(name) == 0 || len(name) == 1 && name[0] == '/' {
-			log.Errorf("container %s has no name", c.ID)
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if copied.HasConstraintOn(kv[0]) {
 			continue
 		}
-		// cut preceding '/'
-		if name[0] == '/' {
-			name = name[1:]
+		constraint := kv[0] + "!=" + kv[1]
+		if w.opts.RescheduleNodeSuitability[label] == SuitabilityAvoid {
+			constraint = kv[0] + "!=~" + kv[1]
+		}
+		if err := copied.AddConstraint(constraint); err != nil {
+			return nil, err
 		}
+	}
 
-		if c.Info.NetworkSettings != nil && len(c.Info.NetworkSettings.Networks) > 0 {
-			// find an engine to do disconnect work
-			randomEngine, err := w.cluster.RANDOMENGINE()
-			if err != nil {
-				log.Errorf("Failed to find an engine to do network cleanup for container %s: %v", c.ID, err)
-				// add the container back, so we can retry later
-				c.Engine.AddContainer(c)
-				continue
-			}
+	// Prefer the targets having the image, unless the container has its own
+	// image affinity. The references by digest can't be expressed as one.
+	if w.opts.ReschedulePreferCachedImage && copied.Image != "" && !strings.Contains(copied.Image, "@") && !hasImageAffinity(copied) {
+		if err := copied.AddAffinity("image==~" + copied.Image); err != nil {
+			return nil, err
+		}
+	}
 
-			clusterNetworks := w.cluster.Networks().Uniq()
-			for networkName, endpoint := range c.Info.NetworkSettings.Networks {
-				net := clusterNetworks.Get(endpoint.NetworkID)
-				if net != nil && (net.Scope == "global" || net.Scope == "swarm") {
-					// record the network, they should be reconstructed on the new container
-					globalNetworks[networkName] = endpoint
-					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-					defer cancel()
-					err = randomEngine.apiClient.NetworkDisconnect(ctx, networkName, name, true)
-					if err != nil {
-						// do not abort here as this endpoint might have been removed before
-						log.Warnf("Failed to remove network endpoint from old container %s: %v", name, err)
-					}
-				}
+	// Prefer the targets actually using less memory, unless the container
+	// asks for its own weight.
+	if _, ok := copied.Labels[SwarmLabelNamespace+".memory-usage-weight"]; !ok && w.opts.RescheduleMemoryUsageWeight > 0 {
+		copied.Labels[SwarmLabelNamespace+".memory-usage-weight"] = strconv.FormatFloat(w.opts.RescheduleMemoryUsageWeight, 'f', -1, 64)
+	}
+
+	// Overcommit the CPUs of the targets rather than leave the container
+	// down, unless the container asks for its own ratio.
+	if _, ok := copied.Labels[SwarmLabelNamespace+".cpu-overcommit"]; !ok && w.opts.RescheduleCPUOvercommit > 1 {
+		copied.Labels[SwarmLabelNamespace+".cpu-overcommit"] = strconv.FormatFloat(w.opts.RescheduleCPUOvercommit, 'f', -1, 64)
+	}
+	return copied, nil
+}
+
+// stick adds a constraint to the last target of the group of the container,
+// unless the container is pinned to a node.
+func (w *Watchdog) stick(config *ContainerConfig) error {
+	group := config.Labels[RescheduleGroupLabel]
+	if group == "" || w.opts.RescheduleStickyWindow <= 0 || w.sticky == nil || config.HaveNodeConstraint() {
+		return nil
+	}
+	target := w.sticky.get(group, w.opts.RescheduleStickyWindow, time.Now())
+	if target == nil {
+		return nil
+	}
+	constraint := "node==~" + target.ID
+	if w.opts.RescheduleStickiness == StickinessRequire {
+		constraint = "node==" + target.ID
+	}
+	if err := config.AddConstraint(constraint); err != nil {
+		return err
+	}
+	config.Labels[rescheduleStickyLabel] = constraint
+	return nil
+}
+
+// dropSelfAffinities removes the affinities of a container with itself, by
+// ID, name or swarm ID, from the config recreating it. As the old container
+// stays on the node the container is moved off, they would pin the new one
+// there or never be satisfied.
+func (w *Watchdog) dropSelfAffinities(c *Container, config *ContainerConfig) error {
+	self := map[string]bool{c.ID: true}
+	if name, err := containerName(c); err == nil {
+		self[name] = true
+	}
+	if swarmID := c.Config.SwarmID(); swarmID != "" {
+		self[swarmID] = true
+	}
+	for _, affinity := range config.Affinities() {
+		if _, _, ref, ok := containerAffinity(affinity); ok && self[ref] {
+			w.log.Warnf("Container %s has an affinity with itself, dropping %s", c.ID, affinity)
+			if err := config.RemoveAffinity(affinity); err != nil {
+				return err
 			}
 		}
+	}
+	return nil
+}
 
-		// Clear out the network configs that we're going to reattach
-		// later.
-		endpointsConfig := map[string]*network.EndpointSettings{}
-		for k, v := range c.Config.NetworkingConfig.EndpointsConfig {
-			net := w.cluster.Networks().Uniq().Get(v.NetworkID)
-			if net != nil && (net.Scope == "global" || net.Scope == "swarm") {
-				// These networks are already in globalNetworks
-				// and thus will be reattached later.
-				continue
-			}
-			endpointsConfig[k] = v
+// followAffinities points the affinities of a container with the containers
+// rescheduled by the wave to their new containers, the references by ID
+// being dangling otherwise. The hard affinities with containers which are
+// gone, or left on an unhealthy engine, are relaxed into soft ones, as they
+// can't be satisfied anymore. The ones with the containers of the failed
+// engine still to be rescheduled are kept, the container is retried once
+// they are.
+func (w *Watchdog) followAffinities(c *Container, config *ContainerConfig, wave *rescheduleWave) error {
+	for _, affinity := range config.Affinities() {
+		operator, soft, ref, ok := containerAffinity(affinity)
+		if !ok {
+			continue
 		}
-		c.Config.NetworkingConfig.EndpointsConfig = endpointsConfig
-		newContainer, err := w.cluster.CreateContainer(c.Config, c.Info.Name, nil)
-		if err != nil {
-			log.Errorf("Failed to reschedule container %s: %v", c.ID, err)
-			// add the container back, so we can retry later
-			c.Engine.AddContainer(c)
-			continue
-		}
-
-		// Docker create command cannot create a container with multiple networks
-		// see https://github.com/docker/docker/issues/17750
-		// Add the global networks one by one
-		for networkName, endpoint := range globalNetworks {
-			hasSubnet := false
-			network := w.cluster.Networks().Uniq().Get(networkName)
-			if network != nil {
-				for _, config := range network.IPAM.Config {
-					if config.Subnet != "" {
-						hasSubnet = true
-						break
-					}
-				}
-			}
-			// If this network did not have a defined subnet, we
-			// cannot connect to it with an explicit IP address.
-			if !hasSubnet && endpoint.IPAMConfig != nil {
-				endpoint.IPAMConfig.IPv4Address = ""
-				endpoint.IPAMConfig.IPv6Address = ""
-			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-			err = newContainer.Engine.apiClient.NetworkConnect(ctx, networkName, name, endpoint)
-			if err != nil {
-				log.Warnf("Failed to connect network %s to container %s: %v", networkName, name, err)
+		var followed string
+		if replacement, ok := wave.replacements[ref]; ok {
+			followed = "container" + operator + replacement.ID
+			if soft {
+				followed = "container" + operator + "~" + replacement.ID
 			}
+			w.log.Infof("Container %s has an affinity with container %s, rescheduled as %s", c.ID, ref, replacement.ID)
+		} else if operator == "==" && !soft && w.lostDependency(ref, wave) {
+			followed = "container==~" + ref
+			w.log.Warnf("Container %s has an affinity with container %s which is not available anymore, relaxing it", c.ID, ref)
+		} else {
+			continue
 		}
+		if err := config.RemoveAffinity(affinity); err != nil {
+			return err
+		}
+		if err := config.AddAffinity(followed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		log.Infof("Rescheduled container %s from %s to %s as %s", c.ID, c.Engine.Name, newContainer.Engine.Name, newContainer.ID)
-		if c.Info.State.Running {
-			log.Infof("Container %s was running, starting container %s", c.ID, newContainer.ID)
-			if err := w.cluster.StartContainer(newContainer, nil); err != nil {
-				log.Errorf("Failed to start rescheduled container %s: %v", newContainer.ID, err)
-			}
+// lostDependency returns true if the container a container has an affinity
+// with is gone, or left on an unhealthy engine, and won't be rescheduled by the
+// wave.
+func (w *Watchdog) lostDependency(ref string, wave *rescheduleWave) bool {
+	dependency := w.cluster.Containers().Get(ref)
+	switch {
+	case dependency == nil:
+		return true
+	case dependency.Engine.IsHealthy():
+		return false
+	case dependency.Engine == wave.engine:
+		return wave.failed[dependency.ID] != nil || w.skipReason(dependency) != ""
+	}
+	return true
+}
+
+// hasImageAffinity returns true if the container has an affinity on images.
+func hasImageAffinity(config *ContainerConfig) bool {
+	for _, affinity := range config.Affinities() {
+		if i := strings.IndexAny(affinity, "=!<>"); i > 0 && strings.TrimSpace(affinity[:i]) == "image" {
+			return true
 		}
 	}
+	return false
+}
+
+// addHint adds the soft version of the hint constraint to the config of a
+// container being moved, warning if no node currently satisfies it.
+func (w *Watchdog) addHint(c *Container, config *ContainerConfig, hint string) error {
+	soft, err := softConstraint(hint)
+	if err != nil {
+		return err
+	}
+
+	probe := copyContainerConfig(config)
+	if err := probe.AddConstraint(hint); err != nil {
+		return err
+	}
+	if _, err := w.cluster.SelectEngine(probe); err != nil {
+		w.log.Warnf("No node satisfies %s for container %s, scheduling it normally: %v", hint, c.ID, err)
+	}
+	return config.AddConstraint(soft)
+}
+
+// RescheduleSourceExcludedLabel is the engine label excluding a node from
+// rescheduling, e.g. reschedule-source-excluded=true for a node running
+// node-local agents: its containers are left in place when it fails.
+const RescheduleSourceExcludedLabel = "reschedule-source-excluded"
+
+// RescheduleMakeBeforeBreakLabel is the label of the containers tolerating a
+// brief duplication, which are moved off healthy nodes make-before-break.
+const RescheduleMakeBeforeBreakLabel = SwarmLabelNamespace + ".reschedule-makebeforebreak"
+
+// rescheduleTargetLabel records the engine a rescheduled container was pinned
+// to by mutateConfig.
+const rescheduleTargetLabel = SwarmLabelNamespace + ".reschedule-target"
+
+const (
+	// RescheduleAttemptLabel is the label of the containers of failed nodes
+	// counting the attempts of the watchdog to reschedule them.
+	RescheduleAttemptLabel = SwarmLabelNamespace + ".reschedule.attempt"
+	// RescheduleLastErrorLabel is the label of the containers of failed
+	// nodes holding why their last rescheduling failed.
+	RescheduleLastErrorLabel = SwarmLabelNamespace + ".reschedule.last-error"
+	// RescheduleGenerationLabel counts the reschedules of a container, its
+	// new container is the next generation.
+	RescheduleGenerationLabel = SwarmLabelNamespace + ".reschedule.generation"
+	// RescheduleKeyLabel is the idempotency key of the reschedule creating a
+	// container: the identity of the container and its new generation. A
+	// move finding a container with its key in the cluster, e.g. created by
+	// the previous primary before it failed over, takes it over rather than
+	// create another one.
+	RescheduleKeyLabel = SwarmLabelNamespace + ".reschedule.key"
+)
+
+// setContainerLabel sets a label of the config of a container, as shown by
+// docker ps. The labels are copied rather than updated in place, so that the
+// copies of the config taken before keep their own. It must not be called
+// while the container is being rescheduled, which reads its config.
+func setContainerLabel(c *Container, key, value string) {
+	labels := make(map[string]string, len(c.Config.Labels)+1)
+	for k, v := range c.Config.Labels {
+		labels[k] = v
+	}
+	labels[key] = value
+	c.Config.Labels = labels
+}
+
+// mutateConfig applies the RescheduleConfigMutator option to the config of a
+// container being rescheduled. As the mutation is made for a given engine,
+// the new container is pinned to it, and the engine is reserved for the
+// concurrent placements not to take it meanwhile. release releases the
+// reservation once the container is created.
+func (w *Watchdog) mutateConfig(c *Container, config *ContainerConfig) (mutated *ContainerConfig, release func(), rerr *RescheduleError) {
+	release = func() {}
+	if w.opts.RescheduleConfigMutator == nil {
+		return config, release, nil
+	}
+
+	var (
+		target *Engine
+		err    error
+	)
+	if config.Reservation() != "" {
+		// The namespace group of the container reserved its target.
+		target, err = w.cluster.SelectEngine(config)
+	} else {
+		target, release, err = w.cluster.ReserveEngine(config)
+	}
+	if err != nil {
+		return nil, nil, &RescheduleError{Container: c, Reason: classifyCreateError(err), Err: err}
+	}
+
+	// The mutator sees the config about to be used, not the original one.
+	rescheduled := *c
+	rescheduled.Config = config
+	mutated, err = w.opts.RescheduleConfigMutator(&rescheduled, target)
+	if err != nil {
+		release()
+		return nil, nil, &RescheduleError{Container: c, Engine: target, Reason: ErrConfigMutation, Err: err}
+	}
+	if mutated == nil {
+		mutated = config
+	}
+
+	mutated = copyContainerConfig(mutated)
+	if err := mutated.AddConstraint("node==" + target.ID); err != nil {
+		release()
+		return nil, nil, &RescheduleError{Container: c, Engine: target, Err: err}
+	}
+	mutated.Labels[rescheduleTargetLabel] = target.ID
+	mutated.SetReservation(config.Reservation())
+	return mutated, release, nil
+}
+
+// relaxCpuset unpins a container from the CPUs of its cpuset if no node has
+// them, with RescheduleRelaxCpuset, rather than not rescheduling it.
+func (w *Watchdog) relaxCpuset(c *Container, config *ContainerConfig) {
+	if !w.opts.RescheduleRelaxCpuset || config.HostConfig.CpusetCpus == "" {
+		return
+	}
+	if _, err := w.cluster.SelectEngine(config); err == nil || !strings.Contains(err.Error(), noCpusetError) {
+		return
+	}
+	w.log.Infof("No node has the CPUs of the cpuset %s of container %s, rescheduling it without", config.HostConfig.CpusetCpus, c.ID)
+	config.HostConfig.CpusetCpus = ""
+}
+
+// rescheduleTimeline holds when the steps of the rescheduling of a container
+// happened.
+type rescheduleTimeline struct {
+	// detected is when the failure was detected, or when the container was
+	// removed for a move.
+	detected time.Time
+	// created is when the new container was created.
+	created time.Time
+	// started is when the new container was started, zero if it wasn't.
+	started time.Time
+}
+
+// downtime returns how long the container was down, until its replacement
+// started. It is 0 for the containers which weren't started.
+func (t rescheduleTimeline) downtime() time.Duration {
+	if t.started.IsZero() {
+		return 0
+	}
+	return t.started.Sub(t.detected)
+}
+
+// recordDowntime records the downtime of a rescheduled container which was
+// started.
+func (w *Watchdog) recordDowntime(timeline rescheduleTimeline) {
+	if !timeline.started.IsZero() {
+		w.downtime.Observe(timeline.downtime())
+	}
+}
+
+// RescheduleDowntime returns the histogram of the downtime of the rescheduled
+// containers, from the detection of the failure to the start of their
+// replacement.
+func (w *Watchdog) RescheduleDowntime() *Histogram {
+	return w.downtime
+}
+
+// emitRescheduledEvent emits an event on the engine of the new container
+// telling which container it replaces, why, whether it was restarted and how
+// long it took.
+func (w *Watchdog) emitRescheduledEvent(c, newContainer *Container, trigger RescheduleTrigger, timeline rescheduleTimeline) {
+	attributes := map[string]string{
+		"container":     c.ID,
+		"new_container": newContainer.ID,
+		"from_node":     c.Engine.Name,
+		"to_node":       newContainer.Engine.Name,
+		"trigger":       string(trigger),
+		"trigger_time":  timeline.detected.Format(time.RFC3339Nano),
+		"created_time":  timeline.created.Format(time.RFC3339Nano),
+	}
+	restarted, reason := w.shouldRestart(c, newContainer.Config)
+	attributes["restarted"] = strconv.FormatBool(restarted)
+	attributes["restart_reason"] = reason
+	if !timeline.started.IsZero() {
+		attributes["started_time"] = timeline.started.Format(time.RFC3339Nano)
+		attributes["downtime"] = timeline.downtime().String()
+	}
+	w.emitEvent(newContainer.Engine, "container_rescheduled", attributes)
+}
+
+// emitEvent emits a swarm event through the event handler of the engine.
+func (w *Watchdog) emitEvent(e *Engine, status string, attributes map[string]string) {
+	ev := w.publish(e, status, attributes)
+	// If there is no event handler registered, abort right now.
+	if e == nil || e.eventHandler == nil {
+		return
+	}
+	e.eventHandler.Handle(ev)
+}
+
+// publish publishes an event of the watchdog to the Emitter option, and
+// returns it.
+func (w *Watchdog) publish(e *Engine, status string, attributes map[string]string) *Event {
+	ev := &Event{
+		Message: events.Message{
+			Status: status,
+			From:   "swarm",
+			Type:   "swarm",
+			Action: status,
+			Actor: events.Actor{
+				Attributes: attributes,
+			},
+			Time:     time.Now().Unix(),
+			TimeNano: time.Now().UnixNano(),
+		},
+		Engine: e,
+	}
+	if w.opts.Emitter == nil {
+		return ev
+	}
+	if err := w.opts.Emitter.Emit(ev); err != nil {
+		w.log.Warnf("Failed to emit event %s: %v", status, err)
+	}
+	return ev
+}
+
+// isRunning returns true if the container was running according to its last
+// known state.
+func isRunning(c *Container) bool {
+	return c.Info.ContainerJSONBase != nil && c.Info.State != nil && c.Info.State.Running
+}
+
+// containerName returns the name of the container without the preceding '/'.
+func containerName(c *Container) (string, error) {
+	if c.Info.ContainerJSONBase == nil {
+		return "", fmt.Errorf("container %s has no name", c.ID)
+	}
+	name := c.Info.Name
+	if len(name) == 0 || len(name) == 1 && name[0] == '/' {
+		return "", fmt.Errorf("container %s has no name", c.ID)
+	}
+	// cut preceding '/'
+	if name[0] == '/' {
+		name = name[1:]
+	}
+	return name, nil
+}
+
+// copyContainerConfig returns a copy of the config whose labels can be
+// modified without altering the original config. The host config is kept
+// whole, for the recreated container to behave as the original one, e.g.
+// with the init process of --init.
+func copyContainerConfig(config *ContainerConfig) *ContainerConfig {
+	copied := *config
+	copied.Labels = make(map[string]string, len(config.Labels))
+	for k, v := range config.Labels {
+		copied.Labels[k] = v
+	}
+	// The name resolution of the container is often adjusted per target,
+	// e.g. by the config mutator, which must not change the old container.
+	copied.HostConfig.ExtraHosts = copyStrings(config.HostConfig.ExtraHosts)
+	copied.HostConfig.DNS = copyStrings(config.HostConfig.DNS)
+	copied.HostConfig.DNSOptions = copyStrings(config.HostConfig.DNSOptions)
+	copied.HostConfig.DNSSearch = copyStrings(config.HostConfig.DNSSearch)
+	return &copied
+}
+
+// copyStrings returns a copy of a slice of strings, nil for nil.
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append([]string{}, values...)
+}
+
+// containersByPriority sorts containers by ascending reschedule priority.
+type containersByPriority Containers
+
+func (c containersByPriority) Len() int      { return len(c) }
+func (c containersByPriority) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c containersByPriority) Less(i, j int) bool {
+	return c[i].Config.ReschedulePriority() < c[j].Config.ReschedulePriority()
 }
 
-// NewWatchdog creates a new watchdog
-func NewWatchdog(cluster Cluster) *Watchdog {
-	log.Debugf("Watchdog enabled")
+// NewWatchdog creates a new watchdog. The defaults are filled in a copy of
+// the options, which may be shared by the watchdogs of successive terms.
+func NewWatchdog(cluster Cluster, opts *WatchdogOpts) *Watchdog {
+	if opts == nil {
+		opts, _ = NewWatchdogOpts(nil)
+	}
+	copied := *opts
+	opts = &copied
+	if opts.RescheduleRetryInterval <= 0 {
+		opts.RescheduleRetryInterval = defaultRescheduleRetryInterval
+	}
+	if opts.ReschedulePassTimeout <= 0 {
+		opts.ReschedulePassTimeout = defaultReschedulePassTimeout
+	}
+	if opts.NetworkAttachAttempts <= 0 {
+		opts.NetworkAttachAttempts = defaultNetworkAttachAttempts
+	}
+	if opts.NetworkAttachRetryInterval <= 0 {
+		opts.NetworkAttachRetryInterval = defaultNetworkAttachRetryInterval
+	}
+	if opts.RescheduleHealthInterval <= 0 {
+		opts.RescheduleHealthInterval = defaultRescheduleHealthInterval
+	}
+	if opts.RestartLoopWindow <= 0 {
+		opts.RestartLoopWindow = defaultRestartLoopWindow
+	}
+	if opts.Checkpoints == nil {
+		opts.Checkpoints = NewMemoryCheckpointStore()
+	}
+	if opts.Quarantine == nil {
+		opts.Quarantine = NewQuarantine()
+	}
+	if opts.RestartLoopCooldown <= 0 {
+		opts.RestartLoopCooldown = defaultRestartLoopCooldown
+	}
+	if opts.NodeFailureBreakerWindow <= 0 {
+		opts.NodeFailureBreakerWindow = defaultNodeFailureBreakerWindow
+	}
+	if opts.RescheduleCooldownWindow <= 0 {
+		opts.RescheduleCooldownWindow = defaultRescheduleCooldownWindow
+	}
+	if opts.RescheduleStickiness == "" {
+		opts.RescheduleStickiness = StickinessPrefer
+	}
+	if opts.RescheduleRateLimitBackoff <= 0 {
+		opts.RescheduleRateLimitBackoff = defaultRescheduleRateLimitBackoff
+	}
+	if opts.RescheduleRetryMaxInterval < opts.RescheduleRetryInterval {
+		opts.RescheduleRetryMaxInterval = defaultRescheduleRetryMaxInterval
+		if opts.RescheduleRetryMaxInterval < opts.RescheduleRetryInterval {
+			opts.RescheduleRetryMaxInterval = opts.RescheduleRetryInterval
+		}
+	}
 	w := &Watchdog{
 		cluster: cluster,
+		opts:    opts,
+		log:     watchdogLogger(opts.LogLevel),
+		running: true,
+		leader:  true,
+		abandon: make(chan struct{}),
+		stopped: make(chan struct{}),
+
+		inflight: make(map[string]*inflightReschedule),
+		waves:    make(map[string]*rescheduleWave),
+		handled:  make(map[string]bool),
+		grace:    make(map[string]bool),
+		stale:    make(map[string]bool),
+
+		detachedNetworks: make(map[string]map[string]*network.EndpointSettings),
+		moving:           make(map[string]bool),
+		sticky:           newStickyTargets(),
+		cooldowns:        newTargetCooldowns(),
+		breaker:          &failureBreaker{},
+
+		restarts:         make(map[string]*restartRecord),
+		restartLoopMoves: make(map[string]time.Time),
+		downtime:         NewHistogram(DefaultDowntimeBuckets),
+	}
+	if templates, err := parseLabelTemplates(opts.RescheduleLabelTemplate); err != nil {
+		w.log.Errorf("Not stamping the labels of the rescheduled containers: %v", err)
+	} else {
+		w.labelTemplates = templates
+	}
+	if opts.RescheduleMaxInflight > 0 {
+		w.slots = make(chan struct{}, opts.RescheduleMaxInflight)
+	}
+	w.log.Debugf("Watchdog enabled")
+	opts.Quarantine.setReleaseHandler(w.releaseQuarantined)
+	// The reschedules left by the previous primary are resumed.
+	engines := w.unhealthyEngines()
+	for id, trigger := range w.restoreCheckpoint(engines) {
+		e, trigger := engines[id], trigger
+		w.background(func() { w.rescheduleContainers(e, trigger) })
 	}
 	cluster.RegisterEventHandler(w)
+	if opts.ReconcileInterval > 0 {
+		go w.reconcileLoop()
+	}
 	return w
 }
+
+// watchdogLogger returns the logger of the watchdog. With a level, it logs
+// like the standard logger, but at its own level.
+func watchdogLogger(level string) *log.Entry {
+	logger := log.StandardLogger()
+	if lvl, err := log.ParseLevel(level); level != "" && err == nil {
+		logger = &log.Logger{
+			Out:       logger.Out,
+			Hooks:     logger.Hooks,
+			Formatter: logger.Formatter,
+			Level:     lvl,
+		}
+	}
+	return logger.WithFields(log.Fields{"name": "watchdog"})
+}
@@ -0,0 +1,4350 @@
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/mount"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-units"
+	engineapimock "github.com/docker/swarm/api/mockclient"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
+)
+
+// mockCluster is a minimal Cluster implementation backed by in-memory
+// engines, used to exercise the watchdog.
+type mockCluster struct {
+	sync.Mutex
+
+	engines  []*Engine
+	networks Networks
+	created  int
+	calls    int
+
+	createErr error
+	// createHook, if set, is called with the creation count and can delay
+	// or fail it.
+	createHook func(count int) error
+	// createPanic makes the creation of the container with that name panic
+	// once.
+	createPanic string
+	removed     []*Container
+	started     []*Container
+	// reserved counts the engine reservations not released yet.
+	reserved int
+	// ops records the creations, starts, removals and renames of
+	// containers, in order.
+	ops []string
+	// spread places the containers on the engine with the fewest
+	// containers among the ones satisfying them, as the spread strategy,
+	// rather than on the first one.
+	spread bool
+}
+
+func (m *mockCluster) CreateContainer(config *ContainerConfig, name string, authConfig *types.AuthConfig) (*Container, error) {
+	// The hook is called unlocked, as it may block.
+	m.Lock()
+	m.calls++
+	calls, hook := m.calls, m.createHook
+	m.Unlock()
+	if hook != nil {
+		if err := hook(calls); err != nil {
+			return nil, err
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.createErr != nil {
+		return nil, m.createErr
+	}
+	if m.createPanic != "" && m.createPanic == name {
+		m.createPanic = ""
+		panic("unexpected container " + name)
+	}
+
+	e := m.selectEngine(config)
+	if e == nil {
+		for _, e := range m.engines {
+			if e.IsHealthy() && len(config.UnsatisfiedRequirements(e.Labels)) > 0 {
+				return nil, errors.New(noDeviceError)
+			}
+		}
+		if config.GPUs() > 0 {
+			return nil, errors.New(noGPUError)
+		}
+		if m.lacksCpuset(config) {
+			return nil, errors.New(noCpusetError)
+		}
+		return nil, errors.New("no resources available to schedule container")
+	}
+
+	m.created++
+	m.ops = append(m.ops, "create "+name)
+	c := &Container{
+		Container: types.Container{ID: fmt.Sprintf("new-%d", m.created), Names: []string{name}},
+		Config:    config,
+		Info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				Name:       name,
+				State:      &types.ContainerState{},
+				HostConfig: &config.HostConfig,
+			},
+			Config: &config.Config,
+		},
+		Engine: e,
+	}
+	e.AddContainer(c)
+	return c, nil
+}
+
+func (m *mockCluster) RemoveContainer(container *Container, force, volumes bool) error {
+	m.Lock()
+	m.removed = append(m.removed, container)
+	m.ops = append(m.ops, "remove "+container.ID)
+	m.Unlock()
+	return container.Engine.removeContainer(container)
+}
+
+func (m *mockCluster) Images() Images {
+	images := Images{}
+	for _, e := range m.engines {
+		images = append(images, e.Images()...)
+	}
+	return images
+}
+func (m *mockCluster) Image(IDOrName string) *Image { return nil }
+func (m *mockCluster) RemoveImages(name string, force bool) ([]types.ImageDelete, error) {
+	return nil, nil
+}
+
+func (m *mockCluster) Containers() Containers {
+	out := Containers{}
+	for _, e := range m.engines {
+		out = append(out, e.Containers()...)
+	}
+	return out
+}
+
+func (m *mockCluster) StartContainer(container *Container, hostConfig *dockerclient.HostConfig) error {
+	m.Lock()
+	defer m.Unlock()
+	m.started = append(m.started, container)
+	m.ops = append(m.ops, "start "+container.ID)
+	container.Info.State.Running = true
+	return nil
+}
+
+func (m *mockCluster) Container(IDOrName string) *Container { return m.Containers().Get(IDOrName) }
+func (m *mockCluster) Networks() Networks                   { return m.networks }
+func (m *mockCluster) CreateNetwork(name string, request *types.NetworkCreate) (*types.NetworkCreateResponse, error) {
+	return nil, nil
+}
+func (m *mockCluster) RemoveNetwork(network *Network) error { return nil }
+func (m *mockCluster) CreateVolume(request *volume.VolumesCreateBody) (*types.Volume, error) {
+	return nil, nil
+}
+func (m *mockCluster) Volumes() Volumes                        { return Volumes{} }
+func (m *mockCluster) RemoveVolumes(name string) (bool, error) { return false, nil }
+func (m *mockCluster) Pull(name string, authConfig *types.AuthConfig, callback func(where, status string, err error)) {
+}
+func (m *mockCluster) Import(source string, ref string, tag string, imageReader io.Reader, callback func(where, status string, err error)) {
+}
+func (m *mockCluster) Load(imageReader io.Reader, callback func(what, status string, err error)) {}
+func (m *mockCluster) Info() [][2]string                                                         { return nil }
+func (m *mockCluster) TotalMemory() int64                                                        { return 0 }
+func (m *mockCluster) TotalCpus() int64                                                          { return 0 }
+func (m *mockCluster) RegisterEventHandler(h EventHandler) error                                 { return nil }
+func (m *mockCluster) UnregisterEventHandler(h EventHandler)                                     {}
+
+func (m *mockCluster) SelectEngine(config *ContainerConfig) (*Engine, error) {
+	m.Lock()
+	defer m.Unlock()
+	if e := m.selectEngine(config); e != nil {
+		return e, nil
+	}
+	if m.lacksCpuset(config) {
+		return nil, errors.New(noCpusetError)
+	}
+	return nil, ErrNoHealthyEngine
+}
+
+func (m *mockCluster) ReserveEngine(config *ContainerConfig) (*Engine, func(), error) {
+	e, err := m.SelectEngine(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.Lock()
+	m.reserved++
+	m.Unlock()
+	var once sync.Once
+	return e, func() {
+		once.Do(func() {
+			m.Lock()
+			m.reserved--
+			m.Unlock()
+		})
+	}, nil
+}
+
+func (m *mockCluster) FreeCapacity() (memory int64, cpus int64) {
+	for _, e := range m.engines {
+		if e.IsHealthy() {
+			memory += e.TotalMemory() - e.UsedMemory()
+			cpus += e.TotalCpus() - e.UsedCpus()
+		}
+	}
+	return memory, cpus
+}
+
+func (m *mockCluster) Engine(IDOrName string) *Engine {
+	for _, e := range m.engines {
+		if e.ID == IDOrName || e.Name == IDOrName {
+			return e
+		}
+	}
+	return nil
+}
+
+func (m *mockCluster) Capacity() CapacityReport {
+	return NewCapacityReport(engineCapacities(m.engines))
+}
+
+func (m *mockCluster) RANDOMENGINE() (*Engine, error) {
+	for _, e := range m.engines {
+		if e.IsHealthy() {
+			return e, nil
+		}
+	}
+	return nil, ErrNoHealthyEngine
+}
+
+func (m *mockCluster) MoveContainer(container *Container, target *Engine, opts MoveOpts) (*MoveResult, error) {
+	return MoveContainer(m, container, target, opts)
+}
+
+func (m *mockCluster) RenameContainer(container *Container, newName string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.ops = append(m.ops, "rename "+container.ID+" "+newName)
+	container.Info.Name = "/" + newName
+	return nil
+}
+func (m *mockCluster) BuildImage(io.Reader, *types.ImageBuildOptions, io.Writer) error {
+	return nil
+}
+func (m *mockCluster) TagImage(IDOrName string, ref string, force bool) error { return nil }
+func (m *mockCluster) RefreshEngine(hostname string) error                    { return nil }
+func (m *mockCluster) RefreshEngines() error                                  { return nil }
+func (m *mockCluster) Snapshot() ClusterState                                 { return ClusterState{} }
+
+// selectEngine returns the first healthy engine satisfying the constraints of
+// the config, or the emptiest one when spreading. Only == and != constraints
+// are honored, soft ones are dropped when no engine satisfies them. The CPUs
+// are only overcommitted when no engine has the CPUs of the container.
+// Placement-only ones and the hard container affinities are honored.
+// Cordoned engines take no container. Dedicated engines only take the
+// containers tolerating them.
+func (m *mockCluster) selectEngine(config *ContainerConfig) *Engine {
+	for _, soft := range []bool{true, false} {
+		for _, cpuRatio := range []float64{1, config.CPUOvercommit()} {
+			var selected *Engine
+			for _, e := range m.engines {
+				if e.IsHealthy() && !e.IsCordoned() && satisfiesConstraints(e, config, soft) && satisfiesImageAffinities(e, config, soft) && satisfiesContainerAffinities(e, config) && len(config.UnsatisfiedRequirements(e.Labels)) == 0 && config.Tolerates(e.Labels) && hasMemory(e, config) && hasCpus(e, config, cpuRatio) && hasBandwidth(e, config) && hasGPUs(e, config) && hasCpuset(e, config) {
+					if !m.spread {
+						return e
+					}
+					if selected == nil || len(e.Containers()) < len(selected.Containers()) {
+						selected = e
+					}
+				}
+			}
+			if selected != nil {
+				return selected
+			}
+		}
+	}
+	return nil
+}
+
+// hasMemory returns true if the engine has the memory the container reserves.
+// The engines without memory take any container.
+func hasMemory(e *Engine, config *ContainerConfig) bool {
+	return e.TotalMemory() == 0 || config.HostConfig.Memory <= e.TotalMemory()-e.UsedMemory()
+}
+
+// hasCpus returns true if the engine has the CPUs the container reserves,
+// with its CPUs multiplied by the ratio. The engines without CPUs take any
+// container.
+func hasCpus(e *Engine, config *ContainerConfig, ratio float64) bool {
+	return e.TotalCpus() == 0 || config.HostConfig.CPUShares <= int64(float64(e.TotalCpus())*ratio)-e.UsedCpus()
+}
+
+// hasBandwidth returns true if the NIC of the engine can take the bandwidth
+// the container reserves.
+func hasBandwidth(e *Engine, config *ContainerConfig) bool {
+	return e.TotalBandwidth() == 0 || config.Bandwidth() <= e.TotalBandwidth()-e.UsedBandwidth()
+}
+
+// hasGPUs returns true if the engine has the free GPUs the container
+// reserves. The engines without GPUs only take the containers reserving none.
+func hasGPUs(e *Engine, config *ContainerConfig) bool {
+	return config.GPUs() <= e.TotalGPUs()-e.UsedGPUs()
+}
+
+// hasCpuset returns true if the engine has the CPUs the container is pinned
+// to. The engines whose CPUs are unknown take any container.
+func hasCpuset(e *Engine, config *ContainerConfig) bool {
+	max, ok := config.CpusetMaxCPU()
+	return !ok || e.Cpus == 0 || max < e.Cpus
+}
+
+// lacksCpuset returns true if the container is pinned to CPUs which no healthy
+// engine has.
+func (m *mockCluster) lacksCpuset(config *ContainerConfig) bool {
+	if _, ok := config.CpusetMaxCPU(); !ok {
+		return false
+	}
+	healthy := false
+	for _, e := range m.engines {
+		if e.IsHealthy() {
+			if hasCpuset(e, config) {
+				return false
+			}
+			healthy = true
+		}
+	}
+	return healthy
+}
+
+// satisfiesImageAffinities returns true if the engine has the images of the
+// image affinities of the container, the soft ones only if soft.
+func satisfiesImageAffinities(e *Engine, config *ContainerConfig, soft bool) bool {
+	for _, affinity := range config.Affinities() {
+		kv := strings.SplitN(affinity, "==", 2)
+		if len(kv) != 2 || kv[0] != "image" {
+			continue
+		}
+		value := strings.TrimLeft(kv[1], "~")
+		if value != kv[1] && !soft {
+			continue
+		}
+		if e.Image(value) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// satisfiesContainerAffinities returns true if the engine has the containers
+// of the hard == container affinities of the config.
+func satisfiesContainerAffinities(e *Engine, config *ContainerConfig) bool {
+	for _, affinity := range affinityDependencies(config) {
+		if e.Containers().Get(affinity) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func satisfiesConstraints(e *Engine, config *ContainerConfig, soft bool) bool {
+	for _, constraint := range config.Constraints() {
+		equal := true
+		kv := strings.SplitN(constraint, "==", 2)
+		if len(kv) != 2 {
+			equal = false
+			kv = strings.SplitN(constraint, "!=", 2)
+		}
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimLeft(kv[1], "~@")
+		if strings.Contains(kv[1][:len(kv[1])-len(value)], "~") && !soft {
+			continue
+		}
+		var matches bool
+		if kv[0] == "node" {
+			matches = e.ID == value || e.Name == value
+		} else {
+			matches = e.Labels[kv[0]] == value
+		}
+		if matches != equal {
+			return false
+		}
+	}
+	return true
+}
+
+// reschedulePass makes a rescheduling pass of the wave with the watchdog
+// locked, as the waves do.
+func reschedulePass(w *Watchdog, wave *rescheduleWave) RescheduleErrors {
+	w.Lock()
+	defer w.Unlock()
+	return w.rescheduleContainersHelper(wave)
+}
+
+func createWatchdogEngine(ID string, healthy bool) *Engine {
+	engine := NewEngine(ID, 0, engOpts)
+	engine.ID = ID
+	engine.Name = ID
+	if healthy {
+		engine.setState(stateHealthy)
+	} else {
+		engine.setState(stateUnhealthy)
+	}
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("NetworkConnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	apiClient.On("NetworkDisconnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	engine.apiClient = apiClient
+	return engine
+}
+
+func createWatchdogContainer(engine *Engine, ID string, labels map[string]string, running bool) *Container {
+	// The labels are copied as the swarm ID is stored in them.
+	containerLabels := make(map[string]string, len(labels))
+	for k, v := range labels {
+		containerLabels[k] = v
+	}
+	config := BuildContainerConfig(containertypes.Config{Labels: containerLabels}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	config.SetSwarmID("swarm-" + ID)
+	container := &Container{
+		Container: types.Container{ID: ID, Names: []string{"/" + ID}},
+		Config:    config,
+		Info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				Name:       "/" + ID,
+				State:      &types.ContainerState{Running: running},
+				HostConfig: &config.HostConfig,
+			},
+			Config: &config.Config,
+		},
+		Engine: engine,
+	}
+	engine.AddContainer(container)
+	return container
+}
+
+var reschedulable = map[string]string{SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure"]`}
+
+var drainable = map[string]string{SwarmLabelNamespace + ".reschedule-policies": `["on-node-drain"]`}
+
+func withPriority(priority int) map[string]string {
+	return map[string]string{
+		SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure"]`,
+		SwarmLabelNamespace + ".reschedule-priority": fmt.Sprintf("%d", priority),
+	}
+}
+
+func pressureEvent(engine *Engine, status, usage string) *Event {
+	return &Event{
+		Message: events.Message{
+			From:   "swarm",
+			Status: status,
+			Actor:  events.Actor{Attributes: map[string]string{"usage": usage}},
+		},
+		Engine: engine,
+	}
+}
+
+func TestWatchdogRescheduleContainers(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, nil)
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", nil, true)
+
+	w.rescheduleContainers(dead, TriggerEngineDisconnect)
+
+	// Only the container with a reschedule policy moved.
+	assert.Len(t, alive.Containers(), 1)
+	assert.Equal(t, "swarm-c1", alive.Containers()[0].Config.SwarmID())
+	assert.Len(t, dead.Containers(), 1)
+	assert.Len(t, cl.started, 1)
+}
+
+func TestWatchdogRelievePressure(t *testing.T) {
+	pressured := createWatchdogEngine("pressured", true)
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{pressured, other}}
+	w := NewWatchdog(cl, &WatchdogOpts{
+		MemoryPressureThreshold:     0.9,
+		PressureEvictionMaxPriority: 10,
+	})
+
+	createWatchdogContainer(pressured, "low", withPriority(1), true)
+	createWatchdogContainer(pressured, "high", withPriority(100), true)
+	createWatchdogContainer(pressured, "stopped", withPriority(1), false)
+	createWatchdogContainer(pressured, "pinned", nil, true)
+
+	// Below the threshold nothing moves.
+	w.relievePressure(pressured, TriggerMemoryPressure, w.opts.MemoryPressureThreshold, "0.5")
+	assert.Len(t, pressured.Containers(), 4)
+
+	// Invalid usage is ignored.
+	w.relievePressure(pressured, TriggerMemoryPressure, w.opts.MemoryPressureThreshold, "lots")
+	assert.Len(t, pressured.Containers(), 4)
+
+	// Disk pressure eviction is disabled.
+	w.relievePressure(pressured, TriggerDiskPressure, w.opts.DiskPressureThreshold, "0.99")
+	assert.Len(t, pressured.Containers(), 4)
+
+	// Only the running, low priority, reschedulable container is evicted.
+	w.relievePressure(pressured, TriggerMemoryPressure, w.opts.MemoryPressureThreshold, "0.95")
+	assert.Len(t, pressured.Containers(), 3)
+	assert.Nil(t, pressured.Containers().Get("low"))
+	assert.Len(t, other.Containers(), 1)
+	assert.Equal(t, "swarm-low", other.Containers()[0].Config.SwarmID())
+	assert.Len(t, cl.removed, 1)
+	assert.Len(t, cl.started, 1)
+}
+
+func TestWatchdogPressureEvictionLimit(t *testing.T) {
+	pressured := createWatchdogEngine("pressured", true)
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{pressured, other}}
+	w := NewWatchdog(cl, &WatchdogOpts{
+		DiskPressureThreshold:       0.8,
+		PressureEvictionMaxPriority: 10,
+		PressureEvictionLimit:       1,
+	})
+
+	createWatchdogContainer(pressured, "c1", withPriority(5), true)
+	createWatchdogContainer(pressured, "c2", withPriority(2), true)
+
+	// The lowest priority container goes first.
+	w.relievePressure(pressured, TriggerDiskPressure, w.opts.DiskPressureThreshold, "0.8")
+	assert.Len(t, other.Containers(), 1)
+	assert.Equal(t, "swarm-c2", other.Containers()[0].Config.SwarmID())
+	assert.NotNil(t, pressured.Containers().Get("c1"))
+}
+
+func TestWatchdogPressureNoTarget(t *testing.T) {
+	pressured := createWatchdogEngine("pressured", true)
+	cl := &mockCluster{engines: []*Engine{pressured}}
+	w := NewWatchdog(cl, &WatchdogOpts{MemoryPressureThreshold: 0.5})
+
+	createWatchdogContainer(pressured, "c1", reschedulable, true)
+
+	// The new container can't go back to the pressured node, the evicted
+	// one is left in place.
+	w.relievePressure(pressured, TriggerMemoryPressure, w.opts.MemoryPressureThreshold, "0.6")
+	assert.Len(t, cl.started, 0)
+	assert.Empty(t, cl.removed)
+	assert.NotNil(t, pressured.Containers().Get("c1"))
+	assert.False(t, w.isMoving(pressured.Containers().Get("c1")))
+}
+
+func TestWatchdogPressureEvictionUnlocked(t *testing.T) {
+	pressured := createWatchdogEngine("pressured", true)
+	other := createWatchdogEngine("other", true)
+	created, resume := make(chan struct{}), make(chan struct{})
+	cl := &mockCluster{engines: []*Engine{pressured, other}, createHook: func(count int) error {
+		close(created)
+		<-resume
+		return nil
+	}}
+	w := NewWatchdog(cl, &WatchdogOpts{MemoryPressureThreshold: 0.5})
+	c := createWatchdogContainer(pressured, "c1", reschedulable, true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.relievePressure(pressured, TriggerMemoryPressure, w.opts.MemoryPressureThreshold, "0.6")
+	}()
+	<-created
+
+	// The watchdog isn't locked by the eviction in progress, and another
+	// pressure event leaves the container being evicted alone.
+	w.Lock()
+	w.Unlock()
+	assert.True(t, w.isMoving(c))
+	w.relievePressure(pressured, TriggerMemoryPressure, w.opts.MemoryPressureThreshold, "0.7")
+	close(resume)
+	<-done
+
+	assert.Equal(t, 1, cl.calls)
+	assert.Len(t, other.Containers(), 1)
+	assert.False(t, w.isMoving(c))
+}
+
+func TestWatchdogRescheduleMemoryUsageWeight(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleMemoryUsageWeight: 0.5})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", map[string]string{
+		SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure"]`,
+		SwarmLabelNamespace + ".memory-usage-weight": "1",
+	}, true)
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 2)
+	for _, c := range alive.Containers() {
+		// The weight of the container prevails.
+		if c.Config.SwarmID() == "swarm-c2" {
+			assert.Equal(t, 1.0, c.Config.MemoryUsageWeight())
+		} else {
+			assert.Equal(t, 0.5, c.Config.MemoryUsageWeight())
+		}
+	}
+}
+
+func TestWatchdogReschedulePreferCachedImage(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	uncached := createWatchdogEngine("uncached", true)
+	cached := createWatchdogEngine("cached", true)
+	cached.images = []*Image{{ImageSummary: types.ImageSummary{ID: "sha256:redis", RepoTags: []string{"redis:3"}}, Engine: cached}}
+	cl := &mockCluster{engines: []*Engine{dead, uncached, cached}}
+	w := NewWatchdog(cl, &WatchdogOpts{ReschedulePreferCachedImage: true})
+	defer w.Stop()
+
+	// The node having the image is preferred.
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	c.Config.Image = "redis:3"
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, cached.Containers(), 1)
+	assert.Contains(t, cached.Containers()[0].Config.Affinities(), "image==~redis:3")
+
+	// The other nodes are used when it can't take the container.
+	c = createWatchdogContainer(dead, "c2", reschedulable, true)
+	c.Config.Image = "redis:3"
+	c.Config.HostConfig.Memory = 1024
+	cached.Memory = 512
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, uncached.Containers(), 1)
+
+	// The image affinity of the container prevails, and the references by
+	// digest are left alone. Other affinities don't count as image ones.
+	other := createWatchdogEngine("other", false)
+	for i, config := range []struct{ image, affinity string }{{"redis:3", "image==postgres"}, {"redis@sha256:abc", ""}} {
+		c = createWatchdogContainer(other, fmt.Sprintf("c%d", i+3), reschedulable, true)
+		c.Config.Image = config.image
+		if config.affinity != "" {
+			assert.NoError(t, c.Config.AddAffinity(config.affinity))
+		}
+		rescheduled, err := w.rescheduleConfig(c.Config)
+		assert.NoError(t, err)
+		assert.Equal(t, c.Config.Affinities(), rescheduled.Affinities())
+	}
+	c = createWatchdogContainer(other, "c5", reschedulable, true)
+	c.Config.Image = "redis:3"
+	assert.NoError(t, c.Config.AddAffinity("imagetag==x"))
+	rescheduled, err := w.rescheduleConfig(c.Config)
+	assert.NoError(t, err)
+	assert.Contains(t, rescheduled.Affinities(), "image==~redis:3")
+
+	// Without the option, the image plays no part.
+	w2 := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+	defer w2.Stop()
+	c = createWatchdogContainer(dead, "c6", reschedulable, true)
+	c.Config.Image = "redis:3"
+	cached.Memory = 0
+	assert.NoError(t, w2.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, uncached.Containers(), 2)
+}
+
+func TestWatchdogRescheduleSticky(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	first := createWatchdogEngine("first", true)
+	second := createWatchdogEngine("second", true)
+	cl := &mockCluster{engines: []*Engine{dead, first, second}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleStickyWindow: time.Hour, RescheduleRetryLimit: 1})
+	defer w.Stop()
+	web := map[string]string{
+		SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure"]`,
+		RescheduleGroupLabel:                         "web",
+	}
+
+	createWatchdogContainer(dead, "web1", web, true)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, first.Containers(), 1)
+
+	// The scheduler now favors the other node, the containers of the group
+	// keep converging on the target of the first one. The others don't.
+	cl.engines = []*Engine{dead, second, first}
+	createWatchdogContainer(dead, "web2", web, true)
+	createWatchdogContainer(dead, "web3", web, true)
+	createWatchdogContainer(dead, "other", reschedulable, true)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, first.Containers(), 3)
+	assert.Len(t, second.Containers(), 1)
+	for _, c := range first.Containers() {
+		if c.Config.SwarmID() != "swarm-web1" {
+			assert.Equal(t, []string{"node==~first"}, c.Config.Constraints())
+		}
+	}
+
+	// The stickiness is a preference, the other nodes take the containers
+	// the target can't.
+	c := createWatchdogContainer(dead, "web4", web, true)
+	c.Config.HostConfig.Memory = 1024
+	first.Memory = 512
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, second.Containers(), 2)
+	first.Memory = 0
+
+	// A required target is a hard constraint, which replaces the one of a
+	// previous rescheduling.
+	w.opts.RescheduleStickiness = StickinessRequire
+	w.sticky.record("web", first, time.Now())
+	var moved *Container
+	for _, c := range second.Containers() {
+		if c.Config.SwarmID() == "swarm-web4" {
+			moved = c
+		}
+	}
+	config, err := w.rescheduleConfig(moved.Config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"node==first"}, config.Constraints())
+
+	// The target of the group is forgotten after the window, or once
+	// unhealthy.
+	w.sticky.record("web", first, time.Now().Add(-2*time.Hour))
+	assert.Nil(t, w.sticky.get("web", time.Hour, time.Now()))
+	w.sticky.record("web", dead, time.Now())
+	assert.Nil(t, w.sticky.get("web", time.Hour, time.Now()))
+	config, err = w.rescheduleConfig(c.Config)
+	assert.NoError(t, err)
+	assert.Empty(t, config.Constraints())
+}
+
+func TestWatchdogRescheduleCooldown(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	a := createWatchdogEngine("a", true)
+	b := createWatchdogEngine("b", true)
+	c := createWatchdogEngine("c", true)
+	cl := &mockCluster{engines: []*Engine{dead, a, b, c}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleCooldownCount: 2, RescheduleCooldownWindow: time.Hour, RescheduleRetryLimit: 1})
+	defer w.Stop()
+
+	// The scheduler favors the first node, which takes 2 containers before
+	// the next ones spread over the nodes not in cooldown.
+	for i := 1; i <= 6; i++ {
+		createWatchdogContainer(dead, fmt.Sprintf("c%d", i), reschedulable, true)
+	}
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, a.Containers(), 2)
+	assert.Len(t, b.Containers(), 2)
+	assert.Len(t, c.Containers(), 2)
+
+	// The cooldown is a preference, the nodes in cooldown take the
+	// containers when all of them are.
+	createWatchdogContainer(dead, "c7", reschedulable, true)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, a.Containers(), 3)
+	var moved *Container
+	for _, container := range a.Containers() {
+		if container.Config.SwarmID() == "swarm-c7" {
+			moved = container
+		}
+	}
+	assert.Equal(t, "node!=~a,node!=~b,node!=~c", moved.Config.Labels[rescheduleCooldownLabel])
+
+	// The constraints of a previous rescheduling are dropped, and the
+	// placements are forgotten after the window.
+	w.cooldowns = newTargetCooldowns()
+	w.cooldowns.record("a", time.Now().Add(-2*time.Hour))
+	w.cooldowns.record("a", time.Now().Add(-2*time.Hour))
+	config, err := w.rescheduleConfig(moved.Config)
+	assert.NoError(t, err)
+	assert.Empty(t, config.Constraints())
+	assert.NotContains(t, config.Labels, rescheduleCooldownLabel)
+
+	// The containers pinned to a node don't avoid it.
+	w.cooldowns.record("b", time.Now())
+	w.cooldowns.record("b", time.Now())
+	assert.Equal(t, []string{"b"}, w.cooldowns.cooling(2, time.Hour, time.Now()))
+	pinned := createWatchdogContainer(dead, "pinned", reschedulable, true)
+	assert.NoError(t, pinned.Config.AddConstraint("node==b"))
+	config, err = w.rescheduleConfig(pinned.Config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"node==b"}, config.Constraints())
+}
+
+func TestWatchdogRescheduleDevices(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	noFuse := createWatchdogEngine("no-fuse", true)
+	noFuse.Labels[DevicesLabel] = "/dev/nvidia0"
+	cl := &mockCluster{engines: []*Engine{dead, noFuse}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleStoppedContainers: true})
+
+	c := createWatchdogContainer(dead, "fuse", reschedulable, true)
+	c.Config.HostConfig.Devices = []containertypes.DeviceMapping{{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "rwm"}}
+	c.Config.HostConfig.Ulimits = []*units.Ulimit{{Name: "nofile", Soft: 1024, Hard: 65536}}
+
+	// No node provides the device, the reschedule fails without retry.
+	err := w.RescheduleEngine(dead, TriggerEngineDisconnect)
+	assert.Error(t, err)
+	errs, ok := err.(RescheduleErrors)
+	assert.True(t, ok)
+	assert.Equal(t, ErrDeviceUnavailable, errs[0].Reason)
+	assert.False(t, errs.Retryable())
+	assert.NotNil(t, dead.Containers().Get("fuse"))
+	assert.Empty(t, noFuse.Containers())
+
+	// The container moves to a node with the device, keeping its devices
+	// and ulimits.
+	fuse := createWatchdogEngine("fuse", true)
+	fuse.Labels[DevicesLabel] = "/dev/fuse,/dev/nvidia*"
+	fuse.Labels[UlimitsLabel] = "nofile=65536"
+	cl.engines = append(cl.engines, fuse)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Empty(t, noFuse.Containers())
+	assert.Len(t, fuse.Containers(), 1)
+	config := fuse.Containers()[0].Config
+	assert.Equal(t, "/dev/fuse", config.HostConfig.Devices[0].PathOnHost)
+	assert.Equal(t, int64(65536), config.HostConfig.Ulimits[0].Hard)
+}
+
+func TestWatchdogRescheduleHostConfig(t *testing.T) {
+	enabled := true
+	// The fields the recreated containers must keep, each dropped one would
+	// change the behavior of the container after the move.
+	cases := map[string]func(*containertypes.HostConfig){
+		"init": func(h *containertypes.HostConfig) { h.Init = &enabled },
+		"restart-policy": func(h *containertypes.HostConfig) {
+			h.RestartPolicy = containertypes.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}
+		},
+		"privileged":    func(h *containertypes.HostConfig) { h.Privileged = true },
+		"capabilities":  func(h *containertypes.HostConfig) { h.CapAdd = []string{"NET_ADMIN"}; h.CapDrop = []string{"MKNOD"} },
+		"security-opt":  func(h *containertypes.HostConfig) { h.SecurityOpt = []string{"no-new-privileges"} },
+		"tmpfs":         func(h *containertypes.HostConfig) { h.Tmpfs = map[string]string{"/run": "rw,size=64m"} },
+		"shm-size":      func(h *containertypes.HostConfig) { h.ShmSize = 256 * 1024 * 1024 },
+		"oom-score-adj": func(h *containertypes.HostConfig) { h.OomScoreAdj = 500 },
+		"pids-limit":    func(h *containertypes.HostConfig) { h.PidsLimit = 100 },
+		"ulimits": func(h *containertypes.HostConfig) {
+			h.Ulimits = []*units.Ulimit{{Name: "nofile", Soft: 1024, Hard: 4096}}
+		},
+		"sysctls": func(h *containertypes.HostConfig) { h.Sysctls = map[string]string{"net.core.somaxconn": "1024"} },
+		"log-config": func(h *containertypes.HostConfig) {
+			h.LogConfig = containertypes.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "10m"}}
+		},
+		"extra-hosts": func(h *containertypes.HostConfig) { h.ExtraHosts = []string{"db:10.0.0.2"} },
+		"dns": func(h *containertypes.HostConfig) {
+			h.DNS = []string{"10.0.0.53"}
+			h.DNSSearch = []string{"example.com"}
+			h.DNSOptions = []string{"ndots:2"}
+		},
+		"group-add":   func(h *containertypes.HostConfig) { h.GroupAdd = []string{"audio"} },
+		"read-only":   func(h *containertypes.HostConfig) { h.ReadonlyRootfs = true },
+		"userns-mode": func(h *containertypes.HostConfig) { h.UsernsMode = "host" },
+		"memory-swap": func(h *containertypes.HostConfig) {
+			h.MemorySwap = 512 * 1024 * 1024
+			h.MemoryReservation = 128 * 1024 * 1024
+		},
+		"cpuset":       func(h *containertypes.HostConfig) { h.CpusetCpus = "0-1" },
+		"blkio-weight": func(h *containertypes.HostConfig) { h.BlkioWeight = 300 },
+		"oom-kill-disable": func(h *containertypes.HostConfig) {
+			disable := true
+			h.OomKillDisable = &disable
+		},
+	}
+
+	for name, set := range cases {
+		for trigger, labels := range map[RescheduleTrigger]map[string]string{TriggerEngineDisconnect: reschedulable, TriggerDrain: drainable} {
+			failed := createWatchdogEngine("failed", trigger == TriggerDrain)
+			target := createWatchdogEngine("target", true)
+			cl := &mockCluster{engines: []*Engine{failed, target}}
+			w := NewWatchdog(cl, nil)
+
+			c := createWatchdogContainer(failed, "c", labels, true)
+			set(&c.Config.HostConfig)
+			if trigger == TriggerDrain {
+				assert.NoError(t, w.Drain(failed, ""), name)
+			} else {
+				assert.NoError(t, w.RescheduleEngine(failed, trigger), name)
+			}
+			if !assert.Len(t, target.Containers(), 1, "%s on %s", name, trigger) {
+				continue
+			}
+
+			// The expected host config is built apart, for the test not to
+			// pass on values shared with the old container.
+			expected := containertypes.HostConfig{}
+			set(&expected)
+			assert.Equal(t, expected, target.Containers()[0].Config.HostConfig, "%s on %s", name, trigger)
+		}
+	}
+}
+
+func TestWatchdogRescheduleNameResolution(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	target := createWatchdogEngine("target", true)
+	cl := &mockCluster{engines: []*Engine{dead, target}}
+	// The mutator points the container to the resolver of its target.
+	w := NewWatchdog(cl, &WatchdogOpts{
+		RescheduleRetryLimit: 1,
+		RescheduleConfigMutator: func(c *Container, target *Engine) (*ContainerConfig, error) {
+			if len(c.Config.HostConfig.DNS) > 0 {
+				c.Config.HostConfig.DNS[0] = "10.0.1.53"
+			}
+			return c.Config, nil
+		},
+	})
+
+	c := createWatchdogContainer(dead, "app", reschedulable, true)
+	c.Config.Hostname = "app-1"
+	c.Config.Domainname = "example.com"
+	c.Config.HostConfig.ExtraHosts = []string{"db:10.0.0.2", "cache:10.0.0.3"}
+	c.Config.HostConfig.DNS = []string{"10.0.0.53", "10.0.0.54"}
+	c.Config.HostConfig.DNSSearch = []string{"example.com", "svc.example.com"}
+	c.Config.HostConfig.DNSOptions = []string{"ndots:2"}
+	sidecar := createWatchdogContainer(dead, "sidecar", reschedulable, true)
+	sidecar.Config.Hostname = "app-1"
+	sidecar.Config.HostConfig.NetworkMode = "container:app"
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	var moved, movedSidecar *Container
+	for _, container := range target.Containers() {
+		switch container.Config.SwarmID() {
+		case "swarm-app":
+			moved = container
+		case "swarm-sidecar":
+			movedSidecar = container
+		}
+	}
+	if !assert.NotNil(t, moved) || !assert.NotNil(t, movedSidecar) {
+		return
+	}
+	assert.Equal(t, "app-1", moved.Config.Hostname)
+	assert.Equal(t, "example.com", moved.Config.Domainname)
+	assert.Equal(t, []string{"db:10.0.0.2", "cache:10.0.0.3"}, moved.Config.HostConfig.ExtraHosts)
+	assert.Equal(t, []string{"10.0.1.53", "10.0.0.54"}, moved.Config.HostConfig.DNS)
+	assert.Equal(t, []string{"example.com", "svc.example.com"}, moved.Config.HostConfig.DNSSearch)
+	assert.Equal(t, []string{"ndots:2"}, moved.Config.HostConfig.DNSOptions)
+	// The old container keeps its own resolver.
+	assert.Equal(t, []string{"10.0.0.53", "10.0.0.54"}, c.Config.HostConfig.DNS)
+
+	// The sidecar gets the hostname of the container whose network it
+	// joins again, rather than set its own.
+	assert.Empty(t, movedSidecar.Config.Hostname)
+	assert.Equal(t, "container:"+moved.ID, string(movedSidecar.Config.HostConfig.NetworkMode))
+}
+
+func TestWatchdogDrain(t *testing.T) {
+	drained := createWatchdogEngine("drained", true)
+	old := createWatchdogEngine("old", true)
+	newRack := createWatchdogEngine("new-rack", true)
+	newRack.Labels["rack"] = "r2"
+	cl := &mockCluster{engines: []*Engine{drained, old, newRack}}
+	w := NewWatchdog(cl, nil)
+
+	createWatchdogContainer(drained, "c1", drainable, true)
+	createWatchdogContainer(drained, "c2", drainable, false)
+	createWatchdogContainer(drained, "pinned", nil, true)
+
+	// The containers go to the new rack.
+	assert.NoError(t, w.Drain(drained, "rack==r2"))
+	assert.Len(t, newRack.Containers(), 2)
+	for _, c := range newRack.Containers() {
+		assert.Contains(t, c.Config.Constraints(), "rack==~r2")
+	}
+	assert.Len(t, cl.started, 1)
+	// Containers without reschedule policy are left in place.
+	assert.Len(t, drained.Containers(), 1)
+	assert.NotNil(t, drained.Containers().Get("pinned"))
+
+	// A hint no node satisfies is ignored.
+	createWatchdogContainer(drained, "c3", drainable, true)
+	assert.NoError(t, w.Drain(drained, "rack==r9"))
+	assert.Len(t, old.Containers(), 1)
+	assert.Equal(t, "swarm-c3", old.Containers()[0].Config.SwarmID())
+
+	// Without hint, the containers are scheduled normally.
+	createWatchdogContainer(drained, "c4", drainable, true)
+	assert.NoError(t, w.Drain(drained, ""))
+	assert.Len(t, old.Containers(), 2)
+
+	assert.Error(t, w.Drain(drained, "r2"))
+	w.Stop()
+	assert.Equal(t, ErrWatchdogInactive, w.Drain(drained, ""))
+}
+
+func TestWatchdogDrainMakeBeforeBreak(t *testing.T) {
+	labels := map[string]string{RescheduleMakeBeforeBreakLabel: "true"}
+	for k, v := range drainable {
+		labels[k] = v
+	}
+	opts := &WatchdogOpts{RescheduleHealthTimeout: time.Second, RescheduleHealthInterval: time.Millisecond}
+
+	// The old container is only removed once the new one is healthy.
+	drained := createWatchdogEngine("drained", true)
+	target := healthEngine("target", "starting", "healthy")
+	cl := &mockCluster{engines: []*Engine{drained, target}}
+	w := NewWatchdog(cl, opts)
+	createWatchdogContainer(drained, "c1", labels, true)
+	assert.NoError(t, w.Drain(drained, ""))
+	assert.Equal(t, []string{"create c1-rescheduling", "start new-1", "remove c1", "rename new-1 c1"}, cl.ops)
+	assert.Len(t, drained.Containers(), 0)
+	if assert.Len(t, target.Containers(), 1) {
+		assert.Equal(t, "/c1", target.Containers()[0].Info.Name)
+	}
+
+	// The old container is left in place if the new one doesn't come up.
+	target = healthEngine("target", "unhealthy")
+	cl = &mockCluster{engines: []*Engine{drained, target}}
+	w = NewWatchdog(cl, opts)
+	createWatchdogContainer(drained, "c2", labels, true)
+	assert.Error(t, w.Drain(drained, ""))
+	assert.Equal(t, []string{"create c2-rescheduling", "start new-1", "remove new-1"}, cl.ops)
+	assert.NotNil(t, drained.Containers().Get("c2"))
+	assert.Len(t, target.Containers(), 0)
+
+	// A container with a static address is moved break-before-make.
+	drained = createWatchdogEngine("drained", true)
+	target = createWatchdogEngine("target", true)
+	networks := Networks{&Network{NetworkResource: types.NetworkResource{ID: "overlay", Name: "overlay", Scope: "swarm"}, Engine: target}}
+	cl = &mockCluster{engines: []*Engine{drained, target}, networks: networks}
+	w = NewWatchdog(cl, &WatchdogOpts{})
+	c := createWatchdogContainer(drained, "c3", labels, true)
+	c.Info.NetworkSettings = &types.NetworkSettings{Networks: map[string]*networktypes.EndpointSettings{
+		"overlay": {NetworkID: "overlay", IPAMConfig: &networktypes.EndpointIPAMConfig{IPv4Address: "10.0.0.3"}},
+	}}
+	assert.NoError(t, w.Drain(drained, ""))
+	assert.Equal(t, []string{"remove c3", "create /c3", "start new-1"}, cl.ops)
+	assert.Len(t, target.Containers(), 1)
+}
+
+func TestWatchdogDrainWithoutTarget(t *testing.T) {
+	drained := createWatchdogEngine("drained", true)
+	small := createWatchdogEngine("small", true)
+	small.Memory = 512
+	cl := &mockCluster{engines: []*Engine{drained, small}}
+	w := NewWatchdog(cl, &WatchdogOpts{})
+
+	// A container no engine can take is left in place, rather than removed
+	// before its creation fails.
+	c := createWatchdogContainer(drained, "c1", drainable, true)
+	c.Config.HostConfig.Memory = 1024
+	err := w.Drain(drained, "")
+	assert.Error(t, err)
+	assert.Empty(t, cl.ops)
+	assert.Empty(t, cl.removed)
+	assert.Equal(t, 0, cl.reserved)
+	assert.NotNil(t, drained.Containers().Get("c1"))
+	assert.Len(t, small.Containers(), 0)
+
+	// It is moved once an engine can take it.
+	small.Memory = 2048
+	assert.NoError(t, w.Drain(drained, ""))
+	assert.Equal(t, []string{"remove c1", "create /c1", "start new-1"}, cl.ops)
+	assert.Len(t, drained.Containers(), 0)
+	assert.Len(t, small.Containers(), 1)
+	assert.Equal(t, 0, cl.reserved)
+}
+
+func TestWatchdogRescheduleIdempotencyKey(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	first := createWatchdogEngine("first", true)
+	cl := &mockCluster{engines: []*Engine{dead, first}}
+	w := NewWatchdog(cl, nil)
+
+	// Each reschedule creates the next generation of the container.
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	if assert.Len(t, first.Containers(), 1) {
+		labels := first.Containers()[0].Config.Labels
+		assert.Equal(t, "1", labels[RescheduleGenerationLabel])
+		assert.Equal(t, "swarm-c1/1", labels[RescheduleKeyLabel])
+	}
+	second := createWatchdogEngine("second", true)
+	cl.engines = append(cl.engines, second)
+	first.setState(stateUnhealthy)
+	assert.NoError(t, w.RescheduleEngine(first, TriggerEngineDisconnect))
+	if assert.Len(t, second.Containers(), 1) {
+		labels := second.Containers()[0].Config.Labels
+		assert.Equal(t, "2", labels[RescheduleGenerationLabel])
+		assert.Equal(t, "swarm-c1/2", labels[RescheduleKeyLabel])
+	}
+}
+
+func TestWatchdogDrainFailover(t *testing.T) {
+	labels := map[string]string{RescheduleMakeBeforeBreakLabel: "true"}
+	for k, v := range drainable {
+		labels[k] = v
+	}
+	drained := createWatchdogEngine("drained", true)
+	target := createWatchdogEngine("target", true)
+	cl := &mockCluster{engines: []*Engine{drained, target}}
+	createWatchdogContainer(drained, "c1", labels, true)
+
+	// The previous primary created the new container, and failed over
+	// before starting it.
+	created := createWatchdogContainer(target, "created", labels, false)
+	created.Config.SetSwarmID("swarm-c1")
+	created.Config.Labels[RescheduleKeyLabel] = "swarm-c1/1"
+	created.Info.Name = "/c1-rescheduling"
+
+	// The new primary takes it over instead of creating another one.
+	w := NewWatchdog(cl, nil)
+	assert.NoError(t, w.Drain(drained, ""))
+	assert.Equal(t, 0, cl.calls)
+	assert.Equal(t, []string{"start created", "remove c1", "rename created c1"}, cl.ops)
+	assert.Len(t, drained.Containers(), 0)
+	if assert.Len(t, target.Containers(), 1) {
+		assert.Equal(t, "/c1", target.Containers()[0].Info.Name)
+	}
+}
+
+func TestWatchdogReschedulePolicies(t *testing.T) {
+	policies := map[string]map[string]string{
+		"drain":   drainable,
+		"failure": reschedulable,
+		"both":    {SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure", "on-node-drain"]`},
+		"neither": nil,
+	}
+
+	// The node drains only move the containers with the on-node-drain policy.
+	drained := createWatchdogEngine("drained", true)
+	crashed := createWatchdogEngine("crashed", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{drained, crashed, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleStoppedContainers: true})
+	for name, labels := range policies {
+		createWatchdogContainer(drained, name, labels, true)
+		createWatchdogContainer(crashed, "crashed-"+name, labels, true)
+	}
+
+	assert.NoError(t, w.Drain(drained, ""))
+	assert.Nil(t, drained.Containers().Get("drain"))
+	assert.Nil(t, drained.Containers().Get("both"))
+	assert.NotNil(t, drained.Containers().Get("failure"))
+	assert.NotNil(t, drained.Containers().Get("neither"))
+	assert.Len(t, alive.Containers(), 2)
+
+	// The node failures only move the containers with the on-node-failure
+	// policy.
+	drained.setState(stateUnhealthy)
+	assert.NoError(t, w.RescheduleEngine(crashed, TriggerEngineDisconnect))
+	assert.NotNil(t, crashed.Containers().Get("crashed-drain"))
+	assert.Nil(t, crashed.Containers().Get("crashed-both"))
+	assert.Nil(t, crashed.Containers().Get("crashed-failure"))
+	assert.NotNil(t, crashed.Containers().Get("crashed-neither"))
+	assert.Len(t, alive.Containers(), 4)
+}
+
+func TestSoftConstraint(t *testing.T) {
+	for constraint, expected := range map[string]string{
+		"rack==r2":      "rack==~r2",
+		"rack==~r2":     "rack==~r2",
+		"rack!=r1":      "rack!=~r1",
+		"kernel>=4.4.0": "kernel>=~4.4.0",
+	} {
+		soft, err := softConstraint(constraint)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, soft)
+	}
+	for _, invalid := range []string{"r2", "==r2", "rack=="} {
+		_, err := softConstraint(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestWatchdogHandlePressureEvent(t *testing.T) {
+	cl := &mockCluster{}
+	w := NewWatchdog(cl, nil)
+	engine := createWatchdogEngine("engine", true)
+
+	// Pressure events are ignored when eviction is disabled, and must not
+	// panic on a missing usage attribute.
+	assert.NoError(t, w.Handle(pressureEvent(engine, "engine_memory_pressure", "")))
+	assert.NoError(t, w.Handle(pressureEvent(engine, "engine_disk_pressure", "1")))
+}
+
+func TestWatchdogLogLevel(t *testing.T) {
+	std := log.StandardLogger()
+	out, level := std.Out, std.Level
+	defer func() {
+		log.SetOutput(out)
+		log.SetLevel(level)
+	}()
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+
+	// The watchdog logs at debug level while the manager logs at info level.
+	log.SetLevel(log.InfoLevel)
+	w := NewWatchdog(&mockCluster{}, &WatchdogOpts{LogLevel: "debug"})
+	log.Debug("manager debug")
+	w.log.Debug("watchdog debug")
+	assert.NotContains(t, buf.String(), "manager debug")
+	assert.Contains(t, buf.String(), "watchdog debug")
+	assert.Contains(t, buf.String(), "name=watchdog")
+
+	// And the other way around.
+	buf.Reset()
+	log.SetLevel(log.DebugLevel)
+	w = NewWatchdog(&mockCluster{}, &WatchdogOpts{LogLevel: "warn"})
+	log.Debug("manager debug")
+	w.log.Info("watchdog info")
+	w.log.Warn("watchdog warning")
+	assert.Contains(t, buf.String(), "manager debug")
+	assert.NotContains(t, buf.String(), "watchdog info")
+	assert.Contains(t, buf.String(), "watchdog warning")
+
+	// Without level, the watchdog follows the manager.
+	buf.Reset()
+	w = NewWatchdog(&mockCluster{}, &WatchdogOpts{})
+	log.SetLevel(log.InfoLevel)
+	w.log.Debug("watchdog debug")
+	assert.NotContains(t, buf.String(), "watchdog debug")
+}
+
+func TestNewWatchdogOpts(t *testing.T) {
+	opts, err := NewWatchdogOpts(DriverOpts{"memory-pressure-threshold=0.9", "disk-pressure-threshold=0.8", "pressure-eviction-max-priority=5", "pressure-eviction-limit=3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.9, opts.MemoryPressureThreshold)
+	assert.Equal(t, 0.8, opts.DiskPressureThreshold)
+	assert.Equal(t, 5, opts.PressureEvictionMaxPriority)
+	assert.Equal(t, 3, opts.PressureEvictionLimit)
+
+	_, err = NewWatchdogOpts(DriverOpts{"memory-pressure-threshold=1.5"})
+	assert.Error(t, err)
+
+	_, err = NewWatchdogOpts(DriverOpts{"pressure-eviction-limit=-1"})
+	assert.Error(t, err)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-quarantine-attempts=-1"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-retry-interval=2s", "reschedule-retry-max-interval=1m", "reschedule-retry-limit=4", "reschedule-pass-timeout=30s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, opts.RescheduleRetryInterval)
+	assert.Equal(t, time.Minute, opts.RescheduleRetryMaxInterval)
+	assert.Equal(t, 4, opts.RescheduleRetryLimit)
+	assert.Equal(t, 30*time.Second, opts.ReschedulePassTimeout)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-pre-outage-grace=45s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Second, opts.ReschedulePreOutageGrace)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-pre-outage-grace=-1s"})
+	assert.Error(t, err)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-pre-outage-grace=soon"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(nil)
+	assert.NoError(t, err)
+	assert.False(t, opts.RescheduleAutoRemove)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-auto-remove=true"})
+	assert.NoError(t, err)
+	assert.True(t, opts.RescheduleAutoRemove)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-health-timeout=2m", "reschedule-health-interval=5s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, opts.RescheduleHealthTimeout)
+	assert.Equal(t, 5*time.Second, opts.RescheduleHealthInterval)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-health-timeout=-1s"})
+	assert.Error(t, err)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-health-interval=0s"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-stop-timeout=30s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, opts.RescheduleStopTimeout)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-stop-timeout=-1s"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-rate-limit-backoff=1h"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, opts.RescheduleRateLimitBackoff)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-rate-limit-backoff=0s"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), opts.ReconcileInterval)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reconcile-interval=1m"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, opts.ReconcileInterval)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reconcile-interval=-1s"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"duplicate-removal-concurrency=4"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, opts.DuplicateRemovalConcurrency)
+
+	_, err = NewWatchdogOpts(DriverOpts{"duplicate-removal-concurrency=-1"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"duplicate-removal-grace=30s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, opts.DuplicateRemovalGrace)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"duplicate-removal-grace=0"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), opts.DuplicateRemovalGrace)
+
+	_, err = NewWatchdogOpts(DriverOpts{"duplicate-removal-grace=-1s"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"duplicate-removal-retries=2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, opts.DuplicateRemovalRetries)
+
+	_, err = NewWatchdogOpts(DriverOpts{"duplicate-removal-retries=-1"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"identity-label=com.example.id"})
+	assert.NoError(t, err)
+	assert.Equal(t, "com.example.id", opts.IdentityLabel)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"duplicate-refresh-full=true", "duplicate-refresh-retries=2"})
+	assert.NoError(t, err)
+	assert.True(t, opts.DuplicateRefreshFull)
+	assert.Equal(t, 2, opts.DuplicateRefreshRetries)
+
+	_, err = NewWatchdogOpts(DriverOpts{"duplicate-refresh-retries=-1"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"min-healthy-engines=2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, opts.MinHealthyEngines)
+
+	_, err = NewWatchdogOpts(DriverOpts{"min-healthy-engines=-1"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-engine-order=highest-priority"})
+	assert.NoError(t, err)
+	assert.Equal(t, EngineOrderHighestPriority, opts.RescheduleEngineOrder)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-engine-order=random"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"stale-container-policy=stop"})
+	assert.NoError(t, err)
+	assert.Equal(t, "stop", opts.StaleContainerPolicy)
+
+	_, err = NewWatchdogOpts(DriverOpts{"stale-container-policy=kill"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-safe-mode=true", "reschedule-capacity-margin=0.2"})
+	assert.NoError(t, err)
+	assert.True(t, opts.RescheduleSafeMode)
+	assert.Equal(t, 0.2, opts.RescheduleCapacityMargin)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-capacity-margin=1"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-memory-usage-weight=0.5"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, opts.RescheduleMemoryUsageWeight)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-sticky-window=10m", "reschedule-stickiness=require"})
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, opts.RescheduleStickyWindow)
+	assert.Equal(t, StickinessRequire, opts.RescheduleStickiness)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-stickiness=always"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-cooldown-count=3", "reschedule-cooldown-window=1m"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, opts.RescheduleCooldownCount)
+	assert.Equal(t, time.Minute, opts.RescheduleCooldownWindow)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-cpu-overcommit=1.5"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, opts.RescheduleCPUOvercommit)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-cpu-overcommit=0.5"})
+	assert.Error(t, err)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-cooldown-count=-1"})
+	assert.Error(t, err)
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-cooldown-window=0s"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"node-failure-breaker-threshold=3", "node-failure-breaker-window=2m"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, opts.NodeFailureBreakerThreshold)
+	assert.Equal(t, 2*time.Minute, opts.NodeFailureBreakerWindow)
+
+	_, err = NewWatchdogOpts(DriverOpts{"node-failure-breaker-threshold=-1"})
+	assert.Error(t, err)
+	_, err = NewWatchdogOpts(DriverOpts{"node-failure-breaker-window=0"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-max-inflight=4"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, opts.RescheduleMaxInflight)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-max-inflight=-1"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-prefer-cached-image=true"})
+	assert.NoError(t, err)
+	assert.True(t, opts.ReschedulePreferCachedImage)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-memory-usage-weight=2"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-local-mounts=true"})
+	assert.NoError(t, err)
+	assert.True(t, opts.RescheduleLocalMounts)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"disable-duplicate-removal=true"})
+	assert.NoError(t, err)
+	assert.True(t, opts.DisableDuplicateRemoval)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"log-level=debug"})
+	assert.NoError(t, err)
+	assert.Equal(t, "debug", opts.LogLevel)
+
+	_, err = NewWatchdogOpts(DriverOpts{"log-level=chatty"})
+	assert.Error(t, err)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-pass-timeout=0s"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"network-attach-attempts=5", "network-attach-retry-interval=2s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, opts.NetworkAttachAttempts)
+	assert.Equal(t, 2*time.Second, opts.NetworkAttachRetryInterval)
+
+	_, err = NewWatchdogOpts(DriverOpts{"network-attach-attempts=0"})
+	assert.Error(t, err)
+
+	assert.True(t, opts.RescheduleStoppedContainers)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"restart-loop-threshold=5", "restart-loop-window=1m", "restart-loop-cooldown=1h"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, opts.RestartLoopThreshold)
+	assert.Equal(t, time.Minute, opts.RestartLoopWindow)
+	assert.Equal(t, time.Hour, opts.RestartLoopCooldown)
+
+	_, err = NewWatchdogOpts(DriverOpts{"restart-loop-window=0s"})
+	assert.Error(t, err)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-retry-interval=soon"})
+	assert.Error(t, err)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-node-suitability=lifecycle=spot:avoid,tier=preemptible:forbid"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]NodeSuitability{"lifecycle=spot": SuitabilityAvoid, "tier=preemptible": SuitabilityForbid}, opts.RescheduleNodeSuitability)
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-active-windows=18:00-08:00", "reschedule-window-escalate=true"})
+	assert.NoError(t, err)
+	assert.Equal(t, TimeWindows{{Start: 18 * time.Hour, End: 8 * time.Hour}}, opts.RescheduleActiveWindows)
+	assert.True(t, opts.RescheduleWindowEscalate)
+
+	_, err = NewWatchdogOpts(DriverOpts{"reschedule-active-windows=nights"})
+	assert.Error(t, err)
+
+	for _, invalid := range []string{"lifecycle=spot", "lifecycle:avoid", "=spot:avoid", "lifecycle=spot:never"} {
+		_, err = NewWatchdogOpts(DriverOpts{"reschedule-node-suitability=" + invalid})
+		assert.Error(t, err, invalid)
+	}
+
+	opts, err = NewWatchdogOpts(DriverOpts{"reschedule-source-exclude=role=agents,tier=edge"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"role=agents", "tier=edge"}, opts.RescheduleSourceExclude)
+
+	for _, invalid := range []string{"role", "=agents", "role="} {
+		_, err = NewWatchdogOpts(DriverOpts{"reschedule-source-exclude=" + invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func rescheduleErrors(t *testing.T, err error) RescheduleErrors {
+	errs, ok := err.(RescheduleErrors)
+	if !assert.True(t, ok, "expected RescheduleErrors, got %v", err) {
+		t.FailNow()
+	}
+	return errs
+}
+
+func TestWatchdogRescheduleNoCapacity(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	cl := &mockCluster{engines: []*Engine{dead}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 2, RescheduleRetryInterval: time.Millisecond})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrNoCapacity, errs[0].Reason)
+	assert.Equal(t, "c1", errs[0].Container.ID)
+	assert.True(t, errs.Retryable())
+	// The container is kept on the engine so that it can be retried.
+	assert.NotNil(t, dead.Containers().Get("c1"))
+}
+
+func TestWatchdogRescheduleImagePull(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}, createErr: errors.New("Error: image library/foo:latest not found")}
+	// An image pull failure is not retried, the limit is never reached.
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: time.Hour})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrImagePull, errs[0].Reason)
+	assert.False(t, errs.Retryable())
+}
+
+func TestWatchdogReschedulePullRateLimit(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}, createErr: errors.New("toomanyrequests: You have reached your pull rate limit.")}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 3, RescheduleRetryInterval: time.Millisecond, RescheduleRateLimitBackoff: 50 * time.Millisecond})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	// The rate limited pulls are retried, but only after the rate limit
+	// backoff rather than the retry interval.
+	start := time.Now()
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	assert.Equal(t, 3, cl.calls)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrPullRateLimit, errs[0].Reason)
+	assert.True(t, errs.Retryable())
+	assert.NotNil(t, dead.Containers().Get("c1"))
+
+	events := handler.without("container_reschedule_failed")
+	assert.Equal(t, "image_pull_rate_limited", events[0].Status)
+	assert.Equal(t, "c1", events[0].Actor.Attributes["container"])
+	assert.NotEmpty(t, events[0].Actor.Attributes["retry_at"])
+
+	// The containers backing off are not attempted, the others are retried
+	// with the usual backoff.
+	wave := newRescheduleWave(context.Background(), dead, TriggerEngineDisconnect)
+	limited := createWatchdogContainer(dead, "c2", reschedulable, true)
+	wave.rateLimited["c1"] = time.Now().Add(time.Hour)
+	wave.rateLimited["c2"] = time.Now().Add(time.Minute)
+	cl.calls = 0
+	errs = reschedulePass(w, wave)
+	assert.Equal(t, 0, cl.calls)
+	until, ok := rateLimitedUntil(wave, errs)
+	assert.True(t, ok)
+	assert.Equal(t, wave.rateLimited["c2"], until)
+	errs = append(errs, &RescheduleError{Container: limited, Reason: ErrNoCapacity})
+	_, ok = rateLimitedUntil(wave, errs)
+	assert.False(t, ok)
+}
+
+func TestWatchdogRescheduleNetworkAttach(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("NetworkConnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("attach failed"))
+	apiClient.On("NetworkDisconnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	alive.apiClient = apiClient
+	cl := &mockCluster{
+		engines:  []*Engine{dead, alive},
+		networks: Networks{{NetworkResource: types.NetworkResource{ID: "overlay", Name: "overlay", Scope: "swarm"}, Engine: alive}},
+	}
+	handler := &recordingHandler{}
+	alive.eventHandler = handler
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: time.Hour, NetworkAttachRetryInterval: time.Millisecond})
+
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	c.Info.NetworkSettings = &types.NetworkSettings{
+		Networks: map[string]*networktypes.EndpointSettings{"overlay": {NetworkID: "overlay"}},
+	}
+
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrNetworkAttach, errs[0].Reason)
+	assert.Equal(t, alive, errs[0].Engine)
+	// The container has been recreated and started anyway.
+	assert.Len(t, alive.Containers(), 1)
+	assert.Len(t, cl.started, 1)
+
+	// Each attempt failed, the network is reported as degraded.
+	apiClient.AssertNumberOfCalls(t, "NetworkConnect", defaultNetworkAttachAttempts)
+	var degraded *Event
+	for _, e := range handler.events {
+		if e.Status == "container_network_degraded" {
+			degraded = e
+		}
+	}
+	if assert.NotNil(t, degraded) {
+		assert.Equal(t, "overlay", degraded.Actor.Attributes["networks"])
+		assert.Equal(t, alive.Containers()[0].ID, degraded.Actor.Attributes["container"])
+	}
+}
+
+func TestWatchdogRescheduleNetworkAttachRetry(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	apiClient := engineapimock.NewMockClient()
+	// Connecting fails twice, then succeeds.
+	apiClient.On("NetworkConnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("overlay hiccup")).Twice()
+	apiClient.On("NetworkConnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	apiClient.On("NetworkDisconnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	alive.apiClient = apiClient
+	handler := &recordingHandler{}
+	alive.eventHandler = handler
+	cl := &mockCluster{
+		engines:  []*Engine{dead, alive},
+		networks: Networks{{NetworkResource: types.NetworkResource{ID: "overlay", Name: "overlay", Scope: "swarm"}, Engine: alive}},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1, NetworkAttachAttempts: 3, NetworkAttachRetryInterval: time.Millisecond})
+
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	c.Info.NetworkSettings = &types.NetworkSettings{
+		Networks: map[string]*networktypes.EndpointSettings{"overlay": {NetworkID: "overlay"}},
+	}
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	apiClient.AssertNumberOfCalls(t, "NetworkConnect", 3)
+	assert.Len(t, alive.Containers(), 1)
+	for _, e := range handler.events {
+		assert.NotEqual(t, "container_network_degraded", e.Status)
+	}
+}
+
+func TestWatchdogReattachNetworks(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	apiClient := engineapimock.NewMockClient()
+	// Connecting to the second network fails the first time.
+	apiClient.On("NetworkConnect", mock.Anything, "n2", mock.Anything, mock.Anything).Return(errors.New("n2 down")).Once()
+	apiClient.On("NetworkConnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	apiClient.On("NetworkDisconnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	alive.apiClient = apiClient
+	handler := &recordingHandler{}
+	alive.eventHandler = handler
+	networks := Networks{}
+	for _, name := range []string{"n1", "n2", "n3"} {
+		networks = append(networks, &Network{NetworkResource: types.NetworkResource{ID: name, Name: name, Scope: "swarm"}, Engine: alive})
+	}
+	cl := &mockCluster{engines: []*Engine{dead, alive}, networks: networks}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1, NetworkAttachAttempts: 1})
+
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	connectContainer(c, map[string]string{"n1": "", "n2": "", "n3": ""})
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrNetworkAttach, errs[0].Reason)
+	assert.Equal(t, []string{"n1", "n2", "n3"}, networkCalls(alive, "NetworkConnect"))
+
+	// The sweep only retries the network which failed, once.
+	w.reattachNetworks()
+	assert.Equal(t, []string{"n1", "n2", "n3", "n2"}, networkCalls(alive, "NetworkConnect"))
+	w.reattachNetworks()
+	assert.Len(t, networkCalls(alive, "NetworkConnect"), 4)
+	var restored *Event
+	for _, e := range handler.events {
+		if e.Status == "container_network_restored" {
+			restored = e
+		}
+	}
+	if assert.NotNil(t, restored) {
+		assert.Equal(t, alive.Containers()[0].ID, restored.Actor.Attributes["container"])
+		assert.Equal(t, "c1", restored.Actor.Attributes["name"])
+	}
+}
+
+func TestWatchdogReattachNetworksStopped(t *testing.T) {
+	alive := createWatchdogEngine("alive", true)
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("NetworkConnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	alive.apiClient = apiClient
+	cl := &mockCluster{engines: []*Engine{alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{})
+
+	c := createWatchdogContainer(alive, "c1", nil, false)
+	w.detachedNetworks[c.ID] = map[string]*networktypes.EndpointSettings{"n1": {NetworkID: "n1"}}
+	w.detachedNetworks["gone"] = map[string]*networktypes.EndpointSettings{"n1": {NetworkID: "n1"}}
+
+	// The networks of a stopped container are retried once it is running,
+	// the containers which are gone are forgotten.
+	w.reattachNetworks()
+	assert.Empty(t, networkCalls(alive, "NetworkConnect"))
+	assert.Len(t, w.detachedNetworks, 1)
+	c.Info.State.Running = true
+	w.reattachNetworks()
+	assert.Equal(t, []string{"n1"}, networkCalls(alive, "NetworkConnect"))
+	assert.Empty(t, w.detachedNetworks)
+}
+
+func TestWatchdogRescheduleNetworkCleanup(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	cl := &mockCluster{engines: []*Engine{dead}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	c.Info.NetworkSettings = &types.NetworkSettings{
+		Networks: map[string]*networktypes.EndpointSettings{"overlay": {NetworkID: "overlay"}},
+	}
+
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrNetworkCleanup, errs[0].Reason)
+	assert.True(t, errs.Retryable())
+	assert.NotNil(t, dead.Containers().Get("c1"))
+}
+
+func TestWatchdogRescheduleNetworkOrder(t *testing.T) {
+	names := []string{"backend", "frontend", "mgmt", "storage"}
+	networks := Networks{}
+	for _, name := range names {
+		networks = append(networks, &Network{NetworkResource: types.NetworkResource{ID: name, Name: name, Scope: "swarm"}})
+	}
+
+	for _, test := range []struct {
+		label    string
+		expected []string
+	}{
+		{"", []string{"backend", "frontend", "mgmt", "storage"}},
+		{"mgmt, storage,unknown,mgmt", []string{"mgmt", "storage", "backend", "frontend"}},
+	} {
+		// The order doesn't depend on the iteration order of the networks.
+		for i := 0; i < 5; i++ {
+			dead := createWatchdogEngine("dead", false)
+			alive := createWatchdogEngine("alive", true)
+			for _, n := range networks {
+				n.Engine = alive
+			}
+			cl := &mockCluster{engines: []*Engine{dead, alive}, networks: networks}
+			w := NewWatchdog(cl, nil)
+
+			c := createWatchdogContainer(dead, "c1", reschedulable, true)
+			if test.label != "" {
+				c.Config.Labels[SwarmLabelNamespace+".network-order"] = test.label
+			}
+			c.Info.NetworkSettings = &types.NetworkSettings{Networks: map[string]*networktypes.EndpointSettings{}}
+			for _, name := range names {
+				c.Info.NetworkSettings.Networks[name] = &networktypes.EndpointSettings{NetworkID: name}
+			}
+
+			assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+			attached := []string{}
+			for _, call := range alive.apiClient.(*engineapimock.MockClient).Calls {
+				if call.Method == "NetworkConnect" {
+					attached = append(attached, call.Arguments.String(1))
+				}
+			}
+			assert.Equal(t, test.expected, attached)
+		}
+	}
+}
+
+// flakyCleanupCluster fails the lookups of the engine for network cleanup
+// until it is given one, and records whether the container being rescheduled
+// was still on its engine at each lookup.
+type flakyCleanupCluster struct {
+	*mockCluster
+	container *Container
+	failures  int
+	present   []bool
+}
+
+func (f *flakyCleanupCluster) RANDOMENGINE() (*Engine, error) {
+	f.present = append(f.present, f.container.Engine.Containers().Get(f.container.ID) == f.container)
+	if f.failures > 0 {
+		f.failures--
+		return nil, ErrNoHealthyEngine
+	}
+	return f.mockCluster.RANDOMENGINE()
+}
+
+func (f *flakyCleanupCluster) MoveContainer(container *Container, target *Engine, opts MoveOpts) (*MoveResult, error) {
+	return MoveContainer(f, container, target, opts)
+}
+
+func TestWatchdogRescheduleNetworkCleanupRetry(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	c.Info.NetworkSettings = &types.NetworkSettings{
+		Networks: map[string]*networktypes.EndpointSettings{"overlay": {NetworkID: "overlay"}},
+	}
+	cl := &flakyCleanupCluster{mockCluster: &mockCluster{engines: []*Engine{dead, alive}}, container: c, failures: 2}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 3, RescheduleRetryInterval: time.Millisecond})
+
+	// The container is left on its engine while no engine can do the
+	// cleanup, and rescheduled once one can.
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Equal(t, []bool{true, true, true}, cl.present)
+	assert.Len(t, dead.Containers(), 0)
+	assert.Len(t, alive.Containers(), 1)
+}
+
+func TestWatchdogRescheduleHostNetwork(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	// Any network call on the engines would fail the test.
+	alive.apiClient = engineapimock.NewMockClient()
+	cl := &mockCluster{
+		engines:  []*Engine{dead, alive},
+		networks: Networks{{NetworkResource: types.NetworkResource{ID: "host", Name: "host", Scope: "local"}, Engine: alive}},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	c.Config.HostConfig.NetworkMode = "host"
+	c.Config.NetworkingConfig.EndpointsConfig = map[string]*networktypes.EndpointSettings{"host": {NetworkID: "host"}}
+	c.Info.NetworkSettings = &types.NetworkSettings{
+		Networks: map[string]*networktypes.EndpointSettings{"host": {NetworkID: "host"}},
+	}
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, dead.Containers(), 0)
+	newContainer := alive.Containers()[0]
+	assert.Equal(t, containertypes.NetworkMode("host"), newContainer.Config.HostConfig.NetworkMode)
+	assert.Contains(t, newContainer.Config.NetworkingConfig.EndpointsConfig, "host")
+}
+
+func TestWatchdogRescheduleDedicatedNodes(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	system := createWatchdogEngine("system", true)
+	system.Labels[DedicatedLabel] = "system"
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, system, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	createWatchdogContainer(dead, "app", reschedulable, true)
+	agent := createWatchdogContainer(dead, "agent", reschedulable, true)
+	assert.NoError(t, agent.Config.AddConstraint("dedicated==system"))
+
+	// Only the tolerating container goes to the dedicated node.
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	if assert.Len(t, system.Containers(), 1) {
+		assert.Equal(t, "swarm-agent", system.Containers()[0].Config.SwarmID())
+	}
+	if assert.Len(t, alive.Containers(), 1) {
+		assert.Equal(t, "swarm-app", alive.Containers()[0].Config.SwarmID())
+	}
+
+	// The dedicated node is not a fallback.
+	alive.setState(stateUnhealthy)
+	dead = createWatchdogEngine("dead", false)
+	createWatchdogContainer(dead, "app2", reschedulable, true)
+	assert.Error(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, system.Containers(), 1)
+}
+
+func TestWatchdogRescheduleBandwidth(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	small := createWatchdogEngine("small", true)
+	small.Labels[NodeBandwidthLabel] = "10G"
+	big := createWatchdogEngine("big", true)
+	big.Labels[NodeBandwidthLabel] = "20G"
+	cl := &mockCluster{engines: []*Engine{dead, small, big}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	running := createWatchdogContainer(small, "running", nil, true)
+	setContainerLabel(running, BandwidthLabel, "5G")
+	// The containers sharing namespaces need 6Gbps together, more than
+	// what is left on the small node.
+	pause := createWatchdogContainer(dead, "pause", reschedulable, true)
+	setContainerLabel(pause, BandwidthLabel, "3G")
+	app := createWatchdogContainer(dead, "app", reschedulable, true)
+	setContainerLabel(app, BandwidthLabel, "3G")
+	app.Config.HostConfig.NetworkMode = "container:pause"
+	single := createWatchdogContainer(dead, "single", reschedulable, true)
+	setContainerLabel(single, BandwidthLabel, "4G")
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, dead.Containers(), 0)
+	assert.Len(t, big.Containers(), 2)
+	assert.NotNil(t, big.Containers().Get("swarm-pause"))
+	assert.NotNil(t, big.Containers().Get("swarm-app"))
+	assert.NotNil(t, small.Containers().Get("swarm-single"))
+	assert.Equal(t, int64(9000000000), small.UsedBandwidth())
+}
+
+func TestWatchdogNodeFailureBreaker(t *testing.T) {
+	dead1 := createWatchdogEngine("dead1", false)
+	dead2 := createWatchdogEngine("dead2", false)
+	dead3 := createWatchdogEngine("dead3", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	for _, e := range []*Engine{dead1, dead2, dead3} {
+		e.eventHandler = handler
+	}
+	cl := &mockCluster{engines: []*Engine{dead1, dead2, dead3, alive}}
+	window := 100 * time.Millisecond
+	w := NewWatchdog(cl, &WatchdogOpts{NodeFailureBreakerThreshold: 1, NodeFailureBreakerWindow: window})
+	defer w.Stop()
+
+	createWatchdogContainer(dead1, "c1", reschedulable, true)
+	createWatchdogContainer(dead2, "c2", reschedulable, true)
+	createWatchdogContainer(dead3, "c3", reschedulable, true)
+
+	// The first failure is below the threshold.
+	w.rescheduleContainers(dead1, TriggerEngineDisconnect)
+	assert.Len(t, dead1.Containers(), 0)
+
+	// The second one, right after, trips the breaker.
+	done := make(chan struct{})
+	go func() {
+		w.rescheduleContainers(dead2, TriggerEngineDisconnect)
+		close(done)
+	}()
+	time.Sleep(window / 2)
+	assert.Len(t, dead2.Containers(), 1)
+	events := handler.without("reschedule_summary")
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "reschedule_breaker_tripped", events[0].Status)
+		assert.Equal(t, "1", events[0].Actor.Attributes["threshold"])
+	}
+
+	// Once no node failed for a whole window, the breaker resets and the
+	// paused reschedule resumes.
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the reschedule was not resumed")
+	}
+	assert.Len(t, dead2.Containers(), 0)
+	events = handler.without("reschedule_summary")
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "reschedule_breaker_reset", events[1].Status)
+	}
+
+	// After a quiet period, a single failure is below the threshold again.
+	time.Sleep(2 * window)
+	w.rescheduleContainers(dead3, TriggerEngineDisconnect)
+	assert.Len(t, dead3.Containers(), 0)
+	assert.Len(t, handler.without("reschedule_summary"), 2)
+	assert.Len(t, alive.Containers(), 3)
+}
+
+func TestWatchdogRescheduleFollowAffinities(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	// The dependents reference their dependencies by ID, which changes once
+	// rescheduled.
+	named := func(id, name string, labels map[string]string) *Container {
+		c := createWatchdogContainer(dead, id, labels, true)
+		c.Names = []string{"/" + name}
+		c.Info.Name = "/" + name
+		return c
+	}
+	app := named("app-id", "app", reschedulable)
+	assert.NoError(t, app.Config.AddAffinity("container==db-id"))
+	assert.NoError(t, app.Config.AddAffinity("container!=~cache-id"))
+	named("db-id", "db", reschedulable)
+	// The cache is left in place, the affinity with it is relaxed.
+	named("cache-id", "cache", nil)
+	worker := named("worker-id", "worker", reschedulable)
+	assert.NoError(t, worker.Config.AddAffinity("container==cache-id"))
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	db := alive.Containers().Get("db")
+	if assert.NotNil(t, db) {
+		newApp := alive.Containers().Get("app")
+		if assert.NotNil(t, newApp) {
+			assert.Equal(t, []string{"container!=~cache-id", "container==" + db.ID}, newApp.Config.Affinities())
+		}
+	}
+	newWorker := alive.Containers().Get("worker")
+	if assert.NotNil(t, newWorker) {
+		assert.Equal(t, []string{"container==~cache-id"}, newWorker.Config.Affinities())
+	}
+	// The original configs are left untouched.
+	assert.Equal(t, []string{"container==db-id", "container!=~cache-id"}, app.Config.Affinities())
+}
+
+func TestWatchdogRescheduleSelfAffinity(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	// The affinities with the container itself, by ID or by name, could only
+	// be satisfied on the dead engine.
+	c := createWatchdogContainer(dead, "self-id", reschedulable, true)
+	c.Names = []string{"/self"}
+	c.Info.Name = "/self"
+	assert.NoError(t, c.Config.AddAffinity("container==self-id"))
+	assert.NoError(t, c.Config.AddAffinity("container!=~self"))
+	assert.NoError(t, c.Config.AddAffinity("image==~redis"))
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, dead.Containers(), 0)
+	moved := alive.Containers().Get("self")
+	if assert.NotNil(t, moved) {
+		assert.Equal(t, []string{"image==~redis"}, moved.Config.Affinities())
+	}
+	assert.Len(t, c.Config.Affinities(), 3)
+
+	// The same goes for the containers drained off a healthy node.
+	other := createWatchdogEngine("other", true)
+	cl.engines = append(cl.engines, other)
+	drained := createWatchdogContainer(alive, "drained-id", drainable, true)
+	assert.NoError(t, drained.Config.AddAffinity("container==drained-id"))
+	assert.NoError(t, w.Drain(alive, ""))
+	assert.Nil(t, alive.Containers().Get("drained-id"))
+	assert.Len(t, other.Containers(), 1)
+}
+
+func TestWatchdogRescheduleGPU(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	cpu := createWatchdogEngine("cpu", true)
+	gpu := createWatchdogEngine("gpu", true)
+	gpu.Labels[NodeGPUsLabel] = "2"
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, cpu, gpu}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	// The GPU container goes to the GPU node, the other one is placed
+	// anywhere.
+	training := createWatchdogContainer(dead, "training", reschedulable, true)
+	setContainerLabel(training, GPUsLabel, "2")
+	createWatchdogContainer(dead, "web", reschedulable, true)
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, dead.Containers(), 0)
+	assert.NotNil(t, gpu.Containers().Get("swarm-training"))
+	assert.Equal(t, int64(2), gpu.UsedGPUs())
+
+	// Once the GPUs of the GPU node are all reserved, the next GPU container
+	// doesn't fall back onto the CPU node.
+	inference := createWatchdogContainer(dead, "inference", reschedulable, true)
+	setContainerLabel(inference, GPUsLabel, "1")
+
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrNoGPUCapacity, errs[0].Reason)
+	assert.True(t, errs.Retryable())
+	assert.NotNil(t, dead.Containers().Get("inference"))
+	assert.Len(t, cpu.Containers(), 1)
+
+	events := handler.without("reschedule_summary")
+	if assert.NotEmpty(t, events) {
+		ev := events[len(events)-1]
+		assert.Equal(t, "container_reschedule_failed", ev.Status)
+		assert.Equal(t, "inference", ev.Actor.Attributes["container"])
+		assert.Contains(t, ev.Actor.Attributes["error"], "no GPU capacity")
+	}
+}
+
+func TestWatchdogRescheduleCpuset(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	small := createWatchdogEngine("small", true)
+	small.Cpus = 4
+	big := createWatchdogEngine("big", true)
+	big.Cpus = 8
+	cl := &mockCluster{engines: []*Engine{dead, small, big}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	// The pinned container goes to the node having its CPUs.
+	createWatchdogContainer(dead, "pinned", reschedulable, true).Config.HostConfig.CpusetCpus = "4-7"
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	if c := big.Containers().Get("swarm-pinned"); assert.NotNil(t, c) {
+		assert.Equal(t, "4-7", c.Config.HostConfig.CpusetCpus)
+	}
+
+	// No other node has them, the container isn't retried.
+	big.setState(stateUnhealthy)
+	errs := rescheduleErrors(t, w.RescheduleEngine(big, TriggerEngineDisconnect))
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, ErrCpusetUnavailable, errs[0].Reason)
+	}
+	assert.False(t, errs.Retryable())
+	assert.Len(t, small.Containers(), 0)
+
+	// Unless the pin is relaxed.
+	w = NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1, RescheduleRelaxCpuset: true})
+	assert.NoError(t, w.RescheduleEngine(big, TriggerEngineDisconnect))
+	if c := small.Containers().Get("swarm-pinned"); assert.NotNil(t, c) {
+		assert.Equal(t, "", c.Config.HostConfig.CpusetCpus)
+	}
+	// A pin the node can honor is kept.
+	createWatchdogContainer(dead, "low", reschedulable, true).Config.HostConfig.CpusetCpus = "0,1"
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	if c := small.Containers().Get("swarm-low"); assert.NotNil(t, c) {
+		assert.Equal(t, "0,1", c.Config.HostConfig.CpusetCpus)
+	}
+
+	opts, err := NewWatchdogOpts(DriverOpts{"reschedule-relax-cpuset=true"})
+	assert.NoError(t, err)
+	assert.True(t, opts.RescheduleRelaxCpuset)
+}
+
+func TestWatchdogRescheduleNodeSuitability(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	spot := createWatchdogEngine("spot", true)
+	spot.Labels["lifecycle"] = "spot"
+	onDemand := createWatchdogEngine("on-demand", true)
+	cl := &mockCluster{engines: []*Engine{dead, spot, onDemand}}
+	w := NewWatchdog(cl, &WatchdogOpts{
+		RescheduleRetryLimit:      1,
+		RescheduleNodeSuitability: map[string]NodeSuitability{"lifecycle=spot": SuitabilityAvoid},
+	})
+
+	// Without a preference, the spot node is avoided.
+	noPreference := createWatchdogContainer(dead, "c1", reschedulable, true)
+	// An explicit preference overrides the policy.
+	preference := createWatchdogContainer(dead, "c2", reschedulable, true)
+	assert.NoError(t, preference.Config.AddConstraint("lifecycle==spot"))
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, onDemand.Containers(), 1)
+	assert.Equal(t, []string{"lifecycle!=~spot"}, onDemand.Containers()[0].Config.Constraints())
+	assert.Len(t, spot.Containers(), 1)
+	assert.Equal(t, []string{"lifecycle==spot"}, spot.Containers()[0].Config.Constraints())
+	// The config of the original container is left untouched.
+	assert.Empty(t, noPreference.Config.Constraints())
+
+	// The spot node is used when no other node fits.
+	onDemand.setState(stateUnhealthy)
+	dead = createWatchdogEngine("dead", false)
+	createWatchdogContainer(dead, "c3", reschedulable, true)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, spot.Containers(), 2)
+
+	// Unless it is forbidden.
+	w.opts.RescheduleNodeSuitability["lifecycle=spot"] = SuitabilityForbid
+	dead = createWatchdogEngine("dead", false)
+	createWatchdogContainer(dead, "c4", reschedulable, true)
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Len(t, spot.Containers(), 2)
+	assert.NotNil(t, dead.Containers().Get("c4"))
+}
+
+func TestWatchdogReschedulePlacementOnly(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	sameRack := createWatchdogEngine("same-rack", true)
+	sameRack.Labels["rack"] = "r1"
+	cl := &mockCluster{engines: []*Engine{dead, sameRack}}
+	w := NewWatchdog(cl, nil)
+
+	// The containers are spread across racks, but only at placement.
+	labels := map[string]string{SwarmLabelNamespace + ".constraints": `["rack!=@r1","rack!=r2"]`}
+	for k, v := range reschedulable {
+		labels[k] = v
+	}
+	createWatchdogContainer(dead, "c1", labels, true)
+
+	_, err := cl.CreateContainer(dead.Containers()[0].Config, "c2", nil)
+	assert.Error(t, err)
+
+	w.rescheduleContainers(dead, TriggerEngineDisconnect)
+	if assert.Len(t, sameRack.Containers(), 1) {
+		constraints := sameRack.Containers()[0].Config.Constraints()
+		assert.NotContains(t, constraints, "rack!=@r1")
+		assert.Contains(t, constraints, "rack!=r2")
+	}
+	assert.Len(t, dead.Containers(), 0)
+}
+
+func TestWatchdogRescheduleNetworkCleanupUnhealthy(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	// The only other engine is unhealthy.
+	other := createWatchdogEngine("other", false)
+	apiClient := engineapimock.NewMockClient()
+	other.apiClient = apiClient
+	cl := &mockCluster{engines: []*Engine{dead, other}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 2, RescheduleRetryInterval: time.Millisecond})
+
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	c.Info.NetworkSettings = &types.NetworkSettings{
+		Networks: map[string]*networktypes.EndpointSettings{"overlay": {NetworkID: "overlay"}},
+	}
+
+	// The rescheduling backs off without attempting the cleanup.
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrNetworkCleanup, errs[0].Reason)
+	assert.Equal(t, ErrNoHealthyEngine, errs[0].Err)
+	assert.True(t, errs.Retryable())
+	apiClient.AssertNotCalled(t, "NetworkDisconnect", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assert.NotNil(t, dead.Containers().Get("c1"))
+}
+
+func TestWatchdogRescheduleConfigMutator(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	first := createWatchdogEngine("first", true)
+	second := createWatchdogEngine("second", true)
+	cl := &mockCluster{engines: []*Engine{dead, first, second}}
+	var targets []*Engine
+	// The mutator takes care of the node specific bind.
+	w := NewWatchdog(cl, &WatchdogOpts{
+		RescheduleRetryLimit:  1,
+		RescheduleLocalMounts: true,
+		RescheduleConfigMutator: func(c *Container, target *Engine) (*ContainerConfig, error) {
+			targets = append(targets, target)
+			config := *c.Config
+			config.HostConfig.Binds = nil
+			config.Env = []string{"AGENT_HOST=" + target.Name}
+			return &config, nil
+		},
+	})
+
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	c.Config.HostConfig.Binds = []string{"/var/run/agent.sock:/agent.sock"}
+	c.Config.Env = []string{"AGENT_HOST=dead"}
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Equal(t, []*Engine{first}, targets)
+	assert.Len(t, first.Containers(), 1)
+	newContainer := first.Containers()[0]
+	assert.Empty(t, newContainer.Config.HostConfig.Binds)
+	assert.Equal(t, []string{"AGENT_HOST=first"}, newContainer.Config.Env)
+	assert.Equal(t, []string{"node==first"}, newContainer.Config.Constraints())
+	assert.Equal(t, "swarm-c1", newContainer.Config.SwarmID())
+	// The original config is left untouched.
+	assert.Equal(t, []string{"AGENT_HOST=dead"}, c.Config.Env)
+	// The target reserved for the mutation is released once the container
+	// is created there.
+	assert.Equal(t, 0, cl.reserved)
+
+	// The pin doesn't prevent the next reschedule.
+	first.setState(stateUnhealthy)
+	assert.NoError(t, w.RescheduleEngine(first, TriggerEngineDisconnect))
+	assert.Equal(t, []*Engine{first, second}, targets)
+	assert.Len(t, second.Containers(), 1)
+	assert.Equal(t, []string{"node==second"}, second.Containers()[0].Config.Constraints())
+}
+
+func TestWatchdogRescheduleConfigMutatorError(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{
+		RescheduleRetryInterval: time.Millisecond,
+		RescheduleConfigMutator: func(c *Container, target *Engine) (*ContainerConfig, error) {
+			if c.ID == "c1" {
+				return nil, errors.New("device tied to the old host")
+			}
+			return c.Config, nil
+		},
+	})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+
+	// c1 is skipped and not retried, c2 is rescheduled.
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrConfigMutation, errs[0].Reason)
+	assert.Equal(t, alive, errs[0].Engine)
+	assert.False(t, errs.Retryable())
+	assert.NotNil(t, dead.Containers().Get("c1"))
+	assert.Len(t, alive.Containers(), 1)
+	assert.Equal(t, 1, cl.calls)
+	assert.Equal(t, 0, cl.reserved)
+
+	events := handler.without("reschedule_summary")
+	assert.Len(t, events, 1)
+	assert.Equal(t, "container_reschedule_failed", events[0].Status)
+	assert.Equal(t, "c1", events[0].Actor.Attributes["container"])
+}
+
+func TestWatchdogRescheduleRetry(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", false)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: time.Millisecond})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	done := make(chan error)
+	go func() { done <- w.RescheduleEngine(dead, TriggerEngineDisconnect) }()
+	time.Sleep(10 * time.Millisecond)
+	alive.setState(stateHealthy)
+
+	assert.NoError(t, <-done)
+	assert.Len(t, alive.Containers(), 1)
+	assert.Len(t, dead.Containers(), 0)
+}
+
+func TestWatchdogRescheduleQuarantine(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}, createErr: errors.New("no resources available to schedule container")}
+	opts, err := NewWatchdogOpts(DriverOpts{"reschedule-quarantine-attempts=3", "reschedule-retry-interval=1ms"})
+	assert.NoError(t, err)
+	w := NewWatchdog(cl, opts)
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	// Without retry limit, the container is quarantined after its third
+	// failed attempt, which completes the rescheduling of the engine.
+	w.rescheduleContainers(dead, TriggerEngineDisconnect)
+	assert.Equal(t, 3, cl.calls)
+	assert.True(t, w.handled[dead.ID])
+	quarantined := w.opts.Quarantine.List()
+	if assert.Len(t, quarantined, 1) {
+		assert.Equal(t, "c1", quarantined[0].ID)
+		assert.Equal(t, "c1", quarantined[0].Name)
+		assert.Equal(t, "dead", quarantined[0].Engine)
+		assert.Equal(t, 3, quarantined[0].Attempts)
+		assert.Contains(t, quarantined[0].Reason, "no resources")
+	}
+	var event *Event
+	for _, e := range handler.events {
+		if e.Status == "container_quarantined" {
+			event = e
+		}
+	}
+	if assert.NotNil(t, event) {
+		assert.Equal(t, "c1", event.Actor.Attributes["container"])
+		assert.Equal(t, "3", event.Actor.Attributes["attempts"])
+	}
+
+	// The quarantined container is not retried.
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerReconcile))
+	assert.Equal(t, 3, cl.calls)
+
+	// Once released, it is retried with a fresh attempt count.
+	cl.Lock()
+	cl.createErr = nil
+	cl.Unlock()
+	assert.False(t, w.opts.Quarantine.Release("c2"))
+	assert.True(t, w.opts.Quarantine.Release("c1"))
+	w.pending.Wait()
+	assert.Empty(t, w.opts.Quarantine.List())
+	assert.Len(t, dead.Containers(), 0)
+	if assert.Len(t, alive.Containers(), 1) {
+		assert.Equal(t, "swarm-c1", alive.Containers()[0].Config.SwarmID())
+	}
+	assert.Len(t, handler.without("container_quarantine_released"), len(handler.events)-1)
+}
+
+func TestWatchdogRescheduleProgressResetsBackoff(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	// Only the first creation of each pass succeeds: the 1st one of the
+	// first pass over 3 containers, then the 4th and the 6th.
+	cl := &mockCluster{
+		engines: []*Engine{dead, alive},
+		createHook: func(count int) error {
+			if count == 1 || count == 4 || count == 6 {
+				return nil
+			}
+			return errors.New("Unable to find a node that satisfies the following conditions")
+		},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: time.Millisecond, RescheduleRetryLimit: 2})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+	createWatchdogContainer(dead, "c3", reschedulable, true)
+
+	// Each pass makes progress, so the retry limit is never reached.
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 3)
+	assert.Equal(t, 6, cl.calls)
+
+	// Without progress, the limit applies.
+	dead = createWatchdogEngine("dead", false)
+	createWatchdogContainer(dead, "c4", reschedulable, true)
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, 8, cl.calls)
+}
+
+func TestWatchdogReschedulePassDeadline(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	// The first creation hangs until released.
+	release := make(chan struct{})
+	cl := &mockCluster{
+		engines: []*Engine{dead, alive},
+		createHook: func(count int) error {
+			if count == 1 {
+				<-release
+			}
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{ReschedulePassTimeout: 20 * time.Millisecond, RescheduleRetryInterval: time.Millisecond})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+
+	// The first pass is abandoned while the first creation hangs, and the
+	// other container is rescheduled by the next pass.
+	errs := rescheduleErrors(t, reschedulePass(w, newRescheduleWave(context.Background(), dead, TriggerEngineDisconnect)))
+	assert.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.Equal(t, ErrPassDeadline, err.Reason)
+	}
+	assert.True(t, errs.Retryable())
+	// The next passes leave the hanging container alone.
+	var hanging *Container
+	for _, err := range errs {
+		if w.isMoving(err.Container) {
+			hanging = err.Container
+		}
+	}
+	if !assert.NotNil(t, hanging) {
+		return
+	}
+	w.Lock()
+	err, moved := w.attemptReschedule(hanging, newRescheduleWave(context.Background(), dead, TriggerEngineDisconnect), nil, new(bool), time.NewTimer(time.Minute))
+	w.Unlock()
+	assert.False(t, moved)
+	assert.Equal(t, ErrPassDeadline, err.Reason)
+
+	done := make(chan error)
+	go func() { done <- w.RescheduleEngine(dead, TriggerEngineDisconnect) }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("rescheduling blocked by a hanging creation")
+	}
+	assert.Len(t, alive.Containers(), 1)
+
+	// The hanging creation eventually completes.
+	close(release)
+	for i := 0; i < 100 && (len(alive.Containers()) < 2 || w.isMoving(hanging)); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, alive.Containers(), 2)
+	assert.Len(t, dead.Containers(), 0)
+	assert.False(t, w.isMoving(hanging))
+}
+
+// inflightCreations reschedules two failed engines at once, and returns the
+// most creations in progress at once. Each creation waits a while for the
+// other one to be in progress too.
+func inflightCreations(t *testing.T, maxInflight int) int {
+	dead1 := createWatchdogEngine("dead1", false)
+	dead2 := createWatchdogEngine("dead2", false)
+	alive := createWatchdogEngine("alive", true)
+	var lock sync.Mutex
+	active, most := 0, 0
+	cl := &mockCluster{
+		engines: []*Engine{dead1, dead2, alive},
+		createHook: func(count int) error {
+			lock.Lock()
+			active++
+			if active > most {
+				most = active
+			}
+			lock.Unlock()
+			for i := 0; i < 100; i++ {
+				lock.Lock()
+				both := most == 2
+				lock.Unlock()
+				if both {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			lock.Lock()
+			active--
+			lock.Unlock()
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleMaxInflight: maxInflight})
+	defer w.Stop()
+	createWatchdogContainer(dead1, "c1", reschedulable, true)
+	createWatchdogContainer(dead2, "c2", reschedulable, true)
+
+	done := make(chan error, 2)
+	for _, e := range []*Engine{dead1, dead2} {
+		go func(e *Engine) { done <- w.RescheduleEngine(e, TriggerEngineDisconnect) }(e)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("rescheduling blocked")
+		}
+	}
+	assert.Len(t, alive.Containers(), 2)
+	assert.Equal(t, 2, cl.calls)
+
+	lock.Lock()
+	defer lock.Unlock()
+	return most
+}
+
+func TestWatchdogRescheduleMaxInflight(t *testing.T) {
+	// The engines compete for the single slot.
+	assert.Equal(t, 1, inflightCreations(t, 1))
+	// Without cap, the engines are rescheduled at once.
+	assert.Equal(t, 2, inflightCreations(t, 0))
+}
+
+// windowFrom returns a daily window starting and ending at the given offsets
+// from now.
+func windowFrom(now time.Time, start, end time.Duration) TimeWindow {
+	offset := now.Sub(midnight(now))
+	return TimeWindow{Start: (offset + start + day) % day, End: (offset + end + day) % day}
+}
+
+func TestWatchdogRescheduleWindows(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	now := time.Now()
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleActiveWindows: TimeWindows{windowFrom(now, time.Hour, 2*time.Hour)}})
+
+	// Outside of the active windows, nothing is rescheduled.
+	c1 := createWatchdogContainer(dead, "c1", reschedulable, true)
+	wave := newRescheduleWave(context.Background(), dead, TriggerEngineDisconnect)
+	errs := reschedulePass(w, wave)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, ErrOutsideWindow, errs[0].Reason)
+	assert.True(t, outsideWindows(errs))
+	assert.WithinDuration(t, now.Add(time.Hour), wave.nextWindow, time.Second)
+	assert.Len(t, alive.Containers(), 0)
+
+	// The label of a container overrides the active windows.
+	labels := map[string]string{
+		SwarmLabelNamespace + ".reschedule-policies": `["on-node-failure"]`,
+		SwarmLabelNamespace + ".reschedule-windows":  "00:00-24:00",
+	}
+	createWatchdogContainer(dead, "c2", labels, true)
+	errs = reschedulePass(w, wave)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, c1, errs[0].Container)
+	assert.Len(t, alive.Containers(), 1)
+
+	// Escalating reschedules the container right away.
+	w.opts.RescheduleWindowEscalate = true
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 2)
+	events := handler.without("reschedule_summary")
+	assert.Len(t, events, 1)
+	assert.Equal(t, "container_reschedule_escalated", events[0].Status)
+	assert.Equal(t, "c1", events[0].Actor.Attributes["container"])
+}
+
+func TestWatchdogRescheduleWindowQueue(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	// The window opens shortly.
+	w := NewWatchdog(cl, &WatchdogOpts{
+		RescheduleActiveWindows: TimeWindows{windowFrom(time.Now(), 50*time.Millisecond, time.Hour)},
+		RescheduleRetryLimit:    1,
+	})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	// Waiting for the window doesn't count as a failed attempt.
+	start := time.Now()
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+	assert.Len(t, alive.Containers(), 1)
+
+	// The queue is dropped when the watchdog stops.
+	dead = createWatchdogEngine("dead", false)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+	w.opts.RescheduleActiveWindows = TimeWindows{windowFrom(time.Now(), time.Hour, 2*time.Hour)}
+	done := make(chan error)
+	go func() { done <- w.RescheduleEngine(dead, TriggerEngineDisconnect) }()
+	time.Sleep(10 * time.Millisecond)
+	w.Stop()
+	assert.Equal(t, ErrWatchdogInactive, <-done)
+	assert.NotNil(t, dead.Containers().Get("c2"))
+}
+
+func TestWatchdogRescheduleSafeMode(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	alive.Memory = 4 << 30
+	alive.Cpus = 4
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleSafeMode: true, RescheduleCapacityMargin: 0.25})
+
+	for _, c := range []*Container{
+		createWatchdogContainer(dead, "c1", withPriority(1), true),
+		createWatchdogContainer(dead, "c2", withPriority(2), true),
+	} {
+		c.Config.HostConfig.Memory = 1 << 30
+		c.Config.HostConfig.CPUShares = 1
+	}
+
+	// The cluster can take all the containers.
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 2)
+	assert.Len(t, handler.without("reschedule_summary"), 0)
+}
+
+func TestWatchdogRescheduleSafeModeShortfall(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	alive.Memory = 2 << 30
+	alive.Cpus = 4
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleSafeMode: true, RescheduleCapacityMargin: 0.25, RescheduleRetryLimit: 1})
+
+	for _, c := range []*Container{
+		createWatchdogContainer(dead, "low", withPriority(1), true),
+		createWatchdogContainer(dead, "high", withPriority(10), true),
+		createWatchdogContainer(dead, "lowest", reschedulable, true),
+	} {
+		c.Config.HostConfig.Memory = 1 << 30
+	}
+
+	// Only 1.5GB out of the 2GB free may be used, the high priority
+	// container goes first and the others are deferred.
+	err := w.RescheduleEngine(dead, TriggerEngineDisconnect)
+	errs := rescheduleErrors(t, err)
+	assert.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.Equal(t, ErrInsufficientCapacity, err.Reason)
+		assert.True(t, err.Retryable())
+	}
+	if assert.Len(t, alive.Containers(), 1) {
+		assert.Equal(t, "swarm-high", alive.Containers()[0].Config.SwarmID())
+	}
+	assert.NotNil(t, dead.Containers().Get("low"))
+	assert.NotNil(t, dead.Containers().Get("lowest"))
+
+	if events := handler.without("reschedule_summary"); assert.Len(t, events, 1) {
+		ev := events[0]
+		assert.Equal(t, "reschedule_capacity_shortfall", ev.Status)
+		assert.Equal(t, fmt.Sprintf("%d", 3<<30), ev.Actor.Attributes["needed_memory"])
+		assert.Equal(t, fmt.Sprintf("%d", 2<<30), ev.Actor.Attributes["free_memory"])
+		assert.Equal(t, "2", ev.Actor.Attributes["deferred"])
+	}
+}
+
+func TestWatchdogMinHealthyEngines(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive1 := createWatchdogEngine("alive1", true)
+	alive2 := createWatchdogEngine("alive2", true)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive1, alive2}}
+	w := NewWatchdog(cl, &WatchdogOpts{MinHealthyEngines: 3, RescheduleRetryLimit: 1})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+	createWatchdogContainer(dead, "static", nil, true)
+
+	// Only 2 engines are healthy, the containers are left down.
+	err := w.RescheduleEngine(dead, TriggerEngineDisconnect)
+	errs := rescheduleErrors(t, err)
+	assert.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.Equal(t, ErrTooFewHealthyEngines, err.Reason)
+		assert.True(t, err.Retryable())
+	}
+	assert.Len(t, alive1.Containers(), 0)
+	assert.Len(t, alive2.Containers(), 0)
+	assert.Len(t, dead.Containers(), 3)
+	if events := handler.without("reschedule_summary"); assert.Len(t, events, 1) {
+		ev := events[0]
+		assert.Equal(t, "reschedule_min_healthy_engines", ev.Status)
+		assert.Equal(t, "2", ev.Actor.Attributes["healthy"])
+		assert.Equal(t, "3", ev.Actor.Attributes["minimum"])
+		assert.Equal(t, "2", ev.Actor.Attributes["deferred"])
+	}
+
+	// At the minimum, the containers are rescheduled.
+	handler = &recordingHandler{}
+	dead.eventHandler = handler
+	w = NewWatchdog(cl, &WatchdogOpts{MinHealthyEngines: 2, RescheduleRetryLimit: 1})
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive1.Containers(), 2)
+	assert.Len(t, dead.Containers(), 1)
+	assert.Len(t, handler.without("reschedule_summary"), 0)
+}
+
+func TestWatchdogRescheduleCPUOvercommit(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	alive.Memory = 4 << 30
+	alive.Cpus = 2
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleCPUOvercommit: 1.5, RescheduleRetryLimit: 1})
+
+	for _, c := range []*Container{
+		createWatchdogContainer(dead, "c1", withPriority(2), true),
+		createWatchdogContainer(dead, "c2", withPriority(1), true),
+	} {
+		c.Config.HostConfig.Memory = 1 << 30
+		c.Config.HostConfig.CPUShares = 1
+	}
+	createWatchdogContainer(dead, "c3", reschedulable, true).Config.HostConfig.CPUShares = 1
+	createWatchdogContainer(dead, "huge", reschedulable, true).Config.HostConfig.Memory = 5 << 30
+
+	// The node runs 3 CPUs worth of containers out of 2, but the
+	// container reserving more memory than it has stays down.
+	err := w.RescheduleEngine(dead, TriggerEngineDisconnect)
+	errs := rescheduleErrors(t, err)
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "huge", errs[0].Container.ID)
+	}
+	assert.Len(t, alive.Containers(), 3)
+	assert.Equal(t, int64(3), alive.UsedCpus())
+	for _, c := range alive.Containers() {
+		assert.Equal(t, "1.5", c.Config.Labels[SwarmLabelNamespace+".cpu-overcommit"])
+	}
+
+	// The ratio only applies to the reschedules, the containers asking for
+	// their own keep it.
+	assert.NotContains(t, dead.Containers().Get("huge").Config.Labels, SwarmLabelNamespace+".cpu-overcommit")
+	c := createWatchdogContainer(dead, "own", reschedulable, true)
+	c.Config.Labels[SwarmLabelNamespace+".cpu-overcommit"] = "2"
+	config, err := w.rescheduleConfig(c.Config)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", config.Labels[SwarmLabelNamespace+".cpu-overcommit"])
+
+	// The safe mode counts the CPUs which may be overcommitted as free.
+	dead = createWatchdogEngine("dead", false)
+	alive = createWatchdogEngine("alive", true)
+	alive.Memory = 4 << 30
+	alive.Cpus = 2
+	cl = &mockCluster{engines: []*Engine{dead, alive}}
+	w = NewWatchdog(cl, &WatchdogOpts{RescheduleSafeMode: true, RescheduleCPUOvercommit: 2, RescheduleRetryLimit: 1})
+	for _, ID := range []string{"c1", "c2"} {
+		createWatchdogContainer(dead, ID, reschedulable, true).Config.HostConfig.CPUShares = 2
+	}
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 2)
+}
+
+func TestWatchdogRescheduleLocalMounts(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{})
+
+	createWatchdogContainer(dead, "tmpfs", reschedulable, true).Config.HostConfig.Mounts = []mount.Mount{{Type: mount.TypeTmpfs, Target: "/tmp"}}
+	createWatchdogContainer(dead, "bind", reschedulable, true).Config.HostConfig.Binds = []string{"/srv/data:/data"}
+
+	// The container mounting a host path is left behind.
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "bind", errs[0].Container.ID)
+		assert.Equal(t, ErrLocalMount, errs[0].Reason)
+		assert.False(t, errs[0].Retryable())
+	}
+	if assert.Len(t, alive.Containers(), 1) {
+		assert.Equal(t, "swarm-tmpfs", alive.Containers()[0].Config.SwarmID())
+	}
+
+	// Unless enabled.
+	w = NewWatchdog(cl, &WatchdogOpts{RescheduleLocalMounts: true})
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 2)
+	assert.Len(t, dead.Containers(), 0)
+}
+
+func TestWatchdogRescheduleBackoff(t *testing.T) {
+	w := NewWatchdog(&mockCluster{}, &WatchdogOpts{RescheduleRetryInterval: time.Second, RescheduleRetryMaxInterval: 5 * time.Second})
+	assert.Equal(t, time.Second, w.rescheduleBackoff(1))
+	assert.Equal(t, 2*time.Second, w.rescheduleBackoff(2))
+	assert.Equal(t, 4*time.Second, w.rescheduleBackoff(3))
+	assert.Equal(t, 5*time.Second, w.rescheduleBackoff(4))
+	assert.Equal(t, 5*time.Second, w.rescheduleBackoff(100))
+}
+
+func TestClassifyCreateError(t *testing.T) {
+	assert.Equal(t, ErrImagePull, classifyCreateError(dockerclient.ErrImageNotFound))
+	assert.Equal(t, ErrImagePull, classifyCreateError(errors.New("Error: pull access denied for foo")))
+	assert.Equal(t, ErrPullRateLimit, classifyCreateError(errors.New("Error response from daemon: toomanyrequests: You have reached your pull rate limit")))
+	assert.Equal(t, ErrPullRateLimit, classifyCreateError(errors.New("429 Too Many Requests")))
+	assert.Equal(t, ErrNoCapacity, classifyCreateError(errors.New("Unable to find a node that satisfies the following conditions")))
+	// Having no node above the minimum health of the scheduler is a lack of
+	// capacity, not a failure of the container.
+	assert.Equal(t, ErrNoCapacity, classifyCreateError(errors.New("No node above the minimum health available in the cluster")))
+	assert.Equal(t, ErrNoGPUCapacity, classifyCreateError(errors.New("No node with enough free GPUs available in the cluster")))
+	assert.Equal(t, ErrCpusetUnavailable, classifyCreateError(errors.New("No node has the CPUs of the cpuset of the container: cpuset 0-15")))
+	assert.Nil(t, classifyCreateError(errors.New("something else")))
+}
+
+func TestWatchdogRescheduleMissingNetworkingConfig(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{
+		engines:  []*Engine{dead, alive},
+		networks: Networks{{NetworkResource: types.NetworkResource{ID: "overlay", Name: "overlay", Scope: "swarm"}, Engine: alive}},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1, RescheduleStoppedContainers: true})
+
+	// A container without networking config nor network settings.
+	bare := createWatchdogContainer(dead, "bare", reschedulable, true)
+	bare.Config.NetworkingConfig = networktypes.NetworkingConfig{}
+	bare.Info.NetworkSettings = nil
+
+	// A container with nil endpoints.
+	nilEndpoints := createWatchdogContainer(dead, "nil-endpoints", reschedulable, true)
+	nilEndpoints.Config.NetworkingConfig.EndpointsConfig = map[string]*networktypes.EndpointSettings{"bridge": nil}
+	nilEndpoints.Info.NetworkSettings = &types.NetworkSettings{
+		Networks: map[string]*networktypes.EndpointSettings{"overlay": nil},
+	}
+
+	// A container without inspect information can't be rescheduled, but
+	// must not prevent the others from being rescheduled.
+	noInfo := createWatchdogContainer(dead, "no-info", reschedulable, true)
+	noInfo.Info = types.ContainerJSON{}
+
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "no-info", errs[0].Container.ID)
+	assert.Len(t, alive.Containers(), 2)
+	assert.Len(t, cl.started, 2)
+}
+
+func TestWatchdogReschedulePanic(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}, createPanic: "/c1"}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+
+	// The panic only fails the rescheduling of c1.
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "c1", errs[0].Container.ID)
+	assert.True(t, errs.Retryable())
+	assert.Len(t, alive.Containers(), 1)
+	assert.Equal(t, "swarm-c2", alive.Containers()[0].Config.SwarmID())
+
+	// c1 is kept on the engine and rescheduled by the next attempt.
+	assert.NotNil(t, dead.Containers().Get("c1"))
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 2)
+}
+
+func TestWatchdogDemotePromote(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, nil)
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	// A demoted watchdog doesn't reschedule anything.
+	w.Demote()
+	assert.False(t, w.active())
+	assert.Equal(t, ErrWatchdogInactive, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 0)
+
+	// Once promoted, the containers of the failed engine are rescheduled.
+	w.Promote()
+	assert.True(t, w.active())
+	for i := 0; i < 100 && len(alive.Containers()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, alive.Containers(), 1)
+	assert.Len(t, dead.Containers(), 0)
+}
+
+func TestWatchdogDemoteAbandonsRetries(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	cl := &mockCluster{engines: []*Engine{dead}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: time.Hour})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	done := make(chan error)
+	go func() { done <- w.RescheduleEngine(dead, TriggerEngineDisconnect) }()
+	time.Sleep(10 * time.Millisecond)
+	w.Demote()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrWatchdogInactive, err)
+	case <-time.After(time.Second):
+		t.Fatal("reschedule was not abandoned")
+	}
+	assert.NotNil(t, dead.Containers().Get("c1"))
+}
+
+func TestWatchdogReconcile(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{ReconcileInterval: 10 * time.Millisecond})
+	defer w.Stop()
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	// The engine_disconnect event is lost, the sweep catches the failure.
+	started := func() int {
+		cl.Lock()
+		defer cl.Unlock()
+		return len(cl.started)
+	}
+	for i := 0; i < 100 && started() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, alive.Containers(), 1)
+	assert.Len(t, dead.Containers(), 0)
+	assert.Equal(t, 1, started())
+}
+
+func TestNewWatchdogCopiesOpts(t *testing.T) {
+	opts := &WatchdogOpts{}
+	w := NewWatchdog(&mockCluster{}, opts)
+	defer w.Stop()
+
+	// The defaults are not written back to the options of the caller.
+	assert.Equal(t, defaultRescheduleRetryInterval, w.opts.RescheduleRetryInterval)
+	assert.Equal(t, time.Duration(0), opts.RescheduleRetryInterval)
+	assert.Nil(t, opts.Quarantine)
+}
+
+func TestWatchdogReconcileInflight(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	release := make(chan struct{})
+	cl := &mockCluster{
+		engines: []*Engine{dead, alive},
+		createHook: func(count int) error {
+			<-release
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	done := make(chan struct{})
+	go func() {
+		w.rescheduleContainers(dead, TriggerEngineDisconnect)
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		cl.Lock()
+		calls := cl.calls
+		cl.Unlock()
+		if calls > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The sweep leaves the engine being rescheduled alone.
+	w.reconcile()
+	close(release)
+	<-done
+	assert.Len(t, alive.Containers(), 1)
+
+	// A handled engine isn't rescheduled again until it has been healthy.
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+	w.reconcile()
+	time.Sleep(10 * time.Millisecond)
+	cl.Lock()
+	assert.Equal(t, 1, cl.calls)
+	cl.Unlock()
+}
+
+func TestWatchdogRescheduleEngineOrder(t *testing.T) {
+	for order, expected := range map[string][]string{
+		EngineOrderMostContainers:  {"b", "c", "a"},
+		EngineOrderHighestPriority: {"a", "c", "b"},
+	} {
+		a := createWatchdogEngine("a", false)
+		b := createWatchdogEngine("b", false)
+		c := createWatchdogEngine("c", false)
+		alive := createWatchdogEngine("alive", true)
+		cl := &mockCluster{engines: []*Engine{a, b, c, alive}}
+		w := NewWatchdog(cl, &WatchdogOpts{RescheduleEngineOrder: order, RescheduleRetryLimit: 1})
+
+		// The containers left in place are not counted.
+		createWatchdogContainer(a, "a1", withPriority(9), true)
+		createWatchdogContainer(a, "a2", nil, true)
+		createWatchdogContainer(a, "a3", nil, true)
+		createWatchdogContainer(a, "a4", nil, true)
+		createWatchdogContainer(b, "b1", reschedulable, true)
+		createWatchdogContainer(b, "b2", reschedulable, true)
+		createWatchdogContainer(b, "b3", reschedulable, true)
+		createWatchdogContainer(c, "c1", withPriority(1), true)
+		createWatchdogContainer(c, "c2", withPriority(1), true)
+
+		// The engines found by the sweep are rescheduled one after the
+		// other, in the configured order.
+		w.reconcile()
+		for i := 0; i < 500 && len(alive.Containers()) < 6; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Len(t, alive.Containers(), 6, order)
+		cl.Lock()
+		engines := []string{}
+		for _, op := range cl.ops {
+			if !strings.HasPrefix(op, "create /") {
+				continue
+			}
+			engine := strings.TrimPrefix(op, "create /")[:1]
+			if len(engines) == 0 || engines[len(engines)-1] != engine {
+				engines = append(engines, engine)
+			}
+		}
+		cl.Unlock()
+		assert.Equal(t, expected, engines, order)
+	}
+}
+
+func TestWatchdogCancelReschedule(t *testing.T) {
+	back := createWatchdogEngine("back", false)
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	release := make(chan struct{})
+	cl := &mockCluster{
+		engines: []*Engine{back, dead, alive},
+		createHook: func(count int) error {
+			if count == 1 {
+				<-release
+			}
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{})
+
+	createWatchdogContainer(back, "b1", reschedulable, true)
+	createWatchdogContainer(back, "b2", reschedulable, true)
+	createWatchdogContainer(dead, "d1", reschedulable, true)
+	createWatchdogContainer(dead, "d2", reschedulable, true)
+
+	done := make(chan struct{})
+	go func() {
+		w.rescheduleContainers(back, TriggerEngineDisconnect)
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		cl.Lock()
+		calls := cl.calls
+		cl.Unlock()
+		if calls > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The container being rescheduled completes, the other one stays.
+	assert.True(t, w.CancelReschedule("back"))
+	close(release)
+	<-done
+	assert.Len(t, alive.Containers(), 1)
+	assert.Len(t, back.Containers(), 1)
+	assert.False(t, w.CancelReschedule("back"))
+
+	// The other engines are not affected.
+	w.rescheduleContainers(dead, TriggerEngineDisconnect)
+	assert.Len(t, alive.Containers(), 3)
+	assert.Len(t, dead.Containers(), 0)
+	assert.False(t, w.CancelReschedule("dead"))
+
+	// Canceling the wait between two passes.
+	wait := NewWatchdog(&mockCluster{engines: []*Engine{back}}, &WatchdogOpts{RescheduleRetryInterval: time.Hour})
+	result := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { result <- wait.rescheduleEngine(ctx, back, TriggerEngineDisconnect, nil) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-result:
+		assert.Equal(t, ErrRescheduleCanceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("reschedule was not canceled")
+	}
+}
+
+func TestWatchdogReschedulePreOutageGrace(t *testing.T) {
+	flap, _ := fencingEngine("flap")
+	flap.setState(stateUnhealthy)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{flap, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{ReschedulePreOutageGrace: time.Hour})
+
+	createWatchdogContainer(flap, "f1", reschedulable, true)
+
+	// The engine reconnects within the grace, its containers stay.
+	done := make(chan struct{})
+	go func() {
+		w.rescheduleContainers(flap, TriggerEngineDisconnect)
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		w.enginesLock.Lock()
+		waiting := w.grace["flap"]
+		w.enginesLock.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	flap.setState(stateHealthy)
+	assert.NoError(t, w.Handle(&Event{Message: events.Message{From: "swarm", Status: "engine_reconnect"}, Engine: flap}))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reschedule was not canceled")
+	}
+	w.pending.Wait()
+	assert.Len(t, flap.Containers(), 1)
+	assert.Len(t, alive.Containers(), 0)
+	assert.False(t, w.CancelReschedule("flap"))
+
+	// The engine doesn't come back, its containers are rescheduled after the
+	// grace.
+	dead := createWatchdogEngine("dead", false)
+	cl.engines = []*Engine{dead, alive}
+	createWatchdogContainer(dead, "d1", reschedulable, true)
+	w.opts.ReschedulePreOutageGrace = 10 * time.Millisecond
+	w.rescheduleContainers(dead, TriggerEngineDisconnect)
+	assert.Len(t, dead.Containers(), 0)
+	assert.Len(t, alive.Containers(), 1)
+
+	// The other triggers don't wait.
+	w.opts.ReschedulePreOutageGrace = time.Hour
+	createWatchdogContainer(dead, "d2", reschedulable, true)
+	w.rescheduleContainers(dead, TriggerReconcile)
+	assert.Len(t, dead.Containers(), 0)
+	assert.Len(t, alive.Containers(), 2)
+}
+
+func TestWatchdogReconnectDuringReschedule(t *testing.T) {
+	back, _ := fencingEngine("back")
+	back.setState(stateUnhealthy)
+	other := createWatchdogEngine("other", true)
+	var w *Watchdog
+	// The engine reconnects while its first container is being rescheduled.
+	cl := &mockCluster{
+		engines: []*Engine{back, other},
+		createHook: func(count int) error {
+			if count == 1 {
+				assert.NoError(t, w.Handle(&Event{Message: events.Message{From: "swarm", Status: "engine_reconnect"}, Engine: back}))
+			}
+			return nil
+		},
+	}
+	w = NewWatchdog(cl, nil)
+
+	createWatchdogContainer(back, "c1", reschedulable, true)
+	createWatchdogContainer(back, "c2", reschedulable, true)
+	createWatchdogContainer(back, "c3", reschedulable, true)
+
+	w.rescheduleContainers(back, TriggerEngineDisconnect)
+	w.pending.Wait()
+	// The container being rescheduled completes, the others stay.
+	assert.Equal(t, 1, cl.calls)
+	assert.Len(t, other.Containers(), 1)
+	assert.Len(t, back.Containers(), 2)
+	w.enginesLock.Lock()
+	assert.False(t, w.handled["back"])
+	w.enginesLock.Unlock()
+	assert.False(t, w.CancelReschedule("back"))
+}
+
+func TestWatchdogRescheduleSwitch(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	s := NewRescheduleSwitch(nil, "")
+	w := NewWatchdog(cl, &WatchdogOpts{Switch: s})
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	// The disconnection is reported but nothing is rescheduled.
+	assert.NoError(t, s.SetEnabled(false))
+	assert.NoError(t, w.Handle(&Event{Message: events.Message{From: "swarm", Status: "engine_disconnect"}, Engine: dead}))
+	w.pending.Wait()
+	assert.Len(t, dead.Containers(), 1)
+	assert.Len(t, alive.Containers(), 0)
+	handler.Lock()
+	assert.Len(t, handler.events, 1)
+	assert.Equal(t, "reschedule_disabled", handler.events[0].Status)
+	assert.Equal(t, string(TriggerEngineDisconnect), handler.events[0].Actor.Attributes["trigger"])
+	handler.Unlock()
+	w.reconcile()
+	assert.Len(t, dead.Containers(), 1)
+
+	// The engine is picked up by the reconciliation sweep once enabled.
+	assert.NoError(t, s.SetEnabled(true))
+	w.reconcile()
+	for i := 0; i < 100 && len(alive.Containers()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, dead.Containers(), 0)
+	assert.Len(t, alive.Containers(), 1)
+}
+
+func TestWatchdogRescheduleSourceExcluded(t *testing.T) {
+	agents := createWatchdogEngine("agents", false)
+	agents.Labels[RescheduleSourceExcludedLabel] = "true"
+	edge := createWatchdogEngine("edge", false)
+	edge.Labels["tier"] = "edge"
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	agents.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{agents, edge, dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleSourceExclude: []string{"tier=edge"}})
+
+	createWatchdogContainer(agents, "agent", reschedulable, true)
+	createWatchdogContainer(edge, "proxy", reschedulable, true)
+	createWatchdogContainer(dead, "app", reschedulable, true)
+
+	// The containers of the excluded nodes are left in place, whatever
+	// their policy.
+	for _, e := range []*Engine{agents, edge, dead} {
+		assert.NoError(t, w.Handle(&Event{Message: events.Message{From: "swarm", Status: "engine_disconnect"}, Engine: e}))
+	}
+	w.pending.Wait()
+	assert.NotNil(t, agents.Containers().Get("agent"))
+	assert.NotNil(t, edge.Containers().Get("proxy"))
+	if assert.Len(t, alive.Containers(), 1) {
+		assert.Equal(t, "swarm-app", alive.Containers()[0].Config.SwarmID())
+	}
+	if assert.Len(t, handler.events, 1) {
+		assert.Equal(t, "reschedule_source_excluded", handler.events[0].Status)
+		assert.Equal(t, string(TriggerEngineDisconnect), handler.events[0].Actor.Attributes["trigger"])
+	}
+
+	// The reconciliation sweep leaves them alone too.
+	w.reconcile()
+	time.Sleep(10 * time.Millisecond)
+	assert.NotNil(t, agents.Containers().Get("agent"))
+	assert.NotNil(t, edge.Containers().Get("proxy"))
+	assert.Len(t, alive.Containers(), 1)
+}
+
+// healthEngine creates a healthy engine whose containers report the given
+// health statuses, in turn, the last one repeating.
+func healthEngine(ID string, statuses ...string) *Engine {
+	e := createWatchdogEngine(ID, true)
+	apiClient := e.apiClient.(*engineapimock.MockClient)
+	for i, status := range statuses {
+		call := apiClient.On("ContainerInspect", mock.Anything, mock.Anything).Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				State: &types.ContainerState{Running: true, Health: &types.Health{Status: status}},
+			},
+		}, nil)
+		if i < len(statuses)-1 {
+			call.Once()
+		}
+	}
+	return e
+}
+
+func TestWatchdogRescheduleHealthTimeout(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	handler := &recordingHandler{}
+	opts := &WatchdogOpts{RescheduleHealthTimeout: 50 * time.Millisecond, RescheduleHealthInterval: time.Millisecond}
+
+	// The container passes its healthcheck within the timeout.
+	alive := healthEngine("alive", types.Starting, types.Starting, types.Healthy)
+	alive.eventHandler = handler
+	w := NewWatchdog(&mockCluster{engines: []*Engine{dead, alive}}, opts)
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	alive.apiClient.(*engineapimock.MockClient).AssertNumberOfCalls(t, "ContainerInspect", 3)
+	assert.Len(t, alive.Containers(), 1)
+
+	// The container stays unhealthy and is reported degraded.
+	sick := healthEngine("sick", types.Unhealthy)
+	sick.eventHandler = handler
+	w = NewWatchdog(&mockCluster{engines: []*Engine{dead, sick}}, opts)
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+	start := time.Now()
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+	assert.Len(t, sick.Containers(), 1)
+
+	handler.Lock()
+	defer handler.Unlock()
+	degraded := []string{}
+	for _, e := range handler.events {
+		if e.Status == "container_health_degraded" {
+			degraded = append(degraded, e.Actor.Attributes["container"])
+		}
+	}
+	assert.Equal(t, []string{sick.Containers()[0].ID}, degraded)
+}
+
+// namespaceGroup creates a group of two containers on an engine, the second
+// one joining the network namespace of the first one by name and its IPC
+// namespace by ID.
+func namespaceGroup(e *Engine, owner, member string, memory int64) (*Container, *Container) {
+	o := createWatchdogContainer(e, owner, reschedulable, true)
+	o.Config.HostConfig.Memory = memory
+	m := createWatchdogContainer(e, member, reschedulable, true)
+	m.Config.HostConfig.Memory = memory
+	m.Config.HostConfig.NetworkMode = containertypes.NetworkMode("container:" + owner)
+	m.Config.HostConfig.IpcMode = containertypes.IpcMode("container:" + owner)
+	return o, m
+}
+
+func TestWatchdogRescheduleNamespaceGroup(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	small := createWatchdogEngine("small", true)
+	small.Memory = 1024
+	big := createWatchdogEngine("big", true)
+	big.Memory = 2048
+	cl := &mockCluster{engines: []*Engine{dead, small, big}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	// Alone, each container would go to the first engine.
+	namespaceGroup(dead, "owner", "member", 600)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, dead.Containers(), 0)
+	assert.Len(t, small.Containers(), 0)
+	if assert.Len(t, big.Containers(), 2) {
+		owner, member := big.Containers().Get("owner"), big.Containers().Get("member")
+		// The owner is rescheduled first, and the member joins it.
+		assert.Equal(t, "new-1", owner.ID)
+		assert.Equal(t, containertypes.NetworkMode("container:new-1"), member.Config.HostConfig.NetworkMode)
+		assert.Equal(t, containertypes.IpcMode("container:new-1"), member.Config.HostConfig.IpcMode)
+	}
+	// The target reserved for the group is released once its members are
+	// rescheduled.
+	assert.Equal(t, 0, cl.reserved)
+}
+
+func TestWatchdogRescheduleNamespaceGroupNoFit(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	first := createWatchdogEngine("first", true)
+	first.Memory = 1024
+	second := createWatchdogEngine("second", true)
+	second.Memory = 1024
+	cl := &mockCluster{engines: []*Engine{dead, first, second}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	// The group doesn't fit on a single engine, it stays in place.
+	namespaceGroup(dead, "owner", "member", 600)
+	alone := createWatchdogContainer(dead, "alone", reschedulable, true)
+	alone.Config.HostConfig.Memory = 600
+
+	errs := rescheduleErrors(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.Equal(t, ErrNamespaceGroup, err.Reason)
+		assert.True(t, err.Retryable())
+	}
+	assert.Len(t, dead.Containers(), 2)
+	assert.NotNil(t, dead.Containers().Get("owner"))
+	assert.NotNil(t, dead.Containers().Get("member"))
+	// Only the container outside of the group was created.
+	assert.Equal(t, 1, len(first.Containers())+len(second.Containers()))
+	assert.Equal(t, 1, cl.calls)
+}
+
+func TestWatchdogRescheduleAutoRemove(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		dead := createWatchdogEngine("dead", false)
+		alive := createWatchdogEngine("alive", true)
+		cl := &mockCluster{engines: []*Engine{dead, alive}}
+		w := NewWatchdog(cl, &WatchdogOpts{RescheduleAutoRemove: include})
+
+		transient := createWatchdogContainer(dead, "transient", reschedulable, true)
+		transient.Config.HostConfig.AutoRemove = true
+		createWatchdogContainer(dead, "durable", reschedulable, true)
+
+		assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+		assert.NotNil(t, alive.Containers().Get("durable"))
+		if include {
+			assert.Len(t, dead.Containers(), 0)
+			assert.NotNil(t, alive.Containers().Get("transient"))
+		} else {
+			assert.Len(t, dead.Containers(), 1)
+			assert.NotNil(t, dead.Containers().Get("transient"))
+			assert.Nil(t, alive.Containers().Get("transient"))
+		}
+	}
+}
+
+func TestWatchdogRescheduleAttemptLabels(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	attempts := []string{}
+	cl := &mockCluster{
+		engines: []*Engine{dead, alive},
+		createHook: func(count int) error {
+			attempts = append(attempts, c.Config.Labels[RescheduleAttemptLabel])
+			if count < 3 {
+				return errors.New("no resources available to schedule container")
+			}
+			return nil
+		},
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 2, RescheduleRetryInterval: time.Millisecond})
+
+	// The labels track the attempts.
+	assert.Error(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Equal(t, []string{"1", "2"}, attempts)
+	assert.Equal(t, "2", c.Config.Labels[RescheduleAttemptLabel])
+	assert.Equal(t, ErrNoCapacity.Error()+": no resources available to schedule container", c.Config.Labels[RescheduleLastErrorLabel])
+	assert.NotNil(t, dead.Containers().Get("c1"))
+
+	// The count goes on, and the new container starts afresh.
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Equal(t, []string{"1", "2", "3"}, attempts)
+	if assert.Len(t, alive.Containers(), 1) {
+		labels := alive.Containers()[0].Config.Labels
+		assert.NotContains(t, labels, RescheduleAttemptLabel)
+		assert.NotContains(t, labels, RescheduleLastErrorLabel)
+		assert.Equal(t, "swarm-c1", alive.Containers()[0].Config.SwarmID())
+	}
+}
+
+func TestWatchdogSkipsAlreadyRescheduled(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, nil)
+
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	// The previous primary already rescheduled the container.
+	rescheduled := createWatchdogContainer(alive, "c1-new", reschedulable, true)
+	rescheduled.Config.SetSwarmID(c.Config.SwarmID())
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 1)
+	assert.Len(t, dead.Containers(), 0)
+	assert.Equal(t, 0, cl.created)
+}
+
+func TestWatchdogResumeCheckpoint(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	c := createWatchdogContainer(dead, "c1", reschedulable, true)
+	kv := &fakeKVStore{values: make(map[string][]byte)}
+	checkpoints := NewKVCheckpointStore(kv, "swarm/watchdog/checkpoint")
+
+	var w *Watchdog
+	attempts := []string{}
+	cl := &mockCluster{
+		engines: []*Engine{dead, alive},
+		createHook: func(count int) error {
+			attempts = append(attempts, c.Config.Labels[RescheduleAttemptLabel])
+			switch count {
+			case 1:
+				return errors.New("no resources available to schedule container")
+			case 2:
+				// The manager loses the primary status in the middle of
+				// the second pass.
+				w.Demote()
+				return errors.New("no resources available to schedule container")
+			}
+			return nil
+		},
+	}
+	w = NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: time.Millisecond, Checkpoints: checkpoints})
+
+	// The abandoned wave is left in the checkpoint.
+	assert.Equal(t, ErrWatchdogInactive, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	checkpoint, err := checkpoints.Load()
+	assert.NoError(t, err)
+	if assert.Contains(t, checkpoint.Engines, "dead") {
+		state := checkpoint.Engines["dead"]
+		assert.Equal(t, TriggerEngineDisconnect, state.Trigger)
+		assert.Equal(t, 1, state.Attempt)
+		assert.Equal(t, map[string]int{"c1": 1}, state.Attempts)
+	}
+
+	// The new primary doesn't know about the attempts, it resumes them from
+	// the checkpoint.
+	delete(c.Config.Labels, RescheduleAttemptLabel)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	w = NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: time.Millisecond, Checkpoints: checkpoints})
+	w.pending.Wait()
+	assert.Equal(t, []string{"1", "2", "2"}, attempts)
+	assert.NotNil(t, alive.Containers().Get("c1"))
+	if events := handler.without("container_rescheduled"); assert.Len(t, events, 1) {
+		assert.Equal(t, string(TriggerEngineDisconnect), events[0].Actor.Attributes["trigger"])
+	}
+	checkpoint, err = checkpoints.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, checkpoint.Engines)
+
+	// The engines which came back are forgotten.
+	checkpoint.Engines["alive"] = &EngineCheckpoint{Trigger: TriggerEngineDisconnect}
+	assert.NoError(t, checkpoints.Save(checkpoint))
+	w = NewWatchdog(cl, &WatchdogOpts{Checkpoints: checkpoints})
+	w.pending.Wait()
+	checkpoint, err = checkpoints.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, checkpoint.Engines)
+	assert.Equal(t, 3, cl.calls)
+}
+
+func TestWatchdogStop(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, nil)
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	w.Stop()
+	// Promoting a stopped watchdog doesn't restart it.
+	w.Demote()
+	w.Promote()
+	assert.False(t, w.active())
+	assert.Equal(t, ErrWatchdogInactive, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	assert.Len(t, alive.Containers(), 0)
+}
+
+func TestWatchdogRescheduleStoppedContainers(t *testing.T) {
+	for _, rescheduleStopped := range []bool{true, false} {
+		dead := createWatchdogEngine("dead", false)
+		alive := createWatchdogEngine("alive", true)
+		cl := &mockCluster{engines: []*Engine{dead, alive}}
+		opts, err := NewWatchdogOpts(DriverOpts{fmt.Sprintf("reschedule-stopped-containers=%t", rescheduleStopped)})
+		assert.NoError(t, err)
+		w := NewWatchdog(cl, opts)
+
+		createWatchdogContainer(dead, "running", reschedulable, true)
+		createWatchdogContainer(dead, "stopped", reschedulable, false)
+
+		assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+		// Only the running container is started.
+		assert.Len(t, cl.started, 1)
+		if rescheduleStopped {
+			assert.Len(t, alive.Containers(), 2)
+			assert.Len(t, dead.Containers(), 0)
+		} else {
+			assert.Len(t, alive.Containers(), 1)
+			assert.Equal(t, "swarm-running", alive.Containers()[0].Config.SwarmID())
+			assert.NotNil(t, dead.Containers().Get("stopped"))
+		}
+	}
+}
+
+func TestWatchdogRescheduleHonorRestartNo(t *testing.T) {
+	for _, honor := range []bool{true, false} {
+		dead := createWatchdogEngine("dead", false)
+		alive := createWatchdogEngine("alive", true)
+		cl := &mockCluster{engines: []*Engine{dead, alive}}
+		opts, err := NewWatchdogOpts(DriverOpts{fmt.Sprintf("reschedule-honor-restart-no=%t", honor)})
+		assert.NoError(t, err)
+		assert.Equal(t, honor, opts.RescheduleHonorRestartNo)
+		w := NewWatchdog(cl, opts)
+
+		for policy, running := range map[string]bool{"no": true, "always": true, "": true, "on-failure": false} {
+			c := createWatchdogContainer(dead, "c-"+policy, reschedulable, running)
+			c.Config.HostConfig.RestartPolicy.Name = policy
+		}
+
+		assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+		// All the containers are moved, the running ones with the "no"
+		// policy are only started if the policy is not honored.
+		assert.Len(t, alive.Containers(), 4)
+		started := []string{}
+		for _, c := range cl.started {
+			started = append(started, c.Config.HostConfig.RestartPolicy.Name)
+		}
+		sort.Strings(started)
+		if honor {
+			assert.Equal(t, []string{"", "always"}, started)
+		} else {
+			assert.Equal(t, []string{"", "always", "no"}, started)
+		}
+	}
+}
+
+func TestWatchdogRescheduleRestartReason(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	alive.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleHonorRestartNo: true, RescheduleStoppedContainers: true})
+
+	for name, policy := range map[string]string{"running": "always", "no": "no", "stopped": "always", "unless-stopped": "unless-stopped"} {
+		c := createWatchdogContainer(dead, name, reschedulable, name == "running" || name == "no")
+		c.Config.HostConfig.RestartPolicy.Name = policy
+	}
+
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	restarts := map[string]string{}
+	for _, ev := range handler.without("reschedule_summary") {
+		assert.Equal(t, "container_rescheduled", ev.Status)
+		restarts[ev.Actor.Attributes["container"]] = ev.Actor.Attributes["restarted"] + " " + ev.Actor.Attributes["restart_reason"]
+	}
+	assert.Equal(t, map[string]string{
+		"running":        "true was-running",
+		"no":             "false restart-policy-no",
+		"stopped":        "false was-stopped",
+		"unless-stopped": "false unless-stopped",
+	}, restarts)
+	assert.Len(t, cl.started, 1)
+}
+
+func TestWatchdogRemoveDuplicateContainers(t *testing.T) {
+	back := createWatchdogEngine("back", true)
+	apiClient := engineapimock.NewMockClient()
+	// Keep the containers of the engine as they are.
+	apiClient.On("ContainerList", mock.Anything, mock.Anything).Return([]types.Container{}, errors.New("refresh failed"))
+	apiClient.On("ContainerRemove", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	back.apiClient = apiClient
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{back, other}}
+	w := NewWatchdog(cl, nil)
+
+	createWatchdogContainer(back, "dup", reschedulable, true)
+	createWatchdogContainer(back, "passive", map[string]string{SwarmLabelNamespace + ".no-auto-dedup": "true"}, true)
+	createWatchdogContainer(other, "dup", reschedulable, true)
+	createWatchdogContainer(other, "passive", nil, true)
+
+	w.removeDuplicateContainers(back)
+
+	// Only the exempted duplicate is preserved.
+	assert.Nil(t, back.Containers().Get("dup"))
+	assert.NotNil(t, back.Containers().Get("passive"))
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 1)
+}
+
+func TestWatchdogDuplicateRemovalPolicy(t *testing.T) {
+	back, apiClient := fencingEngine("back")
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{back, other}}
+	var vetoed []string
+	w := NewWatchdog(cl, &WatchdogOpts{
+		DuplicateRemovalPolicy: func(dup, survivor *Container) bool {
+			assert.Equal(t, other, survivor.Engine)
+			if dup.ID == "newer" {
+				vetoed = append(vetoed, dup.ID)
+				return false
+			}
+			return true
+		},
+	})
+
+	createWatchdogContainer(back, "older", reschedulable, true)
+	createWatchdogContainer(back, "newer", reschedulable, true)
+	createWatchdogContainer(other, "older", reschedulable, true)
+	createWatchdogContainer(other, "newer", reschedulable, true)
+
+	w.removeDuplicateContainers(back)
+
+	// The vetoed duplicate is kept, the other one is removed.
+	assert.Equal(t, []string{"newer"}, vetoed)
+	assert.Nil(t, back.Containers().Get("older"))
+	assert.NotNil(t, back.Containers().Get("newer"))
+	apiClient.AssertCalled(t, "ContainerRemove", mock.Anything, "older", mock.Anything)
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 1)
+}
+
+func TestWatchdogRemoveDuplicatesConcurrently(t *testing.T) {
+	back := createWatchdogEngine("back", true)
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{back, other}}
+	w := NewWatchdog(cl, &WatchdogOpts{DuplicateRemovalConcurrency: 8})
+
+	// The removals are slow, the returning node has many duplicates.
+	var (
+		lock            sync.Mutex
+		inflight, maxed int
+	)
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("ContainerList", mock.Anything, mock.Anything).Return([]types.Container{}, errors.New("refresh failed"))
+	apiClient.On("ContainerRemove", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		lock.Lock()
+		inflight++
+		if inflight > maxed {
+			maxed = inflight
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		inflight--
+		lock.Unlock()
+	})
+	back.apiClient = apiClient
+	for i := 0; i < 40; i++ {
+		id := fmt.Sprintf("c%d", i)
+		createWatchdogContainer(back, id, reschedulable, true)
+		createWatchdogContainer(other, id, reschedulable, true)
+	}
+	createWatchdogContainer(back, "unique", reschedulable, true)
+
+	w.removeDuplicateContainers(back)
+
+	// Each duplicate is removed once, at most 8 at a time.
+	assert.Len(t, back.Containers(), 1)
+	assert.NotNil(t, back.Containers().Get("unique"))
+	assert.Len(t, other.Containers(), 40)
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 40)
+	assert.True(t, maxed > 1, "the duplicates are removed one at a time")
+	assert.True(t, maxed <= 8, "%d duplicates are removed at once", maxed)
+}
+
+func TestWatchdogVerifyDuplicateRemoval(t *testing.T) {
+	back := createWatchdogEngine("back", true)
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{back, other}}
+	w := NewWatchdog(cl, &WatchdogOpts{DuplicateRemovalRetries: 2})
+
+	// The first removal succeeds but leaves the duplicate in place.
+	refresh := mock.MatchedBy(func(opts types.ContainerListOptions) bool { return opts.Filters.Len() == 0 })
+	lookup := mock.MatchedBy(func(opts types.ContainerListOptions) bool { return opts.Filters.Len() > 0 })
+	apiClient := engineapimock.NewMockClient()
+	apiClient.On("ContainerList", mock.Anything, refresh).Return([]types.Container{}, errors.New("refresh failed"))
+	apiClient.On("ContainerList", mock.Anything, lookup).Return([]types.Container{{ID: "dup"}}, nil).Once()
+	apiClient.On("ContainerList", mock.Anything, lookup).Return([]types.Container{}, nil)
+	apiClient.On("ContainerRemove", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	back.apiClient = apiClient
+	createWatchdogContainer(back, "dup", reschedulable, true)
+	createWatchdogContainer(other, "dup", reschedulable, true)
+
+	w.removeDuplicateContainers(back)
+	assert.Empty(t, back.Containers())
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 2)
+
+	// The retries are bounded.
+	stuck := engineapimock.NewMockClient()
+	stuck.On("ContainerList", mock.Anything, refresh).Return([]types.Container{}, errors.New("refresh failed"))
+	stuck.On("ContainerList", mock.Anything, lookup).Return([]types.Container{{ID: "dup"}}, nil)
+	stuck.On("ContainerRemove", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	back.apiClient = stuck
+	dup := createWatchdogContainer(back, "dup", reschedulable, true)
+	assert.EqualError(t, w.removeDuplicate(back, dup), "still on the node after 3 removals")
+	stuck.AssertNumberOfCalls(t, "ContainerRemove", 3)
+}
+
+func TestWatchdogRefreshBeforeDuplicateRemoval(t *testing.T) {
+	// The returning node still runs the container rescheduled while it was
+	// gone, which the manager forgot, but fails its first refresh.
+	returning := func(refreshes ...error) (*Engine, *engineapimock.MockClient, *recordingHandler) {
+		back := createWatchdogEngine("back", true)
+		handler := &recordingHandler{}
+		back.eventHandler = handler
+		apiClient := engineapimock.NewMockClient()
+		for _, err := range refreshes {
+			apiClient.On("ContainerList", mock.Anything, mock.Anything).Return([]types.Container{}, err).Once()
+		}
+		apiClient.On("ContainerList", mock.Anything, mock.Anything).Return([]types.Container{{ID: "dup", Names: []string{"/dup"}}}, nil)
+		apiClient.On("ContainerInspect", mock.Anything, "dup").Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         "dup",
+				Name:       "/dup",
+				State:      &types.ContainerState{Running: true},
+				HostConfig: &containertypes.HostConfig{},
+			},
+			Config:          &containertypes.Config{Labels: map[string]string{SwarmLabelNamespace + ".id": "swarm-dup"}},
+			NetworkSettings: &types.NetworkSettings{},
+		}, nil)
+		apiClient.On("ContainerRemove", mock.Anything, "dup", mock.Anything).Return(nil)
+		back.apiClient = apiClient
+		return back, apiClient, handler
+	}
+	other := createWatchdogEngine("other", true)
+	createWatchdogContainer(other, "dup", reschedulable, true)
+
+	// The failed refresh is retried, the duplicate is found and removed.
+	back, apiClient, handler := returning(errors.New("connection reset"))
+	w := NewWatchdog(&mockCluster{engines: []*Engine{back, other}}, &WatchdogOpts{DuplicateRefreshRetries: 1})
+	w.removeDuplicateContainers(back)
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 1)
+	assert.Empty(t, back.Containers())
+	for _, ev := range handler.without("") {
+		assert.NotEqual(t, "duplicate_refresh_failed", ev.Status)
+	}
+
+	// Once the retries are exhausted, the failure is reported rather than
+	// the node passing for having no duplicates.
+	back, apiClient, handler = returning(errors.New("connection reset"), errors.New("connection reset"))
+	w = NewWatchdog(&mockCluster{engines: []*Engine{back, other}}, &WatchdogOpts{DuplicateRefreshRetries: 1})
+	w.removeDuplicateContainers(back)
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 0)
+	if events := handler.without(""); assert.Len(t, events, 1) {
+		ev := events[0]
+		assert.Equal(t, "duplicate_refresh_failed", ev.Status)
+		assert.Equal(t, "connection reset", ev.Actor.Attributes["error"])
+		assert.Equal(t, "2", ev.Actor.Attributes["attempts"])
+		assert.Equal(t, "0", ev.Actor.Attributes["known"])
+	}
+}
+
+func TestWatchdogDuplicateRemovalGrace(t *testing.T) {
+	back, apiClient := fencingEngine("back")
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{back, other}}
+	w := NewWatchdog(cl, &WatchdogOpts{DuplicateRemovalGrace: 50 * time.Millisecond})
+
+	createWatchdogContainer(back, "web", reschedulable, true)
+	createWatchdogContainer(other, "web", reschedulable, true)
+
+	// The duplicate is removed once the grace is over.
+	start := time.Now()
+	w.removeDuplicateContainers(back)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Nil(t, back.Containers().Get("web"))
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 1)
+}
+
+func TestWatchdogDuplicateRemovalGraceUnstable(t *testing.T) {
+	back, apiClient := fencingEngine("back")
+	other := createWatchdogEngine("other", true)
+	flapping := createWatchdogEngine("flapping", true)
+	cl := &mockCluster{engines: []*Engine{back, other, flapping}}
+	// The duplicates are found before the grace.
+	found := make(chan struct{})
+	w := NewWatchdog(cl, &WatchdogOpts{
+		DuplicateRemovalGrace: 100 * time.Millisecond,
+		DuplicateRemovalPolicy: func(dup, survivor *Container) bool {
+			if dup.ID == "db" {
+				close(found)
+			}
+			return true
+		},
+	})
+
+	createWatchdogContainer(back, "web", reschedulable, true)
+	createWatchdogContainer(back, "db", reschedulable, true)
+	createWatchdogContainer(back, "cache", reschedulable, true)
+	survivor := createWatchdogContainer(other, "web", reschedulable, true)
+	createWatchdogContainer(flapping, "db", reschedulable, true)
+	createWatchdogContainer(other, "cache", reschedulable, true)
+
+	done := make(chan struct{})
+	go func() {
+		w.removeDuplicateContainers(back)
+		close(done)
+	}()
+	<-found
+	// The survivor of web disappears, the node of db's goes away again
+	// during the grace.
+	other.removeContainer(survivor)
+	flapping.setState(stateUnhealthy)
+	<-done
+
+	// Only the duplicate of the stable cache is removed.
+	assert.NotNil(t, back.Containers().Get("web"))
+	assert.NotNil(t, back.Containers().Get("db"))
+	assert.Nil(t, back.Containers().Get("cache"))
+	apiClient.AssertCalled(t, "ContainerRemove", mock.Anything, "cache", mock.Anything)
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 1)
+}
+
+func TestWatchdogIdentityLabel(t *testing.T) {
+	back, apiClient := fencingEngine("back")
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{back, other}}
+	w := NewWatchdog(cl, &WatchdogOpts{IdentityLabel: "com.example.id"})
+
+	// The containers created directly on the engines have no swarm ID, they
+	// are identified by the label, whatever their swarm ID.
+	external := func(e *Engine, ID, identity string) *Container {
+		c := createWatchdogContainer(e, ID, map[string]string{"com.example.id": identity}, true)
+		c.Config.SetSwarmID("")
+		return c
+	}
+	external(back, "web-1", "web")
+	external(back, "db-1", "db")
+	createWatchdogContainer(back, "plain", nil, true).Config.SetSwarmID("")
+	createWatchdogContainer(other, "web-2", map[string]string{"com.example.id": "web"}, true)
+	external(other, "cache", "db-replica")
+
+	w.removeDuplicateContainers(back)
+
+	// Only the duplicate of the label-identified container is removed.
+	assert.Nil(t, back.Containers().Get("web-1"))
+	assert.NotNil(t, back.Containers().Get("db-1"))
+	assert.NotNil(t, back.Containers().Get("plain"))
+	apiClient.AssertCalled(t, "ContainerRemove", mock.Anything, "web-1", mock.Anything)
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 1)
+
+	// The label-identified containers are known to be rescheduled already.
+	dead := createWatchdogEngine("dead", false)
+	db := external(dead, "db-2", "db")
+	assert.True(t, w.rescheduledElsewhere(db))
+	assert.False(t, w.rescheduledElsewhere(external(dead, "queue", "queue")))
+
+	// Without the identity label, the swarm ID is the only identity.
+	w = NewWatchdog(cl, nil)
+	assert.False(t, w.rescheduledElsewhere(db))
+	external(back, "web-3", "web")
+	w.removeDuplicateContainers(back)
+	assert.NotNil(t, back.Containers().Get("web-3"))
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 1)
+}
+
+func fencingEngine(ID string) (*Engine, *engineapimock.MockClient) {
+	e := createWatchdogEngine(ID, true)
+	apiClient := engineapimock.NewMockClient()
+	// Keep the containers of the engine as they are.
+	apiClient.On("ContainerList", mock.Anything, mock.Anything).Return([]types.Container{}, errors.New("refresh failed"))
+	apiClient.On("ContainerRemove", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	apiClient.On("ContainerStop", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	apiClient.On("ContainerKill", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	e.apiClient = apiClient
+	return e, apiClient
+}
+
+func TestWatchdogFenceStaleContainers(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{dead, other}}
+	w := NewWatchdog(cl, nil)
+
+	// The rescheduled containers are stale.
+	c1 := createWatchdogContainer(dead, "c1", reschedulable, true)
+	w.rescheduleContainers(dead, TriggerEngineDisconnect)
+	assert.True(t, w.isStale(c1))
+
+	back, apiClient := fencingEngine("back")
+	cl.engines = append(cl.engines, back)
+	dup := createWatchdogContainer(back, "dup", reschedulable, true)
+	zombie := createWatchdogContainer(back, "zombie", reschedulable, true)
+	kept := createWatchdogContainer(back, "kept", map[string]string{SwarmLabelNamespace + ".no-auto-dedup": "true"}, true)
+	createWatchdogContainer(back, "current", reschedulable, true)
+	createWatchdogContainer(other, "dup", reschedulable, true)
+	for _, c := range []*Container{dup, zombie, kept} {
+		w.markStale(c)
+	}
+
+	w.FenceStaleContainers(back)
+
+	// Only the zombie is removed, the duplicate is left to the deduplication.
+	assert.Nil(t, back.Containers().Get("zombie"))
+	assert.NotNil(t, back.Containers().Get("dup"))
+	assert.NotNil(t, back.Containers().Get("kept"))
+	assert.NotNil(t, back.Containers().Get("current"))
+	apiClient.AssertNumberOfCalls(t, "ContainerRemove", 1)
+	apiClient.AssertNotCalled(t, "ContainerStop", mock.Anything, mock.Anything, mock.Anything)
+	assert.False(t, w.isStale(zombie))
+	assert.False(t, w.isStale(dup))
+	assert.True(t, w.isStale(kept))
+}
+
+func TestWatchdogFenceStaleContainersStop(t *testing.T) {
+	back, apiClient := fencingEngine("back")
+	cl := &mockCluster{engines: []*Engine{back}}
+	w := NewWatchdog(cl, &WatchdogOpts{StaleContainerPolicy: "stop"})
+
+	running := createWatchdogContainer(back, "running", reschedulable, true)
+	stopped := createWatchdogContainer(back, "stopped", reschedulable, false)
+	w.markStale(running)
+	w.markStale(stopped)
+
+	w.FenceStaleContainers(back)
+
+	// The zombie is stopped but kept.
+	assert.NotNil(t, back.Containers().Get("running"))
+	apiClient.AssertCalled(t, "ContainerStop", mock.Anything, "running", mock.Anything)
+	apiClient.AssertNumberOfCalls(t, "ContainerStop", 1)
+	apiClient.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+	assert.False(t, w.isStale(running))
+	assert.False(t, w.isStale(stopped))
+}
+
+// fenceCalls returns the calls of the API client of a fenced engine, as
+// "method container".
+func fenceCalls(apiClient *engineapimock.MockClient) []string {
+	calls := []string{}
+	for _, call := range apiClient.Calls {
+		if call.Method != "ContainerList" {
+			calls = append(calls, call.Method+" "+call.Arguments.String(1))
+		}
+	}
+	return calls
+}
+
+func TestWatchdogFenceMode(t *testing.T) {
+	graceful := map[string]string{SwarmLabelNamespace + ".fence-mode": "graceful", SwarmLabelNamespace + ".fence-timeout": "30s"}
+	kill := map[string]string{SwarmLabelNamespace + ".fence-mode": "kill"}
+	timeout := 30 * time.Second
+
+	// The graceful containers are stopped before being removed.
+	back, apiClient := fencingEngine("back")
+	cl := &mockCluster{engines: []*Engine{back}}
+	w := NewWatchdog(cl, nil)
+	w.markStale(createWatchdogContainer(back, "db", graceful, true))
+	w.FenceStaleContainers(back)
+	assert.Equal(t, []string{"ContainerStop db", "ContainerRemove db"}, fenceCalls(apiClient))
+	apiClient.AssertCalled(t, "ContainerStop", mock.Anything, "db", &timeout)
+	assert.Nil(t, back.Containers().Get("db"))
+
+	back, apiClient = fencingEngine("back")
+	cl.engines = []*Engine{back}
+	w.markStale(createWatchdogContainer(back, "web", kill, true))
+	w.FenceStaleContainers(back)
+	assert.Equal(t, []string{"ContainerRemove web"}, fenceCalls(apiClient))
+
+	// With the stop policy, the killed containers aren't stopped.
+	back, apiClient = fencingEngine("back")
+	cl.engines = []*Engine{back}
+	w = NewWatchdog(cl, &WatchdogOpts{StaleContainerPolicy: "stop"})
+	w.markStale(createWatchdogContainer(back, "web", kill, true))
+	w.markStale(createWatchdogContainer(back, "db", graceful, true))
+	w.FenceStaleContainers(back)
+	apiClient.AssertCalled(t, "ContainerKill", mock.Anything, "web", "KILL")
+	apiClient.AssertNotCalled(t, "ContainerStop", mock.Anything, "web", mock.Anything)
+	apiClient.AssertCalled(t, "ContainerStop", mock.Anything, "db", &timeout)
+	apiClient.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+	assert.NotNil(t, back.Containers().Get("web"))
+	assert.NotNil(t, back.Containers().Get("db"))
+
+	// The duplicates are stopped as gracefully.
+	back, apiClient = fencingEngine("back")
+	other := createWatchdogEngine("other", true)
+	cl.engines = []*Engine{back, other}
+	w = NewWatchdog(cl, nil)
+	createWatchdogContainer(back, "db", graceful, true)
+	createWatchdogContainer(other, "db", graceful, true)
+	w.removeDuplicateContainers(back)
+	assert.Equal(t, []string{"ContainerStop db", "ContainerRemove db"}, fenceCalls(apiClient))
+	assert.NotNil(t, other.Containers().Get("db"))
+}
+
+func TestWatchdogDisableDuplicateRemoval(t *testing.T) {
+	std := log.StandardLogger()
+	out := std.Out
+	defer log.SetOutput(out)
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+
+	back, apiClient := fencingEngine("back")
+	handler := &recordingHandler{}
+	back.eventHandler = handler
+	other := createWatchdogEngine("other", true)
+	cl := &mockCluster{engines: []*Engine{back, other}}
+	w := NewWatchdog(cl, &WatchdogOpts{DisableDuplicateRemoval: true})
+
+	createWatchdogContainer(back, "dup", reschedulable, true)
+	createWatchdogContainer(other, "dup", reschedulable, true)
+
+	assert.NoError(t, w.Handle(&Event{Message: events.Message{From: "swarm", Status: "engine_reconnect"}, Engine: back}))
+	var duplicate *Event
+	for i := 0; i < 100 && duplicate == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		handler.Lock()
+		for _, e := range handler.events {
+			if e.Status == "container_duplicate" {
+				duplicate = e
+			}
+		}
+		handler.Unlock()
+	}
+
+	// The duplicate is reported but kept.
+	if assert.NotNil(t, duplicate) {
+		assert.Equal(t, "dup", duplicate.Actor.Attributes["container"])
+		assert.Equal(t, "other", duplicate.Actor.Attributes["node"])
+	}
+	assert.Contains(t, buf.String(), "duplicate removal is disabled")
+	assert.NotNil(t, back.Containers().Get("dup"))
+	apiClient.AssertNotCalled(t, "ContainerRemove", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestWatchdogRescheduleSubSecondInterval(t *testing.T) {
+	w := NewWatchdog(&mockCluster{}, &WatchdogOpts{RescheduleRetryInterval: 500 * time.Millisecond, RescheduleRetryMaxInterval: 1500 * time.Millisecond})
+	assert.Equal(t, 500*time.Millisecond, w.rescheduleBackoff(1))
+	assert.Equal(t, time.Second, w.rescheduleBackoff(2))
+	assert.Equal(t, 1500*time.Millisecond, w.rescheduleBackoff(3))
+
+	dead := createWatchdogEngine("dead", false)
+	cl := &mockCluster{engines: []*Engine{dead}}
+	w = NewWatchdog(cl, &WatchdogOpts{RescheduleRetryInterval: 500 * time.Millisecond, RescheduleRetryLimit: 2})
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+
+	// A single retry waits for the 500ms interval, not a whole second.
+	start := time.Now()
+	assert.Error(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 500*time.Millisecond, "retried after %s", elapsed)
+	assert.True(t, elapsed < time.Second, "retried after %s", elapsed)
+}
+
+func TestWatchdogRestartLoop(t *testing.T) {
+	node1 := createWatchdogEngine("node1", true)
+	node2 := createWatchdogEngine("node2", true)
+	cl := &mockCluster{engines: []*Engine{node1, node2}}
+	w := NewWatchdog(cl, &WatchdogOpts{RestartLoopThreshold: 3, RestartLoopWindow: time.Minute, RestartLoopCooldown: time.Hour})
+
+	c := createWatchdogContainer(node1, "c1", reschedulable, true)
+	pinned := createWatchdogContainer(node1, "pinned", nil, true)
+	now := time.Now()
+
+	restart := func(c *Container, count int, at time.Time) {
+		c.Info.RestartCount = count
+		w.checkRestartLoop(c.Engine, c.ID, at)
+	}
+
+	// Restarts spread over more than the window are not a loop.
+	restart(c, 1, now)
+	restart(c, 2, now.Add(40*time.Second))
+	restart(c, 4, now.Add(80*time.Second))
+	assert.NotNil(t, node1.Containers().Get("c1"))
+
+	// Containers without reschedule policy stay in place.
+	for i := 0; i <= 3; i++ {
+		restart(pinned, i, now)
+	}
+	assert.NotNil(t, node1.Containers().Get("pinned"))
+
+	// 3 restarts within the window move the container.
+	restart(c, 5, now.Add(90*time.Second))
+	restart(c, 6, now.Add(100*time.Second))
+	assert.NotNil(t, node1.Containers().Get("c1"))
+	restart(c, 7, now.Add(110*time.Second))
+	assert.Nil(t, node1.Containers().Get("c1"))
+	assert.Len(t, node2.Containers(), 1)
+
+	// The new container isn't moved back during the cooldown.
+	moved := node2.Containers()[0]
+	restart(moved, 0, now.Add(2*time.Minute))
+	restart(moved, 5, now.Add(2*time.Minute))
+	assert.Len(t, node2.Containers(), 1)
+	assert.Len(t, node1.Containers(), 1)
+}
+
+func TestWatchdogHandleRestartEvent(t *testing.T) {
+	node := createWatchdogEngine("node", true)
+	cl := &mockCluster{engines: []*Engine{node}}
+	w := NewWatchdog(cl, nil)
+	createWatchdogContainer(node, "c1", reschedulable, true)
+
+	// Restart loop detection is disabled by default.
+	event := &Event{Message: events.Message{From: "busybox", Status: "start", ID: "c1"}, Engine: node}
+	assert.NoError(t, w.Handle(event))
+	assert.Len(t, w.restarts, 0)
+}
+
+// recordingHandler records the events it handles.
+type recordingHandler struct {
+	sync.Mutex
+	events []*Event
+}
+
+func (h *recordingHandler) Handle(e *Event) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, e)
+	return nil
+}
+
+// without returns the recorded events, except the ones with the given status.
+func (h *recordingHandler) without(status string) []*Event {
+	h.Lock()
+	defer h.Unlock()
+	events := []*Event{}
+	for _, e := range h.events {
+		if e.Status != status {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestWatchdogRescheduleEvents(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	alive.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, nil)
+
+	createWatchdogContainer(dead, "c1", reschedulable, true)
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerStartupScan))
+
+	events := handler.without("reschedule_summary")
+	assert.Len(t, events, 1)
+	ev := events[0]
+	assert.Equal(t, "container_rescheduled", ev.Status)
+	assert.Equal(t, alive, ev.Engine)
+	assert.Equal(t, "c1", ev.Actor.Attributes["container"])
+	assert.Equal(t, "dead", ev.Actor.Attributes["from_node"])
+	assert.Equal(t, "alive", ev.Actor.Attributes["to_node"])
+	assert.Equal(t, "startup_scan", ev.Actor.Attributes["trigger"])
+	assert.NotEmpty(t, ev.Actor.Attributes["trigger_time"])
+
+	// Failures are reported with their trigger too.
+	createWatchdogContainer(dead, "c2", reschedulable, true)
+	cl.createErr = errors.New("Error: image foo not found")
+	assert.Error(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	events = handler.without("reschedule_summary")
+	assert.Len(t, events, 2)
+	ev = events[1]
+	assert.Equal(t, "container_reschedule_failed", ev.Status)
+	assert.Equal(t, "c2", ev.Actor.Attributes["container"])
+	assert.Equal(t, "engine_disconnect", ev.Actor.Attributes["trigger"])
+}
+
+func TestWatchdogRescheduleSummary(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	dead.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, &WatchdogOpts{})
+
+	createWatchdogContainer(dead, "moved", reschedulable, true)
+	createWatchdogContainer(dead, "pinned", nil, true)
+	createWatchdogContainer(dead, "stopped", reschedulable, false)
+	createWatchdogContainer(dead, "transient", reschedulable, true).Config.HostConfig.AutoRemove = true
+	createWatchdogContainer(dead, "bind", reschedulable, true).Config.HostConfig.Binds = []string{"/srv/data:/data"}
+
+	assert.Error(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	if assert.Len(t, handler.events, 2) {
+		ev := handler.events[1]
+		assert.Equal(t, "reschedule_summary", ev.Status)
+		assert.Equal(t, dead, ev.Engine)
+		assert.Equal(t, string(TriggerEngineDisconnect), ev.Actor.Attributes["trigger"])
+		assert.Equal(t, "failed", ev.Actor.Attributes["outcome"])
+		assert.Equal(t, "5", ev.Actor.Attributes["total"])
+		assert.Equal(t, "1", ev.Actor.Attributes["rescheduled"])
+		assert.Equal(t, "1", ev.Actor.Attributes["failed"])
+		assert.Equal(t, "3", ev.Actor.Attributes["skipped"])
+		assert.Equal(t, "auto_remove=1,policy=1,stopped=1", ev.Actor.Attributes["skipped_reasons"])
+		assert.NotEmpty(t, ev.Actor.Attributes["duration"])
+	}
+
+	// A wave cut short by the watchdog stepping down is abandoned.
+	handler.events = nil
+	w.Demote()
+	assert.Equal(t, ErrWatchdogInactive, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	if assert.Len(t, handler.events, 1) {
+		assert.Equal(t, "abandoned", handler.events[0].Actor.Attributes["outcome"])
+		assert.Equal(t, "0", handler.events[0].Actor.Attributes["total"])
+	}
+}
+
+func TestWatchdogRescheduleDowntime(t *testing.T) {
+	dead := createWatchdogEngine("dead", false)
+	alive := createWatchdogEngine("alive", true)
+	handler := &recordingHandler{}
+	alive.eventHandler = handler
+	cl := &mockCluster{engines: []*Engine{dead, alive}}
+	w := NewWatchdog(cl, nil)
+
+	createWatchdogContainer(dead, "running", reschedulable, true)
+	createWatchdogContainer(dead, "stopped", reschedulable, false)
+	before := time.Now()
+	assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+	after := time.Now()
+
+	assert.Len(t, handler.events, 2)
+	for _, ev := range handler.events {
+		attributes := ev.Actor.Attributes
+		detected, err := time.Parse(time.RFC3339Nano, attributes["trigger_time"])
+		assert.NoError(t, err)
+		created, err := time.Parse(time.RFC3339Nano, attributes["created_time"])
+		assert.NoError(t, err)
+		assert.False(t, detected.Before(before))
+		assert.False(t, created.Before(detected))
+		assert.False(t, created.After(after))
+
+		if attributes["container"] == "stopped" {
+			// A stopped container isn't started, it isn't down either.
+			assert.Empty(t, attributes["started_time"])
+			assert.Empty(t, attributes["downtime"])
+			continue
+		}
+		started, err := time.Parse(time.RFC3339Nano, attributes["started_time"])
+		assert.NoError(t, err)
+		assert.False(t, started.Before(created))
+		assert.False(t, started.After(after))
+		downtime, err := time.ParseDuration(attributes["downtime"])
+		assert.NoError(t, err)
+		// The monotonic and wall clocks can slightly differ.
+		assert.InDelta(t, float64(started.Sub(detected)), float64(downtime), float64(time.Millisecond))
+	}
+
+	// Only the started container is part of the downtime histogram.
+	assert.Equal(t, uint64(1), w.RescheduleDowntime().Count())
+	assert.True(t, w.RescheduleDowntime().Sum() <= after.Sub(before))
+	assert.Equal(t, time.Second, w.RescheduleDowntime().Quantile(0.99))
+}
+
+func TestWatchdogDefaultReschedulePolicy(t *testing.T) {
+	for _, policy := range []string{"", "off", "on-node-failure", "on-node-drain"} {
+		dead := createWatchdogEngine("dead", false)
+		alive := createWatchdogEngine("alive", true)
+		cl := &mockCluster{engines: []*Engine{dead, alive}}
+		w := NewWatchdog(cl, &WatchdogOpts{DefaultReschedulePolicy: policy, RescheduleStoppedContainers: true})
+
+		createWatchdogContainer(dead, "unlabeled", nil, true)
+		createWatchdogContainer(dead, "opted-out", map[string]string{SwarmLabelNamespace + ".reschedule-policies": `["off"]`}, true)
+		createWatchdogContainer(dead, "opted-in", reschedulable, true)
+
+		assert.NoError(t, w.RescheduleEngine(dead, TriggerEngineDisconnect))
+		// Explicit policies always win.
+		assert.NotNil(t, dead.Containers().Get("opted-out"), policy)
+		assert.Nil(t, dead.Containers().Get("opted-in"), policy)
+		if policy == "on-node-failure" {
+			assert.Nil(t, dead.Containers().Get("unlabeled"), policy)
+			assert.Len(t, alive.Containers(), 2, policy)
+		} else {
+			assert.NotNil(t, dead.Containers().Get("unlabeled"), policy)
+			assert.Len(t, alive.Containers(), 1, policy)
+		}
+	}
+
+	opts, err := NewWatchdogOpts(DriverOpts{"default-reschedule-policy=on-node-failure"})
+	assert.NoError(t, err)
+	assert.Equal(t, "on-node-failure", opts.DefaultReschedulePolicy)
+	_, err = NewWatchdogOpts(DriverOpts{"default-reschedule-policy=always"})
+	assert.Error(t, err)
+}
+
+func BenchmarkWatchdogRescheduleNetworks(b *testing.B) {
+	alive := createWatchdogEngine("alive", true)
+	cl := &mockCluster{engines: []*Engine{alive}}
+	endpoints := map[string]*networktypes.EndpointSettings{}
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("overlay%d", i)
+		cl.networks = append(cl.networks, &Network{NetworkResource: types.NetworkResource{ID: id, Name: id, Scope: "swarm"}, Engine: alive})
+		endpoints[id] = &networktypes.EndpointSettings{NetworkID: id}
+	}
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("bridge%d", i)
+		cl.networks = append(cl.networks, &Network{NetworkResource: types.NetworkResource{ID: id, Name: id, Scope: "local"}, Engine: alive})
+	}
+	w := NewWatchdog(cl, &WatchdogOpts{RescheduleRetryLimit: 1})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dead := createWatchdogEngine("dead", false)
+		// Each container has its own swarm ID, so that it isn't seen as
+		// already rescheduled.
+		c := createWatchdogContainer(dead, fmt.Sprintf("c%d", i), reschedulable, true)
+		c.Config.NetworkingConfig.EndpointsConfig = endpoints
+		c.Info.NetworkSettings = &types.NetworkSettings{Networks: endpoints}
+		b.StartTimer()
+
+		if err := w.RescheduleEngine(dead, TriggerEngineDisconnect); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// TimeWindow is a daily period of time, in local time. A window ending
+// before it starts spans midnight.
+type TimeWindow struct {
+	// Start and End are the offsets of the window from midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+// TimeWindows is a list of daily windows. An empty list matches any time.
+type TimeWindows []TimeWindow
+
+// ParseTimeWindows parses a comma separated list of HH:MM-HH:MM windows, e.g.
+// "18:00-08:00,12:00-13:00".
+func ParseTimeWindows(val string) (TimeWindows, error) {
+	windows := TimeWindows{}
+	for _, w := range strings.Split(val, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(w), "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", w)
+		}
+		start, err := parseTimeOfDay(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, TimeWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses a HH:MM time into its offset from midnight. 24:00 is
+// accepted to end a window at midnight.
+func parseTimeOfDay(val string) (time.Duration, error) {
+	var hours, minutes int
+	if n, err := fmt.Sscanf(val, "%d:%d", &hours, &minutes); err != nil || n != 2 || len(val) != 5 {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", val)
+	}
+	offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	if hours < 0 || minutes < 0 || minutes > 59 || offset > day {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", val)
+	}
+	return offset, nil
+}
+
+// midnight returns the start of the day of t.
+func midnight(t time.Time) time.Time {
+	year, month, d := t.Date()
+	return time.Date(year, month, d, 0, 0, 0, 0, t.Location())
+}
+
+// Contains returns true if t is within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	offset := t.Sub(midnight(t))
+	switch {
+	case w.Start == w.End:
+		return true
+	case w.Start < w.End:
+		return offset >= w.Start && offset < w.End
+	default:
+		return offset >= w.Start || offset < w.End
+	}
+}
+
+// NextOpening returns the first time at or after t within the window.
+func (w TimeWindow) NextOpening(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	opening := midnight(t).Add(w.Start)
+	if opening.Before(t) {
+		opening = midnight(t.Add(day)).Add(w.Start)
+	}
+	return opening
+}
+
+// Contains returns true if t is within one of the windows.
+func (windows TimeWindows) Contains(t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpening returns the first time at or after t within one of the windows.
+func (windows TimeWindows) NextOpening(t time.Time) time.Time {
+	if len(windows) == 0 {
+		return t
+	}
+	next := windows[0].NextOpening(t)
+	for _, w := range windows[1:] {
+		if opening := w.NextOpening(t); opening.Before(next) {
+			next = opening
+		}
+	}
+	return next
+}
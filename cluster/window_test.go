@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeWindows(t *testing.T) {
+	windows, err := ParseTimeWindows("18:00-08:00, 12:30-13:00,00:00-24:00")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeWindows{
+		{Start: 18 * time.Hour, End: 8 * time.Hour},
+		{Start: 12*time.Hour + 30*time.Minute, End: 13 * time.Hour},
+		{Start: 0, End: 24 * time.Hour},
+	}, windows)
+
+	for _, invalid := range []string{"", "18:00", "18:00-8:00", "25:00-08:00", "18:60-08:00", "24:01-08:00", "ab:cd-08:00"} {
+		_, err := ParseTimeWindows(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTimeWindows(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2016, 11, 14, hour, min, 0, 0, time.UTC)
+	}
+	night := TimeWindow{Start: 18 * time.Hour, End: 8 * time.Hour}
+	lunch := TimeWindow{Start: 12 * time.Hour, End: 13 * time.Hour}
+
+	assert.True(t, night.Contains(at(18, 0)))
+	assert.True(t, night.Contains(at(7, 59)))
+	assert.False(t, night.Contains(at(8, 0)))
+	assert.True(t, lunch.Contains(at(12, 0)))
+	assert.False(t, lunch.Contains(at(13, 0)))
+	assert.True(t, TimeWindow{}.Contains(at(9, 0)))
+
+	assert.Equal(t, at(18, 0), night.NextOpening(at(9, 0)))
+	assert.Equal(t, at(19, 0), night.NextOpening(at(19, 0)))
+	assert.Equal(t, at(12, 0).Add(24*time.Hour), lunch.NextOpening(at(14, 0)))
+
+	windows := TimeWindows{night, lunch}
+	assert.True(t, windows.Contains(at(12, 15)))
+	assert.False(t, windows.Contains(at(10, 0)))
+	assert.Equal(t, at(12, 0), windows.NextOpening(at(10, 0)))
+	assert.Equal(t, at(18, 0), windows.NextOpening(at(14, 0)))
+
+	// No window means any time.
+	assert.True(t, TimeWindows{}.Contains(at(10, 0)))
+	assert.Equal(t, at(10, 0), TimeWindows{}.NextOpening(at(10, 0)))
+}
@@ -0,0 +1,55 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+var (
+	// ErrNoNodeWithFreeBandwidth is exported
+	ErrNoNodeWithFreeBandwidth = errors.New("No node with enough free bandwidth available in the cluster")
+)
+
+// BandwidthFilter only schedules the containers reserving network bandwidth
+// on the nodes whose NIC capacity, as advertised by their bandwidth label, can
+// take it on top of the bandwidth already reserved.
+type BandwidthFilter struct {
+}
+
+// Name returns the name of the filter
+func (f *BandwidthFilter) Name() string {
+	return "bandwidth"
+}
+
+// Filter is exported
+func (f *BandwidthFilter) Filter(config *cluster.ContainerConfig, nodes []*node.Node, _ bool) ([]*node.Node, error) {
+	bandwidth := config.Bandwidth()
+	if bandwidth == 0 {
+		return nodes, nil
+	}
+
+	result := []*node.Node{}
+	for _, node := range nodes {
+		// no limit if there is no bandwidth label
+		if node.TotalBandwidth == 0 || node.UsedBandwidth+bandwidth <= node.TotalBandwidth {
+			result = append(result, node)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNoNodeWithFreeBandwidth
+	}
+	return result, nil
+}
+
+// GetFilters returns the bandwidth the container reserves
+func (f *BandwidthFilter) GetFilters(config *cluster.ContainerConfig) ([]string, error) {
+	bandwidth := config.Bandwidth()
+	if bandwidth == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("available bandwidth of %d bps", bandwidth)}, nil
+}
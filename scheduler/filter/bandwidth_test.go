@@ -0,0 +1,82 @@
+package filter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func bandwidthConfig(bandwidth string) *cluster.ContainerConfig {
+	config := cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	if bandwidth != "" {
+		config.Labels[cluster.BandwidthLabel] = bandwidth
+	}
+	return config
+}
+
+func TestBandwidthFilter(t *testing.T) {
+	var (
+		f     = BandwidthFilter{}
+		nodes = []*node.Node{
+			{
+				ID:             "node-0-id",
+				Name:           "node-0-name",
+				TotalBandwidth: 10000000000,
+			},
+			{
+				ID:             "node-1-id",
+				Name:           "node-1-name",
+				TotalBandwidth: 5000000000,
+			},
+		}
+	)
+
+	// Two 5Gbps jobs fill the first node, a third one goes on the second,
+	// and a fourth one can't be placed.
+	config := bandwidthConfig("5Gbps")
+	for i, expected := range []string{"node-0-id", "node-0-id", "node-1-id"} {
+		result, err := f.Filter(config, nodes, true)
+		assert.NoError(t, err)
+		if assert.NotEmpty(t, result) {
+			assert.Equal(t, expected, result[0].ID)
+			c := &cluster.Container{Container: types.Container{ID: fmt.Sprintf("c%d", i)}, Config: config}
+			assert.NoError(t, result[0].AddContainer(c))
+		}
+	}
+	assert.Equal(t, int64(10000000000), nodes[0].UsedBandwidth)
+	assert.Equal(t, int64(5000000000), nodes[1].UsedBandwidth)
+	_, err := f.Filter(config, nodes, true)
+	assert.Equal(t, ErrNoNodeWithFreeBandwidth, err)
+
+	// The containers reserving no bandwidth, or less than what is left, still
+	// fit.
+	result, err := f.Filter(bandwidthConfig(""), nodes, true)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	nodes[1].UsedBandwidth = 4500000000
+	result, err = f.Filter(bandwidthConfig("500M"), nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[1]}, result)
+
+	// The nodes without NIC capacity take any bandwidth.
+	unlimited := &node.Node{ID: "node-2-id", Name: "node-2-name", UsedBandwidth: 50000000000}
+	result, err = f.Filter(config, append(nodes, unlimited), true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{unlimited}, result)
+}
+
+func TestBandwidthFilterGetFilters(t *testing.T) {
+	f := BandwidthFilter{}
+	filters, err := f.GetFilters(bandwidthConfig(""))
+	assert.NoError(t, err)
+	assert.Empty(t, filters)
+	filters, err = f.GetFilters(bandwidthConfig("5G"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"available bandwidth of 5000000000 bps"}, filters)
+}
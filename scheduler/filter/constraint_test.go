@@ -278,3 +278,67 @@ func TestFilterSoftConstraint(t *testing.T) {
 	assert.Error(t, err)
 	assert.Len(t, result, 0)
 }
+
+func TestFilterPlacementOnlyConstraint(t *testing.T) {
+	var (
+		f      = ConstraintFilter{}
+		nodes  = testFixtures()
+		result []*node.Node
+		err    error
+	)
+
+	// Placement-only constraints are enforced like any other.
+	result, err = f.Filter(cluster.BuildContainerConfig(containertypes.Config{Env: []string{"constraint:node==@node-1-name"}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}), nodes, true)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, result[0], nodes[1])
+
+	result, err = f.Filter(cluster.BuildContainerConfig(containertypes.Config{Env: []string{"constraint:region!=@us*"}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}), nodes, true)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+
+	// They can be soft too.
+	result, err = f.Filter(cluster.BuildContainerConfig(containertypes.Config{Env: []string{"constraint:region==~@unknown"}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}), nodes, false)
+	assert.NoError(t, err)
+	assert.Len(t, result, 4)
+}
+
+func TestConstraintVersion(t *testing.T) {
+	var (
+		f     = ConstraintFilter{}
+		nodes = []*node.Node{
+			{
+				ID:     "node-0-id",
+				Name:   "node-0-name",
+				Labels: map[string]string{"kernelversion": "3.10.0-957.el7.x86_64"},
+			},
+			{
+				ID:     "node-1-id",
+				Name:   "node-1-name",
+				Labels: map[string]string{"kernelversion": "4.9.0-8-amd64"},
+			},
+			{
+				ID:     "node-2-id",
+				Name:   "node-2-name",
+				Labels: map[string]string{"kernelversion": "5.4.0-1021-aws"},
+			},
+			{
+				ID:   "node-3-id",
+				Name: "node-3-name",
+			},
+		}
+		result []*node.Node
+		err    error
+	)
+
+	result, err = f.Filter(cluster.BuildContainerConfig(containertypes.Config{Env: []string{"constraint:kernelversion>=4.9"}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}), nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[1], nodes[2]}, result)
+
+	result, err = f.Filter(cluster.BuildContainerConfig(containertypes.Config{Env: []string{"constraint:kernelversion>=4.9", "constraint:kernelversion<5"}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}), nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[1]}, result)
+
+	_, err = f.Filter(cluster.BuildContainerConfig(containertypes.Config{Env: []string{"constraint:kernelversion>6"}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}), nodes, true)
+	assert.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package filter
+
+import (
+	"errors"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+var (
+	// ErrNoUncordonedNodeAvailable is exported
+	ErrNoUncordonedNodeAvailable = errors.New("No uncordoned node available in the cluster")
+)
+
+// CordonFilter only schedules containers on the nodes which are not cordoned,
+// e.g. while drained for maintenance.
+type CordonFilter struct {
+}
+
+// Name returns the name of the filter
+func (f *CordonFilter) Name() string {
+	return "cordon"
+}
+
+// Filter is exported
+func (f *CordonFilter) Filter(_ *cluster.ContainerConfig, nodes []*node.Node, _ bool) ([]*node.Node, error) {
+	result := []*node.Node{}
+	for _, node := range nodes {
+		if !node.Cordoned {
+			result = append(result, node)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNoUncordonedNodeAvailable
+	}
+	return result, nil
+}
+
+// GetFilters returns
+func (f *CordonFilter) GetFilters(config *cluster.ContainerConfig) ([]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,37 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCordonFilter(t *testing.T) {
+	var (
+		f     = CordonFilter{}
+		nodes = []*node.Node{
+			{
+				ID:       "node-0-id",
+				Name:     "node-0-name",
+				Cordoned: true,
+			},
+			{
+				ID:   "node-1-id",
+				Name: "node-1-name",
+			},
+		}
+		config = &cluster.ContainerConfig{}
+	)
+
+	// The cordoned node takes no container.
+	result, err := f.Filter(config, nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[1]}, result)
+
+	// Once all the nodes are cordoned, the container can't be placed.
+	nodes[1].Cordoned = true
+	_, err = f.Filter(config, nodes, true)
+	assert.Equal(t, ErrNoUncordonedNodeAvailable, err)
+}
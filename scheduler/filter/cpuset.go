@@ -0,0 +1,53 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+var (
+	// ErrNoNodeWithCpuset is exported
+	ErrNoNodeWithCpuset = errors.New("No node has the CPUs of the cpuset of the container")
+)
+
+// CpusetFilter only schedules the containers pinned to CPUs by their cpuset
+// on the nodes having these CPUs, where they can start. The nodes whose
+// number of CPUs is unknown take them.
+type CpusetFilter struct {
+}
+
+// Name returns the name of the filter
+func (f *CpusetFilter) Name() string {
+	return "cpuset"
+}
+
+// Filter is exported
+func (f *CpusetFilter) Filter(config *cluster.ContainerConfig, nodes []*node.Node, _ bool) ([]*node.Node, error) {
+	max, ok := config.CpusetMaxCPU()
+	if !ok {
+		return nodes, nil
+	}
+
+	result := []*node.Node{}
+	for _, node := range nodes {
+		if node.Cpus == 0 || max < node.Cpus {
+			result = append(result, node)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%v: cpuset %s", ErrNoNodeWithCpuset, config.HostConfig.CpusetCpus)
+	}
+	return result, nil
+}
+
+// GetFilters returns the cpuset of the container
+func (f *CpusetFilter) GetFilters(config *cluster.ContainerConfig) ([]string, error) {
+	if _, ok := config.CpusetMaxCPU(); !ok {
+		return nil, nil
+	}
+	return []string{"cpuset " + config.HostConfig.CpusetCpus}, nil
+}
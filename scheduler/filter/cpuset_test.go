@@ -0,0 +1,67 @@
+package filter
+
+import (
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func cpusetConfig(cpuset string) *cluster.ContainerConfig {
+	return cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{Resources: containertypes.Resources{CpusetCpus: cpuset}}, networktypes.NetworkingConfig{})
+}
+
+func TestCpusetFilter(t *testing.T) {
+	var (
+		f     = CpusetFilter{}
+		nodes = []*node.Node{
+			{
+				ID:   "node-0-id",
+				Name: "node-0-name",
+				Cpus: 8,
+			},
+			{
+				ID:   "node-1-id",
+				Name: "node-1-name",
+				Cpus: 4,
+			},
+			{
+				ID:   "node-2-id",
+				Name: "node-2-name",
+			},
+		}
+	)
+
+	// The containers which aren't pinned go anywhere.
+	result, err := f.Filter(cpusetConfig(""), nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, nodes, result)
+
+	// The nodes with enough CPUs, or whose CPUs are unknown, take the
+	// pinned containers.
+	result, err = f.Filter(cpusetConfig("0-3"), nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, nodes, result)
+
+	result, err = f.Filter(cpusetConfig("2,6"), nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[0], nodes[2]}, result)
+
+	_, err = f.Filter(cpusetConfig("4-7"), nodes[1:2], true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ErrNoNodeWithCpuset.Error())
+		assert.Contains(t, err.Error(), "cpuset 4-7")
+	}
+
+	// The invalid cpusets are left to the engines.
+	result, err = f.Filter(cpusetConfig("x"), nodes[1:2], true)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+
+	filters, err := f.GetFilters(cpusetConfig("4-7"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpuset 4-7"}, filters)
+}
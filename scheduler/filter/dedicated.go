@@ -0,0 +1,48 @@
+package filter
+
+import (
+	"errors"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+var (
+	// ErrOnlyDedicatedNodes is exported
+	ErrOnlyDedicatedNodes = errors.New("No node accepts the container, the nodes left are dedicated to other containers")
+)
+
+// DedicatedFilter keeps the containers off the nodes dedicated to others, as
+// marked by the dedicated label of the nodes, unless they tolerate them with
+// a constraint on the label.
+type DedicatedFilter struct {
+}
+
+// Name returns the name of the filter
+func (f *DedicatedFilter) Name() string {
+	return "dedicated"
+}
+
+// Filter is exported
+func (f *DedicatedFilter) Filter(config *cluster.ContainerConfig, nodes []*node.Node, _ bool) ([]*node.Node, error) {
+	result := []*node.Node{}
+	for _, node := range nodes {
+		if config.Tolerates(node.Labels) {
+			result = append(result, node)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, ErrOnlyDedicatedNodes
+	}
+	return result, nil
+}
+
+// GetFilters returns the dedicated nodes the container tolerates
+func (f *DedicatedFilter) GetFilters(config *cluster.ContainerConfig) ([]string, error) {
+	tolerations := []string{}
+	for _, toleration := range config.Tolerations() {
+		tolerations = append(tolerations, cluster.DedicatedLabel+"="+toleration)
+	}
+	return tolerations, nil
+}
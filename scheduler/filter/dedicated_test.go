@@ -0,0 +1,56 @@
+package filter
+
+import (
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedicatedFilter(t *testing.T) {
+	var (
+		f     = DedicatedFilter{}
+		nodes = []*node.Node{
+			{
+				ID:     "node-0-id",
+				Name:   "node-0-name",
+				Labels: map[string]string{"dedicated": "system"},
+			},
+			{
+				ID:     "node-1-id",
+				Name:   "node-1-name",
+				Labels: map[string]string{"dedicated": "gpu"},
+			},
+			{
+				ID:     "node-2-id",
+				Name:   "node-2-name",
+				Labels: map[string]string{},
+			},
+		}
+	)
+
+	// A normal container never lands on a dedicated node.
+	config := cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	result, err := f.Filter(config, nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[2]}, result)
+	_, err = f.Filter(config, nodes[:2], true)
+	assert.Equal(t, ErrOnlyDedicatedNodes, err)
+
+	// A tolerating one does.
+	config = cluster.BuildContainerConfig(containertypes.Config{Env: []string{"constraint:dedicated==system"}}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	result, err = f.Filter(config, nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[0], nodes[2]}, result)
+	filters, err := f.GetFilters(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dedicated=system"}, filters)
+
+	// Along with the constraint filter, it only lands on its dedicated node.
+	result, err = ApplyFilters([]Filter{&ConstraintFilter{}, &f}, config, nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[0]}, result)
+}
@@ -34,10 +34,12 @@ func (f *DependencyFilter) Filter(config *cluster.ContainerConfig, nodes []*node
 		links = append(links, strings.SplitN(link, ":", 2)[0])
 	}
 
-	// Check if --net points to a container.
+	// Check if --net, --ipc or --pid point to a container.
 	net := []string{}
-	if strings.HasPrefix(string(config.HostConfig.NetworkMode), "container:") {
-		net = append(net, strings.TrimPrefix(string(config.HostConfig.NetworkMode), "container:"))
+	for _, mode := range namespaceModes(config) {
+		if strings.HasPrefix(mode, "container:") {
+			net = append(net, strings.TrimPrefix(mode, "container:"))
+		}
 	}
 
 	candidates := []*node.Node{}
@@ -65,12 +67,23 @@ func (f *DependencyFilter) GetFilters(config *cluster.ContainerConfig) ([]string
 	for _, link := range config.HostConfig.Links {
 		dependencies = append(dependencies, fmt.Sprintf("--link=%s", link))
 	}
-	if strings.HasPrefix(string(config.HostConfig.NetworkMode), "container:") {
-		dependencies = append(dependencies, fmt.Sprintf("--net=%s", config.HostConfig.NetworkMode))
+	for i, mode := range namespaceModes(config) {
+		if strings.HasPrefix(mode, "container:") {
+			dependencies = append(dependencies, fmt.Sprintf("--%s=%s", namespaceFlags[i], mode))
+		}
 	}
 	return dependencies, nil
 }
 
+// namespaceFlags are the flags of the namespace modes, in the order returned
+// by namespaceModes.
+var namespaceFlags = []string{"net", "ipc", "pid"}
+
+// namespaceModes returns the network, IPC and PID modes of a container.
+func namespaceModes(config *cluster.ContainerConfig) []string {
+	return []string{string(config.HostConfig.NetworkMode), string(config.HostConfig.IpcMode), string(config.HostConfig.PidMode)}
+}
+
 // String gets a string representation of the dependencies found in the container config.
 func (f *DependencyFilter) String(config *cluster.ContainerConfig) string {
 	dependencies, _ := f.GetFilters(config)
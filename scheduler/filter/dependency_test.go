@@ -116,6 +116,20 @@ func TestDependencyFilterSimple(t *testing.T) {
 	assert.Len(t, result, 1)
 	assert.Equal(t, result[0], nodes[2])
 
+	// ipc and pid.
+	config = &cluster.ContainerConfig{
+		Config: containertypes.Config{},
+		HostConfig: containertypes.HostConfig{
+			IpcMode: containertypes.IpcMode("container:c1"),
+			PidMode: containertypes.PidMode("container:c1"),
+		},
+		NetworkingConfig: networktypes.NetworkingConfig{}}
+	result, err = f.Filter(config, nodes, true)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, result[0], nodes[1])
+	assert.Equal(t, "--ipc=container:c1 --pid=container:c1", f.String(config))
+
 	// net not prefixed by "container:" should be ignored.
 	config = &cluster.ContainerConfig{
 		Config: containertypes.Config{},
@@ -0,0 +1,58 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+var (
+	// ErrNoNodeWithDevices is exported
+	ErrNoNodeWithDevices = errors.New("No node satisfies the device and ulimit requirements")
+)
+
+// DeviceFilter only schedules containers on nodes providing the host devices
+// and ulimits they require, as listed by the devices and ulimits labels of
+// the nodes.
+type DeviceFilter struct {
+}
+
+// Name returns the name of the filter
+func (f *DeviceFilter) Name() string {
+	return "device"
+}
+
+// Filter is exported
+func (f *DeviceFilter) Filter(config *cluster.ContainerConfig, nodes []*node.Node, _ bool) ([]*node.Node, error) {
+	if len(config.HostConfig.Devices) == 0 && len(config.HostConfig.Ulimits) == 0 {
+		return nodes, nil
+	}
+
+	result := []*node.Node{}
+	for _, node := range nodes {
+		if len(config.UnsatisfiedRequirements(node.Labels)) == 0 {
+			result = append(result, node)
+		}
+	}
+
+	if len(result) == 0 {
+		requirements, _ := f.GetFilters(config)
+		return nil, fmt.Errorf("%v: %s", ErrNoNodeWithDevices, strings.Join(requirements, ", "))
+	}
+	return result, nil
+}
+
+// GetFilters returns the devices and ulimits required by the container
+func (f *DeviceFilter) GetFilters(config *cluster.ContainerConfig) ([]string, error) {
+	requirements := []string{}
+	for _, device := range config.HostConfig.Devices {
+		requirements = append(requirements, "device "+device.PathOnHost)
+	}
+	for _, ulimit := range config.HostConfig.Ulimits {
+		requirements = append(requirements, fmt.Sprintf("ulimit %s=%d", ulimit.Name, ulimit.Hard))
+	}
+	return requirements, nil
+}
@@ -0,0 +1,77 @@
+package filter
+
+import (
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/go-units"
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceFilter(t *testing.T) {
+	var (
+		f     = DeviceFilter{}
+		nodes = []*node.Node{
+			{
+				ID:     "node-0-id",
+				Name:   "node-0-name",
+				Labels: map[string]string{"devices": "/dev/fuse,/dev/nvidia*", "ulimits": "nofile=65536"},
+			},
+			{
+				ID:     "node-1-id",
+				Name:   "node-1-name",
+				Labels: map[string]string{"devices": "/dev/nvidia0", "ulimits": "nofile=4096"},
+			},
+			{
+				ID:     "node-2-id",
+				Name:   "node-2-name",
+				Labels: map[string]string{},
+			},
+		}
+	)
+
+	hostConfig := func(devices []string, ulimits ...*units.Ulimit) containertypes.HostConfig {
+		config := containertypes.HostConfig{}
+		for _, device := range devices {
+			config.Devices = append(config.Devices, containertypes.DeviceMapping{PathOnHost: device, PathInContainer: device})
+		}
+		config.Ulimits = ulimits
+		return config
+	}
+
+	// Without devices nor ulimits, any node is accepted.
+	result, err := f.Filter(cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{}, networktypes.NetworkingConfig{}), nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, nodes, result)
+
+	// The nodes without labels are assumed to provide any device.
+	config := cluster.BuildContainerConfig(containertypes.Config{}, hostConfig([]string{"/dev/fuse"}), networktypes.NetworkingConfig{})
+	result, err = f.Filter(config, nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[0], nodes[2]}, result)
+
+	// Patterns match the devices.
+	config = cluster.BuildContainerConfig(containertypes.Config{}, hostConfig([]string{"/dev/nvidia1"}), networktypes.NetworkingConfig{})
+	result, err = f.Filter(config, nodes[:2], true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[0]}, result)
+
+	// The hard ulimits must be within the limits of the node.
+	config = cluster.BuildContainerConfig(containertypes.Config{}, hostConfig(nil, &units.Ulimit{Name: "nofile", Soft: 1024, Hard: 65536}), networktypes.NetworkingConfig{})
+	result, err = f.Filter(config, nodes[:2], true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[0]}, result)
+
+	config = cluster.BuildContainerConfig(containertypes.Config{}, hostConfig([]string{"/dev/sda"}), networktypes.NetworkingConfig{})
+	_, err = f.Filter(config, nodes[:2], true)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrNoNodeWithDevices.Error())
+	assert.Contains(t, err.Error(), "device /dev/sda")
+
+	// The error is reported as is by the batch of filters.
+	_, err = ApplyFilters([]Filter{&f}, config, nodes[:2], true)
+	assert.Contains(t, err.Error(), ErrNoNodeWithDevices.Error())
+}
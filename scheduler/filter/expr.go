@@ -3,6 +3,7 @@ package filter
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -13,16 +14,31 @@ const (
 	EQ = iota
 	// NOTEQ is exported
 	NOTEQ
+	// GTE is exported
+	GTE
+	// LTE is exported
+	LTE
+	// GT is exported
+	GT
+	// LT is exported
+	LT
 )
 
 // OPERATORS is exported
-var OPERATORS = []string{"==", "!="}
+// The comparison operators compare versions, e.g. kernelversion>=4.9. The
+// two characters operators must come first to be matched before > and <.
+var OPERATORS = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+var versionRegexp = regexp.MustCompile(`[0-9]+(\.[0-9]+)*`)
 
 type expr struct {
 	key      string
 	operator int
 	value    string
 	isSoft   bool
+	// isPlacementOnly expressions, qualified with @, are enforced when a
+	// container is created but relaxed when it is rescheduled.
+	isPlacementOnly bool
 }
 
 func parseExprs(env []string) ([]expr, error) {
@@ -48,16 +64,17 @@ func parseExprs(env []string) ([]expr, error) {
 
 					// validate value
 					// allow leading = in case of using ==
+					// allow the ~ and @ qualifiers
 					// allow * for globbing
 					// allow regexp
-					matched, err := regexp.MatchString(`^(?i)[=!\/]?(~)?[a-z0-9:\-_\s\.\*/\(\)\?\+\[\]\\\^\$\|]+$`, parts[1])
+					matched, err := regexp.MatchString(`^(?i)[=!\/]?(~|@|~@|@~)?[a-z0-9:\-_\s\.\*/\(\)\?\+\[\]\\\^\$\|]+$`, parts[1])
 					if err != nil {
 						return nil, err
 					}
 					if matched == false {
 						return nil, fmt.Errorf("Value '%s' is invalid", parts[1])
 					}
-					exprs = append(exprs, expr{key: parts[0], operator: i, value: strings.TrimLeft(parts[1], "~"), isSoft: isSoft(parts[1])})
+					exprs = append(exprs, expr{key: parts[0], operator: i, value: strings.TrimLeft(parts[1], "~@"), isSoft: isSoft(parts[1]), isPlacementOnly: isPlacementOnly(parts[1])})
 				} else {
 					exprs = append(exprs, expr{key: parts[0], operator: i})
 				}
@@ -67,13 +84,18 @@ func parseExprs(env []string) ([]expr, error) {
 			}
 		}
 		if !found {
-			return nil, fmt.Errorf("One of operator ==, != is expected")
+			return nil, fmt.Errorf("One of operator %s is expected", strings.Join(OPERATORS, ", "))
 		}
 	}
 	return exprs, nil
 }
 
 func (e *expr) Match(whats ...string) bool {
+	switch e.operator {
+	case GTE, LTE, GT, LT:
+		return e.matchVersion(whats...)
+	}
+
 	var (
 		pattern string
 		match   bool
@@ -109,9 +131,88 @@ func (e *expr) Match(whats ...string) bool {
 	return false
 }
 
-func isSoft(value string) bool {
-	if value[0] == '~' {
-		return true
+// matchVersion compares the versions found in whats to the version of the
+// expression, and returns true if one of them satisfies the comparison.
+func (e *expr) matchVersion(whats ...string) bool {
+	expected, ok := parseVersion(e.value)
+	if !ok {
+		log.Errorf("Invalid version %q", e.value)
+		return false
+	}
+
+	for _, what := range whats {
+		version, ok := parseVersion(what)
+		if !ok {
+			continue
+		}
+		cmp := compareVersions(version, expected)
+		switch e.operator {
+		case GTE:
+			ok = cmp >= 0
+		case LTE:
+			ok = cmp <= 0
+		case GT:
+			ok = cmp > 0
+		case LT:
+			ok = cmp < 0
+		}
+		if ok {
+			return true
+		}
 	}
 	return false
 }
+
+// parseVersion extracts the first dotted number from a version string as
+// reported by engines, such as "4.9.0-8-amd64", "3.10.0-957.el7.x86_64" or
+// "Ubuntu 16.04.2 LTS".
+func parseVersion(value string) ([]uint64, bool) {
+	match := versionRegexp.FindString(value)
+	if match == "" {
+		return nil, false
+	}
+	parts := strings.Split(match, ".")
+	version := make([]uint64, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		version[i] = n
+	}
+	return version, true
+}
+
+// compareVersions returns -1, 0 or 1 if a is lower, equal or greater than b.
+// Missing components are considered to be 0, so 4.9 equals 4.9.0.
+func compareVersions(a, b []uint64) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y uint64
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+func isSoft(value string) bool {
+	return strings.Contains(qualifiers(value), "~")
+}
+
+func isPlacementOnly(value string) bool {
+	return strings.Contains(qualifiers(value), "@")
+}
+
+// qualifiers returns the qualifiers at the beginning of the value.
+func qualifiers(value string) string {
+	return value[:len(value)-len(strings.TrimLeft(value, "~@"))]
+}
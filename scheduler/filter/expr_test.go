@@ -58,6 +58,19 @@ func TestParseExprs(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, exprs[0].key, "node")
 	assert.Equal(t, exprs[0].value, "node 1")
+
+	// Allow the soft and placement-only qualifiers in any order
+	exprs, err = parseExprs([]string{"node==~node1", "node==@node1", "node!=~@node1", "node!=@~node1"})
+	assert.NoError(t, err)
+	for i, e := range []struct{ soft, placementOnly bool }{{true, false}, {false, true}, {true, true}, {true, true}} {
+		assert.Equal(t, "node1", exprs[i].value)
+		assert.Equal(t, e.soft, exprs[i].isSoft)
+		assert.Equal(t, e.placementOnly, exprs[i].isPlacementOnly)
+	}
+
+	// Qualifiers can't be repeated
+	_, err = parseExprs([]string{"node==@@node1"})
+	assert.Error(t, err)
 }
 
 func TestMatch(t *testing.T) {
@@ -82,3 +95,57 @@ func TestMatch(t *testing.T) {
 	assert.False(t, e.Match("fuo"))
 	assert.False(t, e.Match("foo", "fuo", "bar"))
 }
+
+func TestParseComparisonExprs(t *testing.T) {
+	exprs, err := parseExprs([]string{"kernelversion>=4.9", "kernelversion<5", "operatingsystem>~16.04", "kernelversion<=4.19.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, GTE, exprs[0].operator)
+	assert.Equal(t, "4.9", exprs[0].value)
+	assert.Equal(t, LT, exprs[1].operator)
+	assert.Equal(t, GT, exprs[2].operator)
+	assert.True(t, exprs[2].isSoft)
+	assert.Equal(t, LTE, exprs[3].operator)
+
+	// The values can't contain comparison operators.
+	_, err = parseExprs([]string{"node==a>b"})
+	assert.Error(t, err)
+}
+
+func TestMatchVersion(t *testing.T) {
+	e := expr{operator: GTE, value: "4.9"}
+	assert.True(t, e.Match("4.9"))
+	assert.True(t, e.Match("4.9.0-8-amd64"))
+	assert.True(t, e.Match("4.10.0"))
+	assert.True(t, e.Match("4.14.123-111.109.amzn2.x86_64"))
+	assert.True(t, e.Match("5.4.0-1021-aws"))
+	assert.False(t, e.Match("4.4.0-21-generic"))
+	assert.False(t, e.Match("3.10.0-957.el7.x86_64"))
+	// Versions are compared numerically, not lexically.
+	assert.False(t, e.Match("4.8.99"))
+	// Nodes without a version don't match.
+	assert.False(t, e.Match(""))
+	assert.False(t, e.Match("unknown"))
+	assert.True(t, e.Match("unknown", "4.19.76-linuxkit"))
+
+	e = expr{operator: GT, value: "4.9"}
+	assert.False(t, e.Match("4.9.0"))
+	assert.True(t, e.Match("4.9.1"))
+
+	e = expr{operator: LTE, value: "4.9"}
+	assert.True(t, e.Match("4.9.0-8-amd64"))
+	assert.False(t, e.Match("4.9.1"))
+
+	e = expr{operator: LT, value: "16.04"}
+	assert.True(t, e.Match("Ubuntu 14.04.5 LTS"))
+	assert.False(t, e.Match("Ubuntu 16.04.2 LTS"))
+
+	// Invalid versions never match.
+	e = expr{operator: GTE, value: "latest"}
+	assert.False(t, e.Match("4.9"))
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, compareVersions([]uint64{4, 9}, []uint64{4, 9, 0}))
+	assert.Equal(t, -1, compareVersions([]uint64{4, 9}, []uint64{4, 10}))
+	assert.Equal(t, 1, compareVersions([]uint64{5}, []uint64{4, 19, 76}))
+}
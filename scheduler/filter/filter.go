@@ -29,12 +29,18 @@ var (
 func init() {
 	filters = []Filter{
 		&HealthFilter{},
+		&CordonFilter{},
 		&PortFilter{},
 		&SlotsFilter{},
 		&DependencyFilter{},
 		&AffinityFilter{},
 		&ConstraintFilter{},
 		&WhitelistFilter{},
+		&DeviceFilter{},
+		&DedicatedFilter{},
+		&BandwidthFilter{},
+		&GPUFilter{},
+		&CpusetFilter{},
 	}
 }
 
@@ -69,8 +75,10 @@ func ApplyFilters(filters []Filter, config *cluster.ContainerConfig, nodes []*no
 	for _, filter := range filters {
 		candidates, err = filter.Filter(config, candidates, soft)
 		if err != nil {
-			// special case for when no healthy nodes are found
-			if filter.Name() == "health" {
+			// special case for when no healthy or uncordoned nodes are
+			// found, or no node provides the devices, the free GPUs or the
+			// CPUs of the container
+			if filter.Name() == "health" || filter.Name() == "cordon" || filter.Name() == "device" || filter.Name() == "gpu" || filter.Name() == "cpuset" {
 				return nil, err
 			}
 			return nil, fmt.Errorf("Unable to find a node that satisfies the following conditions %s", listAllFilters(filters, config, filter.Name()))
@@ -0,0 +1,54 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+var (
+	// ErrNoNodeWithFreeGPUs is exported
+	ErrNoNodeWithFreeGPUs = errors.New("No node with enough free GPUs available in the cluster")
+)
+
+// GPUFilter only schedules the containers reserving GPUs on the nodes whose
+// GPUs, as advertised by their gpus label, can take them on top of the GPUs
+// already reserved. The nodes without GPUs take none of these containers.
+type GPUFilter struct {
+}
+
+// Name returns the name of the filter
+func (f *GPUFilter) Name() string {
+	return "gpu"
+}
+
+// Filter is exported
+func (f *GPUFilter) Filter(config *cluster.ContainerConfig, nodes []*node.Node, _ bool) ([]*node.Node, error) {
+	gpus := config.GPUs()
+	if gpus == 0 {
+		return nodes, nil
+	}
+
+	result := []*node.Node{}
+	for _, node := range nodes {
+		if node.UsedGPUs+gpus <= node.TotalGPUs {
+			result = append(result, node)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNoNodeWithFreeGPUs
+	}
+	return result, nil
+}
+
+// GetFilters returns the GPUs the container reserves
+func (f *GPUFilter) GetFilters(config *cluster.ContainerConfig) ([]string, error) {
+	gpus := config.GPUs()
+	if gpus == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("%d free GPUs", gpus)}, nil
+}
@@ -0,0 +1,83 @@
+package filter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func gpuConfig(gpus string) *cluster.ContainerConfig {
+	config := cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	if gpus != "" {
+		config.Labels[cluster.GPUsLabel] = gpus
+	}
+	return config
+}
+
+func TestGPUFilter(t *testing.T) {
+	var (
+		f     = GPUFilter{}
+		nodes = []*node.Node{
+			{
+				ID:        "node-0-id",
+				Name:      "node-0-name",
+				TotalGPUs: 4,
+			},
+			{
+				ID:        "node-1-id",
+				Name:      "node-1-name",
+				TotalGPUs: 2,
+			},
+			{
+				ID:   "node-2-id",
+				Name: "node-2-name",
+			},
+		}
+	)
+
+	// Two 2 GPU jobs fill the first node, a third one goes on the second,
+	// and a fourth one can't be placed: the node without GPUs takes none.
+	config := gpuConfig("2")
+	for i, expected := range []string{"node-0-id", "node-0-id", "node-1-id"} {
+		result, err := f.Filter(config, nodes, true)
+		assert.NoError(t, err)
+		if assert.NotEmpty(t, result) {
+			assert.Equal(t, expected, result[0].ID)
+			c := &cluster.Container{Container: types.Container{ID: fmt.Sprintf("c%d", i)}, Config: config}
+			assert.NoError(t, result[0].AddContainer(c))
+		}
+	}
+	assert.Equal(t, int64(4), nodes[0].UsedGPUs)
+	assert.Equal(t, int64(2), nodes[1].UsedGPUs)
+	_, err := f.Filter(config, nodes, true)
+	assert.Equal(t, ErrNoNodeWithFreeGPUs, err)
+
+	// The error is reported as is by the batch of filters.
+	_, err = ApplyFilters([]Filter{&f}, config, nodes, true)
+	assert.Equal(t, ErrNoNodeWithFreeGPUs, err)
+
+	// The containers reserving no GPUs fit anywhere.
+	result, err := f.Filter(gpuConfig(""), nodes, true)
+	assert.NoError(t, err)
+	assert.Len(t, result, 3)
+	nodes[1].UsedGPUs = 1
+	result, err = f.Filter(gpuConfig("1"), nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[1]}, result)
+}
+
+func TestGPUFilterGetFilters(t *testing.T) {
+	f := GPUFilter{}
+	filters, err := f.GetFilters(gpuConfig(""))
+	assert.NoError(t, err)
+	assert.Empty(t, filters)
+	filters, err = f.GetFilters(gpuConfig("2"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2 free GPUs"}, filters)
+}
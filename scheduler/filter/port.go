@@ -32,8 +32,11 @@ func (p *PortFilter) Filter(config *cluster.ContainerConfig, nodes []*node.Node,
 func (p *PortFilter) filterHost(config *cluster.ContainerConfig, nodes []*node.Node) ([]*node.Node, error) {
 	for port := range config.ExposedPorts {
 		candidates := []*node.Node{}
+		// In the host mode, the port is also unavailable if a container
+		// publishes it through a bridge.
+		binding := nat.PortBinding{HostPort: port.Port()}
 		for _, node := range nodes {
-			if !p.portAlreadyExposed(node, string(port)) {
+			if !p.portAlreadyExposed(node, string(port)) && !p.portAlreadyInUse(node, binding) {
 				candidates = append(candidates, node)
 			}
 		}
@@ -50,7 +53,7 @@ func (p *PortFilter) filterBridge(config *cluster.ContainerConfig, nodes []*node
 		for _, binding := range port {
 			candidates := []*node.Node{}
 			for _, node := range nodes {
-				if !p.portAlreadyInUse(node, binding) {
+				if !p.portAlreadyInUse(node, binding) && !p.hostPortAlreadyExposed(node, binding.HostPort) {
 					candidates = append(candidates, node)
 				}
 			}
@@ -65,17 +68,46 @@ func (p *PortFilter) filterBridge(config *cluster.ContainerConfig, nodes []*node
 
 func (p *PortFilter) portAlreadyExposed(node *node.Node, requestedPort string) bool {
 	for _, c := range node.Containers {
-		if c.Info.HostConfig != nil && c.Info.HostConfig.NetworkMode == "host" {
-			for port := range c.Info.Config.ExposedPorts {
-				if string(port) == requestedPort {
-					return true
-				}
+		for port := range hostModeExposedPorts(c) {
+			if string(port) == requestedPort {
+				return true
 			}
 		}
 	}
 	return false
 }
 
+// hostPortAlreadyExposed returns true if a container in the host mode exposes
+// the port requested by a bridge binding, whatever its protocol.
+func (p *PortFilter) hostPortAlreadyExposed(node *node.Node, hostPort string) bool {
+	if hostPort == "" {
+		return false
+	}
+	for _, c := range node.Containers {
+		for port := range hostModeExposedPorts(c) {
+			if port.Port() == hostPort {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostModeExposedPorts returns the ports exposed by a container in the host
+// mode. Pending containers, whose ID is empty, only have their configuration.
+func hostModeExposedPorts(c *cluster.Container) nat.PortSet {
+	if c.ID == "" {
+		if c.Config != nil && c.Config.HostConfig.NetworkMode == "host" {
+			return c.Config.ExposedPorts
+		}
+		return nil
+	}
+	if c.Info.HostConfig != nil && c.Info.HostConfig.NetworkMode == "host" && c.Info.Config != nil {
+		return c.Info.Config.ExposedPorts
+	}
+	return nil
+}
+
 func (p *PortFilter) portAlreadyInUse(node *node.Node, requested nat.PortBinding) bool {
 	for _, c := range node.Containers {
 		// HostConfig.PortBindings contains the requested ports.
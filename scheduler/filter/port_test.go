@@ -354,3 +354,78 @@ func TestPortFilterForHostMode(t *testing.T) {
 	assert.Equal(t, 2, len(result))
 	assert.NotContains(t, result, nodes[0])
 }
+
+func TestPortFilterHostModeBridgeConflicts(t *testing.T) {
+	var (
+		p     = PortFilter{}
+		nodes = []*node.Node{
+			{
+				ID:   "node-1-id",
+				Name: "node-1-name",
+				Addr: "node-1",
+			},
+			{
+				ID:   "node-2-id",
+				Name: "node-2-name",
+				Addr: "node-2",
+			},
+			{
+				ID:   "node-3-id",
+				Name: "node-3-name",
+				Addr: "node-3",
+			},
+		}
+		result []*node.Node
+		err    error
+	)
+
+	// Publish port 80 through a bridge on nodes[0].
+	bridge := &cluster.Container{
+		Container: types.Container{ID: "c1"},
+		Info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				HostConfig: &containertypes.HostConfig{
+					PortBindings: makeBinding("", "80"),
+				},
+			},
+		}}
+	assert.NoError(t, nodes[0].AddContainer(bridge))
+
+	// A pending container exposes port 80 in the host mode on nodes[1].
+	pending := &cluster.Container{
+		Config: &cluster.ContainerConfig{Config: containertypes.Config{
+			ExposedPorts: map[nat.Port]struct{}{nat.Port("80/tcp"): {}},
+		}, HostConfig: containertypes.HostConfig{
+			NetworkMode: containertypes.NetworkMode("host"),
+		}},
+	}
+	assert.NoError(t, nodes[1].AddContainer(pending))
+
+	// Request port 80 in the host mode, only nodes[2] has it available.
+	config := &cluster.ContainerConfig{Config: containertypes.Config{
+		ExposedPorts: map[nat.Port]struct{}{nat.Port("80/tcp"): {}},
+	}, HostConfig: containertypes.HostConfig{
+		NetworkMode: containertypes.NetworkMode("host"),
+	}, NetworkingConfig: networktypes.NetworkingConfig{}}
+	result, err = p.Filter(config, nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[2]}, result)
+
+	// Binding port 80 through a bridge is neither possible on nodes[1].
+	config = &cluster.ContainerConfig{HostConfig: containertypes.HostConfig{
+		PortBindings: makeBinding("", "80"),
+	}}
+	result, err = p.Filter(config, nodes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[2]}, result)
+
+	// Other ports are available everywhere.
+	config = &cluster.ContainerConfig{Config: containertypes.Config{
+		ExposedPorts: map[nat.Port]struct{}{nat.Port("8080/tcp"): {}},
+	}, HostConfig: containertypes.HostConfig{
+		NetworkMode: containertypes.NetworkMode("host"),
+	}, NetworkingConfig: networktypes.NetworkingConfig{}}
+	result, err = p.Filter(config, nodes, true)
+	assert.NoError(t, err)
+	assert.Len(t, result, 3)
+}
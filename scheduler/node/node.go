@@ -20,8 +20,27 @@ type Node struct {
 	UsedCpus    int64
 	TotalMemory int64
 	TotalCpus   int64
+	// Cpus is the number of CPUs of the node, without the overcommit, 0 if
+	// unknown.
+	Cpus int64
+	// MemoryUsage is the actual memory used by the containers, from their
+	// stats, or 0 if unknown.
+	MemoryUsage int64
+	// UsedBandwidth is the bandwidth reserved by the containers, out of the
+	// NIC capacity of the node, TotalBandwidth, 0 if unlimited.
+	UsedBandwidth  int64
+	TotalBandwidth int64
+	// UsedGPUs is the number of GPUs reserved by the containers, out of the
+	// GPUs of the node, TotalGPUs.
+	UsedGPUs  int64
+	TotalGPUs int64
+	// SchedulingLoad is the sum of the scheduling weights of the containers,
+	// in percent of the node.
+	SchedulingLoad int64
 
 	HealthIndicator int64
+	// Cordoned is set when the node takes no new containers.
+	Cordoned bool
 }
 
 // NewNode creates a node from an engine.
@@ -38,10 +57,72 @@ func NewNode(e *cluster.Engine) *Node {
 		UsedCpus:        e.UsedCpus(),
 		TotalMemory:     e.TotalMemory(),
 		TotalCpus:       e.TotalCpus(),
+		Cpus:            e.Cpus,
+		MemoryUsage:     e.MemoryUsage(),
+		UsedBandwidth:   e.UsedBandwidth(),
+		TotalBandwidth:  e.TotalBandwidth(),
+		UsedGPUs:        e.UsedGPUs(),
+		TotalGPUs:       e.TotalGPUs(),
+		SchedulingLoad:  e.SchedulingLoad(),
 		HealthIndicator: e.HealthIndicator(),
+		Cordoned:        e.IsCordoned(),
 	}
 }
 
+// NewNodeFromState creates a node from the state of an engine, without any
+// engine behind it.
+func NewNodeFromState(state cluster.NodeState) *Node {
+	n := &Node{
+		ID:              state.ID,
+		IP:              state.IP,
+		Addr:            state.Addr,
+		Name:            state.Name,
+		Labels:          state.Labels,
+		Containers:      cluster.Containers{},
+		TotalMemory:     state.TotalMemory,
+		TotalCpus:       state.TotalCpus,
+		TotalBandwidth:  cluster.BandwidthCapacity(state.Labels),
+		TotalGPUs:       cluster.GPUCapacity(state.Labels),
+		HealthIndicator: state.HealthIndicator,
+		Cordoned:        state.Cordoned,
+	}
+	for _, image := range state.Images {
+		n.Images = append(n.Images, image.ToImage())
+	}
+	for _, c := range state.Containers {
+		container := c.ToContainer()
+		n.UsedMemory += container.Config.HostConfig.Memory
+		n.UsedCpus += container.Config.HostConfig.CPUShares
+		n.UsedBandwidth += container.Config.Bandwidth()
+		n.UsedGPUs += container.Config.GPUs()
+		n.SchedulingLoad += container.Config.SchedulingWeight()
+		n.Containers = append(n.Containers, container)
+	}
+	return n
+}
+
+// State returns the state of the node.
+func (n *Node) State() cluster.NodeState {
+	state := cluster.NodeState{
+		ID:              n.ID,
+		Name:            n.Name,
+		IP:              n.IP,
+		Addr:            n.Addr,
+		Labels:          n.Labels,
+		TotalMemory:     n.TotalMemory,
+		TotalCpus:       n.TotalCpus,
+		HealthIndicator: n.HealthIndicator,
+		Cordoned:        n.Cordoned,
+	}
+	for _, image := range n.Images {
+		state.Images = append(state.Images, cluster.ImageState{ID: image.ID, RepoTags: image.RepoTags})
+	}
+	for _, c := range n.Containers {
+		state.Containers = append(state.Containers, cluster.NewContainerState(c))
+	}
+	return state
+}
+
 // IsHealthy responses if node is in healthy state
 func (n *Node) IsHealthy() bool {
 	return n.HealthIndicator > 0
@@ -62,6 +143,9 @@ func (n *Node) AddContainer(container *cluster.Container) error {
 		}
 		n.UsedMemory = n.UsedMemory + memory
 		n.UsedCpus = n.UsedCpus + cpus
+		n.UsedBandwidth = n.UsedBandwidth + container.Config.Bandwidth()
+		n.UsedGPUs = n.UsedGPUs + container.Config.GPUs()
+		n.SchedulingLoad = n.SchedulingLoad + container.Config.SchedulingWeight()
 	}
 	n.Containers = append(n.Containers, container)
 	return nil
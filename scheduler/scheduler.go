@@ -2,6 +2,8 @@ package scheduler
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"strings"
 	"sync"
 
@@ -13,6 +15,9 @@ import (
 
 var (
 	errNoNodeAvailable = errors.New("No nodes available in the cluster")
+	// ErrNoNodeAboveMinHealth is returned when all the nodes are below the
+	// minimum health of the scheduler.
+	ErrNoNodeAboveMinHealth = errors.New("No node above the minimum health available in the cluster")
 )
 
 // Scheduler is exported
@@ -21,6 +26,13 @@ type Scheduler struct {
 
 	strategy strategy.PlacementStrategy
 	filters  []filter.Filter
+	// minNodeHealth is the health indicator, between 0 and 100, below which
+	// the nodes are excluded from scheduling.
+	minNodeHealth int64
+
+	// strategies caches the strategies requested by containers, by name.
+	strategiesLock sync.Mutex
+	strategies     map[string]strategy.PlacementStrategy
 }
 
 // New is exported
@@ -31,6 +43,45 @@ func New(strategy strategy.PlacementStrategy, filters []filter.Filter) *Schedule
 	}
 }
 
+// SetMinNodeHealth excludes from scheduling the nodes whose health, between 0
+// and 1, is below minHealth, e.g. the nodes failing to refresh which are
+// not yet unhealthy. Those are otherwise only deprioritized by the
+// strategies. 0, the default, keeps all the healthy nodes. It must be set
+// before the scheduler is used.
+func (s *Scheduler) SetMinNodeHealth(minHealth float64) {
+	s.minNodeHealth = int64(math.Ceil(minHealth * 100))
+}
+
+// strategyFor returns the placement strategy requested by the container
+// config, or the scheduler's strategy if the config doesn't request any.
+func (s *Scheduler) strategyFor(config *cluster.ContainerConfig) (strategy.PlacementStrategy, error) {
+	name := config.Strategy()
+	if name == "" || name == s.strategy.Name() {
+		return s.strategy, nil
+	}
+
+	s.strategiesLock.Lock()
+	defer s.strategiesLock.Unlock()
+
+	if placement, ok := s.strategies[name]; ok {
+		return placement, nil
+	}
+
+	placement, err := strategy.New(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid strategy %s, supported strategies are: %s", name, strings.Join(strategy.List(), ", "))
+	}
+	if placement.Name() == s.strategy.Name() && !strings.Contains(name, ":") {
+		// name is an alias of the scheduler's strategy
+		return s.strategy, nil
+	}
+	if s.strategies == nil {
+		s.strategies = make(map[string]strategy.PlacementStrategy)
+	}
+	s.strategies[name] = placement
+	return placement, nil
+}
+
 // SelectNodesForContainer will return a list of nodes where the container can
 // be scheduled, sorted by order or preference.
 func (s *Scheduler) SelectNodesForContainer(nodes []*node.Node, config *cluster.ContainerConfig) ([]*node.Node, error) {
@@ -43,6 +94,24 @@ func (s *Scheduler) SelectNodesForContainer(nodes []*node.Node, config *cluster.
 }
 
 func (s *Scheduler) selectNodesForContainer(nodes []*node.Node, config *cluster.ContainerConfig, soft bool) ([]*node.Node, error) {
+	placement, err := s.strategyFor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.minNodeHealth > 0 {
+		healthy := []*node.Node{}
+		for _, n := range nodes {
+			if n.HealthIndicator >= s.minNodeHealth {
+				healthy = append(healthy, n)
+			}
+		}
+		if len(healthy) == 0 {
+			return nil, ErrNoNodeAboveMinHealth
+		}
+		nodes = healthy
+	}
+
 	accepted, err := filter.ApplyFilters(s.filters, config, nodes, soft)
 	if err != nil {
 		return nil, err
@@ -52,7 +121,7 @@ func (s *Scheduler) selectNodesForContainer(nodes []*node.Node, config *cluster.
 		return nil, errNoNodeAvailable
 	}
 
-	return s.strategy.RankAndSort(config, accepted)
+	return placement.RankAndSort(config, accepted)
 }
 
 // Strategy returns the strategy name
@@ -58,3 +58,215 @@ func TestSelectNodesForContainer(t *testing.T) {
 	assert.Equal(t, "node-1-id", candidates[0].ID)
 
 }
+
+func TestSelectNodesForContainerStrategyLabel(t *testing.T) {
+	var (
+		s = New(&strategy.SpreadPlacementStrategy{}, []filter.Filter{})
+
+		nodes = []*node.Node{
+			{
+				ID:          "node-0-id",
+				Name:        "node-0-name",
+				Addr:        "node-0",
+				TotalMemory: 4 * 1024 * 1024 * 1024,
+				UsedMemory:  2 * 1024 * 1024 * 1024,
+				TotalCpus:   4,
+			},
+
+			{
+				ID:          "node-1-id",
+				Name:        "node-1-name",
+				Addr:        "node-1",
+				TotalMemory: 4 * 1024 * 1024 * 1024,
+				TotalCpus:   4,
+			},
+		}
+
+		resources = containertypes.HostConfig{
+			Resources: containertypes.Resources{
+				Memory: 1024 * 1024 * 1024,
+			},
+		}
+	)
+
+	// Without label, the cluster strategy spreads the container.
+	config := cluster.BuildContainerConfig(containertypes.Config{}, resources, networktypes.NetworkingConfig{})
+	candidates, err := s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1-id", candidates[0].ID)
+
+	// The label overrides the cluster strategy for this container only.
+	config = cluster.BuildContainerConfig(containertypes.Config{
+		Labels: map[string]string{"com.docker.swarm.strategy": "binpack"},
+	}, resources, networktypes.NetworkingConfig{})
+	candidates, err = s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-0-id", candidates[0].ID)
+	assert.Equal(t, "spread", s.Strategy())
+
+	config = cluster.BuildContainerConfig(containertypes.Config{
+		Labels: map[string]string{"com.docker.swarm.strategy": "spread"},
+	}, resources, networktypes.NetworkingConfig{})
+	candidates, err = s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1-id", candidates[0].ID)
+
+	// Unknown strategies are rejected.
+	config = cluster.BuildContainerConfig(containertypes.Config{
+		Labels: map[string]string{"com.docker.swarm.strategy": "unknown"},
+	}, resources, networktypes.NetworkingConfig{})
+	_, err = s.SelectNodesForContainer(nodes, config)
+	assert.Error(t, err)
+}
+
+func TestSelectNodesForContainerMemoryUsage(t *testing.T) {
+	var (
+		// Both nodes have the same reservations but node-0 actually uses
+		// most of its memory.
+		nodes = []*node.Node{
+			{
+				ID:          "node-0-id",
+				Name:        "node-0-name",
+				Addr:        "node-0",
+				TotalMemory: 4 * 1024 * 1024 * 1024,
+				UsedMemory:  1024 * 1024 * 1024,
+				MemoryUsage: 3 * 1024 * 1024 * 1024,
+				TotalCpus:   4,
+			},
+
+			{
+				ID:          "node-1-id",
+				Name:        "node-1-name",
+				Addr:        "node-1",
+				TotalMemory: 4 * 1024 * 1024 * 1024,
+				UsedMemory:  1024 * 1024 * 1024,
+				MemoryUsage: 512 * 1024 * 1024,
+				TotalCpus:   4,
+			},
+		}
+
+		resources = containertypes.HostConfig{
+			Resources: containertypes.Resources{
+				Memory: 1024 * 1024 * 1024,
+			},
+		}
+	)
+
+	for _, placement := range []strategy.PlacementStrategy{&strategy.SpreadPlacementStrategy{}, &strategy.BinpackPlacementStrategy{}} {
+		s := New(placement, []filter.Filter{})
+		config := cluster.BuildContainerConfig(containertypes.Config{
+			Labels: map[string]string{"com.docker.swarm.memory-usage-weight": "0.5"},
+		}, resources, networktypes.NetworkingConfig{})
+		candidates, err := s.SelectNodesForContainer(nodes, config)
+		assert.NoError(t, err)
+		assert.Len(t, candidates, 2)
+		assert.Equal(t, "node-1-id", candidates[0].ID, placement.Name())
+	}
+
+	// The strategy prevails over a small difference of actual usage.
+	nodes[0].UsedMemory = 0
+	nodes[0].MemoryUsage = 640 * 1024 * 1024
+	s := New(&strategy.SpreadPlacementStrategy{}, []filter.Filter{})
+	config := cluster.BuildContainerConfig(containertypes.Config{
+		Labels: map[string]string{"com.docker.swarm.memory-usage-weight": "0.5"},
+	}, resources, networktypes.NetworkingConfig{})
+	candidates, err := s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-0-id", candidates[0].ID)
+
+	// Without stats, the reservations stand for the actual usage.
+	nodes[0].MemoryUsage = 0
+	nodes[1].MemoryUsage = 0
+	config = cluster.BuildContainerConfig(containertypes.Config{
+		Labels: map[string]string{"com.docker.swarm.memory-usage-weight": "1"},
+	}, resources, networktypes.NetworkingConfig{})
+	candidates, err = s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-0-id", candidates[0].ID)
+}
+
+func TestSelectNodesForContainerSpotNodes(t *testing.T) {
+	var (
+		s = Scheduler{
+			strategy: &strategy.BinpackPlacementStrategy{},
+			filters:  []filter.Filter{&filter.ConstraintFilter{}},
+		}
+
+		nodes = []*node.Node{
+			{
+				ID:          "node-0-id",
+				Name:        "node-0-name",
+				Addr:        "node-0",
+				TotalMemory: 1 * 1024 * 1024 * 1024,
+				TotalCpus:   1,
+				Labels: map[string]string{
+					"lifecycle": "spot",
+				},
+			},
+
+			{
+				ID:          "node-1-id",
+				Name:        "node-1-name",
+				Addr:        "node-1",
+				TotalMemory: 1 * 1024 * 1024 * 1024,
+				TotalCpus:   1,
+			},
+		}
+	)
+
+	// A critical container never lands on a spot node.
+	config := cluster.BuildContainerConfig(containertypes.Config{
+		Env: []string{"constraint:lifecycle!=spot"},
+	}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	candidates, err := s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(candidates))
+	assert.Equal(t, "node-1-id", candidates[0].ID)
+
+	_, err = s.SelectNodesForContainer(nodes[:1], config)
+	assert.Error(t, err)
+
+	// The watchdog discourages spot nodes with a soft constraint, they are
+	// only used when no other node fits.
+	config = cluster.BuildContainerConfig(containertypes.Config{
+		Env: []string{"constraint:lifecycle!=~spot"},
+	}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+	candidates, err = s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(candidates))
+	assert.Equal(t, "node-1-id", candidates[0].ID)
+
+	candidates, err = s.SelectNodesForContainer(nodes[:1], config)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-0-id", candidates[0].ID)
+}
+
+func TestSelectNodesForContainerMinNodeHealth(t *testing.T) {
+	s := New(&strategy.SpreadPlacementStrategy{}, []filter.Filter{&filter.HealthFilter{}})
+	s.SetMinNodeHealth(0.5)
+
+	nodes := []*node.Node{
+		{ID: "degraded", TotalMemory: 1024, TotalCpus: 1, HealthIndicator: 34},
+		{ID: "recovering", TotalMemory: 1024, TotalCpus: 1, HealthIndicator: 50},
+	}
+	config := cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{}, networktypes.NetworkingConfig{})
+
+	// The node below the minimum health is excluded, even though it is
+	// empty and healthy to the health filter.
+	nodes[1].UsedMemory = 512
+	candidates, err := s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	if assert.Len(t, candidates, 1) {
+		assert.Equal(t, "recovering", candidates[0].ID)
+	}
+
+	nodes[1].HealthIndicator = 34
+	_, err = s.SelectNodesForContainer(nodes, config)
+	assert.Equal(t, ErrNoNodeAboveMinHealth, err)
+
+	// Without minimum health, the degraded nodes are only deprioritized.
+	s.SetMinNodeHealth(0)
+	candidates, err = s.SelectNodesForContainer(nodes, config)
+	assert.NoError(t, err)
+	assert.Len(t, candidates, 2)
+}
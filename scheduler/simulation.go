@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+// Simulation runs the filters and strategy of a scheduler against an
+// in-memory cluster state, without touching real engines. It can be used for
+// capacity planning, or to foresee the outcome of a node failure.
+type Simulation struct {
+	scheduler *Scheduler
+	nodes     []*node.Node
+	created   int
+}
+
+// NewSimulation returns a simulation of the scheduler over a cluster state.
+func (s *Scheduler) NewSimulation(state cluster.ClusterState) *Simulation {
+	sim := &Simulation{scheduler: s}
+	for _, n := range state.Nodes {
+		sim.nodes = append(sim.nodes, node.NewNodeFromState(n))
+	}
+	return sim
+}
+
+// Place selects a node for a new container and adds the container to it.
+func (sim *Simulation) Place(config *cluster.ContainerConfig, name string) (*node.Node, error) {
+	nodes, err := sim.scheduler.SelectNodesForContainer(sim.nodes, config)
+	if err != nil {
+		return nil, err
+	}
+
+	sim.created++
+	state := cluster.ContainerState{
+		ID:      fmt.Sprintf("simulated-%d", sim.created),
+		Image:   config.Image,
+		Running: true,
+		Config:  config,
+	}
+	if name != "" {
+		state.Names = []string{"/" + strings.TrimPrefix(name, "/")}
+	}
+	n := nodes[0]
+	if err := n.AddContainer(state.ToContainer()); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// FailNode removes a node from the simulation, and places the containers
+// having an on-node-failure reschedule policy on the remaining nodes like the
+// watchdog would. It returns the new node of the rescheduled containers by
+// their former ID, and an error listing the containers which couldn't be
+// placed.
+func (sim *Simulation) FailNode(IDOrName string) (map[string]*node.Node, error) {
+	var failed *node.Node
+	for i, n := range sim.nodes {
+		if n.ID == IDOrName || n.Name == IDOrName {
+			failed = n
+			sim.nodes = append(sim.nodes[:i], sim.nodes[i+1:]...)
+			break
+		}
+	}
+	if failed == nil {
+		return nil, fmt.Errorf("node %s not found", IDOrName)
+	}
+
+	containers := cluster.Containers{}
+	for _, c := range failed.Containers {
+		if c.Config != nil && c.Config.HasReschedulePolicy("on-node-failure") {
+			containers = append(containers, c)
+		}
+	}
+	sort.Sort(containersByID(containers))
+
+	placed := make(map[string]*node.Node)
+	errs := []string{}
+	for _, c := range containers {
+		name := ""
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		n, err := sim.Place(c.Config, name)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", c.ID, err))
+			continue
+		}
+		placed[c.ID] = n
+	}
+	if len(errs) > 0 {
+		return placed, fmt.Errorf("unable to reschedule containers %s", strings.Join(errs, ", "))
+	}
+	return placed, nil
+}
+
+// State returns the simulated cluster state.
+func (sim *Simulation) State() cluster.ClusterState {
+	state := cluster.ClusterState{}
+	for _, n := range sim.nodes {
+		state.Nodes = append(state.Nodes, n.State())
+	}
+	return state
+}
+
+type containersByID cluster.Containers
+
+func (c containersByID) Len() int           { return len(c) }
+func (c containersByID) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c containersByID) Less(i, j int) bool { return c[i].ID < c[j].ID }
@@ -0,0 +1,168 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/filter"
+	"github.com/docker/swarm/scheduler/strategy"
+	"github.com/stretchr/testify/assert"
+)
+
+func simulationConfig(memory int64, labels map[string]string) *cluster.ContainerConfig {
+	return cluster.BuildContainerConfig(containertypes.Config{Labels: labels}, containertypes.HostConfig{
+		Resources: containertypes.Resources{Memory: memory},
+	}, networktypes.NetworkingConfig{})
+}
+
+func simulationState() cluster.ClusterState {
+	reschedulable := map[string]string{"com.docker.swarm.reschedule-policies": `["on-node-failure"]`}
+	return cluster.ClusterState{
+		Nodes: []cluster.NodeState{
+			{
+				ID:              "node-0-id",
+				Name:            "node-0-name",
+				TotalMemory:     4,
+				TotalCpus:       1,
+				HealthIndicator: 100,
+				Containers: []cluster.ContainerState{
+					{ID: "c1", Names: []string{"/c1"}, Running: true, Config: simulationConfig(2, reschedulable)},
+					{ID: "c2", Names: []string{"/c2"}, Running: true, Config: simulationConfig(1, nil)},
+				},
+			},
+			{
+				ID:              "node-1-id",
+				Name:            "node-1-name",
+				TotalMemory:     4,
+				TotalCpus:       1,
+				HealthIndicator: 100,
+			},
+			{
+				ID:              "node-2-id",
+				Name:            "node-2-name",
+				TotalMemory:     4,
+				TotalCpus:       1,
+				HealthIndicator: 100,
+				Containers: []cluster.ContainerState{
+					{ID: "c3", Names: []string{"/c3"}, Running: true, Config: simulationConfig(3, nil)},
+				},
+			},
+		},
+	}
+}
+
+func TestSimulationPlace(t *testing.T) {
+	s := New(&strategy.SpreadPlacementStrategy{}, []filter.Filter{&filter.HealthFilter{}, &filter.ConstraintFilter{}})
+
+	// The state can be serialized and fed back to a simulation.
+	data, err := json.Marshal(simulationState())
+	assert.NoError(t, err)
+	var state cluster.ClusterState
+	assert.NoError(t, json.Unmarshal(data, &state))
+
+	sim := s.NewSimulation(state)
+	// Spread picks the empty node.
+	n, err := sim.Place(simulationConfig(2, nil), "new")
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1-id", n.ID)
+
+	// No node has room left for 3.
+	_, err = sim.Place(simulationConfig(3, nil), "big")
+	assert.Error(t, err)
+	n, err = sim.Place(simulationConfig(1, nil), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1-id", n.ID)
+
+	// The constraints are honored.
+	config := simulationConfig(1, nil)
+	config.AddConstraint("node==node-0-name")
+	n, err = sim.Place(config, "pinned")
+	assert.NoError(t, err)
+	assert.Equal(t, "node-0-id", n.ID)
+
+	// The original state is left untouched.
+	after := sim.State()
+	assert.Len(t, state.Nodes[1].Containers, 0)
+	assert.Len(t, after.Nodes[1].Containers, 2)
+	assert.Equal(t, []string{"/new"}, after.Nodes[1].Containers[0].Names)
+}
+
+func TestSimulationFailNode(t *testing.T) {
+	s := New(&strategy.BinpackPlacementStrategy{}, []filter.Filter{&filter.HealthFilter{}})
+
+	sim := s.NewSimulation(simulationState())
+	placed, err := sim.FailNode("node-0-name")
+	assert.NoError(t, err)
+	// Only c1 has a reschedule policy, binpack can't fit it on node-2.
+	assert.Len(t, placed, 1)
+	assert.Equal(t, "node-1-id", placed["c1"].ID)
+	assert.Len(t, sim.State().Nodes, 2)
+
+	// Nothing fits once node-1 fails too.
+	placed, err = sim.FailNode("node-1-id")
+	assert.Error(t, err)
+	assert.Len(t, placed, 0)
+
+	_, err = sim.FailNode("unknown")
+	assert.Error(t, err)
+}
+
+func TestSimulationFailNodeHostNetwork(t *testing.T) {
+	s := New(&strategy.SpreadPlacementStrategy{}, []filter.Filter{&filter.HealthFilter{}, &filter.PortFilter{}})
+
+	hostConfig := func(port string, labels map[string]string) *cluster.ContainerConfig {
+		return cluster.BuildContainerConfig(containertypes.Config{
+			Labels:       labels,
+			ExposedPorts: nat.PortSet{nat.Port(port): {}},
+		}, containertypes.HostConfig{NetworkMode: "host"}, networktypes.NetworkingConfig{})
+	}
+	bridgeConfig := cluster.BuildContainerConfig(containertypes.Config{}, containertypes.HostConfig{
+		PortBindings: nat.PortMap{nat.Port("80/tcp"): {{HostPort: "80"}}},
+	}, networktypes.NetworkingConfig{})
+	reschedulable := map[string]string{"com.docker.swarm.reschedule-policies": `["on-node-failure"]`}
+
+	state := func(conflict bool) cluster.ClusterState {
+		state := cluster.ClusterState{
+			Nodes: []cluster.NodeState{
+				{
+					ID: "node-0-id", Name: "node-0-name", TotalMemory: 4, TotalCpus: 1, HealthIndicator: 100,
+					Containers: []cluster.ContainerState{
+						{ID: "web", Names: []string{"/web"}, Running: true, Config: hostConfig("80/tcp", reschedulable)},
+					},
+				},
+				{
+					ID: "node-1-id", Name: "node-1-name", TotalMemory: 4, TotalCpus: 1, HealthIndicator: 100,
+					Containers: []cluster.ContainerState{
+						{ID: "proxy", Names: []string{"/proxy"}, Running: true, Config: bridgeConfig},
+					},
+				},
+				{
+					ID: "node-2-id", Name: "node-2-name", TotalMemory: 4, TotalCpus: 1, HealthIndicator: 100,
+				},
+			},
+		}
+		if conflict {
+			state.Nodes[2].Containers = []cluster.ContainerState{
+				{ID: "other", Names: []string{"/other"}, Running: true, Config: hostConfig("80/tcp", nil)},
+			}
+		}
+		return state
+	}
+
+	// Port 80 is published through a bridge on node-1, the container can
+	// only be rescheduled on node-2.
+	sim := s.NewSimulation(state(false))
+	placed, err := sim.FailNode("node-0-id")
+	assert.NoError(t, err)
+	assert.Equal(t, "node-2-id", placed["web"].ID)
+
+	// Port 80 is also taken in the host mode on node-2.
+	sim = s.NewSimulation(state(true))
+	placed, err = sim.FailNode("node-0-id")
+	assert.Error(t, err)
+	assert.Len(t, placed, 0)
+}
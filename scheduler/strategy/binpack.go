@@ -26,7 +26,9 @@ func (p *BinpackPlacementStrategy) RankAndSort(config *cluster.ContainerConfig,
 	// for binpack, a healthy node should increase its weight to increase its chance of being selected
 	// set healthFactor to 10 to make health degree [0, 100] overpower cpu + memory (each in range [0, 100])
 	const healthFactor int64 = 10
-	weightedNodes, err := weighNodes(config, nodes, healthFactor)
+	// a node actually using more memory should decrease its weight
+	const usageFactor int64 = -1
+	weightedNodes, err := weighNodes(config, nodes, healthFactor, usageFactor)
 	if err != nil {
 		return nil, err
 	}
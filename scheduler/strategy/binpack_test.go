@@ -82,6 +82,46 @@ func TestPlaceEqualWeight(t *testing.T) {
 
 }
 
+func TestPlaceSchedulingWeight(t *testing.T) {
+	s := &BinpackPlacementStrategy{}
+
+	nodes := []*node.Node{}
+	for i := 0; i < 2; i++ {
+		nodes = append(nodes, createNode(fmt.Sprintf("node-%d", i), 4, 4))
+	}
+
+	// Without weights, the containers reserving nothing are all stacked onto
+	// a single node.
+	for i := 0; i < 6; i++ {
+		config := createConfig(0, 0)
+		node := selectTopNode(t, s, config, nodes)
+		assert.NoError(t, node.AddContainer(createContainer(fmt.Sprintf("c%d", i), config)))
+	}
+	assert.Len(t, nodes[0].Containers, 6)
+	assert.Len(t, nodes[1].Containers, 0)
+
+	// With weights, they still pack, but only until the node is full.
+	for i := range nodes {
+		nodes[i] = createNode(fmt.Sprintf("node-%d", i), 4, 4)
+	}
+	placed := map[string]int{}
+	for i := 0; i < 6; i++ {
+		config := createConfig(0, 0)
+		config.Labels[cluster.SwarmLabelNamespace+".scheduling-weight"] = "30"
+		node := selectTopNode(t, s, config, nodes)
+		assert.NoError(t, node.AddContainer(createContainer(fmt.Sprintf("c%d", i), config)))
+		placed[node.ID]++
+	}
+	assert.Equal(t, map[string]int{"node-0": 3, "node-1": 3}, placed)
+	assert.Equal(t, int64(90), nodes[0].SchedulingLoad)
+
+	// A container too heavy for what is left fits nowhere.
+	config := createConfig(0, 0)
+	config.Labels[cluster.SwarmLabelNamespace+".scheduling-weight"] = "20"
+	_, err := s.RankAndSort(config, nodes)
+	assert.Error(t, err)
+}
+
 func TestPlaceContainerMemory(t *testing.T) {
 	s := &BinpackPlacementStrategy{}
 
@@ -0,0 +1,101 @@
+package strategy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/swarm/cluster"
+	"github.com/docker/swarm/scheduler/node"
+)
+
+const defaultHybridThreshold = 0.7
+
+// HybridPlacementStrategy packs containers onto the fullest nodes until they
+// reach a utilization threshold, then spreads them once every node is above
+// the threshold.
+type HybridPlacementStrategy struct {
+	threshold float64
+}
+
+// Initialize a HybridPlacementStrategy.
+func (p *HybridPlacementStrategy) Initialize() error {
+	if p.threshold == 0 {
+		p.threshold = defaultHybridThreshold
+	}
+	return nil
+}
+
+// Name returns the name of the strategy.
+func (p *HybridPlacementStrategy) Name() string {
+	return "hybrid"
+}
+
+// configure returns a new HybridPlacementStrategy using the threshold option.
+func (p *HybridPlacementStrategy) configure(opts cluster.DriverOpts) (PlacementStrategy, error) {
+	strategy := &HybridPlacementStrategy{}
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if kv[0] != "threshold" || len(kv) != 2 {
+			return nil, fmt.Errorf("invalid option %s for strategy hybrid", opt)
+		}
+		threshold, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			return nil, fmt.Errorf("invalid threshold %s for strategy hybrid, it should be between 0 and 1", kv[1])
+		}
+		strategy.threshold = threshold
+	}
+	return strategy, nil
+}
+
+// RankAndSort sorts the nodes which stay below the utilization threshold once
+// the container is placed with the binpack strategy, followed by the other
+// nodes sorted with the spread strategy.
+func (p *HybridPlacementStrategy) RankAndSort(config *cluster.ContainerConfig, nodes []*node.Node) ([]*node.Node, error) {
+	below := []*node.Node{}
+	above := []*node.Node{}
+	for _, n := range nodes {
+		if utilization(config, n) <= p.threshold {
+			below = append(below, n)
+		} else {
+			above = append(above, n)
+		}
+	}
+
+	output := []*node.Node{}
+	if len(below) > 0 {
+		packed, err := (&BinpackPlacementStrategy{}).RankAndSort(config, below)
+		if err != nil && err != ErrNoResourcesAvailable {
+			return nil, err
+		}
+		output = append(output, packed...)
+	}
+	if len(above) > 0 {
+		spread, err := (&SpreadPlacementStrategy{}).RankAndSort(config, above)
+		if err != nil && err != ErrNoResourcesAvailable {
+			return nil, err
+		}
+		output = append(output, spread...)
+	}
+
+	if len(output) == 0 {
+		return nil, ErrNoResourcesAvailable
+	}
+	return output, nil
+}
+
+// utilization returns the highest of the memory and cpu usage ratios of the
+// node once the container is placed on it.
+func utilization(config *cluster.ContainerConfig, n *node.Node) float64 {
+	var memory, cpus float64
+	if n.TotalMemory > 0 {
+		memory = float64(n.UsedMemory+config.HostConfig.Memory) / float64(n.TotalMemory)
+	}
+	if n.TotalCpus > 0 {
+		cpus = float64(n.UsedCpus+config.HostConfig.CPUShares) / float64(n.TotalCpus)
+	}
+	if cpus > memory {
+		return cpus
+	}
+	return memory
+}
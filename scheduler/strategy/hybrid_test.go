@@ -0,0 +1,59 @@
+package strategy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/swarm/scheduler/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHybridPackThenSpread(t *testing.T) {
+	s, err := New("hybrid:threshold=0.5")
+	assert.NoError(t, err)
+
+	nodes := []*node.Node{}
+	for i := 0; i < 2; i++ {
+		nodes = append(nodes, createNode(fmt.Sprintf("node-%d", i), 4, 4))
+	}
+
+	// Each container uses a quarter of a node.
+	place := func(id string) *node.Node {
+		config := createConfig(1, 1)
+		n := selectTopNode(t, s, config, nodes)
+		assert.NoError(t, n.AddContainer(createContainer(id, config)))
+		return n
+	}
+
+	// The first node is filled up to the threshold.
+	first := place("c1")
+	assert.Equal(t, first, place("c2"))
+
+	// Then the second one.
+	second := place("c3")
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, second, place("c4"))
+
+	// Once every node is above the threshold, containers are spread.
+	n5 := place("c5")
+	n6 := place("c6")
+	assert.NotEqual(t, n5, n6)
+	assert.Len(t, first.Containers, 3)
+	assert.Len(t, second.Containers, 3)
+}
+
+func TestHybridOptions(t *testing.T) {
+	s, err := New("hybrid")
+	assert.NoError(t, err)
+	assert.Equal(t, "hybrid", s.Name())
+	assert.Equal(t, defaultHybridThreshold, s.(*HybridPlacementStrategy).threshold)
+
+	s, err = New("hybrid:threshold=0.9")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.9, s.(*HybridPlacementStrategy).threshold)
+
+	for _, name := range []string{"hybrid:threshold=0", "hybrid:threshold=1.5", "hybrid:threshold=high", "hybrid:threshold", "hybrid:other=1", "spread:threshold=0.5"} {
+		_, err := New(name)
+		assert.Error(t, err, name)
+	}
+}
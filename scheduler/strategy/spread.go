@@ -2,11 +2,19 @@ package strategy
 
 import (
 	"sort"
+	"strconv"
 
 	"github.com/docker/swarm/cluster"
 	"github.com/docker/swarm/scheduler/node"
 )
 
+// SchedulerWeightLabel is the node label weighing the capacity of a node in
+// ways the resources don't capture, e.g. NVMe drives. The spread strategy
+// divides the load of the nodes by their weight, so that a node weighing 2
+// takes twice as many containers. Nodes without the label, or with an invalid
+// one, weigh 1.
+const SchedulerWeightLabel = "scheduler-weight"
+
 // SpreadPlacementStrategy places a container on the node with the fewest running containers.
 type SpreadPlacementStrategy struct {
 }
@@ -26,15 +34,62 @@ func (p *SpreadPlacementStrategy) RankAndSort(config *cluster.ContainerConfig, n
 	// for spread, a healthy node should decrease its weight to increase its chance of being selected
 	// set healthFactor to -10 to make health degree [0, 100] overpower cpu + memory (each in range [0, 100])
 	const healthFactor int64 = -10
-	weightedNodes, err := weighNodes(config, nodes, healthFactor)
+	// a node actually using more memory should increase its weight
+	const usageFactor int64 = 1
+	weightedNodes, err := weighNodes(config, nodes, healthFactor, usageFactor)
 	if err != nil {
 		return nil, err
 	}
 
-	sort.Sort(weightedNodes)
+	// The resources the container doesn't request score the same on every
+	// node, they are left out of the load divided by the node weights.
+	var unrequested int64
+	if config.HostConfig.CPUShares == 0 {
+		unrequested += 100
+	}
+	if config.HostConfig.Memory == 0 {
+		unrequested += 100
+	}
+	for _, n := range weightedNodes {
+		if weight := schedulerWeight(n.Node); weight != 1 {
+			health := healthFactor * n.Node.HealthIndicator
+			load := n.Weight - health - unrequested
+			n.Weight = int64(float64(load)/weight) + health + unrequested
+		}
+	}
+
+	sort.Sort(spreadNodeList{weightedNodes})
 	output := make([]*node.Node, len(weightedNodes))
 	for i, n := range weightedNodes {
 		output[i] = n.Node
 	}
 	return output, nil
 }
+
+// schedulerWeight returns the weight of a node, as set by its
+// scheduler-weight label.
+func schedulerWeight(n *node.Node) float64 {
+	weight, err := strconv.ParseFloat(n.Labels[SchedulerWeightLabel], 64)
+	if err != nil || weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+// spreadNodeList sorts the nodes of the same weight by number of containers
+// per unit of scheduler weight.
+type spreadNodeList struct {
+	weightedNodeList
+}
+
+func (n spreadNodeList) Less(i, j int) bool {
+	var (
+		ip = n.weightedNodeList[i]
+		jp = n.weightedNodeList[j]
+	)
+
+	if ip.Weight == jp.Weight {
+		return float64(len(ip.Node.Containers))/schedulerWeight(ip.Node) < float64(len(jp.Node.Containers))/schedulerWeight(jp.Node)
+	}
+	return ip.Weight < jp.Weight
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/docker/swarm/cluster"
 	"github.com/docker/swarm/scheduler/node"
 	"github.com/stretchr/testify/assert"
 )
@@ -184,6 +185,35 @@ func TestSpreadPlaceContainerOvercommit(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestSpreadPlaceContainerCPUOvercommit(t *testing.T) {
+	s := &SpreadPlacementStrategy{}
+
+	nodes := []*node.Node{createNode("node-0", 4, 2), createNode("node-1", 4, 2)}
+	assert.NoError(t, nodes[0].AddContainer(createContainer("c1", createConfig(0, 2))))
+	assert.NoError(t, nodes[1].AddContainer(createContainer("c2", createConfig(0, 1))))
+
+	// No node has the CPUs of the container.
+	config := createConfig(1, 2)
+	_, err := s.RankAndSort(config, nodes)
+	assert.Error(t, err)
+
+	// Its overcommit ratio lets it run on the least overcommitted node.
+	config.Labels[cluster.SwarmLabelNamespace+".cpu-overcommit"] = "2"
+	top := selectTopNode(t, s, config, nodes)
+	assert.Equal(t, nodes[1], top)
+
+	// The nodes having the CPUs are preferred.
+	config.HostConfig.CPUShares = 1
+	ranked, err := s.RankAndSort(config, nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, []*node.Node{nodes[1]}, ranked)
+
+	// The memory is never overcommitted.
+	config.HostConfig.Memory = 5 * 1024 * 1024 * 1024
+	_, err = s.RankAndSort(config, nodes)
+	assert.Error(t, err)
+}
+
 func TestSpreadComplexPlacement(t *testing.T) {
 	s := &SpreadPlacementStrategy{}
 
@@ -213,3 +243,50 @@ func TestSpreadComplexPlacement(t *testing.T) {
 	// check that it ends up on the same node as the 2G
 	assert.Equal(t, node1.ID, node3.ID)
 }
+
+func TestSpreadPlaceSchedulerWeight(t *testing.T) {
+	s := &SpreadPlacementStrategy{}
+
+	nodes := []*node.Node{
+		createNode("node-0", 64, 21),
+		createNode("node-1", 64, 21),
+		createNode("node-2", 64, 21),
+		createNode("node-3", 64, 21),
+	}
+	nodes[1].Labels = map[string]string{SchedulerWeightLabel: "2"}
+	// Invalid weights count as 1.
+	nodes[2].Labels = map[string]string{SchedulerWeightLabel: "heavy"}
+	nodes[3].Labels = map[string]string{SchedulerWeightLabel: "-2"}
+
+	// add 100 containers
+	for i := 0; i < 100; i++ {
+		config := createConfig(0, 0)
+		node := selectTopNode(t, s, config, nodes)
+		assert.NoError(t, node.AddContainer(createContainer(fmt.Sprintf("c%d", i), config)))
+	}
+
+	assert.Equal(t, 20, len(nodes[0].Containers))
+	assert.Equal(t, 40, len(nodes[1].Containers))
+	assert.Equal(t, 20, len(nodes[2].Containers))
+	assert.Equal(t, 20, len(nodes[3].Containers))
+}
+
+func TestSpreadPlaceSchedulerWeightCPUs(t *testing.T) {
+	s := &SpreadPlacementStrategy{}
+
+	nodes := []*node.Node{
+		createNode("node-0", 64, 100),
+		createNode("node-1", 64, 100),
+	}
+	nodes[1].Labels = map[string]string{SchedulerWeightLabel: "3"}
+
+	// add 40 containers 1CPU, the heavier node takes 3 times more
+	for i := 0; i < 40; i++ {
+		config := createConfig(0, 1)
+		node := selectTopNode(t, s, config, nodes)
+		assert.NoError(t, node.AddContainer(createContainer(fmt.Sprintf("c%d", i), config)))
+	}
+
+	assert.Equal(t, 10, len(nodes[0].Containers))
+	assert.Equal(t, 30, len(nodes[1].Containers))
+}
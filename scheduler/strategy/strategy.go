@@ -2,6 +2,8 @@ package strategy
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/swarm/cluster"
@@ -23,6 +25,14 @@ type PlacementStrategy interface {
 	RankAndSort(config *cluster.ContainerConfig, nodes []*node.Node) ([]*node.Node, error)
 }
 
+// configurableStrategy is implemented by the strategies accepting options,
+// e.g. hybrid:threshold=0.7.
+type configurableStrategy interface {
+	PlacementStrategy
+	// configure returns a new instance of the strategy using the options.
+	configure(opts cluster.DriverOpts) (PlacementStrategy, error)
+}
+
 var (
 	strategies []PlacementStrategy
 	// ErrNotSupported is the error returned when a strategy name does not match
@@ -39,17 +49,33 @@ func init() {
 		&SpreadPlacementStrategy{},
 		&BinpackPlacementStrategy{},
 		&RandomPlacementStrategy{},
+		&HybridPlacementStrategy{},
 	}
 }
 
-// New creates a new PlacementStrategy for the given strategy name.
+// New creates a new PlacementStrategy for the given strategy name. Options
+// may follow the name, as in hybrid:threshold=0.7.
 func New(name string) (PlacementStrategy, error) {
+	var opts cluster.DriverOpts
+	if parts := strings.SplitN(name, ":", 2); len(parts) == 2 {
+		name = parts[0]
+		opts = strings.Split(parts[1], ",")
+	}
+
 	if name == "binpacking" { //TODO: remove this compat
 		name = "binpack"
 	}
 
 	for _, strategy := range strategies {
 		if strategy.Name() == name {
+			if configurable, ok := strategy.(configurableStrategy); ok {
+				var err error
+				if strategy, err = configurable.configure(opts); err != nil {
+					return nil, err
+				}
+			} else if len(opts) > 0 {
+				return nil, fmt.Errorf("strategy %s does not accept options", name)
+			}
 			log.WithField("name", name).Debugf("Initializing strategy")
 			err := strategy.Initialize()
 			return strategy, err
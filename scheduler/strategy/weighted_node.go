@@ -36,12 +36,36 @@ func (n weightedNodeList) Less(i, j int) bool {
 	return ip.Weight < jp.Weight
 }
 
-func weighNodes(config *cluster.ContainerConfig, nodes []*node.Node, healthinessFactor int64) (weightedNodeList, error) {
+// weighNodes weighs the nodes which have the resources requested by the
+// container. The scheduling weights of the containers count towards the load
+// of the nodes, the ones too loaded to take the weight of the container being
+// skipped. The actual memory utilization of the nodes counts towards their
+// weight, multiplied by usageFactor, if the container asks for it through a
+// memory usage weight. The CPUs of the nodes are overcommitted by the CPU
+// overcommit ratio of the container when no node has the CPUs it reserves.
+func weighNodes(config *cluster.ContainerConfig, nodes []*node.Node, healthinessFactor, usageFactor int64) (weightedNodeList, error) {
+	weightedNodes := weighNodesWithCPUs(config, nodes, healthinessFactor, usageFactor, 1)
+	if ratio := config.CPUOvercommit(); len(weightedNodes) == 0 && ratio > 1 {
+		weightedNodes = weighNodesWithCPUs(config, nodes, healthinessFactor, usageFactor, ratio)
+	}
+
+	if len(weightedNodes) == 0 {
+		return nil, ErrNoResourcesAvailable
+	}
+
+	return weightedNodes, nil
+}
+
+// weighNodesWithCPUs weighs the nodes, with their CPUs multiplied by the
+// ratio.
+func weighNodesWithCPUs(config *cluster.ContainerConfig, nodes []*node.Node, healthinessFactor, usageFactor int64, cpuRatio float64) weightedNodeList {
 	weightedNodes := weightedNodeList{}
+	usageWeight := config.MemoryUsageWeight()
+	schedulingWeight := config.SchedulingWeight()
 
 	for _, node := range nodes {
 		nodeMemory := node.TotalMemory
-		nodeCpus := node.TotalCpus
+		nodeCpus := int64(float64(node.TotalCpus) * cpuRatio)
 
 		// Skip nodes that are smaller than the requested resources.
 		if nodeMemory < int64(config.HostConfig.Memory) || nodeCpus < config.HostConfig.CPUShares {
@@ -60,14 +84,32 @@ func weighNodes(config *cluster.ContainerConfig, nodes []*node.Node, healthiness
 			memoryScore = (node.UsedMemory + config.HostConfig.Memory) * 100 / nodeMemory
 		}
 
+		loadScore := node.SchedulingLoad + schedulingWeight
+		if schedulingWeight > 0 && loadScore > 100 {
+			continue
+		}
+
 		if cpuScore <= 100 && memoryScore <= 100 {
-			weightedNodes = append(weightedNodes, &weightedNode{Node: node, Weight: cpuScore + memoryScore + healthinessFactor*node.HealthIndicator})
+			weight := cpuScore + memoryScore + loadScore + healthinessFactor*node.HealthIndicator
+			if usageWeight > 0 {
+				weight += usageFactor * int64(usageWeight*float64(memoryUsageScore(config, node)))
+			}
+			weightedNodes = append(weightedNodes, &weightedNode{Node: node, Weight: weight})
 		}
 	}
+	return weightedNodes
+}
 
-	if len(weightedNodes) == 0 {
-		return nil, ErrNoResourcesAvailable
+// memoryUsageScore returns the actual memory utilization of the node once the
+// container is placed on it, in percent. The reserved memory stands for the
+// actual usage of the nodes without stats.
+func memoryUsageScore(config *cluster.ContainerConfig, node *node.Node) int64 {
+	if node.TotalMemory <= 0 {
+		return 0
 	}
-
-	return weightedNodes, nil
+	usage := node.MemoryUsage
+	if usage == 0 {
+		usage = node.UsedMemory
+	}
+	return (usage + config.HostConfig.Memory) * 100 / node.TotalMemory
 }